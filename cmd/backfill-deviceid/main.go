@@ -0,0 +1,69 @@
+// Command backfill-deviceid recomputes the device ID of stored messages with the
+// configured topic rules and device ID extraction strategies.
+//
+//	go run ./cmd/backfill-deviceid -dry-run
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"sit-iot-message-mng-api/config"
+	"sit-iot-message-mng-api/database"
+	"sit-iot-message-mng-api/internal/deviceid"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+	"sit-iot-message-mng-api/internal/services"
+	"sit-iot-message-mng-api/internal/topics"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report the messages that would change without updating them")
+	batchSize := flag.Int("batch-size", 500, "number of messages read per page")
+	clientID := flag.String("client-id", "", "only recompute messages of this client ID")
+	topic := flag.String("topic", "", "only recompute messages matching this topic or MQTT pattern")
+	flag.Parse()
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	dbClients, err := database.InitDatabases(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize databases: %v", err)
+	}
+
+	messageRepo, err := repositories.NewRepositoryFactory(cfg).CreateMessageRepository(dbClients.MongoDB, dbClients.Firestore)
+	if err != nil {
+		log.Fatalf("Failed to create message repository: %v", err)
+	}
+
+	topicRouter, err := topics.LoadRouter(cfg.TopicRulesPath)
+	if err != nil {
+		log.Fatalf("Failed to load topic rules: %v", err)
+	}
+	deviceIDs, err := deviceid.LoadResolver(cfg.DeviceIDRulesPath, nil)
+	if err != nil {
+		log.Fatalf("Failed to load device ID rules: %v", err)
+	}
+
+	backfill := services.NewDeviceIDBackfill(messageRepo, topicRouter, deviceIDs)
+	result, err := backfill.Run(context.Background(), services.DeviceIDBackfillOptions{
+		Filter:    models.MessageFilter{ClientID: *clientID, TopicPattern: *topic},
+		BatchSize: *batchSize,
+		DryRun:    *dryRun,
+	})
+	if result != nil {
+		verb := "updated"
+		if *dryRun {
+			verb = "would update"
+		}
+		log.Printf("Scanned %d messages, %s %d device IDs", result.Scanned, verb, result.Updated)
+	}
+	if err != nil {
+		log.Fatalf("Backfill failed: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+// Command completeness-report prints the gaps, daily coverage and longest outage of the
+// reports of a device against its expected reporting interval.
+//
+//	go run ./cmd/completeness-report -device dev-1 -interval 5m -from 2024-03-01T00:00:00Z -to 2024-03-08T00:00:00Z
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"sit-iot-message-mng-api/config"
+	"sit-iot-message-mng-api/database"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+	"sit-iot-message-mng-api/internal/services"
+)
+
+func main() {
+	deviceID := flag.String("device", "", "device whose reports are checked")
+	channel := flag.String("channel", "", "only check the messages of this channel")
+	interval := flag.Duration("interval", 0, "expected reporting interval, e.g. 5m")
+	grace := flag.Duration("grace", 0, "extra delay tolerated before a silence is a gap (default half the interval)")
+	from := flag.String("from", "", "start of the time range (RFC 3339, default 24 hours before -to)")
+	to := flag.String("to", "", "end of the time range (RFC 3339, default now)")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	toTime := time.Now().UTC()
+	if *to != "" {
+		parsed, err := time.Parse(time.RFC3339, *to)
+		if err != nil {
+			log.Fatalf("Invalid -to: %v", err)
+		}
+		toTime = parsed
+	}
+	fromTime := toTime.Add(-24 * time.Hour)
+	if *from != "" {
+		parsed, err := time.Parse(time.RFC3339, *from)
+		if err != nil {
+			log.Fatalf("Invalid -from: %v", err)
+		}
+		fromTime = parsed
+	}
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	dbClients, err := database.InitDatabases(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize databases: %v", err)
+	}
+
+	repoFactory := repositories.NewRepositoryFactory(cfg)
+	messageRepo, err := repoFactory.CreateMessageRepository(dbClients.MongoDB, dbClients.Firestore)
+	if err != nil {
+		log.Fatalf("Failed to create message repository: %v", err)
+	}
+
+	report, err := services.NewCompletenessChecker(messageRepo).Check(context.Background(), models.CompletenessQuery{
+		DeviceID: *deviceID,
+		Channel:  *channel,
+		Interval: *interval,
+		Grace:    *grace,
+		From:     fromTime,
+		To:       toTime,
+	})
+	if err != nil {
+		log.Fatalf("Completeness check failed: %v", err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatalf("Failed to encode the report: %v", err)
+		}
+		return
+	}
+	printReport(report)
+}
+
+// printReport writes the report as plain text tables
+func printReport(report *models.CompletenessReport) {
+	fmt.Printf("Device %s, every %s from %s to %s\n", report.DeviceID, time.Duration(report.IntervalSeconds*float64(time.Second)),
+		report.From.Format(time.RFC3339), report.To.Format(time.RFC3339))
+	fmt.Printf("Messages: %d, intervals received: %d/%d (%.2f%%), gaps: %d\n", report.Messages, report.Received, report.Expected, report.Coverage, report.GapCount)
+	if report.LastSeen != nil {
+		fmt.Printf("Last seen: %s\n", report.LastSeen.Format(time.RFC3339))
+	}
+	if outage := report.LongestOutage; outage != nil {
+		fmt.Printf("Longest outage: %s from %s to %s\n", time.Duration(outage.DurationSeconds*float64(time.Second)),
+			outage.Start.Format(time.RFC3339), outage.End.Format(time.RFC3339))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\nDATE\tRECEIVED\tEXPECTED\tCOVERAGE")
+	for _, day := range report.Days {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.2f%%\n", day.Date, day.Received, day.Expected, day.Coverage)
+	}
+	if len(report.Gaps) > 0 {
+		fmt.Fprintln(w, "\nGAP START\tGAP END\tDURATION\tMISSING")
+		for _, gap := range report.Gaps {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", gap.Start.Format(time.RFC3339), gap.End.Format(time.RFC3339),
+				time.Duration(gap.DurationSeconds*float64(time.Second)), gap.Missing)
+		}
+	}
+	w.Flush()
+	if report.GapsTruncated {
+		fmt.Printf("Only the first %d of %d gaps are listed\n", len(report.Gaps), report.GapCount)
+	}
+}
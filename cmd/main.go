@@ -1,14 +1,26 @@
 package main
 
 import (
+	"context"
 	"log"
 	"sit-iot-message-mng-api/config"
 	"sit-iot-message-mng-api/database"
+	"sit-iot-message-mng-api/internal/alerting"
 	"sit-iot-message-mng-api/internal/controllers"
+	"sit-iot-message-mng-api/internal/deviceid"
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/feed"
+	"sit-iot-message-mng-api/internal/hub"
+	"sit-iot-message-mng-api/internal/ingestion"
+	"sit-iot-message-mng-api/internal/presence"
 	"sit-iot-message-mng-api/internal/repositories"
+	"sit-iot-message-mng-api/internal/rollup"
 	"sit-iot-message-mng-api/internal/routes"
 	"sit-iot-message-mng-api/internal/services"
+	"sit-iot-message-mng-api/internal/topics"
+	"sit-iot-message-mng-api/internal/webhook"
 
+	"firebase.google.com/go/v4/auth"
 	"github.com/gin-gonic/gin"
 )
 
@@ -27,15 +39,18 @@ func main() {
 		log.Fatalf("Failed to initialize databases: %v", err)
 	}
 
-	// Initialize Firebase for authentication
-	firebaseApp, err := database.InitFirebase(cfg.FirebaseCredentialsPath)
-	if err != nil {
-		log.Fatalf("Failed to initialize Firebase: %v", err)
-	}
+	// Initialize Firebase for authentication; the in-memory provider runs without credentials
+	var firebaseAuth *auth.Client
+	if cfg.DatabaseProvider != "memory" {
+		firebaseApp, err := database.InitFirebase(cfg.FirebaseCredentialsPath)
+		if err != nil {
+			log.Fatalf("Failed to initialize Firebase: %v", err)
+		}
 
-	firebaseAuth, err := firebaseApp.Auth(nil)
-	if err != nil {
-		log.Fatalf("Failed to initialize Firebase Auth: %v", err)
+		firebaseAuth, err = firebaseApp.Auth(nil)
+		if err != nil {
+			log.Fatalf("Failed to initialize Firebase Auth: %v", err)
+		}
 	}
 
 	// Initialize repository factory
@@ -49,17 +64,132 @@ func main() {
 
 	log.Printf("Message repository initialized for %s", repoFactory.GetDatabaseProvider())
 
+	// Load the topic classification rules
+	topicRouter, err := topics.LoadRouter(cfg.TopicRulesPath)
+	if err != nil {
+		log.Fatalf("Failed to load topic rules: %v", err)
+	}
+
+	// Load the device ID extraction strategies
+	deviceIDs, err := deviceid.LoadResolver(cfg.DeviceIDRulesPath, nil)
+	if err != nil {
+		log.Fatalf("Failed to load device ID rules: %v", err)
+	}
+
+	// Messages of the registered devices get the project of their device
+	deviceRepo, err := repoFactory.CreateDeviceRepository(dbClients.MongoDB, dbClients.Firestore)
+	if err != nil {
+		log.Fatalf("Failed to create device repository: %v", err)
+	}
+
+	// Stored messages are announced on the event bus
+	bus := events.NewBus()
+
+	aggregationRepo, err := repoFactory.CreateAggregationRepository(dbClients.MongoDB, dbClients.Firestore)
+	if err != nil {
+		log.Fatalf("Failed to create aggregation repository: %v", err)
+	}
+
+	// Start the optional aggregation rollup worker
+	if cfg.RollupEnabled {
+		worker := rollup.NewWorker(bus, aggregationRepo)
+		if err := worker.Start(context.Background()); err != nil {
+			log.Fatalf("Failed to start rollup worker: %v", err)
+		}
+		defer worker.Stop()
+	}
+
+	// POST the created messages matching the webhooks of the projects
+	webhookRepo, err := repoFactory.CreateWebhookRepository(dbClients.MongoDB, dbClients.Firestore)
+	if err != nil {
+		log.Fatalf("Failed to create webhook repository: %v", err)
+	}
+	dispatcher := webhook.NewDispatcher(bus, webhookRepo, messageRepo)
+	if err := dispatcher.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to start webhook dispatcher: %v", err)
+	}
+	defer dispatcher.Stop()
+
+	// Fan created messages out to the WebSocket subscriptions
+	messageHub := hub.NewHub(bus)
+	if err := messageHub.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to start message hub: %v", err)
+	}
+	defer messageHub.Stop()
+
+	// Publish the changes of every writer from an external feed when configured; the service
+	// then stops publishing its own changes, which the feed also sees
+	serviceBus := bus
+	var source feed.Source
+	switch cfg.EventSource {
+	case "changestream":
+		if repoFactory.GetDatabaseProvider() != "mongo" && repoFactory.GetDatabaseProvider() != "mongodb" {
+			log.Fatalf("EVENT_SOURCE=changestream requires the MongoDB provider")
+		}
+		source = feed.NewMongoChangeStream(dbClients.MongoDB, bus, topicRouter, deviceIDs)
+	case "poll":
+		source = feed.NewPollingSource(messageRepo, bus, topicRouter, deviceIDs, cfg.EventPollInterval, cfg.EventPollLookback)
+	}
+	if source != nil {
+		if err := source.Start(context.Background()); err != nil {
+			log.Fatalf("Failed to start %s event feed: %v", cfg.EventSource, err)
+		}
+		defer source.Stop()
+		serviceBus = events.WithoutPublish(bus)
+	}
+
+	// Evaluate the alert rules of the projects against the created messages; alert messages
+	// are announced like the messages stored by the service
+	alertRepo, err := repoFactory.CreateAlertRepository(dbClients.MongoDB, dbClients.Firestore)
+	if err != nil {
+		log.Fatalf("Failed to create alert repository: %v", err)
+	}
+	alertEngine := alerting.NewEngine(serviceBus, alertRepo, messageRepo)
+	if err := alertEngine.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to start alerting engine: %v", err)
+	}
+	defer alertEngine.Stop()
+
+	// Track which devices are online from their messages and mark the silent ones offline
+	presenceRepo, err := repoFactory.CreatePresenceRepository(dbClients.MongoDB, dbClients.Firestore)
+	if err != nil {
+		log.Fatalf("Failed to create presence repository: %v", err)
+	}
+	presenceTracker := presence.NewTracker(bus, presenceRepo, deviceRepo, cfg.PresenceTimeout)
+	if err := presenceTracker.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to start presence tracker: %v", err)
+	}
+	defer presenceTracker.Stop()
+
 	// Initialize services
-	messageService := services.NewMessageService(messageRepo, firebaseAuth, cfg)
+	messageService := services.NewMessageService(messageRepo, firebaseAuth, cfg, topicRouter, deviceIDs, deviceRepo, serviceBus)
+
+	// Start the optional MQTT ingestion subscriber
+	if cfg.MqttIngestEnabled {
+		subscriber := ingestion.NewSubscriber(cfg, messageService)
+		if err := subscriber.Start(context.Background()); err != nil {
+			log.Fatalf("Failed to start MQTT ingestion: %v", err)
+		}
+		defer subscriber.Stop()
+	}
 
 	// Initialize controllers
 	messageController := controllers.NewMessageController(messageService)
+	aggregationController := controllers.NewAggregationController(
+		services.NewRecomputeJobs(services.NewAggregationRecompute(messageRepo, aggregationRepo)),
+	)
+
+	websocketController := controllers.NewWebSocketController(messageService, messageHub, cfg)
+	webhookController := controllers.NewWebhookController(services.NewWebhookService(webhookRepo, messageService))
+	alertController := controllers.NewAlertController(services.NewAlertService(alertRepo, messageRepo, serviceBus, messageService))
+	presenceController := controllers.NewPresenceController(services.NewPresenceService(presenceRepo, messageService))
+	deviceController := controllers.NewDeviceController(services.NewDeviceService(deviceRepo, messageService, messageService))
 
 	// Initialize Gin router
 	router := gin.Default()
 
 	// Setup routes
-	routes.SetupRoutes(router, messageController, cfg)
+	routes.SetupRoutes(router, messageController, aggregationController, websocketController, webhookController, alertController, presenceController, deviceController, cfg)
 
 	// Start server
 	log.Printf("Server starting on port %s", cfg.Port)
@@ -0,0 +1,75 @@
+// Command recompute-aggregations rebuilds the precomputed aggregations of a device or a
+// project over a time range from the raw messages, e.g. after late uploads.
+//
+//	go run ./cmd/recompute-aggregations -device dev-1 -from 2024-03-01T00:00:00Z -to 2024-03-31T23:59:59Z
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"sit-iot-message-mng-api/config"
+	"sit-iot-message-mng-api/database"
+	"sit-iot-message-mng-api/internal/repositories"
+	"sit-iot-message-mng-api/internal/services"
+)
+
+func main() {
+	deviceID := flag.String("device", "", "device whose aggregations are recomputed")
+	projectID := flag.String("project", "", "recompute every device with messages of this project instead")
+	from := flag.String("from", "", "start of the time range (RFC 3339)")
+	to := flag.String("to", "", "end of the time range (RFC 3339)")
+	batchSize := flag.Int("batch-size", 500, "number of messages read per page")
+	flag.Parse()
+
+	fromTime, err := time.Parse(time.RFC3339, *from)
+	if err != nil {
+		log.Fatalf("Invalid -from: %v", err)
+	}
+	toTime, err := time.Parse(time.RFC3339, *to)
+	if err != nil {
+		log.Fatalf("Invalid -to: %v", err)
+	}
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	dbClients, err := database.InitDatabases(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize databases: %v", err)
+	}
+
+	repoFactory := repositories.NewRepositoryFactory(cfg)
+	messageRepo, err := repoFactory.CreateMessageRepository(dbClients.MongoDB, dbClients.Firestore)
+	if err != nil {
+		log.Fatalf("Failed to create message repository: %v", err)
+	}
+	aggregationRepo, err := repoFactory.CreateAggregationRepository(dbClients.MongoDB, dbClients.Firestore)
+	if err != nil {
+		log.Fatalf("Failed to create aggregation repository: %v", err)
+	}
+
+	recompute := services.NewAggregationRecompute(messageRepo, aggregationRepo)
+	progress, err := recompute.Run(context.Background(), services.AggregationRecomputeOptions{
+		DeviceID:  *deviceID,
+		ProjectID: *projectID,
+		From:      fromTime,
+		To:        toTime,
+		BatchSize: *batchSize,
+		Progress: func(p services.AggregationRecomputeProgress) {
+			log.Printf("Scanned %d/%d messages, replaced the buckets of %d devices", p.Scanned, p.Total, p.Devices)
+		},
+	})
+	if progress != nil {
+		log.Printf("Recomputed %s to %s: %d messages, %d devices, %d buckets",
+			progress.From.Format(time.RFC3339), progress.To.Format(time.RFC3339), progress.Scanned, progress.Devices, progress.Replaced)
+	}
+	if err != nil {
+		log.Fatalf("Recompute failed: %v", err)
+	}
+}
@@ -1,7 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -12,11 +16,67 @@ type Config struct {
 	Audience                string
 	ProjectServiceApiUrl    string
 	DBName                  string
-	DatabaseProvider        string // "mongo" or "firestore"
+	DatabaseProvider        string // "mongo", "firestore" or "memory"
 	MqttServiceApiUrl       string
+	TopicRulesPath          string // JSON topic classification rules; built-in rules when empty
+	DeviceIDRulesPath       string // JSON device ID extraction strategies; topic rule capture, then client ID when empty
+
+	// MQTT ingestion (disabled unless MQTT_INGEST_ENABLED=true)
+	MqttIngestEnabled      bool
+	MqttBrokerURL          string
+	MqttIngestClientID     string
+	MqttUsername           string
+	MqttPassword           string
+	MqttIngestTopics       []string // Topic filters to subscribe to, may contain + and # wildcards
+	MqttIngestQoS          byte
+	MqttClientIDTopicLevel int // Topic level holding the device client ID when the payload has none
+	RollupEnabled          bool
+	AdminEmails            []string // Users allowed to call the admin endpoints
+	AllowedOrigins         []string // Browser origins allowed by CORS and the WebSocket endpoint
+
+	// Event feed: "service" publishes the changes made through the API only, "changestream"
+	// watches MongoDB and "poll" polls the message repository for messages of any writer
+	EventSource       string
+	EventPollInterval time.Duration
+	EventPollLookback time.Duration // How late a message may be stored after its timestamp
+
+	PresenceTimeout time.Duration // Silence after which a device is marked offline
 }
 
 func LoadConfig() (*Config, error) {
+	ingestEnabled, err := strconv.ParseBool(getEnv("MQTT_INGEST_ENABLED", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid MQTT_INGEST_ENABLED: %w", err)
+	}
+	qos, err := strconv.Atoi(getEnv("MQTT_INGEST_QOS", "1"))
+	if err != nil || qos < 0 || qos > 2 {
+		return nil, fmt.Errorf("invalid MQTT_INGEST_QOS: must be 0, 1 or 2")
+	}
+	clientIDLevel, err := strconv.Atoi(getEnv("MQTT_CLIENT_ID_TOPIC_LEVEL", "0"))
+	if err != nil || clientIDLevel < 0 {
+		return nil, fmt.Errorf("invalid MQTT_CLIENT_ID_TOPIC_LEVEL: must be a non-negative integer")
+	}
+	rollupEnabled, err := strconv.ParseBool(getEnv("ROLLUP_ENABLED", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ROLLUP_ENABLED: %w", err)
+	}
+	eventSource := getEnv("EVENT_SOURCE", "service")
+	if eventSource != "service" && eventSource != "changestream" && eventSource != "poll" {
+		return nil, fmt.Errorf("invalid EVENT_SOURCE: must be service, changestream or poll")
+	}
+	pollInterval, err := time.ParseDuration(getEnv("EVENT_POLL_INTERVAL", "5s"))
+	if err != nil || pollInterval <= 0 {
+		return nil, fmt.Errorf("invalid EVENT_POLL_INTERVAL: must be a positive duration")
+	}
+	pollLookback, err := time.ParseDuration(getEnv("EVENT_POLL_LOOKBACK", "1m"))
+	if err != nil || pollLookback < 0 {
+		return nil, fmt.Errorf("invalid EVENT_POLL_LOOKBACK: must be a non-negative duration")
+	}
+	presenceTimeout, err := time.ParseDuration(getEnv("PRESENCE_TIMEOUT", "5m"))
+	if err != nil || presenceTimeout <= 0 {
+		return nil, fmt.Errorf("invalid PRESENCE_TIMEOUT: must be a positive duration")
+	}
+
 	return &Config{
 		Port:                    getEnv("PORT", "8080"),
 		DatabaseURL:             getEnv("DB_URI_MESSAGE_MNG", "mongodb://localhost:27017/sit-iot-message-mng"),
@@ -27,6 +87,23 @@ func LoadConfig() (*Config, error) {
 		DBName:                  getEnv("DB_NAME_MESSAGE_MNG", "sit-iot-messages-mng"),
 		DatabaseProvider:        getEnv("DATABASE_PROVIDER", "mongo"), // Default to MongoDB
 		MqttServiceApiUrl:       getEnv("MQTT_SERVICE_API_URL", "http://localhost"),
+		TopicRulesPath:          getEnv("TOPIC_RULES_PATH", ""),
+		DeviceIDRulesPath:       getEnv("DEVICE_ID_RULES_PATH", ""),
+		MqttIngestEnabled:       ingestEnabled,
+		MqttBrokerURL:           getEnv("MQTT_BROKER_URL", "tcp://localhost:1883"),
+		MqttIngestClientID:      getEnv("MQTT_INGEST_CLIENT_ID", "sit-iot-message-mng-ingest"),
+		MqttUsername:            getEnv("MQTT_USERNAME", ""),
+		MqttPassword:            getEnv("MQTT_PASSWORD", ""),
+		MqttIngestTopics:        splitList(getEnv("MQTT_INGEST_TOPICS", "#")),
+		MqttIngestQoS:           byte(qos),
+		MqttClientIDTopicLevel:  clientIDLevel,
+		RollupEnabled:           rollupEnabled,
+		AdminEmails:             splitList(getEnv("ADMIN_EMAILS", "")),
+		AllowedOrigins:          splitList(getEnv("ALLOWED_ORIGINS", "http://localhost,http://localhost:5173,http://127.0.0.1:5173,https://console.sit-iot.com")),
+		EventSource:             eventSource,
+		EventPollInterval:       pollInterval,
+		EventPollLookback:       pollLookback,
+		PresenceTimeout:         presenceTimeout,
 	}, nil
 }
 
@@ -36,3 +113,14 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// splitList splits a comma-separated environment value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
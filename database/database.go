@@ -36,6 +36,9 @@ func InitDatabases(cfg *config.Config) (*DatabaseClients, error) {
 		}
 		clients.Firestore = firestoreClient
 
+	case "memory":
+		// The in-memory repository does not need any database client
+
 	default:
 		// Initialize both for backwards compatibility or if not specified
 		mongoClient, err := InitMongoDB(cfg)
@@ -0,0 +1,474 @@
+// Package alerting evaluates the alert rules of the projects against the messages of their
+// devices and opens, counts and resolves the alerts they raise.
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// CreatedBy is recorded as the creator of the alert messages
+	CreatedBy = "alert-rules"
+	// eventBuffer is the number of created messages queued for the engine
+	eventBuffer = 1024
+	// evaluateTimeout bounds the evaluation of a single message or missing check
+	evaluateTimeout = 10 * time.Second
+	// ruleCacheTTL is how long the list of rules is reused before being read again
+	ruleCacheTTL = 10 * time.Second
+	// missingCheckInterval is how often the devices are checked against the missing rules
+	missingCheckInterval = 30 * time.Second
+)
+
+// Engine evaluates every created message against the enabled rules of its project. A rule
+// has at most one active alert per device: the condition holding again while the alert is
+// active counts an occurrence, and the condition clearing resolves the alert. An alert
+// resolved by a user while its condition still holds is only opened again once the
+// condition cleared and held again.
+//
+// Opening an alert also stores an alert message (models.MessageTypeAlert) for the device,
+// published like any created message, so alerts are listed and streamed with the other
+// messages. Its metadata follows the state of the alert.
+type Engine interface {
+	// Start subscribes to the event bus, evaluates in the background and checks the missing
+	// rules periodically
+	Start(ctx context.Context) error
+	// Stop unsubscribes and waits for the queued messages to be evaluated
+	Stop()
+	// Process evaluates the rules of the project of the message against it
+	Process(ctx context.Context, message *models.Message) error
+	// CheckMissing opens the alerts of the missing rules whose devices have been silent for
+	// longer than the rule allows. Devices of project-wide rules are known once they sent
+	// a message since the engine started.
+	CheckMissing(ctx context.Context, now time.Time) error
+}
+
+// stateKey identifies the evaluation state of a rule for a device
+type stateKey struct {
+	ruleID   string
+	deviceID string
+}
+
+// deviceKey identifies a device within its project
+type deviceKey struct {
+	projectID string
+	deviceID  string
+}
+
+// ruleState is the evaluation state of a rule for a device
+type ruleState struct {
+	version      time.Time  // UpdatedAt of the rule the state belongs to
+	firing       bool       // The condition held for long enough and did not clear since
+	pendingSince *time.Time // Since when the condition holds, nil when it does not
+	prevValue    float64    // Previous value of a rate rule
+	prevAt       time.Time
+	hasPrev      bool
+}
+
+type engine struct {
+	bus         events.Bus
+	alertRepo   repositories.AlertRepository
+	messageRepo repositories.MessageRepository
+
+	now           func() time.Time
+	startedAt     time.Time
+	ruleTTL       time.Duration
+	checkInterval time.Duration
+
+	mu            sync.Mutex
+	rules         []*models.AlertRule
+	rulesLoadedAt time.Time
+	states        map[stateKey]*ruleState
+	lastSeen      map[deviceKey]time.Time
+
+	cancel func()
+	done   chan struct{}
+}
+
+func NewEngine(bus events.Bus, alertRepo repositories.AlertRepository, messageRepo repositories.MessageRepository) Engine {
+	return &engine{
+		bus:           bus,
+		alertRepo:     alertRepo,
+		messageRepo:   messageRepo,
+		now:           time.Now,
+		startedAt:     time.Now(),
+		ruleTTL:       ruleCacheTTL,
+		checkInterval: missingCheckInterval,
+		states:        make(map[stateKey]*ruleState),
+		lastSeen:      make(map[deviceKey]time.Time),
+	}
+}
+
+func (e *engine) Start(ctx context.Context) error {
+	if e.done != nil {
+		return errors.New("alerting engine already started")
+	}
+
+	queue, cancel := e.bus.Subscribe("alerting", eventBuffer)
+	e.cancel = cancel
+	e.done = make(chan struct{})
+
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(e.checkInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case event, ok := <-queue:
+				if !ok {
+					return
+				}
+				if event.Type != events.MessageCreated {
+					continue
+				}
+				evaluateCtx, cancelEvaluate := context.WithTimeout(ctx, evaluateTimeout)
+				if err := e.Process(evaluateCtx, event.Message); err != nil {
+					log.Printf("Alert evaluation of message %s failed: %v", event.Message.GetIDAsString(), err)
+				}
+				cancelEvaluate()
+			case <-ticker.C:
+				checkCtx, cancelCheck := context.WithTimeout(ctx, evaluateTimeout)
+				if err := e.CheckMissing(checkCtx, e.now()); err != nil {
+					log.Printf("Missing alert check failed: %v", err)
+				}
+				cancelCheck()
+			}
+		}
+	}()
+	log.Printf("Alerting engine started")
+	return nil
+}
+
+func (e *engine) Stop() {
+	if e.cancel == nil {
+		return
+	}
+	e.cancel()
+	<-e.done
+	e.cancel = nil
+}
+
+func (e *engine) Process(ctx context.Context, message *models.Message) error {
+	// Alert messages are raised by the engine itself
+	if message.Type == models.MessageTypeAlert || message.ProjectID == "" || message.DeviceID == "" {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.now().UTC()
+	e.lastSeen[deviceKey{message.ProjectID, message.DeviceID}] = now
+
+	rules, err := e.loadRules(ctx, now)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, rule := range rules {
+		if !rule.AppliesTo(message.ProjectID, message.DeviceID) {
+			continue
+		}
+		if err := e.evaluate(ctx, rule, message, now); err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", rule.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *engine) CheckMissing(ctx context.Context, now time.Time) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now = now.UTC()
+
+	rules, err := e.loadRules(ctx, now)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, rule := range rules {
+		if !rule.Enabled || rule.Kind != models.AlertRuleMissing {
+			continue
+		}
+		for deviceID, seen := range e.devices(rule) {
+			// Silence before the rule changed is not held against the device
+			if rule.UpdatedAt.After(seen) {
+				seen = rule.UpdatedAt
+			}
+			if now.Sub(seen) < rule.For() {
+				continue
+			}
+			state, err := e.state(ctx, rule, deviceID)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("rule %s: %w", rule.ID, err))
+				continue
+			}
+			if state.firing {
+				continue
+			}
+			summary := rule.Name + ": " + rule.Condition()
+			if err := e.open(ctx, rule, deviceID, summary, nil, nil, now); err != nil {
+				errs = append(errs, fmt.Errorf("rule %s: %w", rule.ID, err))
+				continue
+			}
+			state.firing = true
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// devices returns when the devices checked by a missing rule were last seen. A device of a
+// device-specific rule that was not seen counts as seen when the engine started.
+func (e *engine) devices(rule *models.AlertRule) map[string]time.Time {
+	devices := make(map[string]time.Time)
+	if rule.DeviceID != "" {
+		seen, ok := e.lastSeen[deviceKey{rule.ProjectID, rule.DeviceID}]
+		if !ok {
+			seen = e.startedAt
+		}
+		devices[rule.DeviceID] = seen
+		return devices
+	}
+	for key, seen := range e.lastSeen {
+		if key.projectID == rule.ProjectID {
+			devices[key.deviceID] = seen
+		}
+	}
+	return devices
+}
+
+// loadRules returns the rules of every project, read again once the cache expired
+func (e *engine) loadRules(ctx context.Context, now time.Time) ([]*models.AlertRule, error) {
+	if e.rules != nil && now.Sub(e.rulesLoadedAt) < e.ruleTTL {
+		return e.rules, nil
+	}
+	rules, err := e.alertRepo.ListRules(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	if rules == nil {
+		rules = []*models.AlertRule{}
+	}
+	e.rules = rules
+	e.rulesLoadedAt = now
+	return rules, nil
+}
+
+// state returns the evaluation state of the rule for the device. A new state, or the
+// state of a rule that changed since, starts firing when the rule has an active alert.
+func (e *engine) state(ctx context.Context, rule *models.AlertRule, deviceID string) (*ruleState, error) {
+	key := stateKey{rule.ID, deviceID}
+	if state, ok := e.states[key]; ok && state.version.Equal(rule.UpdatedAt) {
+		return state, nil
+	}
+
+	state := &ruleState{version: rule.UpdatedAt}
+	_, err := e.alertRepo.FindActiveAlert(ctx, rule.ID, deviceID)
+	switch {
+	case err == nil:
+		state.firing = true
+	case !errors.Is(err, repositories.ErrAlertNotFound):
+		return nil, err
+	}
+	e.states[key] = state
+	return state, nil
+}
+
+// evaluate applies a rule to a message of one of its devices
+func (e *engine) evaluate(ctx context.Context, rule *models.AlertRule, message *models.Message, now time.Time) error {
+	state, err := e.state(ctx, rule, message.DeviceID)
+	if err != nil {
+		return err
+	}
+	at := message.Timestamp
+	if at.IsZero() {
+		at = now
+	}
+
+	switch rule.Kind {
+	case models.AlertRuleMissing:
+		// The device is not silent anymore
+		if state.firing {
+			return e.clear(ctx, rule, state, message.DeviceID, now)
+		}
+		return nil
+	case models.AlertRuleRate:
+		value, ok := rule.Value(message)
+		if !ok || (state.hasPrev && !at.After(state.prevAt)) {
+			return nil
+		}
+		prevValue, prevAt, hadPrev := state.prevValue, state.prevAt, state.hasPrev
+		state.prevValue, state.prevAt, state.hasPrev = value, at, true
+		if !hadPrev {
+			return nil
+		}
+		return e.observe(ctx, rule, state, message, (value-prevValue)/at.Sub(prevAt).Minutes(), at, now)
+	default:
+		value, ok := rule.Value(message)
+		if !ok {
+			return nil
+		}
+		return e.observe(ctx, rule, state, message, value, at, now)
+	}
+}
+
+// observe updates the state of a threshold or rate rule with a value measured at the given time
+func (e *engine) observe(ctx context.Context, rule *models.AlertRule, state *ruleState, message *models.Message, value float64, at, now time.Time) error {
+	if !rule.Compare(value) {
+		state.pendingSince = nil
+		if state.firing {
+			return e.clear(ctx, rule, state, message.DeviceID, now)
+		}
+		return nil
+	}
+
+	if state.pendingSince == nil {
+		state.pendingSince = &at
+	}
+	if at.Sub(*state.pendingSince) < rule.For() {
+		return nil
+	}
+	if state.firing {
+		return e.recur(ctx, rule, message.DeviceID, now)
+	}
+
+	summary := fmt.Sprintf("%s: %s (value %g)", rule.Name, rule.Condition(), value)
+	if err := e.open(ctx, rule, message.DeviceID, summary, &value, message, now); err != nil {
+		return err
+	}
+	state.firing = true
+	return nil
+}
+
+// open stores a new alert of the rule for the device and its alert message
+func (e *engine) open(ctx context.Context, rule *models.AlertRule, deviceID, summary string, value *float64, trigger *models.Message, now time.Time) error {
+	alert := &models.Alert{
+		ID:              primitive.NewObjectID().Hex(),
+		RuleID:          rule.ID,
+		RuleName:        rule.Name,
+		ProjectID:       rule.ProjectID,
+		DeviceID:        deviceID,
+		Kind:            rule.Kind,
+		Severity:        rule.Severity,
+		State:           models.AlertStateOpen,
+		Summary:         summary,
+		Value:           value,
+		Occurrences:     1,
+		OpenedAt:        now,
+		LastTriggeredAt: now,
+		UpdatedAt:       now,
+	}
+	if trigger != nil {
+		alert.TriggerMessageID = trigger.GetIDAsString()
+	}
+	if err := e.alertRepo.CreateAlert(ctx, alert); err != nil {
+		return err
+	}
+
+	message, err := e.createMessage(ctx, rule, alert, trigger)
+	if err != nil {
+		return fmt.Errorf("alert %s opened without its message: %w", alert.ID, err)
+	}
+	alert.MessageID = message.GetIDAsString()
+	return e.alertRepo.UpdateAlert(ctx, alert)
+}
+
+// recur counts an occurrence of the condition on the active alert of the rule for the device
+func (e *engine) recur(ctx context.Context, rule *models.AlertRule, deviceID string, now time.Time) error {
+	alert, err := e.alertRepo.FindActiveAlert(ctx, rule.ID, deviceID)
+	if errors.Is(err, repositories.ErrAlertNotFound) {
+		// Resolved by a user while the condition still holds
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	alert.Occurrences++
+	alert.LastTriggeredAt = now
+	alert.UpdatedAt = now
+	return e.alertRepo.UpdateAlert(ctx, alert)
+}
+
+// clear resolves the active alert of the rule for the device, whose condition cleared
+func (e *engine) clear(ctx context.Context, rule *models.AlertRule, state *ruleState, deviceID string, now time.Time) error {
+	state.firing = false
+	alert, err := e.alertRepo.FindActiveAlert(ctx, rule.ID, deviceID)
+	if errors.Is(err, repositories.ErrAlertNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := alert.Resolve(models.AlertResolvedByRule, now); err != nil {
+		return err
+	}
+	if err := e.alertRepo.UpdateAlert(ctx, alert); err != nil {
+		return err
+	}
+	if alert.MessageID == "" {
+		return nil
+	}
+	message, err := e.messageRepo.Update(ctx, alert.MessageID, alert.MessageUpdate())
+	if err != nil {
+		return err
+	}
+	e.bus.Publish(events.MessageEvent{Type: events.MessageUpdated, Message: message})
+	return nil
+}
+
+// createMessage stores and publishes the alert message of a new alert
+func (e *engine) createMessage(ctx context.Context, rule *models.AlertRule, alert *models.Alert, trigger *models.Message) (*models.Message, error) {
+	body := map[string]interface{}{
+		"alertId":   alert.ID,
+		"ruleId":    rule.ID,
+		"ruleName":  rule.Name,
+		"kind":      string(rule.Kind),
+		"severity":  string(rule.Severity),
+		"condition": rule.Condition(),
+		"summary":   alert.Summary,
+	}
+	if alert.Value != nil {
+		body["value"] = *alert.Value
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID := alert.DeviceID
+	if trigger != nil && trigger.ClientID != "" {
+		clientID = trigger.ClientID
+	}
+	processedAt := alert.OpenedAt
+	message, err := e.messageRepo.Create(ctx, &models.Message{
+		Topic:       "alerts/" + alert.DeviceID + "/" + rule.ID,
+		Payload:     string(payload),
+		Timestamp:   alert.OpenedAt,
+		Marshalled:  body,
+		ClientID:    clientID,
+		Type:        models.MessageTypeAlert,
+		Status:      models.MessageStatusProcessed,
+		DeviceID:    alert.DeviceID,
+		ProjectID:   alert.ProjectID,
+		ProcessedAt: &processedAt,
+		CreatedAt:   alert.OpenedAt,
+		UpdatedAt:   alert.OpenedAt,
+		CreatedBy:   CreatedBy,
+		Metadata:    alert.MessageMetadata(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.bus.Publish(events.MessageEvent{Type: events.MessageCreated, Message: message})
+	return message, nil
+}
@@ -0,0 +1,227 @@
+package alerting
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+var base = time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+
+// newTestEngine returns an engine over memory repositories holding the rules, whose clock
+// is the returned pointer
+func newTestEngine(t *testing.T, rules ...*models.AlertRule) (*engine, repositories.AlertRepository, repositories.MessageRepository, *time.Time) {
+	t.Helper()
+	alertRepo := repositories.NewMemoryAlertRepository()
+	for _, rule := range rules {
+		rule.ProjectID = "p1"
+		rule.Name = "rule " + rule.ID
+		rule.Severity = models.AlertSeverityCritical
+		rule.Enabled = true
+		if err := alertRepo.CreateRule(context.Background(), rule); err != nil {
+			t.Fatalf("CreateRule() unexpected error: %v", err)
+		}
+	}
+	messageRepo := repositories.NewMemoryMessageRepository(nil, nil)
+
+	clock := base
+	e := NewEngine(events.NewBus(), alertRepo, messageRepo).(*engine)
+	e.now = func() time.Time { return clock }
+	e.startedAt = base
+	e.ruleTTL = 0
+	return e, alertRepo, messageRepo, &clock
+}
+
+// process evaluates a message of the device measuring the temperature at base + minutes
+func process(t *testing.T, e *engine, deviceID string, minutes int, temperature float64) {
+	t.Helper()
+	message := &models.Message{
+		ID:         deviceID + "-" + time.Duration(minutes*int(time.Minute)).String(),
+		ProjectID:  "p1",
+		DeviceID:   deviceID,
+		ClientID:   "client-" + deviceID,
+		Timestamp:  base.Add(time.Duration(minutes) * time.Minute),
+		Marshalled: map[string]interface{}{"temperature": temperature},
+	}
+	if err := e.Process(context.Background(), message); err != nil {
+		t.Fatalf("Process() unexpected error: %v", err)
+	}
+}
+
+func listAlerts(t *testing.T, repo repositories.AlertRepository, ruleID string) []*models.Alert {
+	t.Helper()
+	alerts, _, err := repo.ListAlerts(context.Background(), models.AlertFilter{RuleID: ruleID}, 0, 0)
+	if err != nil {
+		t.Fatalf("ListAlerts() unexpected error: %v", err)
+	}
+	return alerts
+}
+
+func TestEngineThreshold(t *testing.T) {
+	rule := &models.AlertRule{ID: "hot", Kind: models.AlertRuleThreshold, Variable: "marshalled.temperature", Operator: ">", Threshold: 80, ForSeconds: 300}
+	e, alertRepo, messageRepo, _ := newTestEngine(t, rule)
+
+	process(t, e, "dev-1", 0, 85)
+	process(t, e, "dev-1", 4, 90)
+	if alerts := listAlerts(t, alertRepo, "hot"); len(alerts) != 0 {
+		t.Fatalf("alerts before the condition held for 5m = %+v, want none", alerts)
+	}
+
+	process(t, e, "dev-1", 5, 91)
+	process(t, e, "dev-1", 6, 95)
+	process(t, e, "dev-2", 6, 20)
+	alerts := listAlerts(t, alertRepo, "hot")
+	if len(alerts) != 1 {
+		t.Fatalf("alerts = %+v, want one", alerts)
+	}
+	alert := alerts[0]
+	if alert.DeviceID != "dev-1" || alert.State != models.AlertStateOpen || alert.Occurrences != 2 || alert.Value == nil || *alert.Value != 91 ||
+		alert.TriggerMessageID != "dev-1-5m0s" || alert.Summary != "rule hot: marshalled.temperature > 80 for 5m0s (value 91)" {
+		t.Errorf("alert = %+v", alert)
+	}
+
+	message, err := messageRepo.FindByID(context.Background(), alert.MessageID)
+	if err != nil {
+		t.Fatalf("FindByID() of the alert message unexpected error: %v", err)
+	}
+	if message.Type != models.MessageTypeAlert || message.DeviceID != "dev-1" || message.ProjectID != "p1" || message.ClientID != "client-dev-1" ||
+		message.Metadata["alertId"] != alert.ID || message.Metadata["state"] != "open" || message.Marshalled["value"] != 91.0 {
+		t.Errorf("alert message = %+v", message)
+	}
+
+	process(t, e, "dev-1", 7, 70)
+	resolved, err := alertRepo.FindAlert(context.Background(), alert.ID)
+	if err != nil || resolved.State != models.AlertStateResolved || resolved.ResolvedBy != models.AlertResolvedByRule {
+		t.Errorf("alert after the condition cleared = %+v, %v; want resolved by the rule", resolved, err)
+	}
+	if message, _ := messageRepo.FindByID(context.Background(), alert.MessageID); message.Metadata["state"] != "resolved" {
+		t.Errorf("alert message metadata = %v, want the resolved state", message.Metadata)
+	}
+
+	// Firing again opens a new alert
+	process(t, e, "dev-1", 8, 99)
+	process(t, e, "dev-1", 13, 99)
+	if alerts := listAlerts(t, alertRepo, "hot"); len(alerts) != 2 || alerts[0].State != models.AlertStateOpen {
+		t.Errorf("alerts after firing again = %+v, want a new open alert", alerts)
+	}
+}
+
+func TestEngineRate(t *testing.T) {
+	rule := &models.AlertRule{ID: "rising", Kind: models.AlertRuleRate, Variable: "temperature", Operator: ">=", Threshold: 10}
+	e, alertRepo, _, _ := newTestEngine(t, rule)
+
+	process(t, e, "dev-1", 0, 20)
+	process(t, e, "dev-1", 2, 30) // 5 per minute
+	if alerts := listAlerts(t, alertRepo, "rising"); len(alerts) != 0 {
+		t.Fatalf("alerts = %+v, want none", alerts)
+	}
+	process(t, e, "dev-1", 1, 90) // Out of order, ignored
+	process(t, e, "dev-1", 3, 45) // 15 per minute
+	alerts := listAlerts(t, alertRepo, "rising")
+	if len(alerts) != 1 || alerts[0].Value == nil || *alerts[0].Value != 15 {
+		t.Fatalf("alerts = %+v, want one of value 15", alerts)
+	}
+}
+
+func TestEngineMissing(t *testing.T) {
+	device := &models.AlertRule{ID: "silent-dev-1", DeviceID: "dev-1", Kind: models.AlertRuleMissing, ForSeconds: 600}
+	project := &models.AlertRule{ID: "silent", Kind: models.AlertRuleMissing, ForSeconds: 600}
+	e, alertRepo, _, clock := newTestEngine(t, device, project)
+	ctx := context.Background()
+
+	*clock = base.Add(5 * time.Minute)
+	process(t, e, "dev-2", 5, 20)
+	if err := e.CheckMissing(ctx, base.Add(11*time.Minute)); err != nil {
+		t.Fatalf("CheckMissing() unexpected error: %v", err)
+	}
+	if alerts := listAlerts(t, alertRepo, "silent-dev-1"); len(alerts) != 1 || alerts[0].DeviceID != "dev-1" || alerts[0].MessageID == "" {
+		t.Errorf("device rule alerts = %+v, want one for dev-1", alerts)
+	}
+	if alerts := listAlerts(t, alertRepo, "silent"); len(alerts) != 0 {
+		t.Errorf("project rule alerts = %+v, want none while dev-2 is not silent", alerts)
+	}
+
+	if err := e.CheckMissing(ctx, base.Add(16*time.Minute)); err != nil {
+		t.Fatalf("CheckMissing() unexpected error: %v", err)
+	}
+	if alerts := listAlerts(t, alertRepo, "silent"); len(alerts) != 1 || alerts[0].DeviceID != "dev-2" {
+		t.Errorf("project rule alerts = %+v, want one for dev-2", alerts)
+	}
+	if alerts := listAlerts(t, alertRepo, "silent-dev-1"); len(alerts) != 1 {
+		t.Errorf("device rule alerts after a second check = %+v, want no duplicate", alerts)
+	}
+
+	*clock = base.Add(17 * time.Minute)
+	process(t, e, "dev-1", 17, 20)
+	if alerts := listAlerts(t, alertRepo, "silent-dev-1"); alerts[0].State != models.AlertStateResolved {
+		t.Errorf("device rule alert after a message = %+v, want resolved", alerts[0])
+	}
+}
+
+func TestEngineResolvedByUser(t *testing.T) {
+	rule := &models.AlertRule{ID: "hot", Kind: models.AlertRuleThreshold, Variable: "temperature", Operator: ">", Threshold: 80}
+	e, alertRepo, _, _ := newTestEngine(t, rule)
+	ctx := context.Background()
+
+	process(t, e, "dev-1", 0, 85)
+	alert := listAlerts(t, alertRepo, "hot")[0]
+	if err := alert.Resolve("ops@example.com", base); err != nil {
+		t.Fatalf("Resolve() unexpected error: %v", err)
+	}
+	if err := alertRepo.UpdateAlert(ctx, alert); err != nil {
+		t.Fatalf("UpdateAlert() unexpected error: %v", err)
+	}
+
+	process(t, e, "dev-1", 1, 86)
+	if alerts := listAlerts(t, alertRepo, "hot"); len(alerts) != 1 {
+		t.Errorf("alerts while the condition still holds = %+v, want only the resolved one", alerts)
+	}
+	process(t, e, "dev-1", 2, 20)
+	process(t, e, "dev-1", 3, 86)
+	if alerts := listAlerts(t, alertRepo, "hot"); len(alerts) != 2 {
+		t.Errorf("alerts after the condition cleared and held again = %+v, want a new one", alerts)
+	}
+
+	// A restarted engine picks the active alert up instead of opening another one
+	restarted := NewEngine(events.NewBus(), alertRepo, repositories.NewMemoryMessageRepository(nil, nil))
+	if err := restarted.Process(ctx, &models.Message{ProjectID: "p1", DeviceID: "dev-1", Timestamp: base.Add(4 * time.Minute), Marshalled: map[string]interface{}{"temperature": 87.0}}); err != nil {
+		t.Fatalf("Process() unexpected error: %v", err)
+	}
+	if alerts := listAlerts(t, alertRepo, "hot"); len(alerts) != 2 || alerts[0].Occurrences != 2 {
+		t.Errorf("alerts after a restart = %+v, want the active one counted again", alerts)
+	}
+}
+
+func TestEngineStart(t *testing.T) {
+	rule := &models.AlertRule{ID: "hot", Kind: models.AlertRuleThreshold, Variable: "temperature", Operator: ">", Threshold: 80}
+	e, alertRepo, _, _ := newTestEngine(t, rule)
+	bus := e.bus
+	created, _ := bus.Subscribe("test", 16)
+
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatalf("Start() unexpected error: %v", err)
+	}
+	bus.Publish(events.MessageEvent{Type: events.MessageUpdated, Message: &models.Message{ProjectID: "p1", DeviceID: "dev-1", Marshalled: map[string]interface{}{"temperature": 99.0}}})
+	bus.Publish(events.MessageEvent{Type: events.MessageCreated, Message: &models.Message{ProjectID: "p1", DeviceID: "dev-1", Marshalled: map[string]interface{}{"temperature": 99.0}}})
+	bus.Publish(events.MessageEvent{Type: events.MessageCreated, Message: &models.Message{ProjectID: "p2", DeviceID: "dev-1", Marshalled: map[string]interface{}{"temperature": 99.0}}})
+
+	// The alert message is published, and is not evaluated
+	deadline := time.After(time.Second)
+	for alertMessage := false; !alertMessage; {
+		select {
+		case event := <-created:
+			alertMessage = event.Message.Type == models.MessageTypeAlert
+		case <-deadline:
+			t.Fatal("no alert message published")
+		}
+	}
+	e.Stop()
+
+	if alerts := listAlerts(t, alertRepo, "hot"); len(alerts) != 1 || alerts[0].ProjectID != "p1" {
+		t.Errorf("alerts = %+v, want one of p1", alerts)
+	}
+}
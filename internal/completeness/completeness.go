@@ -0,0 +1,144 @@
+// Package completeness finds the gaps in the reports of a device and measures how many of
+// its expected reports arrived, reading the report timestamps as a single ordered stream.
+package completeness
+
+import (
+	"math"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+// Analyzer accumulates the report timestamps of a device, which must be added in ascending
+// order, and builds the completeness report of the query range. The range is split into
+// expected intervals starting at From; an interval holding at least one report is received.
+type Analyzer struct {
+	query     models.CompletenessQuery
+	threshold time.Duration // Silences longer than this are gaps
+	slots     int
+	dayStart  time.Time // UTC midnight of the first day of the range
+
+	report   *models.CompletenessReport
+	previous time.Time // Last report, or From before the first one
+	lastSlot int
+}
+
+// NewAnalyzer starts the analysis of a validated query
+func NewAnalyzer(query models.CompletenessQuery) *Analyzer {
+	from, to := query.From.UTC(), query.To.UTC()
+	query.From, query.To = from, to
+	a := &Analyzer{
+		query:     query,
+		threshold: query.Interval + query.EffectiveGrace(),
+		slots:     int((to.Sub(from) + query.Interval - 1) / query.Interval),
+		dayStart:  from.Truncate(24 * time.Hour),
+		previous:  from,
+		lastSlot:  -1,
+		report: &models.CompletenessReport{
+			DeviceID:        query.DeviceID,
+			Channel:         query.Channel,
+			IntervalSeconds: query.Interval.Seconds(),
+			GraceSeconds:    query.EffectiveGrace().Seconds(),
+			From:            from,
+			To:              to,
+			Gaps:            []models.Gap{},
+		},
+	}
+	a.report.Expected = a.slots
+
+	for day := a.dayStart; day.Before(to); day = day.Add(24 * time.Hour) {
+		expected := a.firstSlotFrom(day.Add(24*time.Hour)) - a.firstSlotFrom(day)
+		a.report.Days = append(a.report.Days, models.DayCoverage{Date: day.Format("2006-01-02"), Expected: expected})
+	}
+	return a
+}
+
+// Add records a report; reports outside the range or older than the previous one are ignored
+func (a *Analyzer) Add(at time.Time) {
+	at = at.UTC()
+	if at.Before(a.query.From) || at.After(a.query.To) || at.Before(a.previous) {
+		return
+	}
+	a.report.Messages++
+
+	if at.Sub(a.previous) > a.threshold {
+		a.addGap(a.previous, at, a.report.Messages == 1, false)
+	}
+	a.previous = at
+
+	if slot := a.slotOf(at); slot != a.lastSlot {
+		a.lastSlot = slot
+		a.report.Received++
+		a.report.Days[a.dayOf(slot)].Received++
+	}
+}
+
+// Report closes the range and returns the completeness report
+func (a *Analyzer) Report() *models.CompletenessReport {
+	report := a.report
+	if a.query.To.Sub(a.previous) > a.threshold {
+		a.addGap(a.previous, a.query.To, report.Messages == 0, true)
+	}
+	if report.Messages > 0 {
+		lastSeen := a.previous
+		report.LastSeen = &lastSeen
+	}
+
+	report.Coverage = percentage(report.Received, report.Expected)
+	for i := range report.Days {
+		report.Days[i].Coverage = percentage(report.Days[i].Received, report.Days[i].Expected)
+	}
+	return report
+}
+
+// addGap records the silence between start and end. The expected reports are those of the
+// intervals strictly between both ends, plus the interval of an end that is a range bound.
+func (a *Analyzer) addGap(start, end time.Time, leading, trailing bool) {
+	missing := a.slotOf(end) - a.slotOf(start) - 1
+	if leading {
+		missing++
+	}
+	if trailing {
+		missing++
+	}
+	gap := models.Gap{Start: start, End: end, DurationSeconds: end.Sub(start).Seconds(), Missing: max(missing, 0)}
+
+	a.report.GapCount++
+	if len(a.report.Gaps) < models.MaxCompletenessGaps {
+		a.report.Gaps = append(a.report.Gaps, gap)
+	} else {
+		a.report.GapsTruncated = true
+	}
+	if a.report.LongestOutage == nil || gap.DurationSeconds > a.report.LongestOutage.DurationSeconds {
+		a.report.LongestOutage = &gap
+	}
+}
+
+// slotOf returns the expected interval holding t; the inclusive end of the range belongs
+// to the last interval
+func (a *Analyzer) slotOf(t time.Time) int {
+	return min(int(t.Sub(a.query.From)/a.query.Interval), a.slots-1)
+}
+
+// firstSlotFrom returns the first interval starting at or after t, clamped to the range
+func (a *Analyzer) firstSlotFrom(t time.Time) int {
+	offset := t.Sub(a.query.From)
+	if offset <= 0 {
+		return 0
+	}
+	return min(int((offset+a.query.Interval-1)/a.query.Interval), a.slots)
+}
+
+// dayOf returns the index in Days of the day the interval starts in
+func (a *Analyzer) dayOf(slot int) int {
+	start := a.query.From.Add(time.Duration(slot) * a.query.Interval)
+	return int(start.Sub(a.dayStart) / (24 * time.Hour))
+}
+
+// percentage returns part/total as a percentage rounded to two decimals; nothing expected is complete
+func percentage(part, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return math.Round(float64(part)/float64(total)*10000) / 100
+}
@@ -0,0 +1,110 @@
+package completeness
+
+import (
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+var from = time.Date(2024, 1, 1, 22, 0, 0, 0, time.UTC)
+
+// at returns the time the given number of minutes after from
+func at(minutes int) time.Time {
+	return from.Add(time.Duration(minutes) * time.Minute)
+}
+
+func query() models.CompletenessQuery {
+	return models.CompletenessQuery{DeviceID: "dev-1", Interval: 10 * time.Minute, From: from, To: at(240)}
+}
+
+func TestAnalyzer(t *testing.T) {
+	a := NewAnalyzer(query())
+	// 22:00 to 22:50, silence, 23:30 to 00:30 with a duplicate at 23:31, silence until 02:00
+	for minutes := 0; minutes <= 50; minutes += 10 {
+		a.Add(at(minutes))
+	}
+	for minutes := 90; minutes <= 150; minutes += 10 {
+		a.Add(at(minutes))
+		if minutes == 90 {
+			a.Add(at(91))
+		}
+	}
+	a.Add(at(100)) // Out of order, ignored
+	a.Add(at(300)) // Outside the range, ignored
+
+	report := a.Report()
+	if report.Messages != 14 || report.Expected != 24 || report.Received != 13 || report.Coverage != 54.17 {
+		t.Errorf("Report() messages %d, expected %d, received %d, coverage %v; want 14, 24, 13, 54.17",
+			report.Messages, report.Expected, report.Received, report.Coverage)
+	}
+
+	wantGaps := []models.Gap{
+		{Start: at(50), End: at(90), DurationSeconds: 2400, Missing: 3},
+		{Start: at(150), End: at(240), DurationSeconds: 5400, Missing: 8},
+	}
+	if report.GapCount != len(wantGaps) || len(report.Gaps) != len(wantGaps) {
+		t.Fatalf("Report() gaps = %+v, want %+v", report.Gaps, wantGaps)
+	}
+	for i, want := range wantGaps {
+		if got := report.Gaps[i]; !got.Start.Equal(want.Start) || !got.End.Equal(want.End) || got.DurationSeconds != want.DurationSeconds || got.Missing != want.Missing {
+			t.Errorf("Gaps[%d] = %+v, want %+v", i, got, want)
+		}
+	}
+	if report.LongestOutage == nil || report.LongestOutage.DurationSeconds != 5400 {
+		t.Errorf("LongestOutage = %+v, want the trailing gap", report.LongestOutage)
+	}
+	if report.LastSeen == nil || !report.LastSeen.Equal(at(150)) {
+		t.Errorf("LastSeen = %v, want %v", report.LastSeen, at(150))
+	}
+
+	wantDays := []models.DayCoverage{
+		{Date: "2024-01-01", Expected: 12, Received: 9, Coverage: 75},
+		{Date: "2024-01-02", Expected: 12, Received: 4, Coverage: 33.33},
+	}
+	if len(report.Days) != len(wantDays) {
+		t.Fatalf("Days = %+v, want %+v", report.Days, wantDays)
+	}
+	for i, want := range wantDays {
+		if report.Days[i] != want {
+			t.Errorf("Days[%d] = %+v, want %+v", i, report.Days[i], want)
+		}
+	}
+}
+
+func TestAnalyzerEdges(t *testing.T) {
+	tests := []struct {
+		name        string
+		reports     []time.Time
+		wantMissing []int
+		wantCover   float64
+	}{
+		{name: "no reports", wantMissing: []int{24}, wantCover: 0},
+		{name: "late start", reports: []time.Time{at(30), at(40), at(50)}, wantMissing: []int{3, 18}, wantCover: 12.5},
+		{name: "jitter within grace", reports: []time.Time{at(0), at(14), at(28), at(40)}, wantMissing: []int{19}, wantCover: 16.67},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAnalyzer(query())
+			for _, report := range tt.reports {
+				a.Add(report)
+			}
+			report := a.Report()
+			if len(report.Gaps) != len(tt.wantMissing) {
+				t.Fatalf("Report() gaps = %+v, want %d", report.Gaps, len(tt.wantMissing))
+			}
+			for i, missing := range tt.wantMissing {
+				if report.Gaps[i].Missing != missing {
+					t.Errorf("Gaps[%d].Missing = %d, want %d", i, report.Gaps[i].Missing, missing)
+				}
+			}
+			if report.Coverage != tt.wantCover {
+				t.Errorf("Coverage = %v, want %v", report.Coverage, tt.wantCover)
+			}
+			if (report.LastSeen == nil) != (len(tt.reports) == 0) {
+				t.Errorf("LastSeen = %v", report.LastSeen)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"sit-iot-message-mng-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AggregationController struct {
+	RecomputeJobs services.RecomputeJobs
+}
+
+func NewAggregationController(recomputeJobs services.RecomputeJobs) *AggregationController {
+	return &AggregationController{
+		RecomputeJobs: recomputeJobs,
+	}
+}
+
+// recomputeRequest is the body of an aggregation recompute request
+type recomputeRequest struct {
+	DeviceID  string    `json:"deviceId"`
+	ProjectID string    `json:"projectId"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+}
+
+// StartRecompute starts rebuilding the precomputed aggregations of a device or project
+// from the raw messages and returns the job to poll
+func (ac *AggregationController) StartRecompute(c *gin.Context) {
+	var request recomputeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recompute body"})
+		return
+	}
+
+	job, err := ac.RecomputeJobs.Start(c.Request.Context(), services.AggregationRecomputeOptions{
+		DeviceID:  request.DeviceID,
+		ProjectID: request.ProjectID,
+		From:      request.From,
+		To:        request.To,
+	})
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	c.Header("Location", "/api/admin/aggregations/recompute/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetRecompute returns the status and progress of a recompute job
+func (ac *AggregationController) GetRecompute(c *gin.Context) {
+	job, err := ac.RecomputeJobs.Get(c.Param("jobId"))
+	if err != nil {
+		if errors.Is(err, services.ErrJobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"net/http"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/services"
+	"sit-iot-message-mng-api/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AlertController struct {
+	AlertService services.AlertService
+}
+
+func NewAlertController(alertService services.AlertService) *AlertController {
+	return &AlertController{
+		AlertService: alertService,
+	}
+}
+
+func (ac *AlertController) ListRules(c *gin.Context) {
+	rules, err := ac.AlertService.ListRules(c.Request.Context(), c.Param("projectId"))
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	if rules == nil {
+		rules = []*models.AlertRule{}
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// CreateRule creates an enabled rule from {"name", "deviceId", "kind", "variable", "channel",
+// "operator", "threshold", "forSeconds", "severity", "description"}
+func (ac *AlertController) CreateRule(c *gin.Context) {
+	var rule models.AlertRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert rule body"})
+		return
+	}
+
+	created, err := ac.AlertService.CreateRule(c.Request.Context(), c.Param("projectId"), &rule)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+func (ac *AlertController) GetRule(c *gin.Context) {
+	rule, err := ac.AlertService.GetRule(c.Request.Context(), c.Param("projectId"), c.Param("ruleId"))
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// UpdateRule changes the condition, the target device, the severity or the enabled flag of a rule
+func (ac *AlertController) UpdateRule(c *gin.Context) {
+	var update models.AlertRuleUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid update body"})
+		return
+	}
+
+	updated, err := ac.AlertService.UpdateRule(c.Request.Context(), c.Param("projectId"), c.Param("ruleId"), update)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteRule deletes a rule and resolves its active alerts
+func (ac *AlertController) DeleteRule(c *gin.Context) {
+	if err := ac.AlertService.DeleteRule(c.Request.Context(), c.Param("projectId"), c.Param("ruleId")); err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// alertListFilter is the React Admin filter object accepted by the alert listing
+type alertListFilter struct {
+	DeviceID string              `json:"deviceId"`
+	RuleID   string              `json:"ruleId"`
+	State    []models.AlertState `json:"state"`
+}
+
+// ListAlerts returns the alerts of a project, most recently opened first, paginated with the
+// React Admin range parameter and filtered with e.g. filter={"state":["open","acknowledged"]}
+func (ac *AlertController) ListAlerts(c *gin.Context) {
+	skip, limit, ok := parseRangeParam(c)
+	if !ok {
+		return
+	}
+
+	var params alertListFilter
+	if err := utils.ParseJSON(c.DefaultQuery("filter", "{}"), &params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter parameter"})
+		return
+	}
+	filter := models.AlertFilter{DeviceID: params.DeviceID, RuleID: params.RuleID, States: params.State}
+
+	alerts, total, err := ac.AlertService.ListAlerts(c.Request.Context(), c.Param("projectId"), filter, skip, limit)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	setContentRange(c, skip, len(alerts), total)
+	if alerts == nil {
+		alerts = []*models.Alert{}
+	}
+	c.JSON(http.StatusOK, alerts)
+}
+
+func (ac *AlertController) GetAlert(c *gin.Context) {
+	alert, err := ac.AlertService.GetAlert(c.Request.Context(), c.Param("projectId"), c.Param("alertId"))
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, alert)
+}
+
+func (ac *AlertController) AcknowledgeAlert(c *gin.Context) {
+	alert, err := ac.AlertService.AcknowledgeAlert(c.Request.Context(), c.Param("projectId"), c.Param("alertId"))
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, alert)
+}
+
+func (ac *AlertController) ResolveAlert(c *gin.Context) {
+	alert, err := ac.AlertService.ResolveAlert(c.Request.Context(), c.Param("projectId"), c.Param("alertId"))
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, alert)
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"net/http"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/services"
+	"sit-iot-message-mng-api/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DeviceController struct {
+	DeviceService services.DeviceService
+}
+
+func NewDeviceController(deviceService services.DeviceService) *DeviceController {
+	return &DeviceController{
+		DeviceService: deviceService,
+	}
+}
+
+// deviceListFilter is the React Admin filter object accepted by the device listing
+type deviceListFilter struct {
+	Type     string `json:"type"`
+	Status   string `json:"status"`
+	ClientID string `json:"clientId"`
+}
+
+// ListDevices returns the registered devices of a project, paginated with the React Admin
+// range parameter, sorted with e.g. sort=["createdAt","DESC"] (["name","ASC"] by default) and
+// filtered with e.g. filter={"type":"sensor","status":"online"}
+func (dc *DeviceController) ListDevices(c *gin.Context) {
+	skip, limit, ok := parseRangeParam(c)
+	if !ok {
+		return
+	}
+
+	var sortArr [2]string
+	if err := utils.ParseJSON(c.DefaultQuery("sort", `["name","ASC"]`), &sortArr); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort parameter"})
+		return
+	}
+
+	var params deviceListFilter
+	if err := utils.ParseJSON(c.DefaultQuery("filter", "{}"), &params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter parameter"})
+		return
+	}
+	filter := models.DeviceFilter{Type: params.Type, Status: params.Status, ClientID: params.ClientID}
+
+	devices, total, err := dc.DeviceService.ListDevices(c.Request.Context(), c.Param("projectId"), filter, sortArr[0], sortArr[1], skip, limit)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	setContentRange(c, skip, len(devices), total)
+	if devices == nil {
+		devices = []*models.Device{}
+	}
+	c.JSON(http.StatusOK, devices)
+}
+
+// CreateDevice registers a device from {"name", "clientId", "type", "status", "metadata"}
+func (dc *DeviceController) CreateDevice(c *gin.Context) {
+	var device models.Device
+	if err := c.ShouldBindJSON(&device); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device body"})
+		return
+	}
+
+	created, err := dc.DeviceService.CreateDevice(c.Request.Context(), c.Param("projectId"), &device)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+func (dc *DeviceController) GetDevice(c *gin.Context) {
+	device, err := dc.DeviceService.GetDevice(c.Request.Context(), c.Param("projectId"), c.Param("id"))
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, device)
+}
+
+// UpdateDevice partially updates the name, client ID, type, status and metadata of a device
+func (dc *DeviceController) UpdateDevice(c *gin.Context) {
+	var update models.DeviceUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid update body"})
+		return
+	}
+
+	updated, err := dc.DeviceService.UpdateDevice(c.Request.Context(), c.Param("projectId"), c.Param("id"), update)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteDevice removes a device from the registry and returns the deleted record, as React
+// Admin expects
+func (dc *DeviceController) DeleteDevice(c *gin.Context) {
+	deleted, err := dc.DeviceService.DeleteDevice(c.Request.Context(), c.Param("projectId"), c.Param("id"))
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, deleted)
+}
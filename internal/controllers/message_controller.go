@@ -1,10 +1,17 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	//  "sit-iot-message-mng-api/internal/middleware"
+	"sit-iot-message-mng-api/internal/models"
 	"sit-iot-message-mng-api/internal/services"
 	"sit-iot-message-mng-api/internal/utils"
 
@@ -33,21 +40,164 @@ func (mc *MessageController) GetMessage(c *gin.Context) {
 	c.JSON(http.StatusOK, message)
 }
 
-func (mc *MessageController) ListMessagesByDevice(c *gin.Context) {
-	deviceID := c.Param("deviceId")
+// CreateMessage stores a single message
+func (mc *MessageController) CreateMessage(c *gin.Context) {
+	var message models.Message
+	if err := c.ShouldBindJSON(&message); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message body"})
+		return
+	}
 
-	// Parse query params with defaults
-	rangeParam := c.DefaultQuery("range", "[0,9]")
-	sortParam := c.DefaultQuery("sort", `["timestamp","DESC"]`)
+	created, err := mc.MessageService.CreateMessage(c.Request.Context(), &message)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// CreateMessages stores a batch of messages sent as a JSON array
+func (mc *MessageController) CreateMessages(c *gin.Context) {
+	var messages []*models.Message
+	if err := c.ShouldBindJSON(&messages); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message batch body"})
+		return
+	}
 
-	// Parse range
+	created, err := mc.MessageService.CreateMessages(c.Request.Context(), messages)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdateMessage partially updates the status, processedAt and metadata of a message
+func (mc *MessageController) UpdateMessage(c *gin.Context) {
+	id := c.Param("id")
+
+	var update models.MessageUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid update body"})
+		return
+	}
+
+	updated, err := mc.MessageService.UpdateMessage(c.Request.Context(), id, update)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteMessage deletes a message and returns the deleted record, as React Admin expects
+func (mc *MessageController) DeleteMessage(c *gin.Context) {
+	id := c.Param("id")
+
+	deleted, err := mc.MessageService.DeleteMessage(c.Request.Context(), id)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, deleted)
+}
+
+// maxPageSize caps the number of messages returned per page
+const maxPageSize = 1000
+
+// parseRangeParam reads the React Admin range parameter, [0,9] by default, as skip and limit;
+// it writes a bad request response when the range is invalid
+func parseRangeParam(c *gin.Context) (int, int, bool) {
 	var rangeArr [2]int
-	if err := utils.ParseJSON(rangeParam, &rangeArr); err != nil {
+	if err := utils.ParseJSON(c.DefaultQuery("range", "[0,9]"), &rangeArr); err != nil || rangeArr[0] < 0 || rangeArr[1] < rangeArr[0] || rangeArr[1]-rangeArr[0] >= maxPageSize {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid range parameter"})
+		return 0, 0, false
+	}
+	return rangeArr[0], rangeArr[1] - rangeArr[0] + 1, true
+}
+
+// setContentRange sets the React Admin Content-Range header of a page of count items
+func setContentRange(c *gin.Context, skip, count, total int) {
+	c.Header("Content-Range", fmt.Sprintf("items %d-%d/%d", skip, skip+count-1, total))
+}
+
+// messageListFilter is the React Admin filter object accepted by message listings
+type messageListFilter struct {
+	Type   models.MessageType   `json:"type"`
+	Status models.MessageStatus `json:"status"`
+	Topic  string               `json:"topic"` // Exact topic or MQTT pattern with + and # wildcards
+	From   *time.Time           `json:"from"`
+	To     *time.Time           `json:"to"`
+}
+
+// parseMessageFilter parses the React Admin filter query parameter
+func parseMessageFilter(c *gin.Context) (models.MessageFilter, error) {
+	var params messageListFilter
+	if err := utils.ParseJSON(c.DefaultQuery("filter", "{}"), &params); err != nil {
+		return models.MessageFilter{}, err
+	}
+
+	filter := models.MessageFilter{
+		Type:         params.Type,
+		Status:       params.Status,
+		TopicPattern: params.Topic,
+		FromTime:     params.From,
+		ToTime:       params.To,
+	}
+	return filter, filter.Validate()
+}
+
+// writeServiceError maps a service error to the matching HTTP status
+func writeServiceError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrInvalidQuery),
+		errors.Is(err, services.ErrInvalidMessage),
+		errors.Is(err, services.ErrInvalidMessageID),
+		errors.Is(err, services.ErrInvalidWebhook),
+		errors.Is(err, services.ErrInvalidAlertRule),
+		errors.Is(err, services.ErrInvalidDevice):
+		status = http.StatusBadRequest
+	case errors.Is(err, services.ErrMessageNotFound),
+		errors.Is(err, services.ErrWebhookNotFound),
+		errors.Is(err, services.ErrAlertRuleNotFound),
+		errors.Is(err, services.ErrAlertNotFound),
+		errors.Is(err, services.ErrPresenceNotFound),
+		errors.Is(err, services.ErrDeviceNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, services.ErrAlertStateConflict),
+		errors.Is(err, services.ErrDeviceClientIDTaken):
+		status = http.StatusConflict
+	case errors.Is(err, services.ErrUnauthenticated):
+		status = http.StatusUnauthorized
+	case errors.Is(err, services.ErrForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, services.ErrStreamUnavailable):
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+func (mc *MessageController) ListMessagesByDevice(c *gin.Context) {
+	deviceID := c.Param("deviceId")
+
+	// Cursor (keyset) pagination is used when a cursor parameter is present; an empty
+	// cursor requests the first page. Otherwise the React Admin range contract applies.
+	if cursorParam, ok := c.GetQuery("cursor"); ok {
+		mc.listMessagesByDeviceCursor(c, deviceID, cursorParam)
 		return
 	}
-	skip := rangeArr[0]
-	limit := rangeArr[1] - rangeArr[0] + 1
+
+	// Parse query params with defaults
+	skip, limit, ok := parseRangeParam(c)
+	if !ok {
+		return
+	}
+	sortParam := c.DefaultQuery("sort", `["timestamp","DESC"]`)
 
 	// Parse sort
 	var sortArr [2]string
@@ -58,35 +208,435 @@ func (mc *MessageController) ListMessagesByDevice(c *gin.Context) {
 	sortField := sortArr[0]
 	sortOrder := sortArr[1]
 
-	messages, total, err := mc.MessageService.ListMessagesByDeviceID(c.Request.Context(), deviceID, nil, sortField, sortOrder, skip, limit)
+	// Parse filter
+	filter, err := parseMessageFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter parameter"})
+		return
+	}
+
+	messages, total, err := mc.MessageService.ListMessagesByDeviceID(c.Request.Context(), deviceID, filter, sortField, sortOrder, skip, limit)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	setContentRange(c, skip, len(messages), total)
+	if messages == nil {
+		messages = []*models.Message{}
+	}
+	c.JSON(http.StatusOK, messages)
+}
+
+// listMessagesByDeviceCursor serves a page of device messages in cursor mode and returns
+// the cursor of the next page in the X-Next-Cursor header (empty on the last page)
+func (mc *MessageController) listMessagesByDeviceCursor(c *gin.Context, deviceID, cursorParam string) {
+	var cursor *models.MessageCursor
+	if cursorParam != "" {
+		decoded, err := models.DecodeMessageCursor(cursorParam)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor parameter"})
+			return
+		}
+		cursor = decoded
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+		return
+	}
+
+	// Cursor pages are always ordered by timestamp; only the direction can be chosen
+	var sortArr [2]string
+	if err := utils.ParseJSON(c.DefaultQuery("sort", `["timestamp","DESC"]`), &sortArr); err != nil || sortArr[0] != "timestamp" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort parameter"})
+		return
+	}
+
+	filter, err := parseMessageFilter(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter parameter"})
 		return
 	}
 
-	// Set Content-Range header
-	end := skip + len(messages) - 1
-	if len(messages) == 0 {
-		end = skip - 1
+	messages, next, err := mc.MessageService.ListMessagesByDeviceIDCursor(c.Request.Context(), deviceID, filter, cursor, sortArr[1], limit)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	nextCursor := ""
+	if next != nil {
+		nextCursor = next.Encode()
 	}
-	contentRange := fmt.Sprintf("items %d-%d/%d", skip, end, total)
-	c.Header("Content-Range", contentRange)
+	if messages == nil {
+		messages = []*models.Message{}
+	}
+	c.Header("X-Next-Cursor", nextCursor)
 	c.JSON(http.StatusOK, messages)
 }
 
-// GetAggregatedDataByDevice returns aggregated data for a device for graphing max, min, avg
+// streamHeartbeatInterval is how often a stream sends a heartbeat event, so clients can tell
+// an idle stream from a dead connection and proxies keep it open
+const streamHeartbeatInterval = 15 * time.Second
+
+// StreamMessagesByDevice pushes the messages of a device as Server-Sent Events as they are
+// stored. The type (comma-separated) and topic (exact topic or MQTT pattern) parameters filter
+// them. Every message event carries a cursor as its ID; a client reconnecting with it in the
+// Last-Event-ID header (or the lastEventId parameter) first receives the messages it missed.
+func (mc *MessageController) StreamMessagesByDevice(c *gin.Context) {
+	var after *models.MessageCursor
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+	if lastEventID != "" {
+		decoded, err := models.DecodeMessageCursor(lastEventID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+			return
+		}
+		after = decoded
+	}
+
+	filter := models.MessageFilter{TopicPattern: c.Query("topic")}
+	for _, messageType := range splitListParam(c, "type") {
+		filter.Types = append(filter.Types, models.MessageType(messageType))
+	}
+
+	ctx := c.Request.Context()
+	stream, err := mc.MessageService.StreamMessagesByDeviceID(ctx, c.Param("deviceId"), filter, after)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Disables response buffering in nginx
+	c.Status(http.StatusOK)
+	fmt.Fprint(c.Writer, "retry: 5000\n\n") // Clients reconnect after 5 seconds
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-stream:
+			if !ok {
+				return
+			}
+			if event.Truncated {
+				writeServerSentEvent(c, "", "truncated", gin.H{"limit": services.StreamMaxReplay})
+				continue
+			}
+			writeServerSentEvent(c, models.CursorFromMessage(event.Message).Encode(), "message", event.Message)
+		case now := <-heartbeat.C:
+			writeServerSentEvent(c, "", "heartbeat", gin.H{"time": now.UTC()})
+		}
+	}
+}
+
+// writeServerSentEvent writes one event with a JSON payload and flushes it to the client
+func writeServerSentEvent(c *gin.Context, id, event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", event, err)
+		return
+	}
+	if id != "" {
+		fmt.Fprintf(c.Writer, "id: %s\n", id)
+	}
+	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event, payload)
+	c.Writer.Flush()
+}
+
+// defaultAggregationWindow is the time range aggregated on demand when from is not given
+const defaultAggregationWindow = 24 * time.Hour
+
+// GetAggregatedDataByDevice returns aggregated data for a device for graphing max, min, avg.
+// The channel, variable and period parameters take comma-separated lists and from/to bound
+// the bucket timestamps. With a bucket parameter the aggregations are computed on demand
+// from the raw messages instead. format=series groups the result per channel and variable.
 func (mc *MessageController) GetAggregatedDataByDevice(c *gin.Context) {
 	deviceID := c.Param("deviceId")
 
-	aggregations, err := mc.MessageService.GetAggregatedDataByDeviceID(c.Request.Context(), deviceID)
+	format := c.DefaultQuery("format", aggregationFormatList)
+	if format != aggregationFormatList && format != aggregationFormatSeries {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format parameter"})
+		return
+	}
+
+	stats := c.DefaultQuery("stats", aggregationStatsBasic)
+	if stats != aggregationStatsBasic && stats != aggregationStatsExtended {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stats parameter"})
+		return
+	}
+	extended := stats == aggregationStatsExtended
+
+	if bucket := c.Query("bucket"); bucket != "" {
+		mc.aggregateMessagesByDevice(c, deviceID, models.BucketSize(bucket), format, extended)
+		return
+	}
+
+	from, err := parseTimeParam(c, "from")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from parameter"})
+		return
+	}
+	to, err := parseTimeParam(c, "to")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to parameter"})
+		return
+	}
+
+	filter := models.AggregationFilter{
+		Channels:  splitListParam(c, "channel"),
+		Variables: splitListParam(c, "variable"),
+		Periods:   splitListParam(c, "period"),
+		FromTime:  from,
+		ToTime:    to,
+		Extended:  extended,
+	}
+	aggregations, err := mc.MessageService.GetAggregatedDataByDeviceID(c.Request.Context(), deviceID, filter)
+	if err != nil {
+		writeServiceError(c, err)
 		return
 	}
 
 	response := gin.H{
 		"device_id":    deviceID,
-		"aggregations": aggregations,
+		"aggregations": formatAggregations(aggregations, format),
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Aggregation response formats
+const (
+	aggregationFormatList   = "list"   // Flat list of buckets ordered by timestamp
+	aggregationFormatSeries = "series" // channel -> variable -> points ordered by timestamp
+)
+
+// Aggregation statistics levels
+const (
+	aggregationStatsBasic    = "basic"    // min, max, avg, sum and count
+	aggregationStatsExtended = "extended" // also percentiles, standard deviation and first/last values
+)
+
+// formatAggregations shapes aggregation buckets for the requested response format
+func formatAggregations(aggregations []*models.AggregatedData, format string) interface{} {
+	if format == aggregationFormatSeries {
+		return models.NewAggregationSeries(aggregations)
+	}
+	if aggregations == nil {
+		return []*models.AggregatedData{}
+	}
+	return aggregations
+}
+
+// ListTopicRules returns the topic classification rules in evaluation order
+func (mc *MessageController) ListTopicRules(c *gin.Context) {
+	c.JSON(http.StatusOK, mc.MessageService.TopicRules())
+}
+
+// PreviewTopicRules tests the topic query parameter against the topic rules and returns
+// the type and fields a message published on it would get
+func (mc *MessageController) PreviewTopicRules(c *gin.Context) {
+	topic := c.Query("topic")
+	if topic == "" || models.IsTopicWildcard(topic) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "topic parameter must be a topic without wildcards"})
+		return
+	}
+
+	response := gin.H{
+		"topic":   topic,
+		"matched": false,
+		"type":    models.MessageTypeUnknown,
+	}
+	if match := mc.MessageService.ClassifyTopic(topic); match != nil {
+		response["matched"] = true
+		response["rule"] = match.Rule
+		response["type"] = match.Type
+		response["fields"] = match.Fields
 	}
 	c.JSON(http.StatusOK, response)
 }
+
+// aggregateMessagesByDevice serves on-demand aggregations for the from/to range (RFC 3339,
+// defaulting to the last 24 hours) and the optional comma-separated fields parameter
+func (mc *MessageController) aggregateMessagesByDevice(c *gin.Context, deviceID string, bucket models.BucketSize, format string, extended bool) {
+	from, to, ok := parseTimeRange(c, defaultAggregationWindow)
+	if !ok {
+		return
+	}
+
+	query := models.AggregationQuery{DeviceID: deviceID, From: from, To: to, Bucket: bucket, Fields: splitListParam(c, "fields"), Extended: extended}
+	aggregations, err := mc.MessageService.AggregateMessagesByDeviceID(c.Request.Context(), query)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_id":    deviceID,
+		"bucket":       bucket,
+		"from":         from,
+		"to":           to,
+		"aggregations": formatAggregations(aggregations, format),
+	})
+}
+
+// defaultSeriesPoints is the number of points of a time series when none is requested
+const defaultSeriesPoints = 1000
+
+// GetDeviceSeries returns the values of one variable of a device downsampled for charting
+func (mc *MessageController) GetDeviceSeries(c *gin.Context) {
+	from, to, ok := parseTimeRange(c, defaultAggregationWindow)
+	if !ok {
+		return
+	}
+	points, err := strconv.Atoi(c.DefaultQuery("points", strconv.Itoa(defaultSeriesPoints)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid points parameter"})
+		return
+	}
+
+	series, err := mc.MessageService.GetDeviceSeries(c.Request.Context(), models.SeriesQuery{
+		DeviceID: c.Param("deviceId"),
+		Variable: c.Query("variable"),
+		Channel:  c.Query("channel"),
+		From:     from,
+		To:       to,
+		Points:   points,
+		Method:   models.DownsampleMethod(c.DefaultQuery("method", string(models.DownsampleLTTB))),
+	})
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, series)
+}
+
+// CompareDevices returns one variable of several devices aligned on the same buckets. The
+// devices are given as a comma-separated devices parameter or as every device of a project;
+// period selects stored rollups and bucket on-demand aggregation. summary=true adds the
+// mean and spread of the device averages per bucket.
+func (mc *MessageController) CompareDevices(c *gin.Context) {
+	from, to, ok := parseTimeRange(c, defaultAggregationWindow)
+	if !ok {
+		return
+	}
+	summary, err := strconv.ParseBool(c.DefaultQuery("summary", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid summary parameter"})
+		return
+	}
+	stats := c.DefaultQuery("stats", aggregationStatsBasic)
+	if stats != aggregationStatsBasic && stats != aggregationStatsExtended {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stats parameter"})
+		return
+	}
+
+	comparison, err := mc.MessageService.CompareDevices(c.Request.Context(), models.ComparisonQuery{
+		DeviceIDs: splitListParam(c, "devices"),
+		ProjectID: c.Query("project"),
+		Variable:  c.Query("variable"),
+		Channel:   c.Query("channel"),
+		Period:    models.RollupPeriod(c.Query("period")),
+		Bucket:    models.BucketSize(c.Query("bucket")),
+		From:      from,
+		To:        to,
+		Summary:   summary,
+		Extended:  stats == aggregationStatsExtended,
+	})
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, comparison)
+}
+
+// CheckDeviceCompleteness reports the gaps and daily coverage of the reports of a device
+// against the expected reporting interval (a duration such as 30s or 5m); grace, defaulting
+// to half the interval, is the extra delay tolerated before a silence counts as a gap
+func (mc *MessageController) CheckDeviceCompleteness(c *gin.Context) {
+	from, to, ok := parseTimeRange(c, defaultAggregationWindow)
+	if !ok {
+		return
+	}
+	interval, err := time.ParseDuration(c.Query("interval"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interval parameter"})
+		return
+	}
+	var grace time.Duration
+	if param := c.Query("grace"); param != "" {
+		if grace, err = time.ParseDuration(param); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid grace parameter"})
+			return
+		}
+	}
+
+	report, err := mc.MessageService.CheckCompleteness(c.Request.Context(), models.CompletenessQuery{
+		DeviceID: c.Param("deviceId"),
+		Channel:  c.Query("channel"),
+		Interval: interval,
+		Grace:    grace,
+		From:     from,
+		To:       to,
+	})
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// parseTimeRange reads the from and to parameters; to defaults to now and from to window
+// before to. It writes a 400 response and returns false when either is invalid.
+func parseTimeRange(c *gin.Context, window time.Duration) (time.Time, time.Time, bool) {
+	to := time.Now().UTC()
+	if parsed, err := parseTimeParam(c, "to"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to parameter"})
+		return time.Time{}, time.Time{}, false
+	} else if parsed != nil {
+		to = *parsed
+	}
+	from := to.Add(-window)
+	if parsed, err := parseTimeParam(c, "from"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from parameter"})
+		return time.Time{}, time.Time{}, false
+	} else if parsed != nil {
+		from = *parsed
+	}
+	return from, to, true
+}
+
+// parseTimeParam parses an optional RFC 3339 query parameter; nil means it was not given
+func parseTimeParam(c *gin.Context, name string) (*time.Time, error) {
+	param := c.Query(name)
+	if param == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, param)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// splitListParam splits an optional comma-separated query parameter
+func splitListParam(c *gin.Context, name string) []string {
+	param := c.Query(name)
+	if param == "" {
+		return nil
+	}
+	return strings.Split(param, ",")
+}
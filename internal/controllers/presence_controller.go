@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"net/http"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/services"
+	"sit-iot-message-mng-api/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PresenceController struct {
+	PresenceService services.PresenceService
+}
+
+func NewPresenceController(presenceService services.PresenceService) *PresenceController {
+	return &PresenceController{
+		PresenceService: presenceService,
+	}
+}
+
+// presenceListFilter is the React Admin filter object accepted by the presence listing
+type presenceListFilter struct {
+	State models.PresenceState `json:"state"`
+}
+
+// ListPresence returns the presence of the devices of a project, most recently seen first,
+// paginated with the React Admin range parameter and filtered with e.g. filter={"state":"offline"}
+func (pc *PresenceController) ListPresence(c *gin.Context) {
+	skip, limit, ok := parseRangeParam(c)
+	if !ok {
+		return
+	}
+
+	var params presenceListFilter
+	if err := utils.ParseJSON(c.DefaultQuery("filter", "{}"), &params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter parameter"})
+		return
+	}
+
+	presences, total, err := pc.PresenceService.ListPresence(c.Request.Context(), c.Param("projectId"), params.State, skip, limit)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	setContentRange(c, skip, len(presences), total)
+	if presences == nil {
+		presences = []*models.DevicePresence{}
+	}
+	c.JSON(http.StatusOK, presences)
+}
+
+func (pc *PresenceController) GetPresence(c *gin.Context) {
+	presence, err := pc.PresenceService.GetPresence(c.Request.Context(), c.Param("projectId"), c.Param("deviceId"))
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, presence)
+}
+
+// ListTransitions returns the presence history of a device, newest first, paginated with the
+// React Admin range parameter and optionally restricted with the RFC3339 from and to parameters
+func (pc *PresenceController) ListTransitions(c *gin.Context) {
+	skip, limit, ok := parseRangeParam(c)
+	if !ok {
+		return
+	}
+	from, err := parseTimeParam(c, "from")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from parameter"})
+		return
+	}
+	to, err := parseTimeParam(c, "to")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to parameter"})
+		return
+	}
+
+	transitions, total, err := pc.PresenceService.ListTransitions(c.Request.Context(), c.Param("projectId"), c.Param("deviceId"), from, to, skip, limit)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	setContentRange(c, skip, len(transitions), total)
+	if transitions == nil {
+		transitions = []*models.PresenceTransition{}
+	}
+	c.JSON(http.StatusOK, transitions)
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"net/http"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookController struct {
+	WebhookService services.WebhookService
+}
+
+func NewWebhookController(webhookService services.WebhookService) *WebhookController {
+	return &WebhookController{
+		WebhookService: webhookService,
+	}
+}
+
+// ListWebhooks returns the webhooks of a project, without their secrets
+func (wc *WebhookController) ListWebhooks(c *gin.Context) {
+	webhooks, err := wc.WebhookService.ListWebhooks(c.Request.Context(), c.Param("projectId"))
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	if webhooks == nil {
+		webhooks = []*models.Webhook{}
+	}
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// CreateWebhook creates a webhook from {"url", "secret", "description", "filter"} and returns
+// it with its secret, which is not returned afterwards
+func (wc *WebhookController) CreateWebhook(c *gin.Context) {
+	var webhook models.Webhook
+	if err := c.ShouldBindJSON(&webhook); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook body"})
+		return
+	}
+
+	created, err := wc.WebhookService.CreateWebhook(c.Request.Context(), c.Param("projectId"), &webhook)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+func (wc *WebhookController) GetWebhook(c *gin.Context) {
+	webhook, err := wc.WebhookService.GetWebhook(c.Request.Context(), c.Param("projectId"), c.Param("webhookId"))
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, webhook)
+}
+
+// UpdateWebhook changes the url, secret, description, filter or active flag of a webhook
+func (wc *WebhookController) UpdateWebhook(c *gin.Context) {
+	var update models.WebhookUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid update body"})
+		return
+	}
+
+	updated, err := wc.WebhookService.UpdateWebhook(c.Request.Context(), c.Param("projectId"), c.Param("webhookId"), update)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+func (wc *WebhookController) DeleteWebhook(c *gin.Context) {
+	if err := wc.WebhookService.DeleteWebhook(c.Request.Context(), c.Param("projectId"), c.Param("webhookId")); err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListDeliveries returns the deliveries of a webhook, newest first, paginated with the
+// React Admin range parameter and optionally restricted to a status
+func (wc *WebhookController) ListDeliveries(c *gin.Context) {
+	skip, limit, ok := parseRangeParam(c)
+	if !ok {
+		return
+	}
+
+	status := models.WebhookDeliveryStatus(c.Query("status"))
+	deliveries, total, err := wc.WebhookService.ListDeliveries(c.Request.Context(), c.Param("projectId"), c.Param("webhookId"), status, skip, limit)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+
+	setContentRange(c, skip, len(deliveries), total)
+	if deliveries == nil {
+		deliveries = []*models.WebhookDelivery{}
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"sit-iot-message-mng-api/config"
+	"sit-iot-message-mng-api/internal/hub"
+	"sit-iot-message-mng-api/internal/middleware"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsWriteWait bounds the time spent writing a frame to a client
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is how long a client may stay silent before the connection is closed; it
+	// must answer the pings sent every wsPingPeriod
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+	// wsMaxFrameSize caps the size of the frames read from a client
+	wsMaxFrameSize = 64 * 1024
+	// wsDeliveryBuffer is the number of messages queued for a slow client before further
+	// messages are dropped
+	wsDeliveryBuffer = 256
+)
+
+// WebSocket frame types
+const (
+	wsFrameSubscribe    = "subscribe"
+	wsFrameUnsubscribe  = "unsubscribe"
+	wsFrameSubscribed   = "subscribed"
+	wsFrameUnsubscribed = "unsubscribed"
+	wsFrameMessage      = "message"
+	wsFrameDropped      = "dropped"
+	wsFrameError        = "error"
+)
+
+// wsFrame is a JSON frame of the subscription WebSocket, in either direction
+type wsFrame struct {
+	Type          string                `json:"type"`
+	ID            string                `json:"id,omitempty"`            // Subscription ID chosen by the client
+	Filter        *models.MessageFilter `json:"filter,omitempty"`        // Criteria of a subscribe frame
+	Subscriptions []string              `json:"subscriptions,omitempty"` // Subscriptions matched by a message
+	Message       *models.Message       `json:"message,omitempty"`
+	Count         int64                 `json:"count,omitempty"` // Messages dropped because the client was too slow
+	Error         string                `json:"error,omitempty"`
+}
+
+type WebSocketController struct {
+	MessageService services.MessageService
+	Hub            hub.Hub
+	Config         *config.Config
+	upgrader       websocket.Upgrader
+}
+
+func NewWebSocketController(messageService services.MessageService, messageHub hub.Hub, cfg *config.Config) *WebSocketController {
+	wc := &WebSocketController{
+		MessageService: messageService,
+		Hub:            messageHub,
+		Config:         cfg,
+	}
+	wc.upgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024, CheckOrigin: wc.checkOrigin}
+	return wc
+}
+
+// checkOrigin accepts the browser origins allowed by CORS and clients sending no Origin
+func (wc *WebSocketController) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range wc.Config.AllowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe upgrades the request to a WebSocket on which the client sends subscribe frames
+// ({"type":"subscribe","id","filter"} with a models.MessageFilter) and unsubscribe frames
+// ({"type":"unsubscribe","id"}), and receives every created message matching any of its
+// subscriptions once, as {"type":"message","subscriptions","message"}. Each subscription is
+// authorized like a REST request: the token of the connection is verified again.
+func (wc *WebSocketController) Subscribe(c *gin.Context) {
+	conn, err := wc.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already replied with an HTTP error
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	client := wc.Hub.Connect(wsDeliveryBuffer)
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	replies := make(chan wsFrame, 16)
+	go wc.readFrames(ctx, cancel, conn, client, replies)
+	wc.writeFrames(ctx, conn, client, replies)
+}
+
+// readFrames handles the frames of the client until the connection fails or closes
+func (wc *WebSocketController) readFrames(ctx context.Context, cancel func(), conn *websocket.Conn, client hub.Client, replies chan<- wsFrame) {
+	defer cancel()
+
+	conn.SetReadLimit(wsMaxFrameSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("WebSocket read failed: %v", err)
+			}
+			return
+		}
+
+		var frame wsFrame
+		reply := wsFrame{Type: wsFrameError, Error: "invalid frame"}
+		if err := json.Unmarshal(data, &frame); err == nil {
+			reply = wc.handleFrame(ctx, client, frame)
+		}
+		select {
+		case replies <- reply:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleFrame applies a subscribe or unsubscribe frame and returns the reply
+func (wc *WebSocketController) handleFrame(ctx context.Context, client hub.Client, frame wsFrame) wsFrame {
+	fail := func(message string) wsFrame {
+		return wsFrame{Type: wsFrameError, ID: frame.ID, Error: message}
+	}
+	if frame.ID == "" {
+		return fail("id is required")
+	}
+
+	switch frame.Type {
+	case wsFrameSubscribe:
+		if frame.Filter == nil {
+			return fail("filter is required")
+		}
+		token, _ := ctx.Value(middleware.TokenKey).(string)
+		if err := middleware.VerifyIDToken(wc.Config, token); err != nil {
+			if errors.Is(err, middleware.ErrInvalidToken) {
+				return fail("Invalid or expired token")
+			}
+			return fail("Failed to verify token")
+		}
+		if err := wc.MessageService.AuthorizeSubscription(ctx, *frame.Filter); err != nil {
+			return fail(err.Error())
+		}
+		if err := client.Subscribe(frame.ID, *frame.Filter); err != nil {
+			return fail(err.Error())
+		}
+		return wsFrame{Type: wsFrameSubscribed, ID: frame.ID}
+	case wsFrameUnsubscribe:
+		if !client.Unsubscribe(frame.ID) {
+			return fail("unknown subscription")
+		}
+		return wsFrame{Type: wsFrameUnsubscribed, ID: frame.ID}
+	default:
+		return fail("unsupported frame type")
+	}
+}
+
+// writeFrames is the only writer of the connection: it sends the replies, the deliveries and
+// the pings until the context is done, then closes the connection
+func (wc *WebSocketController) writeFrames(ctx context.Context, conn *websocket.Conn, client hub.Client, replies <-chan wsFrame) {
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	write := func(frame wsFrame) bool {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := conn.WriteJSON(frame); err != nil {
+			log.Printf("WebSocket write failed: %v", err)
+			return false
+		}
+		return true
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(wsWriteWait))
+			return
+		case reply := <-replies:
+			if !write(reply) {
+				return
+			}
+		case delivery, ok := <-client.Deliveries():
+			if !ok {
+				return
+			}
+			if dropped := client.TakeDropped(); dropped > 0 && !write(wsFrame{Type: wsFrameDropped, Count: dropped}) {
+				return
+			}
+			if !write(wsFrame{Type: wsFrameMessage, Subscriptions: delivery.SubscriptionIDs, Message: delivery.Message}) {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
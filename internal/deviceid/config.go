@@ -0,0 +1,90 @@
+package deviceid
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// StrategyConfig configures one extraction strategy of a rules file
+type StrategyConfig struct {
+	Type     string            `json:"type"`               // template, regex, topicSegment, topicRule or registry
+	Source   string            `json:"source,omitempty"`   // clientId (default) or topic, for template and regex
+	Template string            `json:"template,omitempty"` // template strategy, e.g. "{project}:{gateway}:{device}"
+	Output   string            `json:"output,omitempty"`   // template strategy, e.g. "{device}"
+	Pattern  string            `json:"pattern,omitempty"`  // regex strategy
+	Level    int               `json:"level,omitempty"`    // topicSegment strategy
+	Devices  map[string]string `json:"devices,omitempty"`  // registry strategy: static client ID to device ID map
+}
+
+// ruleFile is the layout of a device ID rules configuration file
+type ruleFile struct {
+	Strategies []StrategyConfig `json:"strategies"`
+}
+
+// ErrNoRegistry is returned when a registry strategy has neither static devices nor a registry to use
+var ErrNoRegistry = errors.New("no device registry configured")
+
+// DefaultStrategies returns the strategies used when no rules file is configured: the
+// deviceId captured by the topic rules, then the client ID
+func DefaultStrategies() []Strategy {
+	return []Strategy{NewTopicRuleStrategy()}
+}
+
+// NewStrategy builds a strategy from its configuration. registry backs registry strategies
+// without static devices and may be nil.
+func NewStrategy(cfg StrategyConfig, registry Registry) (Strategy, error) {
+	source := cfg.Source
+	if source == "" {
+		source = SourceClientID
+	}
+
+	switch cfg.Type {
+	case "template":
+		return NewTemplateStrategy(source, cfg.Template, cfg.Output)
+	case "regex":
+		return NewRegexStrategy(source, cfg.Pattern)
+	case "topicSegment":
+		return NewTopicSegmentStrategy(cfg.Level)
+	case "topicRule":
+		return NewTopicRuleStrategy(), nil
+	case "registry":
+		if cfg.Devices != nil {
+			return NewRegistryStrategy(MapRegistry(cfg.Devices)), nil
+		}
+		if registry == nil {
+			return nil, ErrNoRegistry
+		}
+		return NewRegistryStrategy(registry), nil
+	default:
+		return nil, fmt.Errorf("unknown strategy type %q", cfg.Type)
+	}
+}
+
+// LoadResolver builds a resolver from the JSON rules file at path, of the form
+// {"strategies": [{"type": ...}]}, or from DefaultStrategies when path is empty
+func LoadResolver(path string, registry Registry) (Resolver, error) {
+	if path == "" {
+		return NewResolver(DefaultStrategies()...), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device ID rules: %w", err)
+	}
+	var file ruleFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse device ID rules %s: %w", path, err)
+	}
+
+	strategies := make([]Strategy, 0, len(file.Strategies))
+	for i, cfg := range file.Strategies {
+		strategy, err := NewStrategy(cfg, registry)
+		if err != nil {
+			return nil, fmt.Errorf("device ID strategy %d: %w", i, err)
+		}
+		strategies = append(strategies, strategy)
+	}
+	return NewResolver(strategies...), nil
+}
@@ -0,0 +1,47 @@
+package deviceid
+
+import (
+	"context"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+// Source holds the message data a device ID can be derived from
+type Source struct {
+	ClientID string
+	Topic    string
+	Captures map[string]string // Named captures of the topic rule matching the topic
+}
+
+// Strategy derives a device ID from a message; ok is false when the strategy does not apply
+type Strategy interface {
+	Extract(ctx context.Context, src Source) (deviceID string, ok bool, err error)
+}
+
+// Resolver derives the device ID of a message by trying its strategies in order
+type Resolver interface {
+	Resolve(ctx context.Context, src Source) (string, error)
+}
+
+type resolver struct {
+	strategies []Strategy
+}
+
+// NewResolver returns a resolver trying the strategies in order. When none applies the
+// device ID falls back to models.GetDeviceIDFromClientID.
+func NewResolver(strategies ...Strategy) Resolver {
+	return &resolver{strategies: strategies}
+}
+
+func (r *resolver) Resolve(ctx context.Context, src Source) (string, error) {
+	for _, strategy := range r.strategies {
+		deviceID, ok, err := strategy.Extract(ctx, src)
+		if err != nil {
+			return "", err
+		}
+		if ok && deviceID != "" {
+			return deviceID, nil
+		}
+	}
+	return models.GetDeviceIDFromClientID(src.ClientID), nil
+}
@@ -0,0 +1,145 @@
+package deviceid
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mustStrategy(strategy Strategy, err error) Strategy {
+	if err != nil {
+		panic(err)
+	}
+	return strategy
+}
+
+func TestResolver(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		strategy Strategy
+		src      Source
+		want     string
+	}{
+		{
+			name:     "template",
+			strategy: mustStrategy(NewTemplateStrategy(SourceClientID, "{project}:{gateway}:{device}", "{gateway}/{device}")),
+			src:      Source{ClientID: "proj-42:gw-07:sensor-3"},
+			want:     "gw-07/sensor-3",
+		},
+		{
+			name:     "template not matching falls back to client ID",
+			strategy: mustStrategy(NewTemplateStrategy(SourceClientID, "{project}:{gateway}:{device}", "{device}")),
+			src:      Source{ClientID: "shelly-1234"},
+			want:     "shelly-1234",
+		},
+		{
+			name:     "regex named group on topic",
+			strategy: mustStrategy(NewRegexStrategy(SourceTopic, `^site/(?P<site>[^/]+)/(?P<deviceId>[^/]+)/`)),
+			src:      Source{ClientID: "c", Topic: "site/s1/dev-1/telemetry"},
+			want:     "dev-1",
+		},
+		{
+			name:     "regex first group",
+			strategy: mustStrategy(NewRegexStrategy(SourceClientID, `^shelly-(\w+)$`)),
+			src:      Source{ClientID: "shelly-1234"},
+			want:     "1234",
+		},
+		{
+			name:     "topic segment",
+			strategy: mustStrategy(NewTopicSegmentStrategy(1)),
+			src:      Source{ClientID: "c", Topic: "site/dev-1/telemetry"},
+			want:     "dev-1",
+		},
+		{
+			name:     "topic segment out of range",
+			strategy: mustStrategy(NewTopicSegmentStrategy(5)),
+			src:      Source{ClientID: "c", Topic: "site/dev-1"},
+			want:     "c",
+		},
+		{
+			name:     "topic rule capture",
+			strategy: NewTopicRuleStrategy(),
+			src:      Source{ClientID: "c", Captures: map[string]string{"deviceId": "dev-1"}},
+			want:     "dev-1",
+		},
+		{
+			name:     "registry",
+			strategy: NewRegistryStrategy(MapRegistry{"c": "dev-9"}),
+			src:      Source{ClientID: "c"},
+			want:     "dev-9",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewResolver(tt.strategy).Resolve(ctx, tt.src)
+			if err != nil {
+				t.Fatalf("Resolve() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+type failingRegistry struct{}
+
+func (failingRegistry) LookupDeviceID(ctx context.Context, clientID string) (string, bool, error) {
+	return "", false, errors.New("registry unavailable")
+}
+
+func TestResolverReturnsRegistryErrors(t *testing.T) {
+	resolver := NewResolver(NewRegistryStrategy(failingRegistry{}))
+	if _, err := resolver.Resolve(context.Background(), Source{ClientID: "c"}); err == nil {
+		t.Errorf("Resolve() expected error when the registry fails")
+	}
+}
+
+func TestNewStrategyRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  StrategyConfig
+	}{
+		{"unknown type", StrategyConfig{Type: "guess"}},
+		{"bad source", StrategyConfig{Type: "regex", Source: "payload", Pattern: "(.*)"}},
+		{"regex without group", StrategyConfig{Type: "regex", Pattern: "abc"}},
+		{"template without placeholders", StrategyConfig{Type: "template", Template: "abc", Output: "{device}"}},
+		{"unknown output placeholder", StrategyConfig{Type: "template", Template: "{a}:{b}", Output: "{device}"}},
+		{"negative topic level", StrategyConfig{Type: "topicSegment", Level: -1}},
+		{"registry without devices", StrategyConfig{Type: "registry"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewStrategy(tt.cfg, nil); err == nil {
+				t.Errorf("NewStrategy() expected error")
+			}
+		})
+	}
+}
+
+func TestLoadResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "device-ids.json")
+	rules := `{"strategies": [
+		{"type": "registry", "devices": {"legacy-client": "dev-legacy"}},
+		{"type": "template", "template": "{project}:{gateway}:{device}", "output": "{device}"}
+	]}`
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver, err := LoadResolver(path, nil)
+	if err != nil {
+		t.Fatalf("LoadResolver() unexpected error: %v", err)
+	}
+	for clientID, want := range map[string]string{"legacy-client": "dev-legacy", "proj-42:gw-07:sensor-3": "sensor-3", "other": "other"} {
+		if got, _ := resolver.Resolve(context.Background(), Source{ClientID: clientID}); got != want {
+			t.Errorf("Resolve(%s) = %s, want %s", clientID, got, want)
+		}
+	}
+}
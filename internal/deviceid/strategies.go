@@ -0,0 +1,201 @@
+package deviceid
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Source fields a strategy can read from
+const (
+	SourceClientID = "clientId"
+	SourceTopic    = "topic"
+)
+
+// sourceValue returns the value of the named source field
+func sourceValue(src Source, field string) string {
+	if field == SourceTopic {
+		return src.Topic
+	}
+	return src.ClientID
+}
+
+func validateSourceField(field string) error {
+	if field != SourceClientID && field != SourceTopic {
+		return fmt.Errorf("invalid source %q: must be %q or %q", field, SourceClientID, SourceTopic)
+	}
+	return nil
+}
+
+// templatePlaceholder matches the {name} placeholders of a template
+var templatePlaceholder = regexp.MustCompile(`\{([A-Za-z][A-Za-z0-9_]*)\}`)
+
+type templateStrategy struct {
+	source  string
+	pattern *regexp.Regexp
+	output  string
+}
+
+// NewTemplateStrategy parses the source field with a template such as
+// "{project}:{gateway}:{device}" and renders the device ID from output, e.g. "{gateway}-{device}"
+func NewTemplateStrategy(source, template, output string) (Strategy, error) {
+	if err := validateSourceField(source); err != nil {
+		return nil, err
+	}
+
+	// Turn the template into an anchored regular expression with one group per placeholder
+	var expr strings.Builder
+	expr.WriteString("^")
+	names := make(map[string]bool)
+	last := 0
+	for _, loc := range templatePlaceholder.FindAllStringSubmatchIndex(template, -1) {
+		name := template[loc[2]:loc[3]]
+		if names[name] {
+			return nil, fmt.Errorf("invalid template %q: duplicate placeholder %q", template, name)
+		}
+		names[name] = true
+		expr.WriteString(regexp.QuoteMeta(template[last:loc[0]]))
+		expr.WriteString("(?P<" + name + ">.+?)")
+		last = loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(template[last:]))
+	expr.WriteString("$")
+	if len(names) == 0 {
+		return nil, fmt.Errorf("invalid template %q: no placeholders", template)
+	}
+
+	outputNames := templatePlaceholder.FindAllStringSubmatch(output, -1)
+	if len(outputNames) == 0 {
+		return nil, fmt.Errorf("invalid output %q: no placeholders", output)
+	}
+	for _, match := range outputNames {
+		if !names[match[1]] {
+			return nil, fmt.Errorf("invalid output %q: unknown placeholder %q", output, match[1])
+		}
+	}
+
+	return &templateStrategy{
+		source:  source,
+		pattern: regexp.MustCompile(expr.String()),
+		output:  output,
+	}, nil
+}
+
+func (s *templateStrategy) Extract(ctx context.Context, src Source) (string, bool, error) {
+	match := s.pattern.FindStringSubmatch(sourceValue(src, s.source))
+	if match == nil {
+		return "", false, nil
+	}
+
+	values := make(map[string]string)
+	for i, name := range s.pattern.SubexpNames() {
+		if name != "" {
+			values[name] = match[i]
+		}
+	}
+	deviceID := templatePlaceholder.ReplaceAllStringFunc(s.output, func(placeholder string) string {
+		return values[placeholder[1:len(placeholder)-1]]
+	})
+	return deviceID, true, nil
+}
+
+type regexStrategy struct {
+	source  string
+	pattern *regexp.Regexp
+	group   int
+}
+
+// NewRegexStrategy extracts the device ID from the source field with a regular expression,
+// taking the group named deviceId or, when there is none, the first group
+func NewRegexStrategy(source, pattern string) (Strategy, error) {
+	if err := validateSourceField(source); err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	if re.NumSubexp() == 0 {
+		return nil, fmt.Errorf("invalid pattern %q: a capture group is required", pattern)
+	}
+
+	group := 1
+	if index := re.SubexpIndex("deviceId"); index > 0 {
+		group = index
+	}
+	return &regexStrategy{source: source, pattern: re, group: group}, nil
+}
+
+func (s *regexStrategy) Extract(ctx context.Context, src Source) (string, bool, error) {
+	match := s.pattern.FindStringSubmatch(sourceValue(src, s.source))
+	if match == nil || match[s.group] == "" {
+		return "", false, nil
+	}
+	return match[s.group], true, nil
+}
+
+type topicSegmentStrategy struct {
+	level int
+}
+
+// NewTopicSegmentStrategy uses the topic level at the given index (0 is the first level)
+func NewTopicSegmentStrategy(level int) (Strategy, error) {
+	if level < 0 {
+		return nil, fmt.Errorf("invalid topic level %d", level)
+	}
+	return &topicSegmentStrategy{level: level}, nil
+}
+
+func (s *topicSegmentStrategy) Extract(ctx context.Context, src Source) (string, bool, error) {
+	levels := strings.Split(src.Topic, "/")
+	if s.level >= len(levels) || levels[s.level] == "" {
+		return "", false, nil
+	}
+	return levels[s.level], true, nil
+}
+
+type topicRuleStrategy struct{}
+
+// NewTopicRuleStrategy uses the deviceId capture of the topic rule matching the topic
+func NewTopicRuleStrategy() Strategy {
+	return topicRuleStrategy{}
+}
+
+func (topicRuleStrategy) Extract(ctx context.Context, src Source) (string, bool, error) {
+	deviceID, ok := src.Captures["deviceId"]
+	return deviceID, ok && deviceID != "", nil
+}
+
+// Registry maps the client IDs of known devices to their device IDs
+type Registry interface {
+	LookupDeviceID(ctx context.Context, clientID string) (deviceID string, ok bool, err error)
+}
+
+// MapRegistry is a static Registry keyed by client ID
+type MapRegistry map[string]string
+
+func (r MapRegistry) LookupDeviceID(ctx context.Context, clientID string) (string, bool, error) {
+	deviceID, ok := r[clientID]
+	return deviceID, ok, nil
+}
+
+type registryStrategy struct {
+	registry Registry
+}
+
+// NewRegistryStrategy looks the client ID up in a device registry
+func NewRegistryStrategy(registry Registry) Strategy {
+	return &registryStrategy{registry: registry}
+}
+
+func (s *registryStrategy) Extract(ctx context.Context, src Source) (string, bool, error) {
+	if src.ClientID == "" {
+		return "", false, nil
+	}
+	deviceID, ok, err := s.registry.LookupDeviceID(ctx, src.ClientID)
+	if err != nil {
+		return "", false, fmt.Errorf("device registry lookup failed: %w", err)
+	}
+	return deviceID, ok, nil
+}
@@ -0,0 +1,106 @@
+// Package downsample reduces time series to a target number of points for charting.
+package downsample
+
+import (
+	"math"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+// LTTB downsamples points ordered by timestamp to at most threshold points with the
+// Largest-Triangle-Three-Buckets algorithm, which keeps the visual shape of the series.
+// The first and last points are always kept; series already small enough are returned as is.
+func LTTB(points []models.SeriesPoint, threshold int) []models.SeriesPoint {
+	if threshold >= len(points) || threshold < 3 {
+		return points
+	}
+
+	sampled := make([]models.SeriesPoint, 0, threshold)
+	sampled = append(sampled, points[0])
+
+	// The points between the first and the last are split into threshold-2 buckets
+	every := float64(len(points)-2) / float64(threshold-2)
+	selected := 0
+	for i := 0; i < threshold-2; i++ {
+		// Average of the next bucket, the third vertex of the triangle
+		nextStart := int(float64(i+1)*every) + 1
+		nextEnd := min(int(float64(i+2)*every)+1, len(points))
+		var avgX, avgY float64
+		for _, p := range points[nextStart:nextEnd] {
+			avgX += unix(p)
+			avgY += p.Value
+		}
+		count := float64(nextEnd - nextStart)
+		avgX /= count
+		avgY /= count
+
+		// The point of the current bucket forming the largest triangle with the
+		// previously selected point and the next average
+		start := int(float64(i)*every) + 1
+		end := int(float64(i+1)*every) + 1
+		ax, ay := unix(points[selected]), points[selected].Value
+		maxArea := -1.0
+		next := start
+		for j := start; j < end; j++ {
+			area := math.Abs((ax-avgX)*(points[j].Value-ay) - (ax-unix(points[j]))*(avgY-ay))
+			if area > maxArea {
+				maxArea = area
+				next = j
+			}
+		}
+		sampled = append(sampled, points[next])
+		selected = next
+	}
+
+	return append(sampled, points[len(points)-1])
+}
+
+// MinMax downsamples points ordered by timestamp to at most threshold points by splitting
+// [from, to] into threshold/2 equal time buckets and keeping the lowest and the highest
+// point of each bucket in timestamp order, so spikes are never lost
+func MinMax(points []models.SeriesPoint, threshold int, from, to time.Time) []models.SeriesPoint {
+	buckets := threshold / 2
+	if threshold >= len(points) || buckets < 1 {
+		return points
+	}
+
+	width := to.Sub(from) / time.Duration(buckets)
+	if width <= 0 {
+		width = 1
+	}
+	bucketOf := func(p models.SeriesPoint) int {
+		return max(0, min(int(p.Timestamp.Sub(from)/width), buckets-1))
+	}
+
+	sampled := make([]models.SeriesPoint, 0, threshold)
+	for start := 0; start < len(points); {
+		bucket := bucketOf(points[start])
+		lowest, highest := start, start
+		end := start + 1
+		for ; end < len(points) && bucketOf(points[end]) == bucket; end++ {
+			if points[end].Value < points[lowest].Value {
+				lowest = end
+			}
+			if points[end].Value > points[highest].Value {
+				highest = end
+			}
+		}
+
+		switch {
+		case lowest == highest:
+			sampled = append(sampled, points[lowest])
+		case lowest < highest:
+			sampled = append(sampled, points[lowest], points[highest])
+		default:
+			sampled = append(sampled, points[highest], points[lowest])
+		}
+		start = end
+	}
+	return sampled
+}
+
+// unix returns the timestamp of a point as fractional seconds, the x axis of LTTB
+func unix(p models.SeriesPoint) float64 {
+	return float64(p.Timestamp.UnixNano()) / 1e9
+}
@@ -0,0 +1,112 @@
+package downsample
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+var base = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// series returns one point per minute with the given values
+func series(values ...float64) []models.SeriesPoint {
+	points := make([]models.SeriesPoint, len(values))
+	for i, value := range values {
+		points[i] = models.SeriesPoint{Timestamp: base.Add(time.Duration(i) * time.Minute), Value: value}
+	}
+	return points
+}
+
+func values(points []models.SeriesPoint) []float64 {
+	result := make([]float64, len(points))
+	for i, p := range points {
+		result[i] = p.Value
+	}
+	return result
+}
+
+func equal(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLTTB(t *testing.T) {
+	tests := []struct {
+		name      string
+		points    []models.SeriesPoint
+		threshold int
+		want      []float64
+	}{
+		{"small series unchanged", series(1, 2, 3), 5, []float64{1, 2, 3}},
+		{"threshold below 3 unchanged", series(1, 2, 3, 4), 2, []float64{1, 2, 3, 4}},
+		{"keeps the spike", series(0, 0, 0, 9, 0, 0, 0, 0, 0), 3, []float64{0, 9, 0}},
+		{"keeps both extremes", series(0, 1, 10, 1, 0, -1, -10, -1, 0, 0), 4, []float64{0, 10, -10, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := values(LTTB(tt.points, tt.threshold)); !equal(got, tt.want) {
+				t.Errorf("LTTB() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLTTBLargeSeries(t *testing.T) {
+	points := make([]models.SeriesPoint, 100000)
+	for i := range points {
+		points[i] = models.SeriesPoint{Timestamp: base.Add(time.Duration(i) * time.Second), Value: math.Sin(float64(i) / 1000)}
+	}
+
+	sampled := LTTB(points, 500)
+	if len(sampled) != 500 {
+		t.Fatalf("LTTB() returned %d points, want 500", len(sampled))
+	}
+	if sampled[0] != points[0] || sampled[499] != points[len(points)-1] {
+		t.Errorf("LTTB() must keep the first and last points")
+	}
+	for i := 1; i < len(sampled); i++ {
+		if !sampled[i].Timestamp.After(sampled[i-1].Timestamp) {
+			t.Fatalf("LTTB() points are not ordered at %d", i)
+		}
+	}
+}
+
+func TestMinMax(t *testing.T) {
+	points := series(5, 1, 9, 4, 4, 4, 7, 2)
+	from, to := base, base.Add(8*time.Minute)
+
+	tests := []struct {
+		name      string
+		threshold int
+		want      []float64
+	}{
+		{"unchanged", 8, []float64{5, 1, 9, 4, 4, 4, 7, 2}},
+		// Buckets of 2m40s: [5 1 9] [4 4 4] [7 2]
+		{"three buckets", 7, []float64{1, 9, 4, 7, 2}},
+		// Buckets of 4 minutes: [5 1 9 4] [4 4 7 2]
+		{"two buckets", 4, []float64{1, 9, 7, 2}},
+		{"one bucket", 2, []float64{1, 9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MinMax(points, tt.threshold, from, to)
+			if !equal(values(got), tt.want) {
+				t.Errorf("MinMax() = %v, want %v", values(got), tt.want)
+			}
+			if len(got) > tt.threshold {
+				t.Errorf("MinMax() returned %d points, more than %d", len(got), tt.threshold)
+			}
+		})
+	}
+}
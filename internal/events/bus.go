@@ -0,0 +1,128 @@
+// Package events distributes message lifecycle events inside the service, e.g. from the
+// message service to the rollup worker.
+package events
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+// EventType identifies what happened to a message
+type EventType string
+
+const (
+	MessageCreated EventType = "message.created"
+	MessageUpdated EventType = "message.updated"
+	MessageDeleted EventType = "message.deleted"
+)
+
+// MessageEvent carries the message as stored after the change (as it was before a delete)
+type MessageEvent struct {
+	Type       EventType
+	Message    *models.Message
+	OccurredAt time.Time
+}
+
+// Bus fans out message events to its subscribers
+type Bus interface {
+	// Publish delivers the event to every subscriber. Subscribers whose buffer is full miss
+	// the event, except the durable ones, which Publish waits for.
+	Publish(event MessageEvent)
+	// Subscribe returns a channel receiving the events published from now on and a function
+	// that cancels the subscription and closes the channel
+	Subscribe(name string, buffer int) (<-chan MessageEvent, func())
+	// SubscribeDurable is Subscribe for the consumers that must see every event, such as the
+	// webhook dispatcher: publishers block while its buffer is full instead of dropping events.
+	// The subscriber must keep receiving until it cancels and must not publish itself.
+	SubscribeDurable(name string, buffer int) (<-chan MessageEvent, func())
+}
+
+type subscriber struct {
+	name    string
+	events  chan MessageEvent
+	durable bool
+	// cancelled releases the publishers waiting for a durable subscriber
+	cancelled chan struct{}
+}
+
+type bus struct {
+	mu          sync.RWMutex
+	subscribers map[*subscriber]struct{}
+}
+
+func NewBus() Bus {
+	return &bus{subscribers: make(map[*subscriber]struct{})}
+}
+
+func (b *bus) Publish(event MessageEvent) {
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now().UTC()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subscribers {
+		if sub.durable {
+			select {
+			case sub.events <- event:
+			case <-sub.cancelled:
+			}
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			log.Printf("Event subscriber %s is full, dropping %s of message %s", sub.name, event.Type, event.Message.GetIDAsString())
+		}
+	}
+}
+
+func (b *bus) Subscribe(name string, buffer int) (<-chan MessageEvent, func()) {
+	return b.subscribe(name, buffer, false)
+}
+
+func (b *bus) SubscribeDurable(name string, buffer int) (<-chan MessageEvent, func()) {
+	return b.subscribe(name, buffer, true)
+}
+
+func (b *bus) subscribe(name string, buffer int, durable bool) (<-chan MessageEvent, func()) {
+	sub := &subscriber{
+		name:      name,
+		events:    make(chan MessageEvent, buffer),
+		durable:   durable,
+		cancelled: make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			// Blocked publishers hold the read lock; they must give up before it is taken
+			close(sub.cancelled)
+			b.mu.Lock()
+			delete(b.subscribers, sub)
+			b.mu.Unlock()
+			close(sub.events)
+		})
+	}
+	return sub.events, cancel
+}
+
+// WithoutPublish returns a bus sharing the subscribers of bus whose Publish does nothing.
+// It is given to the message service when an external feed (see the feed package) already
+// publishes every stored change, so that no change is announced twice.
+func WithoutPublish(bus Bus) Bus {
+	return silentBus{bus}
+}
+
+type silentBus struct {
+	Bus
+}
+
+func (silentBus) Publish(MessageEvent) {}
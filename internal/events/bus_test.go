@@ -0,0 +1,104 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+	first, cancelFirst := b.Subscribe("first", 1)
+	second, cancelSecond := b.Subscribe("second", 2)
+	defer cancelSecond()
+
+	message := &models.Message{Topic: "dev-1/status"}
+	b.Publish(MessageEvent{Type: MessageCreated, Message: message})
+
+	for name, events := range map[string]<-chan MessageEvent{"first": first, "second": second} {
+		event := <-events
+		if event.Type != MessageCreated || event.Message != message || event.OccurredAt.IsZero() {
+			t.Errorf("%s received %+v", name, event)
+		}
+	}
+
+	// A full subscriber misses events without blocking the others
+	b.Publish(MessageEvent{Type: MessageUpdated, Message: message})
+	b.Publish(MessageEvent{Type: MessageDeleted, Message: message})
+	if event := <-first; event.Type != MessageUpdated {
+		t.Errorf("first received %s, want %s", event.Type, MessageUpdated)
+	}
+	select {
+	case event := <-first:
+		t.Errorf("first received %s after its buffer was full", event.Type)
+	default:
+	}
+	if len(second) != 2 {
+		t.Errorf("second buffered %d events, want 2", len(second))
+	}
+
+	cancelFirst()
+	cancelFirst()
+	if _, ok := <-first; ok {
+		t.Errorf("cancelled subscription channel is still open")
+	}
+	b.Publish(MessageEvent{Type: MessageCreated, Message: message})
+}
+
+func TestDurableSubscriber(t *testing.T) {
+	b := NewBus()
+	durable, cancel := b.SubscribeDurable("durable", 1)
+	message := &models.Message{Topic: "dev-1/status"}
+
+	// The second event waits for room in the buffer instead of being dropped
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		b.Publish(MessageEvent{Type: MessageCreated, Message: message})
+		b.Publish(MessageEvent{Type: MessageUpdated, Message: message})
+	}()
+	select {
+	case <-published:
+		t.Fatal("Publish() returned while the durable subscriber was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	for _, want := range []EventType{MessageCreated, MessageUpdated} {
+		if event := <-durable; event.Type != want {
+			t.Errorf("durable received %s, want %s", event.Type, want)
+		}
+	}
+	<-published
+
+	// Cancelling releases a blocked publisher
+	b.Publish(MessageEvent{Type: MessageCreated, Message: message})
+	published = make(chan struct{})
+	go func() {
+		defer close(published)
+		b.Publish(MessageEvent{Type: MessageDeleted, Message: message})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("Publish() still blocked after the durable subscription was cancelled")
+	}
+}
+
+func TestWithoutPublish(t *testing.T) {
+	b := NewBus()
+	silent := WithoutPublish(b)
+	events, cancel := silent.Subscribe("silent", 2)
+	defer cancel()
+
+	silent.Publish(MessageEvent{Type: MessageCreated, Message: &models.Message{}})
+	message := &models.Message{Topic: "dev-1/status"}
+	b.Publish(MessageEvent{Type: MessageUpdated, Message: message})
+	if event := <-events; event.Type != MessageUpdated || event.Message != message {
+		t.Errorf("received %+v, want the event published on the underlying bus", event)
+	}
+	if len(events) != 0 {
+		t.Errorf("received %d events published through WithoutPublish", len(events))
+	}
+}
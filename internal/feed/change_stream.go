@@ -0,0 +1,237 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"sit-iot-message-mng-api/internal/deviceid"
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/topics"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// stateCollection holds the resume token of the change stream
+	stateCollection = "event_feed_state"
+	// stateID is the _id of the change stream document in stateCollection
+	stateID = "changestream"
+	// checkpointInterval is the minimum time between two saves of the resume token
+	checkpointInterval = 5 * time.Second
+	// maxRetryDelay caps the delay before reopening a failed change stream
+	maxRetryDelay = 30 * time.Second
+)
+
+// Server error codes meaning the resume token can no longer be used
+const (
+	codeChangeStreamFatal       = 280
+	codeChangeStreamHistoryLost = 286
+)
+
+// changeEvent is the part of a change stream event used by the feed
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		ID interface{} `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument bson.Raw `bson:"fullDocument"`
+}
+
+type changeStream struct {
+	db        *mongo.Database
+	bus       events.Bus
+	deviceIDs deviceIDs
+	state     *mongo.Collection
+	token     bson.Raw
+	cancel    func()
+	done      chan struct{}
+}
+
+// NewMongoChangeStream returns a source watching the messages collection of the database,
+// which must belong to a replica set. Inserts, updates, replaces and deletes of messages are
+// published as message events. The resume token is saved in the event_feed_state collection
+// so that a restarted service publishes the changes made while it was down, as long as the
+// oplog still has them.
+// Messages stored without a device ID get one from the topic rules and device ID strategies.
+func NewMongoChangeStream(db *mongo.Database, bus events.Bus, topicRouter topics.Router, resolver deviceid.Resolver) Source {
+	return &changeStream{
+		db:        db,
+		bus:       bus,
+		deviceIDs: deviceIDs{topicRouter: topicRouter, resolver: resolver},
+		state:     db.Collection(stateCollection),
+	}
+}
+
+func (s *changeStream) Start(ctx context.Context) error {
+	if s.done != nil {
+		return errors.New("change stream already started")
+	}
+
+	token, err := s.loadToken(ctx)
+	if err != nil {
+		return err
+	}
+	s.token = token
+
+	// Open the first stream now so that a deployment without change streams fails at startup
+	stream, err := s.open(ctx)
+	if err != nil && isTokenLost(err) {
+		log.Printf("Change stream resume token expired, changes made while the service was down are missed")
+		s.token = nil
+		stream, err = s.open(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		s.run(ctx, stream)
+	}()
+	log.Printf("Change stream started")
+	return nil
+}
+
+func (s *changeStream) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+	s.cancel = nil
+}
+
+// run watches until the context is done, reopening the stream after failures
+func (s *changeStream) run(ctx context.Context, stream *mongo.ChangeStream) {
+	delay := time.Second
+	for {
+		err := s.watch(ctx, stream)
+		stream.Close(context.Background())
+		s.saveToken()
+
+		for ctx.Err() == nil {
+			if isTokenLost(err) {
+				log.Printf("Change stream resume token expired, some changes are missed: %v", err)
+				s.token = nil
+			} else {
+				log.Printf("Change stream interrupted, reopening in %s: %v", delay, err)
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+				delay = min(2*delay, maxRetryDelay)
+			}
+			if stream, err = s.open(ctx); err == nil {
+				delay = time.Second
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// open starts a change stream after the current resume token, if any
+func (s *changeStream) open(ctx context.Context) (*mongo.ChangeStream, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}},
+		}}},
+	}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if s.token != nil {
+		opts.SetResumeAfter(s.token)
+	}
+	return s.db.Collection("messages").Watch(ctx, pipeline, opts)
+}
+
+// watch publishes the events of the stream until it fails or the context is done
+func (s *changeStream) watch(ctx context.Context, stream *mongo.ChangeStream) error {
+	lastSave := time.Now()
+	for stream.Next(ctx) {
+		var change changeEvent
+		if err := stream.Decode(&change); err != nil {
+			log.Printf("Failed to decode change stream event: %v", err)
+		} else {
+			s.publish(ctx, change)
+		}
+
+		// Publish only returns once the durable subscribers queued the event, so the saved
+		// token never skips an event they did not get
+		s.token = stream.ResumeToken()
+		if time.Since(lastSave) >= checkpointInterval {
+			s.saveToken()
+			lastSave = time.Now()
+		}
+	}
+	return stream.Err()
+}
+
+// publish announces a change of a message on the event bus
+func (s *changeStream) publish(ctx context.Context, change changeEvent) {
+	eventType := events.MessageUpdated
+	switch change.OperationType {
+	case "insert":
+		eventType = events.MessageCreated
+	case "delete":
+		// The message is gone, only its ID is known
+		s.bus.Publish(events.MessageEvent{Type: events.MessageDeleted, Message: &models.Message{ID: change.DocumentKey.ID}})
+		return
+	}
+	// An update of a message deleted since then has no document left
+	if change.FullDocument == nil {
+		return
+	}
+	var message models.Message
+	if err := bson.Unmarshal(change.FullDocument, &message); err != nil {
+		log.Printf("Failed to decode changed message: %v", err)
+		return
+	}
+	s.deviceIDs.publishMessage(ctx, s.bus, eventType, &message)
+}
+
+// loadToken returns the saved resume token, or nil when there is none
+func (s *changeStream) loadToken(ctx context.Context) (bson.Raw, error) {
+	var state struct {
+		Token bson.Raw `bson:"token"`
+	}
+	err := s.state.FindOne(ctx, bson.M{"_id": stateID}).Decode(&state)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return state.Token, nil
+}
+
+// saveToken persists the current resume token
+func (s *changeStream) saveToken() {
+	if s.token == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	update := bson.M{"$set": bson.M{"token": s.token, "updatedAt": time.Now().UTC()}}
+	if _, err := s.state.UpdateOne(ctx, bson.M{"_id": stateID}, update, options.Update().SetUpsert(true)); err != nil {
+		log.Printf("Failed to save change stream resume token: %v", err)
+	}
+}
+
+// isTokenLost reports whether the stream cannot resume from its token
+func isTokenLost(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) &&
+		(serverErr.HasErrorCode(codeChangeStreamHistoryLost) || serverErr.HasErrorCode(codeChangeStreamFatal))
+}
@@ -0,0 +1,125 @@
+package feed
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/events"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestChangeStreamPublish(t *testing.T) {
+	id := primitive.NewObjectID()
+	document := func(v interface{}) bson.Raw {
+		raw, err := bson.Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal() unexpected error: %v", err)
+		}
+		return raw
+	}
+	change := func(operation string, fullDocument bson.Raw) changeEvent {
+		var c changeEvent
+		c.OperationType = operation
+		c.DocumentKey.ID = id
+		c.FullDocument = fullDocument
+		return c
+	}
+	message := document(bson.M{"_id": id, "topic": "dev-1/telemetry", "client_id": "dev-1"})
+	nested := document(bson.M{"_id": id, "topic": "site/dev-7/telemetry", "client_id": "client-7"})
+	router, resolver := defaultDeviceIDs(t)
+
+	tests := []struct {
+		name     string
+		change   changeEvent
+		wantType events.EventType // Empty when nothing is published
+		wantID   string
+		wantDev  string
+	}{
+		{"insert", change("insert", message), events.MessageCreated, id.Hex(), "dev-1"},
+		{"update", change("update", message), events.MessageUpdated, id.Hex(), "dev-1"},
+		{"replace", change("replace", message), events.MessageUpdated, id.Hex(), "dev-1"},
+		{"device ID from the topic", change("insert", nested), events.MessageCreated, id.Hex(), "dev-7"},
+		{"update of a deleted message", change("update", nil), "", "", ""},
+		{"delete", change("delete", nil), events.MessageDeleted, id.Hex(), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := events.NewBus()
+			queue, cancel := bus.Subscribe("test", 1)
+			defer cancel()
+			source := &changeStream{bus: bus, deviceIDs: deviceIDs{topicRouter: router, resolver: resolver}}
+			source.publish(context.Background(), tt.change)
+
+			if tt.wantType == "" {
+				if len(queue) != 0 {
+					t.Errorf("published %+v, want nothing", <-queue)
+				}
+				return
+			}
+			event := <-queue
+			if event.Type != tt.wantType {
+				t.Errorf("type = %s, want %s", event.Type, tt.wantType)
+			}
+			if event.Message.GetIDAsString() != tt.wantID || event.Message.DeviceID != tt.wantDev {
+				t.Errorf("message = %+v, want ID %s and device %q", event.Message, tt.wantID, tt.wantDev)
+			}
+		})
+	}
+}
+
+// TestMongoChangeStream runs against the MongoDB replica set at MONGO_TEST_URI, e.g.
+// MONGO_TEST_URI=mongodb://localhost:27017/?replicaSet=rs0 go test ./internal/feed/...
+func TestMongoChangeStream(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("failed to connect to MongoDB: %v", err)
+	}
+	db := client.Database(fmt.Sprintf("feed_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+
+	bus := events.NewBus()
+	queue, cancel := bus.Subscribe("test", 16)
+	defer cancel()
+
+	router, resolver := defaultDeviceIDs(t)
+	source := NewMongoChangeStream(db, bus, router, resolver)
+	if err := source.Start(ctx); err != nil {
+		t.Fatalf("Start() unexpected error: %v", err)
+	}
+	if _, err := db.Collection("messages").InsertOne(ctx, bson.M{"topic": "dev-1/telemetry", "client_id": "dev-1", "timestamp": time.Now()}); err != nil {
+		t.Fatalf("InsertOne() unexpected error: %v", err)
+	}
+	if event := receive(t, queue); event.Type != events.MessageCreated || event.Message.DeviceID != "dev-1" {
+		t.Errorf("event = %s %+v, want the created dev-1 message", event.Type, event.Message)
+	}
+	source.Stop()
+
+	// A restarted source resumes after the saved token
+	if _, err := db.Collection("messages").InsertOne(ctx, bson.M{"topic": "dev-2/telemetry", "client_id": "dev-2", "timestamp": time.Now()}); err != nil {
+		t.Fatalf("InsertOne() unexpected error: %v", err)
+	}
+	restarted := NewMongoChangeStream(db, bus, router, resolver)
+	if err := restarted.Start(ctx); err != nil {
+		t.Fatalf("Start() after restart unexpected error: %v", err)
+	}
+	defer restarted.Stop()
+	if event := receive(t, queue); event.Type != events.MessageCreated || event.Message.DeviceID != "dev-2" {
+		t.Errorf("event after restart = %s %+v, want the created dev-2 message", event.Type, event.Message)
+	}
+}
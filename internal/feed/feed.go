@@ -0,0 +1,47 @@
+// Package feed publishes on the event bus the changes made to the stored messages by any
+// writer, not only by this service: a MongoDB change stream, or a polling fallback for the
+// other providers.
+package feed
+
+import (
+	"context"
+	"log"
+
+	"sit-iot-message-mng-api/internal/deviceid"
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/topics"
+)
+
+// Source watches the stored messages and publishes their changes on the event bus
+type Source interface {
+	// Start begins watching; it fails when the store cannot be watched
+	Start(ctx context.Context) error
+	// Stop stops watching and waits for the source to finish
+	Stop()
+}
+
+// deviceIDs derives the device ID of the messages stored without one the way the message
+// service does: with the configured strategies, given the captures of the matching topic rule
+type deviceIDs struct {
+	topicRouter topics.Router
+	resolver    deviceid.Resolver
+}
+
+// publishMessage announces a change of a stored message. Messages stored by an external
+// writer may lack the derived device ID, which is then derived from the message; a
+// message whose device ID cannot be derived is published without one.
+func (d deviceIDs) publishMessage(ctx context.Context, bus events.Bus, eventType events.EventType, message *models.Message) {
+	if message.DeviceID == "" {
+		src := deviceid.Source{ClientID: message.ClientID, Topic: message.Topic}
+		if match := d.topicRouter.Classify(message.Topic); match != nil {
+			src.Captures = match.Fields
+		}
+		deviceID, err := d.resolver.Resolve(ctx, src)
+		if err != nil {
+			log.Printf("Failed to derive the device ID of message %s: %v", message.GetIDAsString(), err)
+		}
+		message.DeviceID = deviceID
+	}
+	bus.Publish(events.MessageEvent{Type: eventType, Message: message})
+}
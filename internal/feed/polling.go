@@ -0,0 +1,133 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"sit-iot-message-mng-api/internal/deviceid"
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+	"sit-iot-message-mng-api/internal/topics"
+)
+
+// pollPageSize is the number of messages read per query while polling
+const pollPageSize = 500
+
+type pollingSource struct {
+	repo      repositories.MessageRepository
+	bus       events.Bus
+	deviceIDs deviceIDs
+	interval  time.Duration
+	lookback  time.Duration
+
+	// seen holds the timestamp of the messages already published, by ID
+	seen   map[string]time.Time
+	cancel func()
+	done   chan struct{}
+}
+
+// NewPollingSource returns a source publishing a MessageCreated event for every message
+// stored since it started, for providers without change streams. Every interval it reads
+// the messages timestamped less than lookback before the previous poll, so a message is
+// missed when it is stored more than lookback after its timestamp. Updates and deletes are
+// not detected. Messages stored without a device ID get one from the topic rules and device
+// ID strategies.
+func NewPollingSource(repo repositories.MessageRepository, bus events.Bus, topicRouter topics.Router, resolver deviceid.Resolver, interval, lookback time.Duration) Source {
+	return &pollingSource{
+		repo:      repo,
+		bus:       bus,
+		deviceIDs: deviceIDs{topicRouter: topicRouter, resolver: resolver},
+		interval:  interval,
+		lookback:  lookback,
+		seen:      make(map[string]time.Time),
+	}
+}
+
+func (s *pollingSource) Start(ctx context.Context) error {
+	if s.done != nil {
+		return errors.New("polling source already started")
+	}
+	if s.interval <= 0 || s.lookback < 0 {
+		return errors.New("polling source requires a positive interval and a non-negative lookback")
+	}
+
+	// The messages already stored are only recorded as seen
+	since := time.Now().UTC()
+	if err := s.poll(ctx, since.Add(-s.lookback), false); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			now := time.Now().UTC()
+			if err := s.poll(ctx, since.Add(-s.lookback), true); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("Polling for new messages failed: %v", err)
+				continue
+			}
+			since = now
+		}
+	}()
+	log.Printf("Polling for new messages every %s", s.interval)
+	return nil
+}
+
+func (s *pollingSource) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+	s.cancel = nil
+}
+
+// poll reads the messages timestamped from the given time and records the unseen ones,
+// publishing them when requested. Seen messages older than from are forgotten.
+func (s *pollingSource) poll(ctx context.Context, from time.Time, publish bool) error {
+	filter := models.MessageFilter{FromTime: &from}
+	var after *models.MessageCursor
+	for {
+		messages, next, err := s.repo.ListByCursor(ctx, filter, after, "ASC", pollPageSize)
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			id := message.GetIDAsString()
+			if _, ok := s.seen[id]; ok {
+				continue
+			}
+			s.seen[id] = message.Timestamp
+			if publish {
+				s.deviceIDs.publishMessage(ctx, s.bus, events.MessageCreated, message)
+			}
+		}
+		if next == nil {
+			break
+		}
+		after = next
+	}
+
+	for id, timestamp := range s.seen {
+		if timestamp.Before(from) {
+			delete(s.seen, id)
+		}
+	}
+	return nil
+}
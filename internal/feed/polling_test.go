@@ -0,0 +1,82 @@
+package feed
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/deviceid"
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+	"sit-iot-message-mng-api/internal/topics"
+)
+
+// defaultDeviceIDs returns the built-in topic rules and device ID strategies
+func defaultDeviceIDs(t *testing.T) (topics.Router, deviceid.Resolver) {
+	t.Helper()
+	router, err := topics.NewRouter(topics.DefaultRules())
+	if err != nil {
+		t.Fatalf("NewRouter() unexpected error: %v", err)
+	}
+	return router, deviceid.NewResolver(deviceid.DefaultStrategies()...)
+}
+
+// receive returns the next event of the subscription or fails after a second
+func receive(t *testing.T, queue <-chan events.MessageEvent) events.MessageEvent {
+	t.Helper()
+	select {
+	case event := <-queue:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	return events.MessageEvent{}
+}
+
+func TestPollingSource(t *testing.T) {
+	now := time.Now().UTC()
+	existing := &models.Message{Topic: "dev-1/telemetry", ClientID: "dev-1", DeviceID: "dev-1", Timestamp: now.Add(-time.Second)}
+	existing.SetIDFromString("65a000000000000000000001")
+	repo := repositories.NewMemoryMessageRepository([]*models.Message{existing}, nil)
+
+	bus := events.NewBus()
+	queue, cancel := bus.Subscribe("test", 16)
+	defer cancel()
+
+	router, resolver := defaultDeviceIDs(t)
+	source := NewPollingSource(repo, bus, router, resolver, 10*time.Millisecond, time.Minute)
+	if err := source.Start(context.Background()); err != nil {
+		t.Fatalf("Start() unexpected error: %v", err)
+	}
+	defer source.Stop()
+
+	// Messages written by another writer are published once, with the device ID derived
+	// from their topic; messages stored before the source started are not published
+	late := &models.Message{Topic: "dev-2/telemetry", ClientID: "client-2", Timestamp: now.Add(-30 * time.Second)}
+	if _, err := repo.Create(context.Background(), late); err != nil {
+		t.Fatalf("Create() unexpected error: %v", err)
+	}
+	event := receive(t, queue)
+	if event.Type != events.MessageCreated || event.Message.ClientID != "client-2" || event.Message.DeviceID != "dev-2" {
+		t.Errorf("event = %s %+v, want the created dev-2 message", event.Type, event.Message)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case event := <-queue:
+		t.Errorf("unexpected event %s %+v", event.Type, event.Message)
+	default:
+	}
+
+	source.Stop()
+	source.Stop()
+}
+
+func TestPollingSourceRequiresInterval(t *testing.T) {
+	router, resolver := defaultDeviceIDs(t)
+	source := NewPollingSource(repositories.NewMemoryMessageRepository(nil, nil), events.NewBus(), router, resolver, 0, time.Minute)
+	if err := source.Start(context.Background()); err == nil {
+		t.Error("Start() without interval succeeded")
+	}
+}
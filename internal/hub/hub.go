@@ -0,0 +1,211 @@
+// Package hub fans out the messages stored by the service to connected clients, each holding
+// any number of filtered subscriptions, e.g. for the WebSocket subscription API.
+package hub
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+)
+
+// eventBuffer is the number of created messages queued for the hub
+const eventBuffer = 1024
+
+// MaxSubscriptions caps the number of subscriptions of a single client
+const MaxSubscriptions = 100
+
+var (
+	// ErrDuplicateSubscription is returned when a client reuses the ID of an active subscription
+	ErrDuplicateSubscription = errors.New("subscription ID already in use")
+	// ErrTooManySubscriptions is returned when a client exceeds MaxSubscriptions
+	ErrTooManySubscriptions = errors.New("too many subscriptions")
+	// ErrClientClosed is returned when subscribing on a closed client
+	ErrClientClosed = errors.New("client closed")
+)
+
+// Delivery is a created message with the IDs of the client subscriptions it matches
+type Delivery struct {
+	SubscriptionIDs []string
+	Message         *models.Message
+}
+
+// Hub delivers every created message once to each connected client with a matching subscription
+type Hub interface {
+	// Start subscribes to the event bus and dispatches events in the background
+	Start(ctx context.Context) error
+	// Stop unsubscribes from the event bus; connected clients stop receiving messages
+	Stop()
+	// Connect registers a client whose deliveries are queued up to buffer; further
+	// deliveries are dropped and counted until the client catches up
+	Connect(buffer int) Client
+}
+
+// Client is a connection to the hub holding filtered subscriptions
+type Client interface {
+	// Subscribe starts delivering the created messages matching the filter under the given ID
+	Subscribe(id string, filter models.MessageFilter) error
+	// Unsubscribe stops the subscription; it returns false when the ID is unknown
+	Unsubscribe(id string) bool
+	// Deliveries receives the matching messages; it is closed by Close
+	Deliveries() <-chan Delivery
+	// TakeDropped returns the number of deliveries dropped since the last call
+	TakeDropped() int64
+	// Close disconnects the client from the hub
+	Close()
+}
+
+type hub struct {
+	bus events.Bus
+
+	mu      sync.RWMutex
+	clients map[*client]struct{}
+
+	cancel func()
+	done   chan struct{}
+}
+
+func NewHub(bus events.Bus) Hub {
+	return &hub{
+		bus:     bus,
+		clients: make(map[*client]struct{}),
+	}
+}
+
+func (h *hub) Start(ctx context.Context) error {
+	if h.done != nil {
+		return errors.New("hub already started")
+	}
+
+	queue, cancel := h.bus.Subscribe("hub", eventBuffer)
+	h.cancel = cancel
+	h.done = make(chan struct{})
+
+	go func() {
+		defer close(h.done)
+		for event := range queue {
+			if event.Type == events.MessageCreated {
+				h.dispatch(event.Message)
+			}
+		}
+	}()
+	return nil
+}
+
+func (h *hub) Stop() {
+	if h.cancel == nil {
+		return
+	}
+	h.cancel()
+	<-h.done
+	h.cancel = nil
+}
+
+func (h *hub) Connect(buffer int) Client {
+	c := &client{
+		hub:           h,
+		deliveries:    make(chan Delivery, buffer),
+		subscriptions: make(map[string]models.MessageFilter),
+	}
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+	return c
+}
+
+// dispatch queues the message for every client with a matching subscription
+func (h *hub) dispatch(message *models.Message) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.clients {
+		ids := c.matching(message)
+		if len(ids) == 0 {
+			continue
+		}
+		select {
+		case c.deliveries <- Delivery{SubscriptionIDs: ids, Message: message}:
+		default:
+			if c.dropped.Add(1) == 1 {
+				log.Printf("Hub client is full, dropping message %s", message.GetIDAsString())
+			}
+		}
+	}
+}
+
+type client struct {
+	hub        *hub
+	deliveries chan Delivery
+	dropped    atomic.Int64
+
+	mu            sync.RWMutex
+	subscriptions map[string]models.MessageFilter
+	closed        bool
+}
+
+func (c *client) Subscribe(id string, filter models.MessageFilter) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClientClosed
+	}
+	if _, ok := c.subscriptions[id]; ok {
+		return ErrDuplicateSubscription
+	}
+	if len(c.subscriptions) >= MaxSubscriptions {
+		return ErrTooManySubscriptions
+	}
+	c.subscriptions[id] = filter
+	return nil
+}
+
+func (c *client) Unsubscribe(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subscriptions[id]; !ok {
+		return false
+	}
+	delete(c.subscriptions, id)
+	return true
+}
+
+func (c *client) Deliveries() <-chan Delivery {
+	return c.deliveries
+}
+
+func (c *client) TakeDropped() int64 {
+	return c.dropped.Swap(0)
+}
+
+func (c *client) Close() {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	if _, ok := c.hub.clients[c]; !ok {
+		return
+	}
+	delete(c.hub.clients, c)
+
+	c.mu.Lock()
+	c.closed = true
+	c.subscriptions = nil
+	c.mu.Unlock()
+	close(c.deliveries)
+}
+
+// matching returns the IDs of the subscriptions whose filter matches the message
+func (c *client) matching(message *models.Message) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var ids []string
+	for id, filter := range c.subscriptions {
+		if filter.Matches(message) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,115 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+)
+
+// next returns the next delivery of the client or fails after a second
+func next(t *testing.T, c Client) Delivery {
+	t.Helper()
+	select {
+	case delivery, ok := <-c.Deliveries():
+		if !ok {
+			t.Fatal("deliveries closed unexpectedly")
+		}
+		return delivery
+	case <-time.After(time.Second):
+		t.Fatal("no delivery received")
+	}
+	return Delivery{}
+}
+
+func TestHub(t *testing.T) {
+	bus := events.NewBus()
+	h := NewHub(bus)
+	if err := h.Start(context.Background()); err != nil {
+		t.Fatalf("Start() unexpected error: %v", err)
+	}
+	defer h.Stop()
+
+	wall := h.Connect(16)
+	defer wall.Close()
+	if err := wall.Subscribe("devices", models.MessageFilter{DeviceIDs: []string{"dev-1", "dev-2"}}); err != nil {
+		t.Fatalf("Subscribe() unexpected error: %v", err)
+	}
+	if err := wall.Subscribe("alerts", models.MessageFilter{Types: []models.MessageType{models.MessageTypeEvent}}); err != nil {
+		t.Fatalf("Subscribe() unexpected error: %v", err)
+	}
+	if err := wall.Subscribe("alerts", models.MessageFilter{}); !errors.Is(err, ErrDuplicateSubscription) {
+		t.Errorf("Subscribe() duplicate error = %v, want %v", err, ErrDuplicateSubscription)
+	}
+	idle := h.Connect(16)
+	defer idle.Close()
+
+	publish := func(deviceID string, messageType models.MessageType) {
+		message := &models.Message{DeviceID: deviceID, Type: messageType}
+		message.SetIDFromString(deviceID + "-" + string(messageType))
+		bus.Publish(events.MessageEvent{Type: events.MessageCreated, Message: message})
+	}
+	publish("dev-3", models.MessageTypeTelemetry) // Matches nothing
+	publish("dev-1", models.MessageTypeEvent)     // Matches both subscriptions, delivered once
+	bus.Publish(events.MessageEvent{Type: events.MessageDeleted, Message: &models.Message{DeviceID: "dev-1"}})
+	publish("dev-2", models.MessageTypeTelemetry)
+
+	if delivery := next(t, wall); delivery.Message.DeviceID != "dev-1" || fmt.Sprint(delivery.SubscriptionIDs) != "[alerts devices]" {
+		t.Errorf("first delivery = %v %+v, want dev-1 for [alerts devices]", delivery.SubscriptionIDs, delivery.Message)
+	}
+	if delivery := next(t, wall); delivery.Message.DeviceID != "dev-2" || fmt.Sprint(delivery.SubscriptionIDs) != "[devices]" {
+		t.Errorf("second delivery = %v %+v, want dev-2 for [devices]", delivery.SubscriptionIDs, delivery.Message)
+	}
+
+	if !wall.Unsubscribe("devices") || wall.Unsubscribe("devices") {
+		t.Error("Unsubscribe() should succeed once")
+	}
+	publish("dev-1", models.MessageTypeTelemetry)
+	publish("dev-2", models.MessageTypeEvent)
+	if delivery := next(t, wall); delivery.Message.DeviceID != "dev-2" {
+		t.Errorf("delivery after unsubscribe = %+v, want dev-2", delivery.Message)
+	}
+
+	select {
+	case delivery := <-idle.Deliveries():
+		t.Errorf("client without subscriptions received %+v", delivery)
+	default:
+	}
+
+	wall.Close()
+	if _, ok := <-wall.Deliveries(); ok {
+		t.Error("deliveries not closed after Close()")
+	}
+	if err := wall.Subscribe("late", models.MessageFilter{}); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("Subscribe() after Close() error = %v, want %v", err, ErrClientClosed)
+	}
+}
+
+func TestHubDropsForSlowClients(t *testing.T) {
+	h := NewHub(events.NewBus()).(*hub)
+	c := h.Connect(1)
+	defer c.Close()
+
+	for i := 0; i < MaxSubscriptions; i++ {
+		if err := c.Subscribe(fmt.Sprint(i), models.MessageFilter{DeviceID: "dev-1"}); err != nil {
+			t.Fatalf("Subscribe() unexpected error: %v", err)
+		}
+	}
+	if err := c.Subscribe("one-too-many", models.MessageFilter{}); !errors.Is(err, ErrTooManySubscriptions) {
+		t.Errorf("Subscribe() error = %v, want %v", err, ErrTooManySubscriptions)
+	}
+
+	for i := 0; i < 3; i++ {
+		h.dispatch(&models.Message{DeviceID: "dev-1"})
+	}
+	if dropped := c.TakeDropped(); dropped != 2 {
+		t.Errorf("TakeDropped() = %d, want 2", dropped)
+	}
+	if dropped := c.TakeDropped(); dropped != 0 {
+		t.Errorf("TakeDropped() after reset = %d, want 0", dropped)
+	}
+}
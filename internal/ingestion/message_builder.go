@@ -0,0 +1,51 @@
+package ingestion
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+// ErrNoClientID is returned when the client ID of a device cannot be found in an MQTT message
+var ErrNoClientID = errors.New("no client ID in MQTT message")
+
+// payloadClientIDKeys are the payload fields checked, in order, for the client ID of the device
+var payloadClientIDKeys = []string{"client_id", "clientId", "src"}
+
+// BuildMessage turns a raw MQTT publication into a message record. MQTT does not carry the
+// publisher's client ID, so it is read from the JSON payload when present and otherwise from
+// the topic level at clientIDLevel (0 for Shelly-style topics such as "shelly-1234/status/switch:0").
+// Derived fields (type, device ID, marshalled payload, status) are filled in by the message service.
+func BuildMessage(topic string, payload []byte, receivedAt time.Time, clientIDLevel int) (*models.Message, error) {
+	message := &models.Message{
+		Topic:     topic,
+		Payload:   string(payload),
+		Timestamp: receivedAt.UTC(),
+	}
+
+	var marshalled map[string]interface{}
+	if err := json.Unmarshal(payload, &marshalled); err == nil {
+		message.Marshalled = marshalled
+		for _, key := range payloadClientIDKeys {
+			if clientID, ok := marshalled[key].(string); ok && clientID != "" {
+				message.ClientID = clientID
+				break
+			}
+		}
+	}
+
+	if message.ClientID == "" {
+		levels := strings.Split(topic, "/")
+		if clientIDLevel < len(levels) {
+			message.ClientID = levels[clientIDLevel]
+		}
+	}
+	if message.ClientID == "" {
+		return nil, ErrNoClientID
+	}
+
+	return message, nil
+}
@@ -0,0 +1,74 @@
+package ingestion
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBuildMessage(t *testing.T) {
+	receivedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		topic         string
+		payload       string
+		clientIDLevel int
+		wantClientID  string
+		wantParsed    bool
+		wantErr       error
+	}{
+		{
+			name:         "client ID from topic",
+			topic:        "shelly-1234/status/switch:0",
+			payload:      `{"output":true}`,
+			wantClientID: "shelly-1234",
+			wantParsed:   true,
+		},
+		{
+			name:          "client ID from configured topic level",
+			topic:         "site/dev-1/telemetry",
+			payload:       "21.5",
+			clientIDLevel: 1,
+			wantClientID:  "dev-1",
+		},
+		{
+			name:         "client ID from payload wins over topic",
+			topic:        "site/dev-1/telemetry",
+			payload:      `{"clientId":"dev-9","temperature":21.5}`,
+			wantClientID: "dev-9",
+			wantParsed:   true,
+		},
+		{
+			name:          "topic level out of range",
+			topic:         "telemetry",
+			payload:       "1",
+			clientIDLevel: 3,
+			wantErr:       ErrNoClientID,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := BuildMessage(tt.topic, []byte(tt.payload), receivedAt, tt.clientIDLevel)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("BuildMessage() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BuildMessage() unexpected error: %v", err)
+			}
+			if message.ClientID != tt.wantClientID {
+				t.Errorf("BuildMessage() clientId = %s, want %s", message.ClientID, tt.wantClientID)
+			}
+			if (message.Marshalled != nil) != tt.wantParsed {
+				t.Errorf("BuildMessage() marshalled = %v, want parsed %v", message.Marshalled, tt.wantParsed)
+			}
+			if message.Topic != tt.topic || message.Payload != tt.payload || !message.Timestamp.Equal(receivedAt) {
+				t.Errorf("BuildMessage() = %+v", message)
+			}
+		})
+	}
+}
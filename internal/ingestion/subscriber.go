@@ -0,0 +1,112 @@
+package ingestion
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"sit-iot-message-mng-api/config"
+	"sit-iot-message-mng-api/internal/models"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MessageSink persists the messages received by the subscriber (implemented by the message service)
+type MessageSink interface {
+	IngestMessage(ctx context.Context, message *models.Message) (*models.Message, error)
+}
+
+// storeTimeout bounds the time spent persisting a single message
+const storeTimeout = 10 * time.Second
+
+// Subscriber subscribes to MQTT topic filters and stores every received message
+type Subscriber interface {
+	// Start connects to the broker in the background; subscriptions are (re)made on every connect
+	Start(ctx context.Context) error
+	// Stop disconnects from the broker
+	Stop()
+}
+
+type subscriber struct {
+	cfg    *config.Config
+	sink   MessageSink
+	client mqtt.Client
+	ctx    context.Context
+}
+
+func NewSubscriber(cfg *config.Config, sink MessageSink) Subscriber {
+	return &subscriber{
+		cfg:  cfg,
+		sink: sink,
+	}
+}
+
+func (s *subscriber) Start(ctx context.Context) error {
+	if len(s.cfg.MqttIngestTopics) == 0 {
+		return errors.New("no MQTT ingestion topics configured")
+	}
+	for _, topic := range s.cfg.MqttIngestTopics {
+		if err := models.ValidateTopicPattern(topic); err != nil {
+			return err
+		}
+	}
+
+	s.ctx = ctx
+	opts := mqtt.NewClientOptions().
+		AddBroker(s.cfg.MqttBrokerURL).
+		SetClientID(s.cfg.MqttIngestClientID).
+		SetUsername(s.cfg.MqttUsername).
+		SetPassword(s.cfg.MqttPassword).
+		SetCleanSession(false).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second).
+		SetOrderMatters(false).
+		SetOnConnectHandler(s.subscribe).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("MQTT ingestion: connection lost: %v", err)
+		})
+
+	s.client = mqtt.NewClient(opts)
+	// With connect retry enabled the token only completes once connected, so it is not awaited
+	s.client.Connect()
+	log.Printf("MQTT ingestion: connecting to %s", s.cfg.MqttBrokerURL)
+	return nil
+}
+
+func (s *subscriber) Stop() {
+	if s.client != nil {
+		s.client.Disconnect(250)
+	}
+}
+
+// subscribe subscribes to the configured topic filters; called on every (re)connect
+func (s *subscriber) subscribe(client mqtt.Client) {
+	filters := make(map[string]byte, len(s.cfg.MqttIngestTopics))
+	for _, topic := range s.cfg.MqttIngestTopics {
+		filters[topic] = s.cfg.MqttIngestQoS
+	}
+
+	token := client.SubscribeMultiple(filters, s.handleMessage)
+	if token.Wait() && token.Error() != nil {
+		log.Printf("MQTT ingestion: failed to subscribe to %v: %v", s.cfg.MqttIngestTopics, token.Error())
+		return
+	}
+	log.Printf("MQTT ingestion: subscribed to %v", s.cfg.MqttIngestTopics)
+}
+
+// handleMessage builds and stores a received message; failures are logged and the message dropped
+func (s *subscriber) handleMessage(_ mqtt.Client, msg mqtt.Message) {
+	message, err := BuildMessage(msg.Topic(), msg.Payload(), time.Now(), s.cfg.MqttClientIDTopicLevel)
+	if err != nil {
+		log.Printf("MQTT ingestion: dropping message on %s: %v", msg.Topic(), err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, storeTimeout)
+	defer cancel()
+	if _, err := s.sink.IngestMessage(ctx, message); err != nil {
+		log.Printf("MQTT ingestion: failed to store message on %s: %v", msg.Topic(), err)
+	}
+}
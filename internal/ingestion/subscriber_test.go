@@ -0,0 +1,70 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/config"
+	"sit-iot-message-mng-api/internal/models"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type channelSink chan *models.Message
+
+func (s channelSink) IngestMessage(ctx context.Context, message *models.Message) (*models.Message, error) {
+	s <- message
+	return message, nil
+}
+
+// TestSubscriberIngestsMessages runs against the MQTT broker at MQTT_TEST_BROKER_URL, e.g.
+// MQTT_TEST_BROKER_URL=tcp://localhost:1883 go test ./internal/ingestion/...
+func TestSubscriberIngestsMessages(t *testing.T) {
+	brokerURL := os.Getenv("MQTT_TEST_BROKER_URL")
+	if brokerURL == "" {
+		t.Skip("MQTT_TEST_BROKER_URL not set")
+	}
+
+	prefix := fmt.Sprintf("ingestion-test-%d", time.Now().UnixNano())
+	cfg := &config.Config{
+		MqttBrokerURL:          brokerURL,
+		MqttIngestClientID:     prefix + "-subscriber",
+		MqttIngestTopics:       []string{prefix + "/+/telemetry"},
+		MqttIngestQoS:          1,
+		MqttClientIDTopicLevel: 1,
+	}
+	sink := make(channelSink, 1)
+	subscriber := NewSubscriber(cfg, sink)
+	if err := subscriber.Start(context.Background()); err != nil {
+		t.Fatalf("Start() unexpected error: %v", err)
+	}
+	defer subscriber.Stop()
+
+	publisher := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(brokerURL).SetClientID(prefix + "-publisher"))
+	if token := publisher.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("failed to connect publisher: %v", token.Error())
+	}
+	defer publisher.Disconnect(250)
+
+	// Publish until the subscription is in place; retained messages are avoided so nothing leaks between runs
+	topic := prefix + "/dev-1/telemetry"
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case message := <-sink:
+			if message.Topic != topic || message.ClientID != "dev-1" || message.Marshalled["temperature"] != 21.5 {
+				t.Errorf("ingested message = %+v", message)
+			}
+			return
+		case <-ticker.C:
+			publisher.Publish(topic, 1, false, `{"temperature":21.5}`)
+		case <-timeout:
+			t.Fatal("no message ingested before timeout")
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// AccessTokenFromQuery lets clients that cannot set request headers, such as the browser
+// EventSource, pass their ID token as the access_token query parameter. It must run before
+// IdentityPlatformMiddleware; an Authorization header takes precedence.
+func AccessTokenFromQuery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := c.Query("access_token"); token != "" && c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"sit-iot-message-mng-api/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireAdmin only lets through users whose email is listed in ADMIN_EMAILS. It must run
+// after IdentityPlatformMiddleware.
+func RequireAdmin(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		email, _ := c.Request.Context().Value(UserEmailKey).(string)
+		for _, admin := range cfg.AdminEmails {
+			if email != "" && strings.EqualFold(email, admin) {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+	}
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -19,6 +20,33 @@ const UserIDKey contextKey = "userID"
 const UserEmailKey contextKey = "userEmail"
 const TokenKey contextKey = "tokenKey"
 
+// ErrInvalidToken is returned by VerifyIDToken when Identity Platform rejects the token
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// VerifyIDToken checks with Identity Platform's REST API that the ID token is valid and not expired
+func VerifyIDToken(cfg *config.Config, token string) error {
+	apiURL := fmt.Sprintf("https://identitytoolkit.googleapis.com/v1/accounts:lookup?key=%s", cfg.AuthApiKey)
+
+	req, err := http.NewRequestWithContext(context.Background(), "POST", apiURL, strings.NewReader(fmt.Sprintf(`{"idToken":"%s"}`, token)))
+	if err != nil {
+		log.Printf("Failed to create request: %v", err)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to validate token: %v", err)
+		return ErrInvalidToken
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
 func IdentityPlatformMiddleware(cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the Authorization header
@@ -40,28 +68,12 @@ func IdentityPlatformMiddleware(cfg *config.Config) gin.HandlerFunc {
 		}
 
 		// Validate the token using Identity Platform's REST API
-		apiURL := fmt.Sprintf("https://identitytoolkit.googleapis.com/v1/accounts:lookup?key=%s", cfg.AuthApiKey)
-
-		req, err := http.NewRequestWithContext(context.Background(), "POST", apiURL, strings.NewReader(fmt.Sprintf(`{"idToken":"%s"}`, token)))
-		if err != nil {
-			log.Printf("Failed to create request: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
-			c.Abort()
-			return
-		}
-		req.Header.Set("Content-Type", "application/json")
-
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			log.Printf("Failed to validate token: %v", err)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-			c.Abort()
-			return
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		if err := VerifyIDToken(cfg, token); err != nil {
+			if errors.Is(err, ErrInvalidToken) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
+			}
 			c.Abort()
 			return
 		}
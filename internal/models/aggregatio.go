@@ -6,21 +6,32 @@ import (
 
 // ClientAggregations holds all aggregation data for a client_id
 type ClientAggregations struct {
-	ClientID     string                                                      `bson:"client_id" json:"client_id"`
-	Aggregations map[string]map[string]map[string]map[string]*AggregatedData `bson:"aggregations" json:"aggregations"`
+	ClientID     string                                                      `bson:"client_id" json:"client_id" firestore:"client_id"`
+	Aggregations map[string]map[string]map[string]map[string]*AggregatedData `bson:"aggregations" json:"aggregations" firestore:"aggregations"`
 	// Structure: channel -> variable -> period -> timestamp -> AggregatedData
 }
 
 // AggregatedData holds the aggregation result for a client/channel/variable/time period
 type AggregatedData struct {
-	ClientID  string    `bson:"client_id" json:"client_id"`
-	Channel   string    `bson:"channel" json:"channel"`
-	Variable  string    `bson:"variable" json:"variable"`
-	Period    string    `bson:"period" json:"period"`
-	Timestamp time.Time `bson:"timestamp" json:"timestamp"`
-	Sum       float64   `bson:"sum" json:"sum"`
-	Count     int       `bson:"count" json:"count"`
-	Min       float64   `bson:"min" json:"min"`
-	Max       float64   `bson:"max" json:"max"`
-	Avg       float64   `bson:"avg" json:"avg"`
-}
\ No newline at end of file
+	ClientID  string    `bson:"client_id" json:"client_id" firestore:"client_id"`
+	Channel   string    `bson:"channel" json:"channel" firestore:"channel"`
+	Variable  string    `bson:"variable" json:"variable" firestore:"variable"`
+	Period    string    `bson:"period" json:"period" firestore:"period"`
+	Timestamp time.Time `bson:"timestamp" json:"timestamp" firestore:"timestamp"`
+	Sum       float64   `bson:"sum" json:"sum" firestore:"sum"`
+	Count     int       `bson:"count" json:"count" firestore:"count"`
+	Min       float64   `bson:"min" json:"min" firestore:"min"`
+	Max       float64   `bson:"max" json:"max" firestore:"max"`
+	Avg       float64   `bson:"avg" json:"avg" firestore:"avg"`
+
+	// Mergeable state behind the extended statistics; absent from buckets written by older jobs
+	SumSquares float64        `bson:"sum_sq,omitempty" json:"-" firestore:"sum_sq,omitempty"`
+	First      float64        `bson:"first,omitempty" json:"-" firestore:"first,omitempty"`
+	FirstAt    *time.Time     `bson:"first_at,omitempty" json:"-" firestore:"first_at,omitempty"`
+	Last       float64        `bson:"last,omitempty" json:"-" firestore:"last,omitempty"`
+	LastAt     *time.Time     `bson:"last_at,omitempty" json:"-" firestore:"last_at,omitempty"`
+	Sketch     QuantileSketch `bson:"sketch,omitempty" json:"-" firestore:"sketch,omitempty"`
+
+	// Returned with stats=extended only
+	*ExtendedStats `bson:"-" firestore:"-"`
+}
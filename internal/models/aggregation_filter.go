@@ -0,0 +1,67 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// AggregationFilter selects entries of the precomputed aggregations of a device.
+// Empty lists leave a level unconstrained; time bounds apply to the bucket start.
+type AggregationFilter struct {
+	Channels  []string
+	Variables []string
+	Periods   []string
+	FromTime  *time.Time // Inclusive lower bound on the bucket timestamp
+	ToTime    *time.Time // Inclusive upper bound on the bucket timestamp
+	Extended  bool       // Also return percentiles, standard deviation and first/last values
+}
+
+// Validate checks that the filter is well formed
+func (f AggregationFilter) Validate() error {
+	if f.FromTime != nil && f.ToTime != nil && f.FromTime.After(*f.ToTime) {
+		return errors.New("fromTime must not be after toTime")
+	}
+	return nil
+}
+
+// MatchesKey reports whether the channel, variable and period of an entry are selected
+func (f AggregationFilter) MatchesKey(channel, variable, period string) bool {
+	return matchesValue(channel, "", f.Channels) &&
+		matchesValue(variable, "", f.Variables) &&
+		matchesValue(period, "", f.Periods)
+}
+
+// MatchesPeriod reports whether the buckets of a period are selected
+func (f AggregationFilter) MatchesPeriod(period string) bool {
+	return matchesValue(period, "", f.Periods)
+}
+
+// MatchesTime reports whether a bucket timestamp falls within the time bounds
+func (f AggregationFilter) MatchesTime(timestamp time.Time) bool {
+	if f.FromTime != nil && timestamp.Before(*f.FromTime) {
+		return false
+	}
+	if f.ToTime != nil && timestamp.After(*f.ToTime) {
+		return false
+	}
+	return true
+}
+
+// aggregationTimestampLayouts are the formats of the bucket keys written by the aggregator
+var aggregationTimestampLayouts = []string{
+	"2006-01-02T15",
+	"2006-01-02",
+	"2006-01",
+	time.RFC3339,
+	"2006-01-02T15:04",
+}
+
+// ParseAggregationTimestamp parses the timestamp key of a precomputed bucket as UTC
+func ParseAggregationTimestamp(key string) (time.Time, bool) {
+	for _, layout := range aggregationTimestampLayouts {
+		if t, err := time.Parse(layout, key); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BucketSize is the width of the time buckets of an on-demand aggregation
+type BucketSize string
+
+const (
+	Bucket1Minute  BucketSize = "1m"
+	Bucket5Minutes BucketSize = "5m"
+	Bucket1Hour    BucketSize = "1h"
+	Bucket1Day     BucketSize = "1d"
+)
+
+// bucketDurations lists the supported bucket sizes
+var bucketDurations = map[BucketSize]time.Duration{
+	Bucket1Minute:  time.Minute,
+	Bucket5Minutes: 5 * time.Minute,
+	Bucket1Hour:    time.Hour,
+	Bucket1Day:     24 * time.Hour,
+}
+
+// MaxAggregationBuckets caps the number of buckets a single query may span
+const MaxAggregationBuckets = 20000
+
+// Duration returns the width of the bucket; ok is false for unsupported sizes
+func (b BucketSize) Duration() (time.Duration, bool) {
+	d, ok := bucketDurations[b]
+	return d, ok
+}
+
+// Truncate returns the start of the UTC bucket holding t
+func (b BucketSize) Truncate(t time.Time) time.Time {
+	return t.UTC().Truncate(bucketDurations[b])
+}
+
+// AggregationQuery selects the messages of a device aggregated on demand. Every numeric
+// field of the parsed payload is aggregated unless Fields restricts them; nested fields
+// can be named with dot paths such as "temperature.tC".
+type AggregationQuery struct {
+	DeviceID string
+	From     time.Time // Inclusive lower bound on timestamp
+	To       time.Time // Inclusive upper bound on timestamp
+	Bucket   BucketSize
+	Fields   []string
+	Extended bool // Also return percentiles, standard deviation and first/last values
+}
+
+// Validate checks that the query is well formed and spans a bounded number of buckets
+func (q AggregationQuery) Validate() error {
+	if q.DeviceID == "" {
+		return errors.New("device ID is required")
+	}
+	width, ok := q.Bucket.Duration()
+	if !ok {
+		return fmt.Errorf("unsupported bucket %q: must be 1m, 5m, 1h or 1d", q.Bucket)
+	}
+	if q.From.IsZero() || q.To.IsZero() {
+		return errors.New("from and to are required")
+	}
+	if q.From.After(q.To) {
+		return errors.New("from must not be after to")
+	}
+	if q.To.Sub(q.From)/width >= MaxAggregationBuckets {
+		return fmt.Errorf("time range spans more than %d %s buckets", MaxAggregationBuckets, q.Bucket)
+	}
+	for _, field := range q.Fields {
+		if field == "" || strings.Contains(field, "$") {
+			return fmt.Errorf("invalid field name %q", field)
+		}
+	}
+	return nil
+}
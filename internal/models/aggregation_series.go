@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// AggregationPoint is one bucket of an aggregation series
+type AggregationPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Period    string    `json:"period"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Avg       float64   `json:"avg"`
+	Sum       float64   `json:"sum"`
+	Count     int       `json:"count"`
+	*ExtendedStats
+}
+
+// AggregationSeries groups aggregation buckets as channel -> variable -> points ordered by timestamp
+type AggregationSeries map[string]map[string][]AggregationPoint
+
+// NewAggregationSeries groups buckets, already ordered by timestamp, into series
+func NewAggregationSeries(data []*AggregatedData) AggregationSeries {
+	series := AggregationSeries{}
+	for _, d := range data {
+		variables, ok := series[d.Channel]
+		if !ok {
+			variables = map[string][]AggregationPoint{}
+			series[d.Channel] = variables
+		}
+		variables[d.Variable] = append(variables[d.Variable], AggregationPoint{
+			Timestamp:     d.Timestamp,
+			Period:        d.Period,
+			Min:           d.Min,
+			Max:           d.Max,
+			Avg:           d.Avg,
+			Sum:           d.Sum,
+			Count:         d.Count,
+			ExtendedStats: d.ExtendedStats,
+		})
+	}
+	return series
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewAggregationSeries(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	series := NewAggregationSeries([]*AggregatedData{
+		{Channel: "ch1", Variable: "temperature", Period: "hourly", Timestamp: base, Avg: 20, Count: 2},
+		{Channel: "ch2", Variable: "humidity", Period: "hourly", Timestamp: base, Avg: 40, Count: 1},
+		{Channel: "ch1", Variable: "temperature", Period: "hourly", Timestamp: base.Add(time.Hour), Avg: 22, Count: 1},
+	})
+
+	points := series["ch1"]["temperature"]
+	if len(points) != 2 || !points[0].Timestamp.Equal(base) || points[1].Avg != 22 {
+		t.Errorf("series[ch1][temperature] = %+v", points)
+	}
+	if len(series["ch2"]["humidity"]) != 1 || len(series) != 2 {
+		t.Errorf("NewAggregationSeries() = %+v", series)
+	}
+}
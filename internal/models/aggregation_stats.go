@@ -0,0 +1,198 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// sketchAccuracy is the relative accuracy of the quantiles estimated by QuantileSketch
+const sketchAccuracy = 0.01
+
+var (
+	sketchGamma = (1 + sketchAccuracy) / (1 - sketchAccuracy)
+	// SketchLogGamma divides the log of a magnitude to give the index of its bin; it is
+	// exported for the database pipelines that compute the bins server side
+	SketchLogGamma = math.Log(sketchGamma)
+)
+
+// SketchMinValue is the smallest magnitude told apart from zero
+const SketchMinValue = 1e-9
+
+// QuantileSketch is a mergeable quantile sketch with logarithmic bins (DDSketch). Every
+// estimated quantile is within 1% of a value of the bucket. Keys are "p<index>" and
+// "n<index>" for positive and negative values and "z" for zero; values are counts.
+type QuantileSketch map[string]int64
+
+// SketchBin returns the bin of a value in a QuantileSketch
+func SketchBin(value float64) string {
+	magnitude := math.Abs(value)
+	if magnitude < SketchMinValue {
+		return "z"
+	}
+	index := strconv.Itoa(int(math.Ceil(math.Log(magnitude) / SketchLogGamma)))
+	if value < 0 {
+		return "n" + index
+	}
+	return "p" + index
+}
+
+// sketchBinValue returns the representative value of a bin
+func sketchBinValue(bin string) (float64, bool) {
+	if bin == "z" {
+		return 0, true
+	}
+	if len(bin) < 2 {
+		return 0, false
+	}
+	index, err := strconv.Atoi(bin[1:])
+	if err != nil {
+		return 0, false
+	}
+	value := 2 * math.Pow(sketchGamma, float64(index)) / (sketchGamma + 1)
+	switch bin[0] {
+	case 'p':
+		return value, true
+	case 'n':
+		return -value, true
+	default:
+		return 0, false
+	}
+}
+
+// Merge adds the counts of another sketch
+func (s QuantileSketch) Merge(other QuantileSketch) {
+	for bin, count := range other {
+		s[bin] += count
+	}
+}
+
+// Quantile estimates the q-quantile (0 <= q <= 1); ok is false for an empty sketch
+func (s QuantileSketch) Quantile(q float64) (float64, bool) {
+	type bin struct {
+		value float64
+		count int64
+	}
+	var bins []bin
+	var total int64
+	for key, count := range s {
+		if value, ok := sketchBinValue(key); ok && count > 0 {
+			bins = append(bins, bin{value, count})
+			total += count
+		}
+	}
+	if total == 0 {
+		return 0, false
+	}
+	sort.Slice(bins, func(i, j int) bool { return bins[i].value < bins[j].value })
+
+	rank := int64(q * float64(total-1))
+	var seen int64
+	for _, b := range bins {
+		seen += b.count
+		if seen > rank {
+			return b.value, true
+		}
+	}
+	return bins[len(bins)-1].value, true
+}
+
+// ExtendedStats are the optional statistics of a bucket, derived from its mergeable state
+type ExtendedStats struct {
+	StdDev  *float64   `json:"stddev,omitempty"` // Population standard deviation
+	P50     *float64   `json:"p50,omitempty"`
+	P90     *float64   `json:"p90,omitempty"`
+	P99     *float64   `json:"p99,omitempty"`
+	First   *float64   `json:"first,omitempty"`
+	FirstAt *time.Time `json:"first_at,omitempty"`
+	Last    *float64   `json:"last,omitempty"`
+	LastAt  *time.Time `json:"last_at,omitempty"`
+}
+
+// Add accumulates a value observed at the given time into the bucket
+func (a *AggregatedData) Add(value float64, at time.Time) {
+	if a.Count == 0 || value < a.Min {
+		a.Min = value
+	}
+	if a.Count == 0 || value > a.Max {
+		a.Max = value
+	}
+	a.Sum += value
+	a.Count++
+	a.Avg = a.Sum / float64(a.Count)
+	a.SumSquares += value * value
+
+	if a.FirstAt == nil || at.Before(*a.FirstAt) {
+		first := at
+		a.First, a.FirstAt = value, &first
+	}
+	if a.LastAt == nil || !at.Before(*a.LastAt) {
+		last := at
+		a.Last, a.LastAt = value, &last
+	}
+	if a.Sketch == nil {
+		a.Sketch = QuantileSketch{}
+	}
+	a.Sketch[SketchBin(value)]++
+}
+
+// Merge combines another bucket of the same channel and variable into this one, e.g. to
+// derive a daily bucket from its hourly buckets
+func (a *AggregatedData) Merge(other *AggregatedData) {
+	if other.Count == 0 {
+		return
+	}
+	if a.Count == 0 || other.Min < a.Min {
+		a.Min = other.Min
+	}
+	if a.Count == 0 || other.Max > a.Max {
+		a.Max = other.Max
+	}
+	a.Sum += other.Sum
+	a.Count += other.Count
+	a.Avg = a.Sum / float64(a.Count)
+	a.SumSquares += other.SumSquares
+
+	if other.FirstAt != nil && (a.FirstAt == nil || other.FirstAt.Before(*a.FirstAt)) {
+		first := *other.FirstAt
+		a.First, a.FirstAt = other.First, &first
+	}
+	if other.LastAt != nil && (a.LastAt == nil || !other.LastAt.Before(*a.LastAt)) {
+		last := *other.LastAt
+		a.Last, a.LastAt = other.Last, &last
+	}
+	if len(other.Sketch) > 0 {
+		if a.Sketch == nil {
+			a.Sketch = QuantileSketch{}
+		}
+		a.Sketch.Merge(other.Sketch)
+	}
+}
+
+// WithExtendedStats fills ExtendedStats from the mergeable state of the bucket. Buckets
+// written without that state (e.g. by an external job) only get what can be derived.
+func (a *AggregatedData) WithExtendedStats() {
+	stats := &ExtendedStats{}
+	if a.Count > 0 && (a.SumSquares != 0 || a.Sketch != nil) {
+		variance := math.Max(0, a.SumSquares/float64(a.Count)-a.Avg*a.Avg)
+		stdDev := math.Sqrt(variance)
+		stats.StdDev = &stdDev
+	}
+	for q, field := range map[float64]**float64{0.5: &stats.P50, 0.9: &stats.P90, 0.99: &stats.P99} {
+		if value, ok := a.Sketch.Quantile(q); ok {
+			// The estimate never leaves the exact range of the bucket
+			value = math.Min(math.Max(value, a.Min), a.Max)
+			*field = &value
+		}
+	}
+	if a.FirstAt != nil {
+		first, firstAt := a.First, *a.FirstAt
+		stats.First, stats.FirstAt = &first, &firstAt
+	}
+	if a.LastAt != nil {
+		last, lastAt := a.Last, *a.LastAt
+		stats.Last, stats.LastAt = &last, &lastAt
+	}
+	a.ExtendedStats = stats
+}
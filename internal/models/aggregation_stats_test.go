@@ -0,0 +1,101 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuantileSketch(t *testing.T) {
+	sketch := QuantileSketch{}
+	for i := 1; i <= 1000; i++ {
+		sketch[SketchBin(float64(i))]++
+	}
+	for _, tt := range []struct{ q, want float64 }{{0, 1}, {0.5, 500}, {0.9, 900}, {0.99, 990}, {1, 1000}} {
+		got, ok := sketch.Quantile(tt.q)
+		if !ok || math.Abs(got-tt.want)/tt.want > 2*sketchAccuracy {
+			t.Errorf("Quantile(%v) = %v, want %v within %v%%", tt.q, got, tt.want, 200*sketchAccuracy)
+		}
+	}
+
+	mixed := QuantileSketch{}
+	for _, value := range []float64{-50, -1, 0, 0, 2} {
+		mixed[SketchBin(value)]++
+	}
+	if got, _ := mixed.Quantile(0); math.Abs(got+50) > 1 {
+		t.Errorf("Quantile(0) of mixed signs = %v, want about -50", got)
+	}
+	if got, _ := mixed.Quantile(0.5); got != 0 {
+		t.Errorf("Quantile(0.5) of mixed signs = %v, want 0", got)
+	}
+	if _, ok := (QuantileSketch{}).Quantile(0.5); ok {
+		t.Errorf("Quantile() of an empty sketch should not be ok")
+	}
+}
+
+func TestAggregatedDataMerge(t *testing.T) {
+	base := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+	values := []float64{12, 3, 7, 30, 1, 8}
+
+	whole := &AggregatedData{}
+	first, second := &AggregatedData{}, &AggregatedData{}
+	for i, value := range values {
+		at := base.Add(time.Duration(i) * time.Minute)
+		whole.Add(value, at)
+		if i%2 == 0 {
+			first.Add(value, at)
+		} else {
+			second.Add(value, at)
+		}
+	}
+	merged := &AggregatedData{}
+	merged.Merge(second)
+	merged.Merge(first)
+	merged.Merge(&AggregatedData{})
+
+	merged.WithExtendedStats()
+	whole.WithExtendedStats()
+	gotJSON, _ := json.Marshal(merged)
+	wantJSON, _ := json.Marshal(whole)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("Merge() = %s, want %s", gotJSON, wantJSON)
+	}
+	if *whole.ExtendedStats.First != 12 || *whole.ExtendedStats.Last != 8 || !whole.ExtendedStats.LastAt.Equal(base.Add(5*time.Minute)) {
+		t.Errorf("first/last = %v/%v, want 12/8", *whole.ExtendedStats.First, *whole.ExtendedStats.Last)
+	}
+	if want := 9.5467; math.Abs(*whole.StdDev-want) > 0.001 {
+		t.Errorf("stddev = %v, want %v", *whole.StdDev, want)
+	}
+	if *whole.P99 > whole.Max || *whole.P50 < 6.5 || *whole.P50 > 8.5 {
+		t.Errorf("p50 = %v, p99 = %v out of range", *whole.P50, *whole.P99)
+	}
+}
+
+func TestAggregatedDataJSON(t *testing.T) {
+	bucket := &AggregatedData{}
+	bucket.Add(5, time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC))
+
+	basic, _ := json.Marshal(bucket)
+	for _, field := range []string{"stddev", "p50", "first", "sketch", "sum_sq"} {
+		if strings.Contains(string(basic), `"`+field+`"`) {
+			t.Errorf("basic JSON %s contains %s", basic, field)
+		}
+	}
+
+	bucket.WithExtendedStats()
+	extended, _ := json.Marshal(bucket)
+	for _, field := range []string{"stddev", "p50", "p90", "p99", "first", "first_at", "last", "last_at"} {
+		if !strings.Contains(string(extended), `"`+field+`"`) {
+			t.Errorf("extended JSON %s lacks %s", extended, field)
+		}
+	}
+
+	// Buckets written without the mergeable state only report what can be derived
+	legacy := &AggregatedData{Sum: 10, Count: 2, Min: 4, Max: 6, Avg: 5}
+	legacy.WithExtendedStats()
+	if legacy.StdDev != nil || legacy.P50 != nil || legacy.First != 0 || legacy.ExtendedStats.First != nil {
+		t.Errorf("legacy extended stats = %+v, want none", legacy.ExtendedStats)
+	}
+}
@@ -0,0 +1,297 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AlertRuleKind selects how an alert rule is evaluated
+type AlertRuleKind string
+
+const (
+	AlertRuleThreshold AlertRuleKind = "threshold" // The variable compared with the threshold
+	AlertRuleRate      AlertRuleKind = "rate"      // The change of the variable per minute between two messages compared with the threshold
+	AlertRuleMissing   AlertRuleKind = "missing"   // No message from the device for ForSeconds
+)
+
+// AlertSeverity ranks the alerts of a rule
+type AlertSeverity string
+
+const (
+	AlertSeverityInfo     AlertSeverity = "info"
+	AlertSeverityWarning  AlertSeverity = "warning"
+	AlertSeverityCritical AlertSeverity = "critical"
+)
+
+// MaxAlertRuleFor caps the time a condition must hold before an alert opens
+const MaxAlertRuleFor = 7 * 24 * time.Hour
+
+// alertOperators lists the comparison operators of the threshold and rate rules
+var alertOperators = map[string]func(value, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+// AlertRule opens an alert for a device of its project (or only for DeviceID when set) when
+// its condition holds for ForSeconds, e.g. a threshold rule {"variable": "temperature",
+// "operator": ">", "threshold": 80, "forSeconds": 300}
+type AlertRule struct {
+	ID          string        `bson:"_id" json:"id" firestore:"-"`
+	ProjectID   string        `bson:"projectId" json:"projectId" firestore:"projectId"`
+	DeviceID    string        `bson:"deviceId,omitempty" json:"deviceId,omitempty" firestore:"deviceId,omitempty"` // Every device of the project when empty
+	Name        string        `bson:"name" json:"name" firestore:"name"`
+	Kind        AlertRuleKind `bson:"kind" json:"kind" firestore:"kind"`
+	Variable    string        `bson:"variable,omitempty" json:"variable,omitempty" firestore:"variable,omitempty"` // Dotted path in marshalled
+	Channel     string        `bson:"channel,omitempty" json:"channel,omitempty" firestore:"channel,omitempty"`    // Every channel when empty
+	Operator    string        `bson:"operator,omitempty" json:"operator,omitempty" firestore:"operator,omitempty"`
+	Threshold   float64       `bson:"threshold" json:"threshold" firestore:"threshold"`
+	ForSeconds  int64         `bson:"forSeconds" json:"forSeconds" firestore:"forSeconds"`
+	Severity    AlertSeverity `bson:"severity" json:"severity" firestore:"severity"`
+	Enabled     bool          `bson:"enabled" json:"enabled" firestore:"enabled"`
+	CreatedBy   string        `bson:"createdBy" json:"createdBy" firestore:"createdBy"`
+	CreatedAt   time.Time     `bson:"createdAt" json:"createdAt" firestore:"createdAt"`
+	UpdatedAt   time.Time     `bson:"updatedAt" json:"updatedAt" firestore:"updatedAt"`
+	Description string        `bson:"description,omitempty" json:"description,omitempty" firestore:"description,omitempty"`
+}
+
+// AlertRuleUpdate lists the fields of a rule that can be changed; nil fields are kept
+type AlertRuleUpdate struct {
+	Name        *string        `json:"name,omitempty"`
+	Description *string        `json:"description,omitempty"`
+	DeviceID    *string        `json:"deviceId,omitempty"`
+	Variable    *string        `json:"variable,omitempty"`
+	Channel     *string        `json:"channel,omitempty"`
+	Operator    *string        `json:"operator,omitempty"`
+	Threshold   *float64       `json:"threshold,omitempty"`
+	ForSeconds  *int64         `json:"forSeconds,omitempty"`
+	Severity    *AlertSeverity `json:"severity,omitempty"`
+	Enabled     *bool          `json:"enabled,omitempty"`
+}
+
+// IsEmpty reports whether the update changes nothing
+func (u AlertRuleUpdate) IsEmpty() bool {
+	return u == AlertRuleUpdate{}
+}
+
+// Apply copies the set fields of the update onto the rule
+func (u AlertRuleUpdate) Apply(r *AlertRule) {
+	if u.Name != nil {
+		r.Name = *u.Name
+	}
+	if u.Description != nil {
+		r.Description = *u.Description
+	}
+	if u.DeviceID != nil {
+		r.DeviceID = *u.DeviceID
+	}
+	if u.Variable != nil {
+		r.Variable = *u.Variable
+	}
+	if u.Channel != nil {
+		r.Channel = *u.Channel
+	}
+	if u.Operator != nil {
+		r.Operator = *u.Operator
+	}
+	if u.Threshold != nil {
+		r.Threshold = *u.Threshold
+	}
+	if u.ForSeconds != nil {
+		r.ForSeconds = *u.ForSeconds
+	}
+	if u.Severity != nil {
+		r.Severity = *u.Severity
+	}
+	if u.Enabled != nil {
+		r.Enabled = *u.Enabled
+	}
+}
+
+// Validate checks that the rule can be evaluated
+func (r *AlertRule) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name is required")
+	}
+	switch r.Severity {
+	case AlertSeverityInfo, AlertSeverityWarning, AlertSeverityCritical:
+	default:
+		return fmt.Errorf("severity must be %s, %s or %s", AlertSeverityInfo, AlertSeverityWarning, AlertSeverityCritical)
+	}
+	if r.ForSeconds < 0 || time.Duration(r.ForSeconds)*time.Second > MaxAlertRuleFor {
+		return fmt.Errorf("forSeconds must be between 0 and %d", int64(MaxAlertRuleFor/time.Second))
+	}
+
+	switch r.Kind {
+	case AlertRuleThreshold, AlertRuleRate:
+		if r.Variable == "" {
+			return fmt.Errorf("variable is required for %s rules", r.Kind)
+		}
+		if _, ok := alertOperators[r.Operator]; !ok {
+			return errors.New("operator must be one of >, >=, <, <=, ==, !=")
+		}
+	case AlertRuleMissing:
+		if r.ForSeconds <= 0 {
+			return errors.New("forSeconds must be positive for missing rules")
+		}
+	default:
+		return fmt.Errorf("kind must be %s, %s or %s", AlertRuleThreshold, AlertRuleRate, AlertRuleMissing)
+	}
+	return nil
+}
+
+// For returns how long the condition must hold before an alert opens
+func (r *AlertRule) For() time.Duration {
+	return time.Duration(r.ForSeconds) * time.Second
+}
+
+// AppliesTo reports whether the rule evaluates the messages of the device in the project
+func (r *AlertRule) AppliesTo(projectID, deviceID string) bool {
+	return r.Enabled && r.ProjectID == projectID && (r.DeviceID == "" || r.DeviceID == deviceID)
+}
+
+// Value returns the variable of the rule in the message, when the message has it numerically
+// and belongs to the channel of the rule
+func (r *AlertRule) Value(message *Message) (float64, bool) {
+	if r.Channel != "" && message.Channel() != r.Channel {
+		return 0, false
+	}
+	return toFloat(lookupPath(message.Marshalled, strings.TrimPrefix(r.Variable, "marshalled.")))
+}
+
+// Compare applies the operator of the rule to the value and the threshold
+func (r *AlertRule) Compare(value float64) bool {
+	compare, ok := alertOperators[r.Operator]
+	return ok && compare(value, r.Threshold)
+}
+
+// Condition describes the condition of the rule, e.g. "temperature > 80 for 5m0s"
+func (r *AlertRule) Condition() string {
+	var condition string
+	switch r.Kind {
+	case AlertRuleRate:
+		condition = "rate of " + r.Variable + " per minute " + r.Operator + " " + strconv.FormatFloat(r.Threshold, 'g', -1, 64)
+	case AlertRuleMissing:
+		return "no message for " + r.For().String()
+	default:
+		condition = r.Variable + " " + r.Operator + " " + strconv.FormatFloat(r.Threshold, 'g', -1, 64)
+	}
+	if r.ForSeconds > 0 {
+		condition += " for " + r.For().String()
+	}
+	return condition
+}
+
+// AlertState is the lifecycle state of an alert
+type AlertState string
+
+const (
+	AlertStateOpen         AlertState = "open"         // The condition holds and nobody acknowledged the alert
+	AlertStateAcknowledged AlertState = "acknowledged" // Someone is handling the alert
+	AlertStateResolved     AlertState = "resolved"     // The condition cleared or someone resolved the alert
+)
+
+// AlertResolvedByRule is recorded as the resolver of alerts whose condition cleared
+const AlertResolvedByRule = "rule"
+
+// Alert is raised by a rule for a device. A rule has at most one active (open or
+// acknowledged) alert per device: while it is active, the condition firing again only
+// counts an occurrence.
+type Alert struct {
+	ID               string        `bson:"_id" json:"id" firestore:"-"`
+	RuleID           string        `bson:"ruleId" json:"ruleId" firestore:"ruleId"`
+	RuleName         string        `bson:"ruleName" json:"ruleName" firestore:"ruleName"`
+	ProjectID        string        `bson:"projectId" json:"projectId" firestore:"projectId"`
+	DeviceID         string        `bson:"deviceId" json:"deviceId" firestore:"deviceId"`
+	Kind             AlertRuleKind `bson:"kind" json:"kind" firestore:"kind"`
+	Severity         AlertSeverity `bson:"severity" json:"severity" firestore:"severity"`
+	State            AlertState    `bson:"state" json:"state" firestore:"state"`
+	Summary          string        `bson:"summary" json:"summary" firestore:"summary"`
+	Value            *float64      `bson:"value,omitempty" json:"value,omitempty" firestore:"value,omitempty"` // Value that opened the alert
+	Occurrences      int           `bson:"occurrences" json:"occurrences" firestore:"occurrences"`
+	MessageID        string        `bson:"messageId,omitempty" json:"messageId,omitempty" firestore:"messageId,omitempty"`                      // Alert message stored with the other messages
+	TriggerMessageID string        `bson:"triggerMessageId,omitempty" json:"triggerMessageId,omitempty" firestore:"triggerMessageId,omitempty"` // Message that opened the alert
+	OpenedAt         time.Time     `bson:"openedAt" json:"openedAt" firestore:"openedAt"`
+	LastTriggeredAt  time.Time     `bson:"lastTriggeredAt" json:"lastTriggeredAt" firestore:"lastTriggeredAt"`
+	AcknowledgedAt   *time.Time    `bson:"acknowledgedAt,omitempty" json:"acknowledgedAt,omitempty" firestore:"acknowledgedAt,omitempty"`
+	AcknowledgedBy   string        `bson:"acknowledgedBy,omitempty" json:"acknowledgedBy,omitempty" firestore:"acknowledgedBy,omitempty"`
+	ResolvedAt       *time.Time    `bson:"resolvedAt,omitempty" json:"resolvedAt,omitempty" firestore:"resolvedAt,omitempty"`
+	ResolvedBy       string        `bson:"resolvedBy,omitempty" json:"resolvedBy,omitempty" firestore:"resolvedBy,omitempty"`
+	UpdatedAt        time.Time     `bson:"updatedAt" json:"updatedAt" firestore:"updatedAt"`
+}
+
+// IsActive reports whether the alert is open or acknowledged
+func (a *Alert) IsActive() bool {
+	return a.State == AlertStateOpen || a.State == AlertStateAcknowledged
+}
+
+// Acknowledge records that the user handles the alert
+func (a *Alert) Acknowledge(user string, at time.Time) error {
+	if a.State != AlertStateOpen {
+		return fmt.Errorf("an %s alert cannot be acknowledged", a.State)
+	}
+	a.State = AlertStateAcknowledged
+	a.AcknowledgedAt = &at
+	a.AcknowledgedBy = user
+	a.UpdatedAt = at
+	return nil
+}
+
+// Resolve closes the alert
+func (a *Alert) Resolve(by string, at time.Time) error {
+	if !a.IsActive() {
+		return errors.New("the alert is already resolved")
+	}
+	a.State = AlertStateResolved
+	a.ResolvedAt = &at
+	a.ResolvedBy = by
+	a.UpdatedAt = at
+	return nil
+}
+
+// MessageMetadata returns the metadata of the alert message, which follows the alert state
+func (a *Alert) MessageMetadata() map[string]string {
+	return map[string]string{
+		"alertId":  a.ID,
+		"ruleId":   a.RuleID,
+		"severity": string(a.Severity),
+		"state":    string(a.State),
+	}
+}
+
+// MessageUpdate returns the update that brings the metadata of the alert message up to date
+func (a *Alert) MessageUpdate() MessageUpdate {
+	return MessageUpdate{Metadata: a.MessageMetadata(), UpdatedAt: a.UpdatedAt}
+}
+
+// AlertFilter selects alerts; empty fields match everything
+type AlertFilter struct {
+	ProjectID string
+	DeviceID  string
+	RuleID    string
+	States    []AlertState
+}
+
+// Matches reports whether the alert is selected by the filter
+func (f AlertFilter) Matches(a *Alert) bool {
+	if (f.ProjectID != "" && a.ProjectID != f.ProjectID) ||
+		(f.DeviceID != "" && a.DeviceID != f.DeviceID) ||
+		(f.RuleID != "" && a.RuleID != f.RuleID) {
+		return false
+	}
+	if len(f.States) == 0 {
+		return true
+	}
+	for _, state := range f.States {
+		if a.State == state {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlertRuleValidate(t *testing.T) {
+	threshold := func() AlertRule {
+		return AlertRule{Name: "hot", Kind: AlertRuleThreshold, Variable: "temperature", Operator: ">", Threshold: 80, ForSeconds: 300, Severity: AlertSeverityWarning}
+	}
+	missing := AlertRule{Name: "silent", Kind: AlertRuleMissing, ForSeconds: 600, Severity: AlertSeverityCritical}
+
+	tests := []struct {
+		name    string
+		change  func(r *AlertRule)
+		base    AlertRule
+		wantErr bool
+	}{
+		{name: "threshold", base: threshold()},
+		{name: "rate", base: threshold(), change: func(r *AlertRule) { r.Kind = AlertRuleRate }},
+		{name: "missing", base: missing},
+		{name: "missing name", base: threshold(), change: func(r *AlertRule) { r.Name = " " }, wantErr: true},
+		{name: "unknown kind", base: threshold(), change: func(r *AlertRule) { r.Kind = "anomaly" }, wantErr: true},
+		{name: "unknown severity", base: threshold(), change: func(r *AlertRule) { r.Severity = "major" }, wantErr: true},
+		{name: "missing variable", base: threshold(), change: func(r *AlertRule) { r.Variable = "" }, wantErr: true},
+		{name: "unknown operator", base: threshold(), change: func(r *AlertRule) { r.Operator = "=>" }, wantErr: true},
+		{name: "negative for", base: threshold(), change: func(r *AlertRule) { r.ForSeconds = -1 }, wantErr: true},
+		{name: "for too long", base: threshold(), change: func(r *AlertRule) { r.ForSeconds = int64(MaxAlertRuleFor/time.Second) + 1 }, wantErr: true},
+		{name: "missing without for", base: missing, change: func(r *AlertRule) { r.ForSeconds = 0 }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := tt.base
+			if tt.change != nil {
+				tt.change(&rule)
+			}
+			err := rule.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAlertRuleEvaluation(t *testing.T) {
+	rule := AlertRule{ProjectID: "p1", Kind: AlertRuleThreshold, Variable: "marshalled.env.temperature", Channel: "ch1", Operator: ">=", Threshold: 80, ForSeconds: 300, Enabled: true}
+	message := &Message{
+		Metadata:   map[string]string{ChannelMetadataKey: "ch1"},
+		Marshalled: map[string]interface{}{"env": map[string]interface{}{"temperature": 85.5}},
+	}
+
+	if value, ok := rule.Value(message); !ok || value != 85.5 {
+		t.Errorf("Value() = %v, %v; want 85.5, true", value, ok)
+	}
+	if !rule.Compare(80) || rule.Compare(79.9) {
+		t.Error("Compare() does not apply >=")
+	}
+	message.Metadata = nil
+	if _, ok := rule.Value(message); ok {
+		t.Error("Value() of a message of another channel should not be found")
+	}
+	if got := rule.Condition(); got != "marshalled.env.temperature >= 80 for 5m0s" {
+		t.Errorf("Condition() = %q", got)
+	}
+
+	if !rule.AppliesTo("p1", "dev-1") || rule.AppliesTo("p2", "dev-1") {
+		t.Error("AppliesTo() should match every device of the project only")
+	}
+	rule.DeviceID = "dev-2"
+	if rule.AppliesTo("p1", "dev-1") {
+		t.Error("AppliesTo() should only match the device of the rule")
+	}
+	rule.Enabled = false
+	if rule.AppliesTo("p1", "dev-2") {
+		t.Error("AppliesTo() should not match for a disabled rule")
+	}
+}
+
+func TestAlertLifecycle(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	alert := &Alert{State: AlertStateOpen}
+
+	if err := alert.Acknowledge("ops@example.com", at); err != nil || alert.State != AlertStateAcknowledged || alert.AcknowledgedBy != "ops@example.com" {
+		t.Fatalf("Acknowledge() = %v, alert %+v", err, alert)
+	}
+	if err := alert.Acknowledge("ops@example.com", at); err == nil {
+		t.Error("Acknowledge() twice should fail")
+	}
+	if !alert.IsActive() {
+		t.Error("acknowledged alert should be active")
+	}
+	if err := alert.Resolve(AlertResolvedByRule, at.Add(time.Minute)); err != nil || alert.State != AlertStateResolved || !alert.ResolvedAt.Equal(at.Add(time.Minute)) {
+		t.Fatalf("Resolve() = %v, alert %+v", err, alert)
+	}
+	if err := alert.Resolve("ops@example.com", at); err == nil {
+		t.Error("Resolve() of a resolved alert should fail")
+	}
+	if alert.MessageMetadata()["state"] != "resolved" {
+		t.Errorf("MessageMetadata() = %v", alert.MessageMetadata())
+	}
+}
+
+func TestAlertFilterMatches(t *testing.T) {
+	alert := &Alert{ProjectID: "p1", DeviceID: "dev-1", RuleID: "r1", State: AlertStateAcknowledged}
+
+	tests := []struct {
+		name   string
+		filter AlertFilter
+		want   bool
+	}{
+		{name: "empty", want: true},
+		{name: "project and device", filter: AlertFilter{ProjectID: "p1", DeviceID: "dev-1"}, want: true},
+		{name: "active states", filter: AlertFilter{States: []AlertState{AlertStateOpen, AlertStateAcknowledged}}, want: true},
+		{name: "other state", filter: AlertFilter{States: []AlertState{AlertStateResolved}}},
+		{name: "other rule", filter: AlertFilter{RuleID: "r2"}},
+		{name: "other project", filter: AlertFilter{ProjectID: "p2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Matches(alert); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxCompareDevices caps the number of devices a single comparison may span
+const MaxCompareDevices = 50
+
+// ComparisonQuery selects one variable of several devices, either listed explicitly or
+// every device of a project, aggregated either from the stored rollups (Period) or on
+// demand (Bucket). Without a channel the buckets of every channel are merged.
+type ComparisonQuery struct {
+	DeviceIDs []string
+	ProjectID string
+	Variable  string
+	Channel   string       // Optional; only buckets of this channel
+	Period    RollupPeriod // Stored rollup period; exclusive with Bucket
+	Bucket    BucketSize   // On-demand bucket size; exclusive with Period
+	From      time.Time    // Inclusive lower bound on the bucket timestamp
+	To        time.Time    // Inclusive upper bound on the bucket timestamp
+	Summary   bool         // Also return the cross-device summary of every bucket
+	Extended  bool         // Also return percentiles, standard deviation and first/last values
+}
+
+// Validate checks that the query is well formed
+func (q ComparisonQuery) Validate() error {
+	if (len(q.DeviceIDs) == 0) == (q.ProjectID == "") {
+		return errors.New("exactly one of devices or project is required")
+	}
+	if len(q.DeviceIDs) > MaxCompareDevices {
+		return fmt.Errorf("at most %d devices can be compared", MaxCompareDevices)
+	}
+	seen := make(map[string]bool, len(q.DeviceIDs))
+	for _, deviceID := range q.DeviceIDs {
+		if deviceID == "" {
+			return errors.New("device IDs must not be empty")
+		}
+		if seen[deviceID] {
+			return fmt.Errorf("device %q is listed more than once", deviceID)
+		}
+		seen[deviceID] = true
+	}
+	if q.Variable == "" || strings.Contains(q.Variable, "$") {
+		return fmt.Errorf("invalid variable %q", q.Variable)
+	}
+	if (q.Period == "") == (q.Bucket == "") {
+		return errors.New("exactly one of period or bucket is required")
+	}
+	if q.Period != "" {
+		if _, ok := rollupKeyLayouts[q.Period]; !ok {
+			return fmt.Errorf("unsupported period %q: must be hourly, daily or monthly", q.Period)
+		}
+		if q.From.IsZero() || q.To.IsZero() {
+			return errors.New("from and to are required")
+		}
+		if q.From.After(q.To) {
+			return errors.New("from must not be after to")
+		}
+		return nil
+	}
+	// The device ID is checked above; reuse the bucket and range checks of a single device
+	return AggregationQuery{DeviceID: "-", From: q.From, To: q.To, Bucket: q.Bucket, Fields: []string{q.Variable}}.Validate()
+}
+
+// ComparisonSummary describes the spread of the device averages of one bucket
+type ComparisonSummary struct {
+	Timestamp time.Time `json:"timestamp"`
+	Devices   int       `json:"devices"` // Devices with data in the bucket
+	Mean      float64   `json:"mean"`    // Mean of the device averages
+	Min       float64   `json:"min"`     // Lowest device average
+	Max       float64   `json:"max"`     // Highest device average
+	Spread    float64   `json:"spread"`  // Max - Min
+	StdDev    float64   `json:"stddev"`  // Population standard deviation of the device averages
+}
+
+// DeviceComparison holds the buckets of several devices aligned on the same timestamps:
+// Series[device][i] is the bucket of Timestamps[i], or null when the device has no data there
+type DeviceComparison struct {
+	Variable   string                         `json:"variable"`
+	Channel    string                         `json:"channel,omitempty"`
+	Period     RollupPeriod                   `json:"period,omitempty"`
+	Bucket     BucketSize                     `json:"bucket,omitempty"`
+	From       time.Time                      `json:"from"`
+	To         time.Time                      `json:"to"`
+	Devices    []string                       `json:"devices"`
+	Timestamps []time.Time                    `json:"timestamps"`
+	Series     map[string][]*AggregationPoint `json:"series"`
+	Summary    []ComparisonSummary            `json:"summary,omitempty"`
+}
+
+// NewDeviceComparison aligns the buckets of every device, given as device ID -> buckets
+// of the query variable. Buckets of the same device and timestamp (one per channel) are merged.
+func NewDeviceComparison(query ComparisonQuery, deviceIDs []string, buckets map[string][]*AggregatedData) *DeviceComparison {
+	merged := make(map[string]map[time.Time]*AggregatedData, len(deviceIDs))
+	seen := map[time.Time]bool{}
+	for _, deviceID := range deviceIDs {
+		byTime := map[time.Time]*AggregatedData{}
+		for _, bucket := range buckets[deviceID] {
+			at := bucket.Timestamp.UTC()
+			current, ok := byTime[at]
+			if !ok {
+				current = &AggregatedData{ClientID: deviceID, Channel: query.Channel, Variable: bucket.Variable, Period: bucket.Period, Timestamp: at}
+				byTime[at] = current
+			}
+			current.Merge(bucket)
+			seen[at] = true
+		}
+		merged[deviceID] = byTime
+	}
+
+	timestamps := make([]time.Time, 0, len(seen))
+	for at := range seen {
+		timestamps = append(timestamps, at)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i].Before(timestamps[j]) })
+
+	comparison := &DeviceComparison{
+		Variable:   query.Variable,
+		Channel:    query.Channel,
+		Period:     query.Period,
+		Bucket:     query.Bucket,
+		From:       query.From,
+		To:         query.To,
+		Devices:    deviceIDs,
+		Timestamps: timestamps,
+		Series:     make(map[string][]*AggregationPoint, len(deviceIDs)),
+	}
+	for _, deviceID := range deviceIDs {
+		points := make([]*AggregationPoint, len(timestamps))
+		for i, at := range timestamps {
+			bucket, ok := merged[deviceID][at]
+			if !ok {
+				continue
+			}
+			if query.Extended {
+				bucket.WithExtendedStats()
+			}
+			points[i] = &AggregationPoint{
+				Timestamp:     at,
+				Period:        bucket.Period,
+				Min:           bucket.Min,
+				Max:           bucket.Max,
+				Avg:           bucket.Avg,
+				Sum:           bucket.Sum,
+				Count:         bucket.Count,
+				ExtendedStats: bucket.ExtendedStats,
+			}
+		}
+		comparison.Series[deviceID] = points
+	}
+
+	if query.Summary {
+		comparison.Summary = make([]ComparisonSummary, 0, len(timestamps))
+		for i, at := range timestamps {
+			comparison.Summary = append(comparison.Summary, summarize(at, comparison.Series, deviceIDs, i))
+		}
+	}
+	return comparison
+}
+
+// summarize computes the cross-device summary of the i-th bucket
+func summarize(at time.Time, series map[string][]*AggregationPoint, deviceIDs []string, i int) ComparisonSummary {
+	summary := ComparisonSummary{Timestamp: at}
+	var sum, sumSquares float64
+	for _, deviceID := range deviceIDs {
+		point := series[deviceID][i]
+		if point == nil {
+			continue
+		}
+		if summary.Devices == 0 || point.Avg < summary.Min {
+			summary.Min = point.Avg
+		}
+		if summary.Devices == 0 || point.Avg > summary.Max {
+			summary.Max = point.Avg
+		}
+		sum += point.Avg
+		sumSquares += point.Avg * point.Avg
+		summary.Devices++
+	}
+	if summary.Devices > 0 {
+		n := float64(summary.Devices)
+		summary.Mean = sum / n
+		summary.Spread = summary.Max - summary.Min
+		summary.StdDev = math.Sqrt(math.Max(0, sumSquares/n-summary.Mean*summary.Mean))
+	}
+	return summary
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestComparisonQueryValidate(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	tooMany := make([]string, MaxCompareDevices+1)
+	for i := range tooMany {
+		tooMany[i] = string(rune('a'+i%26)) + string(rune('a'+i/26))
+	}
+
+	tests := []struct {
+		name    string
+		query   ComparisonQuery
+		wantErr bool
+	}{
+		{name: "devices with period", query: ComparisonQuery{DeviceIDs: []string{"a", "b"}, Variable: "temperature", Period: PeriodHourly, From: from, To: to}},
+		{name: "project with bucket", query: ComparisonQuery{ProjectID: "p1", Variable: "temperature", Bucket: Bucket1Hour, From: from, To: to}},
+		{name: "neither devices nor project", query: ComparisonQuery{Variable: "temperature", Period: PeriodHourly, From: from, To: to}, wantErr: true},
+		{name: "both devices and project", query: ComparisonQuery{DeviceIDs: []string{"a"}, ProjectID: "p1", Variable: "temperature", Period: PeriodHourly, From: from, To: to}, wantErr: true},
+		{name: "too many devices", query: ComparisonQuery{DeviceIDs: tooMany, Variable: "temperature", Period: PeriodHourly, From: from, To: to}, wantErr: true},
+		{name: "duplicate device", query: ComparisonQuery{DeviceIDs: []string{"a", "a"}, Variable: "temperature", Period: PeriodHourly, From: from, To: to}, wantErr: true},
+		{name: "empty device", query: ComparisonQuery{DeviceIDs: []string{"a", ""}, Variable: "temperature", Period: PeriodHourly, From: from, To: to}, wantErr: true},
+		{name: "missing variable", query: ComparisonQuery{DeviceIDs: []string{"a"}, Period: PeriodHourly, From: from, To: to}, wantErr: true},
+		{name: "operator variable", query: ComparisonQuery{DeviceIDs: []string{"a"}, Variable: "$where", Period: PeriodHourly, From: from, To: to}, wantErr: true},
+		{name: "period and bucket", query: ComparisonQuery{DeviceIDs: []string{"a"}, Variable: "temperature", Period: PeriodHourly, Bucket: Bucket1Hour, From: from, To: to}, wantErr: true},
+		{name: "neither period nor bucket", query: ComparisonQuery{DeviceIDs: []string{"a"}, Variable: "temperature", From: from, To: to}, wantErr: true},
+		{name: "unsupported period", query: ComparisonQuery{DeviceIDs: []string{"a"}, Variable: "temperature", Period: "weekly", From: from, To: to}, wantErr: true},
+		{name: "unsupported bucket", query: ComparisonQuery{DeviceIDs: []string{"a"}, Variable: "temperature", Bucket: "2h", From: from, To: to}, wantErr: true},
+		{name: "inverted range", query: ComparisonQuery{DeviceIDs: []string{"a"}, Variable: "temperature", Period: PeriodDaily, From: to, To: from}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.query.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewDeviceComparison(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	query := ComparisonQuery{DeviceIDs: []string{"a", "b", "c"}, Variable: "temperature", Period: PeriodHourly, From: base, To: base.Add(2 * time.Hour), Summary: true}
+	buckets := map[string][]*AggregatedData{
+		"a": {
+			{Channel: "ch1", Variable: "temperature", Period: "hourly", Timestamp: base, Sum: 40, Count: 2, Min: 19, Max: 21, Avg: 20},
+			// Same hour on another channel, merged into the first bucket
+			{Channel: "ch2", Variable: "temperature", Period: "hourly", Timestamp: base, Sum: 26, Count: 1, Min: 26, Max: 26, Avg: 26},
+		},
+		"b": {
+			{Channel: "ch1", Variable: "temperature", Period: "hourly", Timestamp: base, Sum: 30, Count: 1, Min: 30, Max: 30, Avg: 30},
+			{Channel: "ch1", Variable: "temperature", Period: "hourly", Timestamp: base.Add(time.Hour), Sum: 25, Count: 1, Min: 25, Max: 25, Avg: 25},
+		},
+	}
+
+	got := NewDeviceComparison(query, query.DeviceIDs, buckets)
+
+	if len(got.Timestamps) != 2 || !got.Timestamps[0].Equal(base) || !got.Timestamps[1].Equal(base.Add(time.Hour)) {
+		t.Fatalf("Timestamps = %v", got.Timestamps)
+	}
+	a := got.Series["a"]
+	if len(a) != 2 || a[0] == nil || a[0].Count != 3 || a[0].Avg != 22 || a[0].Min != 19 || a[0].Max != 26 || a[1] != nil {
+		t.Errorf("Series[a] = %+v", a)
+	}
+	if b := got.Series["b"]; len(b) != 2 || b[0] == nil || b[1] == nil || b[1].Avg != 25 {
+		t.Errorf("Series[b] = %+v", b)
+	}
+	if c := got.Series["c"]; len(c) != 2 || c[0] != nil || c[1] != nil {
+		t.Errorf("Series[c] = %+v, want two empty points", c)
+	}
+
+	if len(got.Summary) != 2 {
+		t.Fatalf("Summary = %+v", got.Summary)
+	}
+	first := got.Summary[0]
+	if first.Devices != 2 || first.Mean != 26 || first.Min != 22 || first.Max != 30 || first.Spread != 8 || math.Abs(first.StdDev-4) > 1e-9 {
+		t.Errorf("Summary[0] = %+v", first)
+	}
+	if second := got.Summary[1]; second.Devices != 1 || second.Mean != 25 || second.Spread != 0 || second.StdDev != 0 {
+		t.Errorf("Summary[1] = %+v", second)
+	}
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// MinCompletenessInterval is the shortest expected reporting interval that can be checked
+	MinCompletenessInterval = time.Second
+	// MaxCompletenessSlots caps the number of expected reports a single check may span
+	MaxCompletenessSlots = 1000000
+	// MaxCompletenessGaps caps the number of gaps listed in a report; longer lists are truncated
+	MaxCompletenessGaps = 1000
+)
+
+// CompletenessQuery checks that a device reported every Interval between From and To.
+// Silences longer than Interval + Grace are gaps; Grace defaults to half the interval.
+type CompletenessQuery struct {
+	DeviceID string
+	Channel  string // Optional; only messages of this channel
+	Interval time.Duration
+	Grace    time.Duration
+	From     time.Time
+	To       time.Time
+}
+
+// Validate checks that the query is well formed and spans a bounded number of reports
+func (q CompletenessQuery) Validate() error {
+	if q.DeviceID == "" {
+		return errors.New("device ID is required")
+	}
+	if q.Interval < MinCompletenessInterval {
+		return fmt.Errorf("interval must be at least %s", MinCompletenessInterval)
+	}
+	if q.Grace < 0 {
+		return errors.New("grace must not be negative")
+	}
+	if q.From.IsZero() || q.To.IsZero() {
+		return errors.New("from and to are required")
+	}
+	if !q.From.Before(q.To) {
+		return errors.New("from must be before to")
+	}
+	if q.To.Sub(q.From)/q.Interval >= MaxCompletenessSlots {
+		return fmt.Errorf("time range spans more than %d intervals", MaxCompletenessSlots)
+	}
+	return nil
+}
+
+// EffectiveGrace returns the grace period, defaulting to half the interval
+func (q CompletenessQuery) EffectiveGrace() time.Duration {
+	if q.Grace == 0 {
+		return q.Interval / 2
+	}
+	return q.Grace
+}
+
+// Gap is a period without any report. Start is the last report before the gap, or the
+// start of the checked range; End is the next report, or the end of the checked range.
+type Gap struct {
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Missing         int       `json:"missing"` // Expected reports that never arrived
+}
+
+// DayCoverage is the share of the expected reports of a UTC day that arrived
+type DayCoverage struct {
+	Date     string  `json:"date"` // 2006-01-02
+	Expected int     `json:"expected"`
+	Received int     `json:"received"` // Intervals holding at least one report
+	Coverage float64 `json:"coverage"` // Percentage, 0 to 100
+}
+
+// CompletenessReport describes how completely a device reported over a time range.
+// Coverage counts the intervals, starting at From, that hold at least one report.
+type CompletenessReport struct {
+	DeviceID        string        `json:"device_id"`
+	Channel         string        `json:"channel,omitempty"`
+	IntervalSeconds float64       `json:"interval_seconds"`
+	GraceSeconds    float64       `json:"grace_seconds"`
+	From            time.Time     `json:"from"`
+	To              time.Time     `json:"to"`
+	Messages        int           `json:"messages"`
+	Expected        int           `json:"expected"`
+	Received        int           `json:"received"`
+	Coverage        float64       `json:"coverage"` // Percentage, 0 to 100
+	GapCount        int           `json:"gap_count"`
+	GapsTruncated   bool          `json:"gaps_truncated,omitempty"` // More than MaxCompletenessGaps gaps were found
+	Gaps            []Gap         `json:"gaps"`
+	LongestOutage   *Gap          `json:"longest_outage"` // Null when there is no gap
+	LastSeen        *time.Time    `json:"last_seen"`      // Last report in the range
+	Days            []DayCoverage `json:"days"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompletenessQueryValidate(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+
+	tests := []struct {
+		name    string
+		query   CompletenessQuery
+		wantErr bool
+	}{
+		{name: "valid", query: CompletenessQuery{DeviceID: "dev-1", Interval: time.Minute, From: from, To: to}},
+		{name: "missing device", query: CompletenessQuery{Interval: time.Minute, From: from, To: to}, wantErr: true},
+		{name: "interval too short", query: CompletenessQuery{DeviceID: "dev-1", Interval: time.Millisecond, From: from, To: to}, wantErr: true},
+		{name: "negative grace", query: CompletenessQuery{DeviceID: "dev-1", Interval: time.Minute, Grace: -time.Second, From: from, To: to}, wantErr: true},
+		{name: "empty range", query: CompletenessQuery{DeviceID: "dev-1", Interval: time.Minute, From: from, To: from}, wantErr: true},
+		{name: "too many intervals", query: CompletenessQuery{DeviceID: "dev-1", Interval: time.Second, From: from, To: from.AddDate(0, 1, 0)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.query.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if grace := (CompletenessQuery{Interval: time.Minute}).EffectiveGrace(); grace != 30*time.Second {
+		t.Errorf("EffectiveGrace() = %v, want 30s", grace)
+	}
+}
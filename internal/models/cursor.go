@@ -0,0 +1,43 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// MessageCursor marks a position in a message listing ordered by timestamp and ID.
+// Clients only ever see its opaque encoded form.
+type MessageCursor struct {
+	Timestamp time.Time `json:"ts"`
+	ID        string    `json:"id"`
+}
+
+// CursorFromMessage returns the cursor positioned on the given message
+func CursorFromMessage(m *Message) *MessageCursor {
+	return &MessageCursor{
+		Timestamp: m.Timestamp,
+		ID:        m.GetIDAsString(),
+	}
+}
+
+// Encode returns the opaque string representation of the cursor
+func (c *MessageCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeMessageCursor parses a cursor produced by Encode
+func DecodeMessageCursor(encoded string) (*MessageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	var cursor MessageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" || cursor.Timestamp.IsZero() {
+		return nil, errors.New("invalid cursor")
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Limits of the fields of a registered device
+const (
+	MaxDeviceNameLength     = 200
+	MaxDeviceClientIDLength = 256
+)
+
+// DeviceFilter selects registered devices; empty fields match everything
+type DeviceFilter struct {
+	ProjectID string
+	Type      string
+	Status    string
+	ClientID  string
+}
+
+// Matches reports whether the device is selected by the filter
+func (f DeviceFilter) Matches(d *Device) bool {
+	return (f.ProjectID == "" || d.ProjectID == f.ProjectID) &&
+		(f.Type == "" || d.Type == f.Type) &&
+		(f.Status == "" || d.Status == f.Status) &&
+		(f.ClientID == "" || d.ClientID == f.ClientID)
+}
+
+// DeviceUpdate holds the fields of a partial device update. Nil fields are left unchanged.
+type DeviceUpdate struct {
+	Name      *string           `json:"name"`
+	Type      *string           `json:"type"`
+	Status    *string           `json:"status"`
+	ClientID  *string           `json:"clientId"`
+	Metadata  map[string]string `json:"metadata"` // Replaces the metadata when set
+	UpdatedAt time.Time         `json:"-"`        // Set by the service layer
+}
+
+// IsEmpty reports whether the update changes nothing
+func (u DeviceUpdate) IsEmpty() bool {
+	return u.Name == nil && u.Type == nil && u.Status == nil && u.ClientID == nil && u.Metadata == nil
+}
+
+// Apply copies the set fields of the update onto the device
+func (u DeviceUpdate) Apply(d *Device) {
+	if u.Name != nil {
+		d.Name = *u.Name
+	}
+	if u.Type != nil {
+		d.Type = *u.Type
+	}
+	if u.Status != nil {
+		d.Status = *u.Status
+	}
+	if u.ClientID != nil {
+		d.ClientID = *u.ClientID
+	}
+	if u.Metadata != nil {
+		d.Metadata = u.Metadata
+	}
+	if !u.UpdatedAt.IsZero() {
+		d.UpdatedAt = u.UpdatedAt
+	}
+}
+
+// Validate checks the fields of a device set by the users
+func (d *Device) Validate() error {
+	if strings.TrimSpace(d.Name) == "" {
+		return errors.New("name is required")
+	}
+	if len(d.Name) > MaxDeviceNameLength {
+		return fmt.Errorf("name must be at most %d characters", MaxDeviceNameLength)
+	}
+	if d.ClientID == "" || strings.TrimSpace(d.ClientID) != d.ClientID {
+		return errors.New("clientId is required and must not start or end with spaces")
+	}
+	if len(d.ClientID) > MaxDeviceClientIDLength {
+		return fmt.Errorf("clientId must be at most %d characters", MaxDeviceClientIDLength)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDeviceValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		device  Device
+		wantErr bool
+	}{
+		{name: "valid", device: Device{Name: "Boiler sensor", ClientID: "boiler-01"}},
+		{name: "missing name", device: Device{Name: "  ", ClientID: "boiler-01"}, wantErr: true},
+		{name: "long name", device: Device{Name: strings.Repeat("n", MaxDeviceNameLength+1), ClientID: "boiler-01"}, wantErr: true},
+		{name: "missing client ID", device: Device{Name: "Boiler sensor"}, wantErr: true},
+		{name: "padded client ID", device: Device{Name: "Boiler sensor", ClientID: " boiler-01"}, wantErr: true},
+		{name: "long client ID", device: Device{Name: "Boiler sensor", ClientID: strings.Repeat("c", MaxDeviceClientIDLength+1)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.device.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeviceUpdateApply(t *testing.T) {
+	name, clientID := "Renamed", "boiler-02"
+	device := Device{Name: "Boiler sensor", Type: "sensor", ClientID: "boiler-01", Metadata: map[string]string{"floor": "1"}}
+
+	if !(DeviceUpdate{}).IsEmpty() || (DeviceUpdate{Metadata: map[string]string{}}).IsEmpty() {
+		t.Error("IsEmpty() should only be true for an update without fields")
+	}
+	DeviceUpdate{Name: &name, ClientID: &clientID, Metadata: map[string]string{}}.Apply(&device)
+	if device.Name != name || device.ClientID != clientID || device.Type != "sensor" || len(device.Metadata) != 0 {
+		t.Errorf("Apply() = %+v, want renamed with the new client ID and no metadata", device)
+	}
+}
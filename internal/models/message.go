@@ -20,6 +20,11 @@ const (
 	MessageTypeUnknown   MessageType = "unknown"   // Unknown message type
 )
 
+// DerivedMessageTypes are the types of the messages stored by the service itself rather than
+// sent by the devices, such as alerts. They are left out of the aggregations, series and
+// completeness reports of the devices.
+var DerivedMessageTypes = []MessageType{MessageTypeAlert}
+
 // MessageStatus represents the processing status of a message
 type MessageStatus string
 
@@ -32,107 +37,48 @@ const (
 
 // Message represents an IoT MQTT message in the system
 type Message struct {
-	ID         interface{}            `bson:"_id,omitempty" json:"id"`                // Can be ObjectID for MongoDB or string for Firestore
-	Topic      string                 `bson:"topic" json:"topic"`                     // MQTT topic
-	Payload    string                 `bson:"payload" json:"payload"`                 // Raw message payload
-	Timestamp  time.Time              `bson:"timestamp" json:"timestamp"`             // Message timestamp
-	Marshalled map[string]interface{} `bson:"marshalled,omitempty" json:"marshalled"` // Parsed JSON payload (variable structure)
-	ClientID   string                 `bson:"client_id" json:"clientId"`              // MQTT client ID (device identifier)
+	ID         interface{}            `bson:"_id,omitempty" json:"id" firestore:"-"`                                   // Can be ObjectID for MongoDB or string for Firestore
+	Topic      string                 `bson:"topic" json:"topic" firestore:"topic"`                                    // MQTT topic
+	Payload    string                 `bson:"payload" json:"payload" firestore:"payload"`                              // Raw message payload
+	Timestamp  time.Time              `bson:"timestamp" json:"timestamp" firestore:"timestamp"`                        // Message timestamp
+	Marshalled map[string]interface{} `bson:"marshalled,omitempty" json:"marshalled" firestore:"marshalled,omitempty"` // Parsed JSON payload (variable structure)
+	ClientID   string                 `bson:"client_id" json:"clientId" firestore:"client_id"`                         // MQTT client ID (device identifier)
 
 	// Derived/computed fields
-	Type      MessageType   `bson:"type,omitempty" json:"type"`           // Message type derived from topic
-	Status    MessageStatus `bson:"status,omitempty" json:"status"`       // Processing status
-	DeviceID  string        `bson:"deviceId,omitempty" json:"deviceId"`   // Device ID (derived from client_id or topic)
-	ProjectID string        `bson:"projectId,omitempty" json:"projectId"` // Associated project ID
+	Type      MessageType   `bson:"type,omitempty" json:"type" firestore:"type,omitempty"`                // Message type derived from topic
+	Status    MessageStatus `bson:"status,omitempty" json:"status" firestore:"status,omitempty"`          // Processing status
+	DeviceID  string        `bson:"deviceId,omitempty" json:"deviceId" firestore:"deviceId,omitempty"`    // Device ID (derived from client_id or topic)
+	ProjectID string        `bson:"projectId,omitempty" json:"projectId" firestore:"projectId,omitempty"` // Associated project ID
 
 	// Metadata and audit fields
-	ProcessedAt *time.Time        `bson:"processedAt,omitempty" json:"processedAt"`     // When message was processed
-	CreatedAt   time.Time         `bson:"createdAt" json:"createdAt"`                   // When record was created
-	UpdatedAt   time.Time         `bson:"updatedAt" json:"updatedAt"`                   // When record was last updated
-	CreatedBy   string            `bson:"createdBy,omitempty" json:"createdBy"`         // User who processed/created record
-	Metadata    map[string]string `bson:"metadata,omitempty" json:"metadata,omitempty"` // Additional metadata
+	ProcessedAt *time.Time        `bson:"processedAt,omitempty" json:"processedAt" firestore:"processedAt,omitempty"`  // When message was processed
+	CreatedAt   time.Time         `bson:"createdAt" json:"createdAt" firestore:"createdAt"`                            // When record was created
+	UpdatedAt   time.Time         `bson:"updatedAt" json:"updatedAt" firestore:"updatedAt"`                            // When record was last updated
+	CreatedBy   string            `bson:"createdBy,omitempty" json:"createdBy" firestore:"createdBy,omitempty"`        // User who processed/created record
+	Metadata    map[string]string `bson:"metadata,omitempty" json:"metadata,omitempty" firestore:"metadata,omitempty"` // Additional metadata
 }
 
 // Device represents an IoT device
 type Device struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	ProjectID string             `bson:"projectId" json:"projectId"`
-	Name      string             `bson:"name" json:"name"`
-	Type      string             `bson:"type" json:"type"`
-	Status    string             `bson:"status" json:"status"`
-	ClientID  string             `bson:"clientId" json:"clientId"`           // MQTT client ID
-	LastSeen  *time.Time         `bson:"lastSeen,omitempty" json:"lastSeen"` // Last time device sent a message
-	Metadata  map[string]string  `bson:"metadata,omitempty" json:"metadata,omitempty"`
-	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
-	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
-	CreatedBy string             `bson:"createdBy" json:"createdBy"`
-}
-
-// MessageFilter represents filtering options for message queries
-type MessageFilter struct {
-	ProjectID    string        `json:"projectId,omitempty"`
-	DeviceID     string        `json:"deviceId,omitempty"`
-	ClientID     string        `json:"clientId,omitempty"`
-	Type         MessageType   `json:"type,omitempty"`
-	Status       MessageStatus `json:"status,omitempty"`
-	TopicPattern string        `json:"topicPattern,omitempty"` // For filtering by topic pattern
-	FromTime     *time.Time    `json:"fromTime,omitempty"`
-	ToTime       *time.Time    `json:"toTime,omitempty"`
-}
-
-// GetMessageTypeFromTopic derives message type from MQTT topic
-func GetMessageTypeFromTopic(topic string) MessageType {
-	if topic == "" {
-		return MessageTypeUnknown
-	}
-
-	// Check common patterns in the topic
-	switch {
-	case contains(topic, "/status/"):
-		return MessageTypeStatus
-	case contains(topic, "/events/"):
-		return MessageTypeEvent
-	case contains(topic, "/online"):
-		return MessageTypeOnline
-	case contains(topic, "/rpc"):
-		return MessageTypeRPC
-	case contains(topic, "/command"):
-		return MessageTypeCommand
-	case contains(topic, "/telemetry"):
-		return MessageTypeTelemetry
-	case contains(topic, "/alert"):
-		return MessageTypeAlert
-	default:
-		return MessageTypeUnknown
-	}
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id" firestore:"-"` // Firestore document ID is the hex ID
+	ProjectID string             `bson:"projectId" json:"projectId" firestore:"projectId"`
+	Name      string             `bson:"name" json:"name" firestore:"name"`
+	Type      string             `bson:"type" json:"type" firestore:"type"`
+	Status    string             `bson:"status" json:"status" firestore:"status"`                 // Presence state, set by the presence tracker
+	ClientID  string             `bson:"clientId" json:"clientId" firestore:"clientId"`           // MQTT client ID
+	LastSeen  *time.Time         `bson:"lastSeen,omitempty" json:"lastSeen" firestore:"lastSeen"` // Last time device sent a message
+	Metadata  map[string]string  `bson:"metadata,omitempty" json:"metadata,omitempty" firestore:"metadata,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt" firestore:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt" firestore:"updatedAt"`
+	CreatedBy string             `bson:"createdBy" json:"createdBy" firestore:"createdBy"`
 }
 
-// GetDeviceIDFromClientID extracts device ID from client ID (can be customized based on naming convention)
+// GetDeviceIDFromClientID extracts device ID from client ID. It is the fallback used when
+// none of the configured strategies of the deviceid package applies.
 func GetDeviceIDFromClientID(clientID string) string {
-	// For now, use client ID as device ID
-	// This can be customized based on your device naming convention
 	return clientID
 }
 
-// Helper function to check if string contains substring
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr ||
-		(len(s) > len(substr) &&
-			(s[:len(substr)] == substr ||
-				s[len(s)-len(substr):] == substr ||
-				indexOf(s, substr) >= 0)))
-}
-
-// Helper function to find substring index
-func indexOf(s, substr string) int {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return i
-		}
-	}
-	return -1
-}
-
 // GetIDAsString returns the ID as string regardless of the underlying type
 func (m *Message) GetIDAsString() string {
 	switch id := m.ID.(type) {
@@ -154,3 +100,12 @@ func (m *Message) SetIDFromString(id string) {
 func (m *Message) SetIDFromObjectID(id primitive.ObjectID) {
 	m.ID = id
 }
+
+// MessageUpdate holds the fields of a partial message update. Nil fields are left unchanged.
+type MessageUpdate struct {
+	Status      *MessageStatus    `json:"status,omitempty"`
+	ProcessedAt *time.Time        `json:"processedAt,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"` // Replaces the whole metadata map when set
+	DeviceID    *string           `json:"-"`                  // Only recomputed by the device ID backfill, never set through the API
+	UpdatedAt   time.Time         `json:"-"`                  // Set by the service layer
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// MessageFilter represents filtering options for message queries.
+// All populated criteria must match (AND). A scalar field and its list
+// counterpart (e.g. ClientID and ClientIDs) are both applied, so the scalar
+// value must also be part of the list for a message to match.
+type MessageFilter struct {
+	IDs          []string        `json:"ids,omitempty"`
+	ProjectID    string          `json:"projectId,omitempty"`
+	ProjectIDs   []string        `json:"projectIds,omitempty"`
+	DeviceID     string          `json:"deviceId,omitempty"`
+	DeviceIDs    []string        `json:"deviceIds,omitempty"`
+	ClientID     string          `json:"clientId,omitempty"`
+	ClientIDs    []string        `json:"clientIds,omitempty"`
+	Type         MessageType     `json:"type,omitempty"`
+	Types        []MessageType   `json:"types,omitempty"`
+	ExcludeTypes []MessageType   `json:"excludeTypes,omitempty"` // Types the messages must not have
+	Status       MessageStatus   `json:"status,omitempty"`
+	Statuses     []MessageStatus `json:"statuses,omitempty"`
+	TopicPattern string          `json:"topicPattern,omitempty"` // Exact topic or MQTT pattern with + and # wildcards
+	TopicPrefix  string          `json:"topicPrefix,omitempty"`  // Plain string prefix of the topic
+	FromTime     *time.Time      `json:"fromTime,omitempty"`     // Inclusive lower bound on timestamp
+	ToTime       *time.Time      `json:"toTime,omitempty"`       // Inclusive upper bound on timestamp
+}
+
+// Validate checks that the filter is well formed
+func (f MessageFilter) Validate() error {
+	if f.TopicPattern != "" {
+		if err := ValidateTopicPattern(f.TopicPattern); err != nil {
+			return err
+		}
+	}
+	if f.FromTime != nil && f.ToTime != nil && f.FromTime.After(*f.ToTime) {
+		return errors.New("fromTime must not be after toTime")
+	}
+	return nil
+}
+
+// Matches reports whether a message satisfies every criterion of the filter
+func (f MessageFilter) Matches(m *Message) bool {
+	if !matchesValue(m.GetIDAsString(), "", f.IDs) ||
+		!matchesValue(m.ProjectID, f.ProjectID, f.ProjectIDs) ||
+		!matchesValue(m.DeviceID, f.DeviceID, f.DeviceIDs) ||
+		!matchesValue(m.ClientID, f.ClientID, f.ClientIDs) ||
+		!matchesValue(string(m.Type), string(f.Type), messageTypesToStrings(f.Types)) ||
+		!matchesValue(string(m.Status), string(f.Status), messageStatusesToStrings(f.Statuses)) {
+		return false
+	}
+	for _, excluded := range f.ExcludeTypes {
+		if m.Type == excluded {
+			return false
+		}
+	}
+	if f.TopicPattern != "" && !MatchTopic(f.TopicPattern, m.Topic) {
+		return false
+	}
+	if f.TopicPrefix != "" && !strings.HasPrefix(m.Topic, f.TopicPrefix) {
+		return false
+	}
+	if f.FromTime != nil && m.Timestamp.Before(*f.FromTime) {
+		return false
+	}
+	if f.ToTime != nil && m.Timestamp.After(*f.ToTime) {
+		return false
+	}
+	return true
+}
+
+// FieldValues returns the values a stored field is allowed to take given a
+// scalar criterion and its list counterpart. ok is false when the field is
+// unconstrained; an empty result with ok set means nothing can match.
+func FieldValues(scalar string, list []string) (values []string, ok bool) {
+	if scalar != "" {
+		if len(list) > 0 && !containsString(list, scalar) {
+			return []string{}, true
+		}
+		return []string{scalar}, true
+	}
+	if len(list) > 0 {
+		return list, true
+	}
+	return nil, false
+}
+
+// ValidateTopicPattern checks that a pattern uses MQTT wildcards correctly:
+// "+" must occupy a whole level and "#" must be the last level.
+func ValidateTopicPattern(pattern string) error {
+	levels := strings.Split(pattern, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return errors.New("invalid topic pattern: '#' must be the last level")
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return errors.New("invalid topic pattern: '+' must occupy a whole level")
+		}
+	}
+	return nil
+}
+
+// IsTopicWildcard reports whether a topic pattern contains MQTT wildcards
+func IsTopicWildcard(pattern string) bool {
+	return strings.ContainsAny(pattern, "+#")
+}
+
+// MatchTopic reports whether a topic matches an MQTT topic pattern.
+// "+" matches exactly one level and a trailing "#" matches the parent level
+// and any number of child levels.
+func MatchTopic(pattern, topic string) bool {
+	patternLevels := strings.Split(pattern, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range patternLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(patternLevels) == len(topicLevels)
+}
+
+func matchesValue(actual, scalar string, list []string) bool {
+	values, ok := FieldValues(scalar, list)
+	return !ok || containsString(values, actual)
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func messageTypesToStrings(types []MessageType) []string {
+	if len(types) == 0 {
+		return nil
+	}
+	result := make([]string, len(types))
+	for i, t := range types {
+		result[i] = string(t)
+	}
+	return result
+}
+
+func messageStatusesToStrings(statuses []MessageStatus) []string {
+	if len(statuses) == 0 {
+		return nil
+	}
+	result := make([]string, len(statuses))
+	for i, s := range statuses {
+		result[i] = string(s)
+	}
+	return result
+}
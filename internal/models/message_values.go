@@ -0,0 +1,81 @@
+package models
+
+import "strings"
+
+const (
+	// ChannelMetadataKey is the metadata entry holding the channel of a message (see the topic rules)
+	ChannelMetadataKey = "channel"
+	// DefaultChannel groups the aggregations of messages published without a channel
+	DefaultChannel = "default"
+)
+
+// IsDerived reports whether the message was stored by the service rather than sent by its
+// device, see DerivedMessageTypes
+func (m *Message) IsDerived() bool {
+	for _, derived := range DerivedMessageTypes {
+		if m.Type == derived {
+			return true
+		}
+	}
+	return false
+}
+
+// Channel returns the channel the message belongs to for aggregations
+func (m *Message) Channel() string {
+	if channel := m.Metadata[ChannelMetadataKey]; channel != "" {
+		return channel
+	}
+	return DefaultChannel
+}
+
+// NumericFields returns the numeric payload values to aggregate: every top-level numeric
+// field, or only the named (possibly dotted) fields when some are requested
+func NumericFields(marshalled map[string]interface{}, fields []string) map[string]float64 {
+	values := make(map[string]float64)
+	if len(fields) == 0 {
+		for name, raw := range marshalled {
+			if value, ok := toFloat(raw); ok {
+				values[name] = value
+			}
+		}
+		return values
+	}
+
+	for _, field := range fields {
+		if value, ok := toFloat(lookupPath(marshalled, field)); ok {
+			values[field] = value
+		}
+	}
+	return values
+}
+
+// lookupPath resolves a dot separated path in a parsed payload
+func lookupPath(marshalled map[string]interface{}, path string) interface{} {
+	var current interface{} = marshalled
+	for _, part := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[part]
+	}
+	return current
+}
+
+// toFloat converts the numeric types produced by the JSON, BSON and Firestore decoders
+func toFloat(raw interface{}) (float64, bool) {
+	switch value := raw.(type) {
+	case float64:
+		return value, true
+	case float32:
+		return float64(value), true
+	case int:
+		return float64(value), true
+	case int32:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	default:
+		return 0, false
+	}
+}
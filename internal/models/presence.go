@@ -0,0 +1,99 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// PresenceState tells whether a device is connected
+type PresenceState string
+
+const (
+	PresenceOnline  PresenceState = "online"
+	PresenceOffline PresenceState = "offline"
+)
+
+// PresenceReason tells what changed the presence of a device
+type PresenceReason string
+
+const (
+	PresenceReasonMessage  PresenceReason = "message"  // A status or telemetry message was received
+	PresenceReasonReported PresenceReason = "reported" // The device reported its state in an online message
+	PresenceReasonTimeout  PresenceReason = "timeout"  // No message was received for the silence timeout
+)
+
+// DevicePresence is the current presence of a device
+type DevicePresence struct {
+	DeviceID  string         `bson:"_id" json:"deviceId" firestore:"-"`
+	ProjectID string         `bson:"projectId" json:"projectId" firestore:"projectId"`
+	ClientID  string         `bson:"clientId,omitempty" json:"clientId,omitempty" firestore:"clientId,omitempty"`
+	State     PresenceState  `bson:"state" json:"state" firestore:"state"`
+	Reason    PresenceReason `bson:"reason" json:"reason" firestore:"reason"`
+	Since     time.Time      `bson:"since" json:"since" firestore:"since"`          // When the state last changed
+	LastSeen  time.Time      `bson:"lastSeen" json:"lastSeen" firestore:"lastSeen"` // Timestamp of the last message of the device
+	UpdatedAt time.Time      `bson:"updatedAt" json:"updatedAt" firestore:"updatedAt"`
+}
+
+// PresenceTransition records a change of the presence of a device
+type PresenceTransition struct {
+	ID        string         `bson:"_id" json:"id" firestore:"-"`
+	DeviceID  string         `bson:"deviceId" json:"deviceId" firestore:"deviceId"`
+	ProjectID string         `bson:"projectId" json:"projectId" firestore:"projectId"`
+	From      PresenceState  `bson:"from,omitempty" json:"from,omitempty" firestore:"from,omitempty"` // Empty for the first presence of the device
+	To        PresenceState  `bson:"to" json:"to" firestore:"to"`
+	Reason    PresenceReason `bson:"reason" json:"reason" firestore:"reason"`
+	MessageID string         `bson:"messageId,omitempty" json:"messageId,omitempty" firestore:"messageId,omitempty"` // Message that caused the transition
+	At        time.Time      `bson:"at" json:"at" firestore:"at"`
+}
+
+// PresenceFilter selects device presences; empty fields match everything
+type PresenceFilter struct {
+	ProjectID  string
+	State      PresenceState
+	SeenBefore *time.Time // Only devices whose last message is older
+}
+
+// Matches reports whether the presence is selected by the filter
+func (f PresenceFilter) Matches(p *DevicePresence) bool {
+	return (f.ProjectID == "" || p.ProjectID == f.ProjectID) &&
+		(f.State == "" || p.State == f.State) &&
+		(f.SeenBefore == nil || p.LastSeen.Before(*f.SeenBefore))
+}
+
+// presenceWords maps the words devices use to report their connection state
+var presenceWords = map[string]PresenceState{
+	"true": PresenceOnline, "1": PresenceOnline, "online": PresenceOnline, "connected": PresenceOnline, "up": PresenceOnline,
+	"false": PresenceOffline, "0": PresenceOffline, "offline": PresenceOffline, "disconnected": PresenceOffline, "down": PresenceOffline,
+}
+
+// IsPresenceMessage reports whether the message tells that its device is connected or not:
+// online messages report a state, status and telemetry messages show the device is online
+func (m *Message) IsPresenceMessage() bool {
+	return m.Type == MessageTypeOnline || m.Type == MessageTypeStatus || m.Type == MessageTypeTelemetry
+}
+
+// ReportedPresence returns the state reported by an online message, read from a plain
+// payload ("online", "offline", "true", "0", ...) or from the "online", "connected", "state"
+// or "status" field of a JSON payload
+func (m *Message) ReportedPresence() (PresenceState, bool) {
+	if m.Type != MessageTypeOnline {
+		return "", false
+	}
+	if state, ok := presenceWords[strings.ToLower(strings.TrimSpace(m.Payload))]; ok {
+		return state, true
+	}
+	for _, field := range []string{"online", "connected", "state", "status"} {
+		switch value := m.Marshalled[field].(type) {
+		case bool:
+			if value {
+				return PresenceOnline, true
+			}
+			return PresenceOffline, true
+		case string:
+			if state, ok := presenceWords[strings.ToLower(value)]; ok {
+				return state, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReportedPresence(t *testing.T) {
+	tests := []struct {
+		name    string
+		message Message
+		want    PresenceState
+		wantOK  bool
+	}{
+		{name: "plain online", message: Message{Type: MessageTypeOnline, Payload: " Online\n"}, want: PresenceOnline, wantOK: true},
+		{name: "plain zero", message: Message{Type: MessageTypeOnline, Payload: "0"}, want: PresenceOffline, wantOK: true},
+		{name: "JSON bool", message: Message{Type: MessageTypeOnline, Payload: `{"online":false}`, Marshalled: map[string]interface{}{"online": false}}, want: PresenceOffline, wantOK: true},
+		{name: "JSON state", message: Message{Type: MessageTypeOnline, Marshalled: map[string]interface{}{"state": "connected"}}, want: PresenceOnline, wantOK: true},
+		{name: "unknown word", message: Message{Type: MessageTypeOnline, Payload: "maybe"}},
+		{name: "telemetry", message: Message{Type: MessageTypeTelemetry, Payload: "online"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.message.ReportedPresence()
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("ReportedPresence() = %q, %v; want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestPresenceFilterMatches(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	presence := &DevicePresence{ProjectID: "p1", State: PresenceOnline, LastSeen: at}
+	later, earlier := at.Add(time.Minute), at.Add(-time.Minute)
+
+	tests := []struct {
+		name   string
+		filter PresenceFilter
+		want   bool
+	}{
+		{name: "empty", want: true},
+		{name: "project and state", filter: PresenceFilter{ProjectID: "p1", State: PresenceOnline}, want: true},
+		{name: "seen before", filter: PresenceFilter{SeenBefore: &later}, want: true},
+		{name: "seen since", filter: PresenceFilter{SeenBefore: &earlier}},
+		{name: "other state", filter: PresenceFilter{State: PresenceOffline}},
+		{name: "other project", filter: PresenceFilter{ProjectID: "p2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Matches(presence); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// RollupPeriod is a resolution of the precomputed aggregations
+type RollupPeriod string
+
+const (
+	PeriodHourly  RollupPeriod = "hourly"
+	PeriodDaily   RollupPeriod = "daily"
+	PeriodMonthly RollupPeriod = "monthly"
+)
+
+// RollupPeriods lists the periods maintained by the rollup worker
+var RollupPeriods = []RollupPeriod{PeriodHourly, PeriodDaily, PeriodMonthly}
+
+// rollupKeyLayouts are the formats of the timestamp keys of each period
+var rollupKeyLayouts = map[RollupPeriod]string{
+	PeriodHourly:  "2006-01-02T15",
+	PeriodDaily:   "2006-01-02",
+	PeriodMonthly: "2006-01",
+}
+
+// Key returns the timestamp key of the UTC bucket holding t
+func (p RollupPeriod) Key(t time.Time) string {
+	return t.UTC().Format(rollupKeyLayouts[p])
+}
+
+// rollupPartitions describe how the buckets of each period are stored: one document per
+// device holds a UTC day of hourly buckets, a month of daily ones or a year of monthly
+// ones, so no document grows with the age of the device
+var rollupPartitions = map[RollupPeriod]struct {
+	layout              string
+	years, months, days int
+}{
+	PeriodHourly:  {"2006-01-02", 0, 0, 1},
+	PeriodDaily:   {"2006-01", 0, 1, 0},
+	PeriodMonthly: {"2006", 1, 0, 0},
+}
+
+// RollupPartition identifies the stored document holding the buckets of one period of a
+// device over one partition, e.g. the hourly buckets of 2024-03-05
+type RollupPartition struct {
+	Period RollupPeriod
+	Key    string
+}
+
+// Partition returns the storage partition of the bucket holding t
+func (p RollupPeriod) Partition(t time.Time) RollupPartition {
+	return RollupPartition{Period: p, Key: t.UTC().Format(rollupPartitions[p].layout)}
+}
+
+// PartitionOf returns the storage partition of the bucket with the given timestamp key; ok
+// is false when the key is not a timestamp key of the period
+func (p RollupPeriod) PartitionOf(key string) (RollupPartition, bool) {
+	layout, ok := rollupKeyLayouts[p]
+	if !ok {
+		return RollupPartition{}, false
+	}
+	t, err := time.Parse(layout, key)
+	if err != nil {
+		return RollupPartition{}, false
+	}
+	return p.Partition(t), true
+}
+
+// Partitions returns the storage partitions holding the buckets of the period whose
+// timestamp falls in [from, to), in chronological order
+func (p RollupPeriod) Partitions(from, to time.Time) []RollupPartition {
+	partition, ok := rollupPartitions[p]
+	if !ok || !from.Before(to) {
+		return nil
+	}
+	start, _ := time.Parse(partition.layout, p.Partition(from).Key)
+	var partitions []RollupPartition
+	for ; start.Before(to); start = start.AddDate(partition.years, partition.months, partition.days) {
+		partitions = append(partitions, p.Partition(start))
+	}
+	return partitions
+}
+
+// RollupSample is one value added to one precomputed bucket
+type RollupSample struct {
+	Channel   string
+	Variable  string
+	Period    RollupPeriod
+	Key       string // Timestamp key of the bucket
+	Value     float64
+	Timestamp time.Time // Message timestamp, for the first and last values
+}
+
+// MessageRollup is the contribution of a single message to the aggregations of its device.
+// MessageID makes applying it idempotent.
+type MessageRollup struct {
+	MessageID string
+	DeviceID  string
+	Samples   []RollupSample
+}
+
+// NewMessageRollup extracts the top-level numeric fields of a message for every rollup
+// period. Variables that cannot be stored as a nested map key are skipped, and derived
+// messages such as alerts have no samples.
+func NewMessageRollup(message *Message) MessageRollup {
+	rollup := MessageRollup{
+		MessageID: message.GetIDAsString(),
+		DeviceID:  message.DeviceID,
+	}
+
+	channel := message.Channel()
+	if message.IsDerived() || !IsRollupKey(channel) {
+		return rollup
+	}
+	for variable, value := range NumericFields(message.Marshalled, nil) {
+		if !IsRollupKey(variable) {
+			continue
+		}
+		for _, period := range RollupPeriods {
+			rollup.Samples = append(rollup.Samples, RollupSample{
+				Channel:   channel,
+				Variable:  variable,
+				Period:    period,
+				Key:       period.Key(message.Timestamp),
+				Value:     value,
+				Timestamp: message.Timestamp,
+			})
+		}
+	}
+	return rollup
+}
+
+// IsRollupKey reports whether a channel or variable name can be used as a key of the
+// nested aggregations map in every backend
+func IsRollupKey(name string) bool {
+	return name != "" && !strings.Contains(name, ".") && !strings.HasPrefix(name, "$")
+}
+
+// Apply adds a sample to an aggregations document, creating the bucket when needed
+func (a *ClientAggregations) Apply(sample RollupSample) {
+	bucket := a.Aggregations[sample.Channel][sample.Variable][string(sample.Period)][sample.Key]
+	if bucket == nil {
+		bucket = &AggregatedData{}
+		a.setBucket(sample.Channel, sample.Variable, string(sample.Period), sample.Key, bucket)
+	}
+	bucket.Add(sample.Value, sample.Timestamp)
+}
+
+// DerivePeriod rebuilds the buckets of a coarser period by merging the buckets of a finer
+// one, e.g. the daily buckets from the hourly ones. Existing buckets of the coarser period
+// are replaced.
+func (a *ClientAggregations) DerivePeriod(from, to RollupPeriod) {
+	for _, variables := range a.Aggregations {
+		for _, periods := range variables {
+			source, ok := periods[string(from)]
+			if !ok {
+				continue
+			}
+			derived := make(map[string]*AggregatedData)
+			for key, bucket := range source {
+				timestamp, ok := ParseAggregationTimestamp(key)
+				if !ok || bucket == nil {
+					continue
+				}
+				target, ok := derived[to.Key(timestamp)]
+				if !ok {
+					target = &AggregatedData{}
+					derived[to.Key(timestamp)] = target
+				}
+				target.Merge(bucket)
+			}
+			periods[string(to)] = derived
+		}
+	}
+}
+
+// BucketPaths returns the channel, variable, period and timestamp key of every bucket
+// whose timestamp falls in [from, to). Buckets with an unparseable key are left out.
+func (a *ClientAggregations) BucketPaths(from, to time.Time) [][]string {
+	var paths [][]string
+	for channel, variables := range a.Aggregations {
+		for variable, periods := range variables {
+			for period, buckets := range periods {
+				for key := range buckets {
+					timestamp, ok := ParseAggregationTimestamp(key)
+					if !ok || timestamp.Before(from) || !timestamp.Before(to) {
+						continue
+					}
+					paths = append(paths, []string{channel, variable, period, key})
+				}
+			}
+		}
+	}
+	return paths
+}
+
+// ReplaceRange removes the buckets whose timestamp falls in [from, to) and copies in
+// every bucket of replacement
+func (a *ClientAggregations) ReplaceRange(from, to time.Time, replacement *ClientAggregations) {
+	for _, path := range a.BucketPaths(from, to) {
+		delete(a.Aggregations[path[0]][path[1]][path[2]], path[3])
+	}
+	for channel, variables := range replacement.Aggregations {
+		for variable, periods := range variables {
+			for period, buckets := range periods {
+				for key, bucket := range buckets {
+					copied := *bucket
+					a.setBucket(channel, variable, period, key, &copied)
+				}
+			}
+		}
+	}
+}
+
+// Merge adds the buckets of other, merging the buckets held by both
+func (a *ClientAggregations) Merge(other *ClientAggregations) {
+	for channel, variables := range other.Aggregations {
+		for variable, periods := range variables {
+			for period, buckets := range periods {
+				for key, bucket := range buckets {
+					if bucket == nil {
+						continue
+					}
+					existing := a.Aggregations[channel][variable][period][key]
+					if existing == nil {
+						existing = &AggregatedData{}
+						a.setBucket(channel, variable, period, key, existing)
+					}
+					existing.Merge(bucket)
+				}
+			}
+		}
+	}
+}
+
+// Partitioned splits the aggregations into the documents of their storage partitions.
+// Buckets of another period or with a key that is not a key of their period are left out.
+func (a *ClientAggregations) Partitioned() map[RollupPartition]*ClientAggregations {
+	partitions := make(map[RollupPartition]*ClientAggregations)
+	for channel, variables := range a.Aggregations {
+		for variable, periods := range variables {
+			for period, buckets := range periods {
+				for key, bucket := range buckets {
+					partition, ok := RollupPeriod(period).PartitionOf(key)
+					if !ok || bucket == nil {
+						continue
+					}
+					part, ok := partitions[partition]
+					if !ok {
+						part = &ClientAggregations{ClientID: a.ClientID}
+						partitions[partition] = part
+					}
+					copied := *bucket
+					part.setBucket(channel, variable, period, key, &copied)
+				}
+			}
+		}
+	}
+	return partitions
+}
+
+// setBucket stores a bucket, creating the enclosing maps when needed
+func (a *ClientAggregations) setBucket(channel, variable, period, key string, bucket *AggregatedData) {
+	if a.Aggregations == nil {
+		a.Aggregations = make(map[string]map[string]map[string]map[string]*AggregatedData)
+	}
+	if a.Aggregations[channel] == nil {
+		a.Aggregations[channel] = make(map[string]map[string]map[string]*AggregatedData)
+	}
+	if a.Aggregations[channel][variable] == nil {
+		a.Aggregations[channel][variable] = make(map[string]map[string]*AggregatedData)
+	}
+	if a.Aggregations[channel][variable][period] == nil {
+		a.Aggregations[channel][variable][period] = make(map[string]*AggregatedData)
+	}
+	a.Aggregations[channel][variable][period][key] = bucket
+}
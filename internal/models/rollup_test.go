@@ -0,0 +1,212 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestNewMessageRollup(t *testing.T) {
+	timestamp := time.Date(2024, 3, 5, 10, 15, 0, 0, time.FixedZone("CET", 3600))
+
+	tests := []struct {
+		name        string
+		messageType MessageType
+		metadata    map[string]string
+		payload     map[string]interface{}
+		want        []string
+	}{
+		{
+			name:     "numeric fields for every period",
+			metadata: map[string]string{"channel": "ch1"},
+			payload:  map[string]interface{}{"temperature": 21.5, "label": "x", "nested": map[string]interface{}{"v": 1.0}},
+			want: []string{
+				"ch1/temperature/daily/2024-03-05=21.5",
+				"ch1/temperature/hourly/2024-03-05T09=21.5",
+				"ch1/temperature/monthly/2024-03=21.5",
+			},
+		},
+		{
+			name:    "default channel",
+			payload: map[string]interface{}{"count": int64(3)},
+			want: []string{
+				"default/count/daily/2024-03-05=3",
+				"default/count/hourly/2024-03-05T09=3",
+				"default/count/monthly/2024-03=3",
+			},
+		},
+		{
+			name:    "unsafe variable names",
+			payload: map[string]interface{}{"a.b": 1.0, "$c": 2.0},
+		},
+		{
+			name:     "unsafe channel",
+			metadata: map[string]string{"channel": "ch1.2"},
+			payload:  map[string]interface{}{"temperature": 21.5},
+		},
+		{
+			name:        "alert",
+			messageType: MessageTypeAlert,
+			payload:     map[string]interface{}{"value": 85.0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := &Message{DeviceID: "dev-1", Type: tt.messageType, Timestamp: timestamp, Metadata: tt.metadata, Marshalled: tt.payload}
+			message.SetIDFromString("65a000000000000000000001")
+
+			rollup := NewMessageRollup(message)
+			if rollup.MessageID != "65a000000000000000000001" || rollup.DeviceID != "dev-1" {
+				t.Errorf("NewMessageRollup() = %s/%s, want the message and device IDs", rollup.MessageID, rollup.DeviceID)
+			}
+			var got []string
+			for _, s := range rollup.Samples {
+				got = append(got, fmt.Sprintf("%s/%s/%s/%s=%v", s.Channel, s.Variable, s.Period, s.Key, s.Value))
+			}
+			sort.Strings(got)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("NewMessageRollup() samples = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientAggregationsApply(t *testing.T) {
+	agg := &ClientAggregations{ClientID: "dev-1"}
+	base := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+	for i, value := range []float64{4, 1, 7} {
+		agg.Apply(RollupSample{Channel: "ch1", Variable: "v", Period: PeriodDaily, Key: "2024-03-05", Value: value, Timestamp: base.Add(time.Duration(i) * time.Minute)})
+	}
+
+	got := agg.Aggregations["ch1"]["v"]["daily"]["2024-03-05"]
+	if got == nil || got.Sum != 12 || got.Count != 3 || got.Min != 1 || got.Max != 7 || got.Avg != 4 {
+		t.Fatalf("Apply() bucket = %+v, want sum 12, count 3, min 1, max 7, avg 4", got)
+	}
+	if got.First != 4 || got.Last != 7 || got.SumSquares != 66 {
+		t.Errorf("Apply() bucket first = %v, last = %v, sum of squares = %v; want 4, 7, 66", got.First, got.Last, got.SumSquares)
+	}
+}
+
+func TestClientAggregationsDerivePeriod(t *testing.T) {
+	direct := &ClientAggregations{}
+	derived := &ClientAggregations{}
+	base := time.Date(2024, 3, 5, 22, 30, 0, 0, time.UTC)
+	for i, value := range []float64{3, 9, -2, 5, 5} {
+		message := &Message{Timestamp: base.Add(time.Duration(i) * 40 * time.Minute), Marshalled: map[string]interface{}{"v": value}}
+		for _, sample := range NewMessageRollup(message).Samples {
+			direct.Apply(sample)
+			if sample.Period == PeriodHourly {
+				derived.Apply(sample)
+			}
+		}
+	}
+	derived.DerivePeriod(PeriodHourly, PeriodDaily)
+	derived.DerivePeriod(PeriodHourly, PeriodMonthly)
+
+	for _, period := range []string{"daily", "monthly"} {
+		want := direct.Aggregations[DefaultChannel]["v"][period]
+		got := derived.Aggregations[DefaultChannel]["v"][period]
+		if len(got) != len(want) {
+			t.Fatalf("DerivePeriod(%s) = %d buckets, want %d", period, len(got), len(want))
+		}
+		for key, w := range want {
+			g := got[key]
+			if g == nil || g.Sum != w.Sum || g.Count != w.Count || g.Min != w.Min || g.Max != w.Max || g.Avg != w.Avg ||
+				g.SumSquares != w.SumSquares || g.First != w.First || g.Last != w.Last || !g.FirstAt.Equal(*w.FirstAt) || !g.LastAt.Equal(*w.LastAt) ||
+				fmt.Sprint(g.Sketch) != fmt.Sprint(w.Sketch) {
+				t.Errorf("DerivePeriod(%s)[%s] = %+v, want %+v", period, key, g, w)
+			}
+		}
+	}
+}
+
+func TestRollupPeriodPartitions(t *testing.T) {
+	from := time.Date(2024, 12, 30, 22, 0, 0, 0, time.UTC)
+	tests := []struct {
+		period RollupPeriod
+		to     time.Time
+		want   string
+	}{
+		{PeriodHourly, time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), "[2024-12-30 2024-12-31 2025-01-01]"},
+		{PeriodHourly, time.Date(2025, 1, 2, 0, 0, 1, 0, time.UTC), "[2024-12-30 2024-12-31 2025-01-01 2025-01-02]"},
+		{PeriodDaily, time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), "[2024-12 2025-01]"},
+		{PeriodMonthly, time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), "[2024 2025]"},
+		{PeriodMonthly, from, "[]"},
+		{RollupPeriod("weekly"), time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), "[]"},
+	}
+	for _, tt := range tests {
+		keys := []string{}
+		for _, partition := range tt.period.Partitions(from, tt.to) {
+			if partition.Period != tt.period {
+				t.Errorf("Partitions(%s) returned a partition of %s", tt.period, partition.Period)
+			}
+			keys = append(keys, partition.Key)
+		}
+		if got := fmt.Sprint(keys); got != tt.want {
+			t.Errorf("%s.Partitions(%v, %v) = %s, want %s", tt.period, from, tt.to, got, tt.want)
+		}
+	}
+
+	if got, ok := PeriodHourly.PartitionOf("2024-03-05T10"); !ok || got != (RollupPartition{PeriodHourly, "2024-03-05"}) {
+		t.Errorf("PartitionOf(hourly key) = %v, %v", got, ok)
+	}
+	if _, ok := PeriodHourly.PartitionOf("2024-03-05"); ok {
+		t.Errorf("PartitionOf(daily key) of the hourly period should fail")
+	}
+}
+
+func TestClientAggregationsPartitionedAndMerge(t *testing.T) {
+	agg := &ClientAggregations{ClientID: "dev-1"}
+	for i, timestamp := range []time.Time{
+		time.Date(2024, 3, 5, 10, 15, 0, 0, time.UTC),
+		time.Date(2024, 3, 5, 11, 0, 0, 0, time.UTC),
+		time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		message := &Message{Timestamp: timestamp, Marshalled: map[string]interface{}{"v": float64(i + 1)}}
+		for _, sample := range NewMessageRollup(message).Samples {
+			agg.Apply(sample)
+		}
+	}
+	agg.setBucket(DefaultChannel, "v", "weekly", "2024-W10", &AggregatedData{Count: 1})
+
+	partitions := agg.Partitioned()
+	var got []string
+	for partition, part := range partitions {
+		var keys []string
+		for period, buckets := range part.Aggregations[DefaultChannel]["v"] {
+			for key := range buckets {
+				keys = append(keys, period+"/"+key)
+			}
+		}
+		sort.Strings(keys)
+		got = append(got, fmt.Sprintf("%s/%s=%v", partition.Period, partition.Key, keys))
+	}
+	sort.Strings(got)
+	want := []string{
+		"daily/2024-03=[daily/2024-03-05]",
+		"daily/2024-04=[daily/2024-04-01]",
+		"hourly/2024-03-05=[hourly/2024-03-05T10 hourly/2024-03-05T11]",
+		"hourly/2024-04-01=[hourly/2024-04-01T00]",
+		"monthly/2024=[monthly/2024-03 monthly/2024-04]",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Partitioned() = %v, want %v", got, want)
+	}
+
+	merged := &ClientAggregations{ClientID: "dev-1"}
+	for _, part := range partitions {
+		merged.Merge(part)
+	}
+	merged.Merge(&ClientAggregations{Aggregations: map[string]map[string]map[string]map[string]*AggregatedData{
+		DefaultChannel: {"v": {"daily": {"2024-03-05": {Sum: 10, Count: 1, Min: 10, Max: 10, Avg: 10}}}},
+	}})
+	daily := merged.Aggregations[DefaultChannel]["v"]["daily"]["2024-03-05"]
+	if daily == nil || daily.Count != 3 || daily.Sum != 13 || daily.Max != 10 || daily.Min != 1 {
+		t.Errorf("Merge() daily bucket = %+v, want count 3, sum 13, min 1, max 10", daily)
+	}
+	if monthly := merged.Aggregations[DefaultChannel]["v"]["monthly"]["2024-04"]; monthly == nil || monthly.Count != 1 || monthly.Sum != 3 {
+		t.Errorf("Merge() monthly bucket = %+v, want count 1, sum 3", monthly)
+	}
+}
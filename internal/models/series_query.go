@@ -0,0 +1,76 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DownsampleMethod selects how a time series is reduced to the requested number of points
+type DownsampleMethod string
+
+const (
+	DownsampleLTTB   DownsampleMethod = "lttb"   // Largest-Triangle-Three-Buckets, keeps the visual shape
+	DownsampleMinMax DownsampleMethod = "minmax" // Lowest and highest point per time bucket, keeps every spike
+)
+
+const (
+	// MaxSeriesPoints caps the number of points a series may be downsampled to
+	MaxSeriesPoints = 10000
+	// MaxSeriesRawPoints caps the number of raw values read for a single series
+	MaxSeriesRawPoints = 1000000
+)
+
+// SeriesPoint is a single value of a time series
+type SeriesPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// SeriesQuery selects the raw values of one variable of a device, downsampled to Points.
+// Variable is a top-level payload field or a dot path such as "temperature.tC".
+type SeriesQuery struct {
+	DeviceID string
+	Variable string
+	Channel  string // Optional; only messages of this channel
+	From     time.Time
+	To       time.Time
+	Points   int
+	Method   DownsampleMethod
+}
+
+// Validate checks that the query is well formed
+func (q SeriesQuery) Validate() error {
+	if q.DeviceID == "" {
+		return errors.New("device ID is required")
+	}
+	if q.Variable == "" || strings.Contains(q.Variable, "$") {
+		return fmt.Errorf("invalid variable %q", q.Variable)
+	}
+	if q.From.IsZero() || q.To.IsZero() {
+		return errors.New("from and to are required")
+	}
+	if q.From.After(q.To) {
+		return errors.New("from must not be after to")
+	}
+	if q.Points < 3 || q.Points > MaxSeriesPoints {
+		return fmt.Errorf("points must be between 3 and %d", MaxSeriesPoints)
+	}
+	if q.Method != DownsampleLTTB && q.Method != DownsampleMinMax {
+		return fmt.Errorf("unsupported method %q: must be lttb or minmax", q.Method)
+	}
+	return nil
+}
+
+// DeviceSeries is a downsampled time series of a device variable
+type DeviceSeries struct {
+	DeviceID  string           `json:"device_id"`
+	Variable  string           `json:"variable"`
+	Channel   string           `json:"channel,omitempty"`
+	Method    DownsampleMethod `json:"method"`
+	From      time.Time        `json:"from"`
+	To        time.Time        `json:"to"`
+	RawPoints int              `json:"raw_points"` // Values found before downsampling
+	Points    []SeriesPoint    `json:"points"`
+}
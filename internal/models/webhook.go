@@ -0,0 +1,169 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// MaxWebhookSecretLength caps the length of a webhook signing secret
+const MaxWebhookSecretLength = 256
+
+// MinWebhookSecretLength is the minimum length of a secret chosen by the client
+const MinWebhookSecretLength = 16
+
+// Webhook is a subscription of a project to the created messages matching a filter, which are
+// POSTed to URL and signed with Secret
+type Webhook struct {
+	ID          string        `bson:"_id" json:"id" firestore:"-"`
+	ProjectID   string        `bson:"projectId" json:"projectId" firestore:"projectId"`
+	URL         string        `bson:"url" json:"url" firestore:"url"`
+	Secret      string        `bson:"secret" json:"secret,omitempty" firestore:"secret"` // Only returned when the webhook is created
+	Description string        `bson:"description,omitempty" json:"description,omitempty" firestore:"description,omitempty"`
+	Filter      MessageFilter `bson:"filter" json:"filter" firestore:"filter"` // Restricted to the messages of the project
+	Active      bool          `bson:"active" json:"active" firestore:"active"`
+	CreatedBy   string        `bson:"createdBy" json:"createdBy" firestore:"createdBy"`
+	CreatedAt   time.Time     `bson:"createdAt" json:"createdAt" firestore:"createdAt"`
+	UpdatedAt   time.Time     `bson:"updatedAt" json:"updatedAt" firestore:"updatedAt"`
+}
+
+// WebhookUpdate lists the fields of a webhook that can be changed; nil fields are kept
+type WebhookUpdate struct {
+	URL         *string        `json:"url,omitempty"`
+	Secret      *string        `json:"secret,omitempty"`
+	Description *string        `json:"description,omitempty"`
+	Filter      *MessageFilter `json:"filter,omitempty"`
+	Active      *bool          `json:"active,omitempty"`
+}
+
+// Apply copies the set fields of the update onto the webhook
+func (u WebhookUpdate) Apply(w *Webhook) {
+	if u.URL != nil {
+		w.URL = *u.URL
+	}
+	if u.Secret != nil {
+		w.Secret = *u.Secret
+	}
+	if u.Description != nil {
+		w.Description = *u.Description
+	}
+	if u.Filter != nil {
+		w.Filter = *u.Filter
+	}
+	if u.Active != nil {
+		w.Active = *u.Active
+	}
+}
+
+// webhookResolveTimeout bounds the resolution of the host of a webhook URL
+const webhookResolveTimeout = 2 * time.Second
+
+// ErrWebhookAddressNotAllowed is returned for webhook targets on a loopback, private,
+// link-local or otherwise internal address
+var ErrWebhookAddressNotAllowed = errors.New("webhook address not allowed")
+
+// nonPublicNetworks lists the IPv4 ranges not covered by the net.IP classifications that
+// must not be reachable from webhooks
+var nonPublicNetworks = []*net.IPNet{
+	{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)},     // "This" network
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}, // Carrier-grade NAT, used by some metadata services
+}
+
+// IsPublicWebhookAddress reports whether webhooks may connect to the address: loopback,
+// private, link-local (169.254.169.254 included), multicast and unspecified addresses are
+// refused
+func IsPublicWebhookAddress(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks the target URL, the secret and the filter of the webhook. The host of the
+// URL is resolved and rejected when one of its addresses is not public; a host that cannot
+// be resolved now is accepted, as the dispatcher checks the address of every connection.
+func (w *Webhook) Validate() error {
+	target, err := url.Parse(w.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if target.User != nil {
+		return errors.New("url must not contain credentials")
+	}
+	if err := checkWebhookHost(target.Hostname()); err != nil {
+		return err
+	}
+	if len(w.Secret) < MinWebhookSecretLength || len(w.Secret) > MaxWebhookSecretLength {
+		return fmt.Errorf("secret must be %d to %d characters long", MinWebhookSecretLength, MaxWebhookSecretLength)
+	}
+	return w.Filter.Validate()
+}
+
+// checkWebhookHost rejects the hosts with a non-public address
+func checkWebhookHost(host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !IsPublicWebhookAddress(ip) {
+			return fmt.Errorf("%w: %s", ErrWebhookAddressNotAllowed, host)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookResolveTimeout)
+	defer cancel()
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, address := range addresses {
+		if !IsPublicWebhookAddress(address.IP) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrWebhookAddressNotAllowed, host, address.IP)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the webhook is active and the message belongs to its project and
+// matches its filter
+func (w *Webhook) Matches(message *Message) bool {
+	return w.Active && message.ProjectID == w.ProjectID && w.Filter.Matches(message)
+}
+
+// WebhookDeliveryStatus is the outcome of a webhook delivery
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"   // Not delivered yet, another attempt is scheduled
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded" // The target answered with a 2xx status
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"    // Every attempt failed, or the target rejected the message
+)
+
+// WebhookDelivery records the delivery of a message to a webhook and each of its attempts
+type WebhookDelivery struct {
+	ID            string                `bson:"_id" json:"id" firestore:"-"`
+	WebhookID     string                `bson:"webhookId" json:"webhookId" firestore:"webhookId"`
+	ProjectID     string                `bson:"projectId" json:"projectId" firestore:"projectId"`
+	MessageID     string                `bson:"messageId" json:"messageId" firestore:"messageId"`
+	Event         string                `bson:"event" json:"event" firestore:"event"`
+	Status        WebhookDeliveryStatus `bson:"status" json:"status" firestore:"status"`
+	Attempts      []WebhookAttempt      `bson:"attempts" json:"attempts" firestore:"attempts"`
+	NextAttemptAt *time.Time            `bson:"nextAttemptAt,omitempty" json:"nextAttemptAt,omitempty" firestore:"nextAttemptAt,omitempty"`
+	CreatedAt     time.Time             `bson:"createdAt" json:"createdAt" firestore:"createdAt"`
+	UpdatedAt     time.Time             `bson:"updatedAt" json:"updatedAt" firestore:"updatedAt"`
+}
+
+// WebhookAttempt is a single POST of a delivery
+type WebhookAttempt struct {
+	AttemptedAt time.Time `bson:"attemptedAt" json:"attemptedAt" firestore:"attemptedAt"`
+	StatusCode  int       `bson:"statusCode,omitempty" json:"statusCode,omitempty" firestore:"statusCode,omitempty"` // Zero when no response was received
+	Error       string    `bson:"error,omitempty" json:"error,omitempty" firestore:"error,omitempty"`
+	DurationMs  int64     `bson:"durationMs" json:"durationMs" firestore:"durationMs"`
+}
@@ -0,0 +1,325 @@
+// Package presence tracks whether the devices are online from their online, status and
+// telemetry messages and marks the silent ones offline.
+package presence
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// eventBuffer is the number of created messages queued for the tracker
+	eventBuffer = 1024
+	// processTimeout bounds the processing of a single message or sweep
+	processTimeout = 10 * time.Second
+	// lastSeenPrecision is how far the stored LastSeen of a device may lag behind its last
+	// message, which saves a write for every message of chatty devices
+	lastSeenPrecision = 15 * time.Second
+	// minSweepInterval and maxSweepInterval bound how often silent devices are looked for
+	minSweepInterval = time.Second
+	maxSweepInterval = time.Minute
+)
+
+// Tracker keeps the presence of every device that sent a presence message up to date and
+// records its transitions. Online messages set the state they report; status and telemetry
+// messages show the device is online. A device silent for the timeout is marked offline. The
+// state and LastSeen of a presence are copied to the device registered with its client ID.
+type Tracker interface {
+	// Start subscribes to the event bus, processes events in the background and sweeps the
+	// silent devices periodically
+	Start(ctx context.Context) error
+	// Stop unsubscribes, waits for the queued events to be processed and stores the pending
+	// LastSeen updates
+	Stop()
+	// Process updates the presence of the device of a message
+	Process(ctx context.Context, message *models.Message) error
+	// Sweep marks offline the online devices whose last message is older than the timeout
+	Sweep(ctx context.Context, now time.Time) error
+}
+
+type tracker struct {
+	bus     events.Bus
+	repo    repositories.PresenceRepository
+	devices repositories.DeviceRepository
+	timeout time.Duration
+	now     func() time.Time
+
+	mu        sync.Mutex
+	presences map[string]*models.DevicePresence // Known presences by device ID
+	stored    map[string]time.Time              // Stored LastSeen of the known presences
+
+	cancel func()
+	done   chan struct{}
+}
+
+// NewTracker returns a tracker storing the presences in repo; devices may be nil when there
+// is no device registry to update
+func NewTracker(bus events.Bus, repo repositories.PresenceRepository, devices repositories.DeviceRepository, timeout time.Duration) Tracker {
+	return &tracker{
+		bus:       bus,
+		repo:      repo,
+		devices:   devices,
+		timeout:   timeout,
+		now:       time.Now,
+		presences: make(map[string]*models.DevicePresence),
+		stored:    make(map[string]time.Time),
+	}
+}
+
+func (t *tracker) Start(ctx context.Context) error {
+	if t.done != nil {
+		return errors.New("presence tracker already started")
+	}
+
+	queue, cancel := t.bus.Subscribe("presence", eventBuffer)
+	t.cancel = cancel
+	t.done = make(chan struct{})
+
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(min(max(t.timeout/10, minSweepInterval), maxSweepInterval))
+		defer ticker.Stop()
+		for {
+			select {
+			case event, ok := <-queue:
+				if !ok {
+					return
+				}
+				if event.Type != events.MessageCreated {
+					continue
+				}
+				processCtx, cancelProcess := context.WithTimeout(ctx, processTimeout)
+				if err := t.Process(processCtx, event.Message); err != nil {
+					log.Printf("Presence update from message %s failed: %v", event.Message.GetIDAsString(), err)
+				}
+				cancelProcess()
+			case <-ticker.C:
+				sweepCtx, cancelSweep := context.WithTimeout(ctx, processTimeout)
+				if err := t.Sweep(sweepCtx, t.now()); err != nil {
+					log.Printf("Presence sweep failed: %v", err)
+				}
+				cancelSweep()
+			}
+		}
+	}()
+	log.Printf("Presence tracker started with a %s timeout", t.timeout)
+	return nil
+}
+
+func (t *tracker) Stop() {
+	if t.cancel == nil {
+		return
+	}
+	t.cancel()
+	<-t.done
+	t.cancel = nil
+
+	var pending []presenceChange
+	t.mu.Lock()
+	for deviceID, presence := range t.presences {
+		if presence.LastSeen.After(t.stored[deviceID]) {
+			pending = append(pending, presenceChange{presence: *presence})
+		}
+	}
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), processTimeout)
+	defer cancel()
+	for _, change := range pending {
+		if err := t.save(ctx, change); err != nil {
+			log.Printf("Storing the presence of device %s failed: %v", change.presence.DeviceID, err)
+		}
+	}
+}
+
+func (t *tracker) Process(ctx context.Context, message *models.Message) error {
+	if !message.IsPresenceMessage() || message.DeviceID == "" {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now().UTC()
+	seen := message.Timestamp.UTC()
+	if seen.IsZero() || seen.After(now) {
+		seen = now
+	}
+
+	presence, err := t.presence(ctx, message.DeviceID)
+	if err != nil {
+		return err
+	}
+	write := false
+	if presence == nil {
+		presence = &models.DevicePresence{DeviceID: message.DeviceID}
+		write = true
+	}
+	if message.ProjectID != "" && presence.ProjectID != message.ProjectID {
+		presence.ProjectID = message.ProjectID
+		write = true
+	}
+	if message.ClientID != "" && presence.ClientID != message.ClientID {
+		presence.ClientID = message.ClientID
+		write = true
+	}
+
+	// Messages older than the last one or than the timeout, e.g. replayed by an event feed,
+	// only move LastSeen forward
+	current := !seen.Before(presence.LastSeen) && now.Sub(seen) < t.timeout
+	if seen.After(presence.LastSeen) {
+		presence.LastSeen = seen
+		write = write || seen.Sub(t.stored[presence.DeviceID]) >= lastSeenPrecision
+	}
+
+	var transition *models.PresenceTransition
+	state, reason := models.PresenceOnline, models.PresenceReasonMessage
+	if reported, ok := message.ReportedPresence(); ok {
+		state, reason = reported, models.PresenceReasonReported
+	}
+	if current && presence.State != state {
+		transition = &models.PresenceTransition{
+			ID:        primitive.NewObjectID().Hex(),
+			DeviceID:  presence.DeviceID,
+			ProjectID: presence.ProjectID,
+			From:      presence.State,
+			To:        state,
+			Reason:    reason,
+			MessageID: message.GetIDAsString(),
+			At:        seen,
+		}
+		presence.State = state
+		presence.Reason = reason
+		presence.Since = seen
+		write = true
+	}
+
+	t.presences[presence.DeviceID] = presence
+	if !write {
+		return nil
+	}
+	if presence.State == "" {
+		// First seen through a late message: silent since then
+		presence.State = models.PresenceOffline
+		presence.Reason = models.PresenceReasonTimeout
+		presence.Since = now
+	}
+	presence.UpdatedAt = now
+	change := presenceChange{presence: *presence, transition: transition}
+	if err := t.store(ctx, change); err != nil {
+		return err
+	}
+	t.stored[presence.DeviceID] = presence.LastSeen
+	return nil
+}
+
+func (t *tracker) Sweep(ctx context.Context, now time.Time) error {
+	now = now.UTC()
+	cutoff := now.Add(-t.timeout)
+	silent, _, err := t.repo.ListPresence(ctx, models.PresenceFilter{State: models.PresenceOnline, SeenBefore: &cutoff}, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	// The changes are decided under the lock and stored without holding it
+	var changes []presenceChange
+	t.mu.Lock()
+	for _, presence := range silent {
+		if known, ok := t.presences[presence.DeviceID]; ok && !known.LastSeen.Before(cutoff) {
+			// Seen since LastSeen was stored
+			changes = append(changes, presenceChange{presence: *known})
+			continue
+		}
+
+		transition := &models.PresenceTransition{
+			ID:        primitive.NewObjectID().Hex(),
+			DeviceID:  presence.DeviceID,
+			ProjectID: presence.ProjectID,
+			From:      presence.State,
+			To:        models.PresenceOffline,
+			Reason:    models.PresenceReasonTimeout,
+			At:        now,
+		}
+		presence.State = models.PresenceOffline
+		presence.Reason = models.PresenceReasonTimeout
+		presence.Since = now
+		presence.UpdatedAt = now
+		t.presences[presence.DeviceID] = presence
+		changes = append(changes, presenceChange{presence: *presence, transition: transition})
+	}
+	t.mu.Unlock()
+
+	var errs []error
+	for _, change := range changes {
+		if err := t.save(ctx, change); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// presence returns the known presence of a device, reading it on first use; nil when the
+// device has none yet
+func (t *tracker) presence(ctx context.Context, deviceID string) (*models.DevicePresence, error) {
+	if presence, ok := t.presences[deviceID]; ok {
+		return presence, nil
+	}
+	presence, err := t.repo.FindPresence(ctx, deviceID)
+	if errors.Is(err, repositories.ErrPresenceNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.stored[deviceID] = presence.LastSeen
+	return presence, nil
+}
+
+// presenceChange is a copy of a presence to store, with the transition that changed it if any
+type presenceChange struct {
+	presence   models.DevicePresence
+	transition *models.PresenceTransition
+}
+
+// save stores a change without holding the lock, then records its LastSeen as stored
+func (t *tracker) save(ctx context.Context, change presenceChange) error {
+	if err := t.store(ctx, change); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if change.presence.LastSeen.After(t.stored[change.presence.DeviceID]) {
+		t.stored[change.presence.DeviceID] = change.presence.LastSeen
+	}
+	return nil
+}
+
+// store writes a presence, the transition that changed it and the presence of the device
+// registered with its client ID
+func (t *tracker) store(ctx context.Context, change presenceChange) error {
+	presence := &change.presence
+	if err := t.repo.SavePresence(ctx, presence); err != nil {
+		return err
+	}
+	if change.transition != nil {
+		if err := t.repo.AddTransition(ctx, change.transition); err != nil {
+			return err
+		}
+	}
+	if t.devices == nil || presence.ClientID == "" {
+		return nil
+	}
+	err := t.devices.SetPresence(ctx, presence.ClientID, string(presence.State), presence.LastSeen)
+	if errors.Is(err, repositories.ErrDeviceNotFound) {
+		return nil
+	}
+	return err
+}
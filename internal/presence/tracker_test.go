@@ -0,0 +1,239 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var base = time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+
+// newTestTracker returns a tracker with a 5 minute timeout whose clock is the returned pointer
+func newTestTracker() (*tracker, repositories.PresenceRepository, *time.Time) {
+	repo := repositories.NewMemoryPresenceRepository()
+	clock := base
+	t := NewTracker(events.NewBus(), repo, nil, 5*time.Minute).(*tracker)
+	t.now = func() time.Time { return clock }
+	return t, repo, &clock
+}
+
+// message returns a message of dev-1 sent at base + seconds, its JSON payload parsed
+func message(id string, messageType models.MessageType, payload string, seconds int) *models.Message {
+	var marshalled map[string]interface{}
+	json.Unmarshal([]byte(payload), &marshalled)
+	return &models.Message{
+		ID:         id,
+		Type:       messageType,
+		Payload:    payload,
+		DeviceID:   "dev-1",
+		ProjectID:  "p1",
+		ClientID:   "client-1",
+		Timestamp:  base.Add(time.Duration(seconds) * time.Second),
+		Marshalled: marshalled,
+	}
+}
+
+// history describes the transitions of dev-1, oldest first
+func history(t *testing.T, repo repositories.PresenceRepository) string {
+	t.Helper()
+	transitions, _, err := repo.ListTransitions(context.Background(), "dev-1", nil, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("ListTransitions() unexpected error: %v", err)
+	}
+	var steps []string
+	for i := len(transitions) - 1; i >= 0; i-- {
+		steps = append(steps, fmt.Sprintf("%s->%s(%s)", transitions[i].From, transitions[i].To, transitions[i].Reason))
+	}
+	return fmt.Sprint(steps)
+}
+
+func TestTracker(t *testing.T) {
+	tr, repo, clock := newTestTracker()
+	ctx := context.Background()
+	process := func(m *models.Message) {
+		t.Helper()
+		if err := tr.Process(ctx, m); err != nil {
+			t.Fatalf("Process(%v) unexpected error: %v", m.ID, err)
+		}
+	}
+
+	// Commands are sent to the device and tell nothing about it
+	process(message("m-0", models.MessageTypeCommand, "reboot", 0))
+	if _, err := repo.FindPresence(ctx, "dev-1"); err == nil {
+		t.Fatal("FindPresence() after a command found a presence")
+	}
+
+	process(message("m-1", models.MessageTypeTelemetry, `{"temperature":20}`, 0))
+	presence, err := repo.FindPresence(ctx, "dev-1")
+	if err != nil {
+		t.Fatalf("FindPresence() unexpected error: %v", err)
+	}
+	if presence.State != models.PresenceOnline || presence.ProjectID != "p1" || presence.ClientID != "client-1" || !presence.LastSeen.Equal(base) {
+		t.Errorf("presence = %+v, want online since base", presence)
+	}
+
+	// LastSeen is only stored once it moved by lastSeenPrecision
+	*clock = base.Add(20 * time.Second)
+	process(message("m-2", models.MessageTypeStatus, "{}", 10))
+	if presence, _ := repo.FindPresence(ctx, "dev-1"); !presence.LastSeen.Equal(base) {
+		t.Errorf("stored LastSeen = %s, want it unchanged", presence.LastSeen)
+	}
+	process(message("m-3", models.MessageTypeStatus, "{}", 20))
+	if presence, _ := repo.FindPresence(ctx, "dev-1"); !presence.LastSeen.Equal(base.Add(20 * time.Second)) {
+		t.Errorf("stored LastSeen = %s, want base + 20s", presence.LastSeen)
+	}
+
+	// The device reports itself offline, e.g. with its last will
+	*clock = base.Add(time.Minute)
+	process(message("m-4", models.MessageTypeOnline, "offline", 60))
+	// A message sent before does not bring it back online
+	process(message("m-5", models.MessageTypeTelemetry, "{}", 30))
+	if presence, _ := repo.FindPresence(ctx, "dev-1"); presence.State != models.PresenceOffline || presence.Reason != models.PresenceReasonReported {
+		t.Errorf("presence = %+v, want reported offline", presence)
+	}
+
+	*clock = base.Add(2 * time.Minute)
+	process(message("m-6", models.MessageTypeOnline, `{"online":true}`, 120))
+
+	// Silent for the timeout
+	if err := tr.Sweep(ctx, base.Add(6*time.Minute)); err != nil {
+		t.Fatalf("Sweep() unexpected error: %v", err)
+	}
+	if presence, _ := repo.FindPresence(ctx, "dev-1"); presence.State != models.PresenceOnline {
+		t.Errorf("presence before the timeout = %+v, want online", presence)
+	}
+	if err := tr.Sweep(ctx, base.Add(8*time.Minute)); err != nil {
+		t.Fatalf("Sweep() unexpected error: %v", err)
+	}
+	presence, _ = repo.FindPresence(ctx, "dev-1")
+	if presence.State != models.PresenceOffline || presence.Reason != models.PresenceReasonTimeout || !presence.Since.Equal(base.Add(8*time.Minute)) {
+		t.Errorf("presence after the timeout = %+v, want offline since the sweep", presence)
+	}
+
+	// A message older than the timeout, e.g. replayed by the event feed, only moves LastSeen
+	*clock = base.Add(10 * time.Minute)
+	process(message("m-7", models.MessageTypeTelemetry, "{}", 240))
+	if presence, _ := repo.FindPresence(ctx, "dev-1"); presence.State != models.PresenceOffline || !presence.LastSeen.Equal(base.Add(4*time.Minute)) {
+		t.Errorf("presence after a late message = %+v, want offline, seen at base + 4m", presence)
+	}
+
+	want := "[->online(message) online->offline(reported) offline->online(reported) online->offline(timeout)]"
+	if got := history(t, repo); got != want {
+		t.Errorf("transitions = %s, want %s", got, want)
+	}
+}
+
+func TestTrackerSweepUsesUnstoredLastSeen(t *testing.T) {
+	tr, repo, clock := newTestTracker()
+	ctx := context.Background()
+
+	tr.Process(ctx, message("m-1", models.MessageTypeTelemetry, "{}", 0))
+	*clock = base.Add(5 * time.Minute)
+	tr.Process(ctx, message("m-2", models.MessageTypeTelemetry, "{}", 290))
+	tr.Process(ctx, message("m-3", models.MessageTypeTelemetry, "{}", 300))
+	// Stored at 290s, seen at 300s: still online at 5m + 295s
+	if err := tr.Sweep(ctx, base.Add(595*time.Second)); err != nil {
+		t.Fatalf("Sweep() unexpected error: %v", err)
+	}
+	if presence, _ := repo.FindPresence(ctx, "dev-1"); presence.State != models.PresenceOnline || !presence.LastSeen.Equal(base.Add(300*time.Second)) {
+		t.Errorf("presence = %+v, want online and seen at base + 300s", presence)
+	}
+}
+
+func TestTrackerUpdatesRegisteredDevices(t *testing.T) {
+	tr, _, clock := newTestTracker()
+	ctx := context.Background()
+	devices := repositories.NewMemoryDeviceRepository()
+	tr.devices = devices
+	device := &models.Device{ID: primitive.NewObjectID(), ProjectID: "p1", Name: "boiler", ClientID: "client-1", Status: "installed"}
+	if err := devices.Create(ctx, device); err != nil {
+		t.Fatalf("Create() unexpected error: %v", err)
+	}
+	registered := func() *models.Device {
+		t.Helper()
+		found, err := devices.FindByID(ctx, device.ID.Hex())
+		if err != nil {
+			t.Fatalf("FindByID() unexpected error: %v", err)
+		}
+		return found
+	}
+
+	tr.Process(ctx, message("m-1", models.MessageTypeTelemetry, "{}", 0))
+	if found := registered(); found.Status != "online" || found.LastSeen == nil || !found.LastSeen.Equal(base) || found.Name != "boiler" {
+		t.Errorf("device = %+v, want online and seen at base", found)
+	}
+	*clock = base.Add(time.Minute)
+	tr.Process(ctx, message("m-2", models.MessageTypeTelemetry, "{}", 60))
+	if found := registered(); !found.LastSeen.Equal(base.Add(time.Minute)) {
+		t.Errorf("device LastSeen = %s, want base + 1m", found.LastSeen)
+	}
+
+	if err := tr.Sweep(ctx, base.Add(10*time.Minute)); err != nil {
+		t.Fatalf("Sweep() unexpected error: %v", err)
+	}
+	if found := registered(); found.Status != "offline" || !found.LastSeen.Equal(base.Add(time.Minute)) {
+		t.Errorf("device after the timeout = %+v, want offline and seen at base + 1m", found)
+	}
+
+	// Devices without a registered client ID are tracked all the same
+	other := message("m-3", models.MessageTypeTelemetry, "{}", 600)
+	other.DeviceID, other.ClientID = "dev-2", "client-2"
+	if err := tr.Process(ctx, other); err != nil {
+		t.Errorf("Process() of an unregistered device unexpected error: %v", err)
+	}
+}
+
+// lockCheckingRepo fails the writes made while the tracker holds its lock
+type lockCheckingRepo struct {
+	repositories.PresenceRepository
+	tracker *tracker
+}
+
+func (r *lockCheckingRepo) SavePresence(ctx context.Context, presence *models.DevicePresence) error {
+	if !r.tracker.mu.TryLock() {
+		return fmt.Errorf("presence of %s saved while holding the tracker lock", presence.DeviceID)
+	}
+	r.tracker.mu.Unlock()
+	return r.PresenceRepository.SavePresence(ctx, presence)
+}
+
+func TestTrackerSweepStoresWithoutTheLock(t *testing.T) {
+	tr, repo, _ := newTestTracker()
+	ctx := context.Background()
+	tr.Process(ctx, message("m-1", models.MessageTypeTelemetry, "{}", 0))
+
+	tr.repo = &lockCheckingRepo{PresenceRepository: repo, tracker: tr}
+	if err := tr.Sweep(ctx, base.Add(10*time.Minute)); err != nil {
+		t.Fatalf("Sweep() unexpected error: %v", err)
+	}
+	if presence, _ := repo.FindPresence(ctx, "dev-1"); presence.State != models.PresenceOffline {
+		t.Errorf("presence = %+v, want offline", presence)
+	}
+}
+
+func TestTrackerStart(t *testing.T) {
+	tr, repo, clock := newTestTracker()
+	*clock = base.Add(time.Minute)
+	bus := tr.bus
+	if err := tr.Start(context.Background()); err != nil {
+		t.Fatalf("Start() unexpected error: %v", err)
+	}
+	bus.Publish(events.MessageEvent{Type: events.MessageCreated, Message: message("m-1", models.MessageTypeTelemetry, "{}", 0)})
+	bus.Publish(events.MessageEvent{Type: events.MessageUpdated, Message: message("m-2", models.MessageTypeOnline, "offline", 1)})
+	bus.Publish(events.MessageEvent{Type: events.MessageCreated, Message: message("m-3", models.MessageTypeTelemetry, "{}", 5)})
+	tr.Stop()
+
+	// Stopping stores the LastSeen not stored yet
+	presence, err := repo.FindPresence(context.Background(), "dev-1")
+	if err != nil || presence.State != models.PresenceOnline || !presence.LastSeen.Equal(base.Add(5*time.Second)) {
+		t.Errorf("presence = %+v, %v; want online and seen at base + 5s", presence, err)
+	}
+}
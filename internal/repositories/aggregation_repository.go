@@ -0,0 +1,179 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+// AggregationRepository maintains the precomputed aggregations read by
+// MessageRepository.GetAggregatedDataByDeviceID
+type AggregationRepository interface {
+	// ApplyRollups adds the samples of a batch of messages to the aggregations of their
+	// devices, the messages of a device together, and returns the number of messages
+	// applied. Every message is applied at most once: a message whose ID was already
+	// applied, or that appears earlier in the batch, is skipped without changing anything.
+	ApplyRollups(ctx context.Context, rollups []models.MessageRollup) (int, error)
+	// SnapshotBuckets records the state of the aggregations holding the buckets of a device
+	// whose timestamp falls in [from, to). It is taken before reading the messages a
+	// replacement of the range is computed from.
+	SnapshotBuckets(ctx context.Context, deviceID string, from, to time.Time) (*AggregationSnapshot, error)
+	// ReplaceBuckets replaces the buckets of the snapshot range with the buckets of
+	// replacement, which must all fall in the range, and fails with ErrAggregationsChanged
+	// when the aggregations changed since the snapshot. The given messages are recorded as
+	// applied first, so rollups still queued for them do not count them again.
+	ReplaceBuckets(ctx context.Context, snapshot *AggregationSnapshot, replacement *models.ClientAggregations, messageIDs []string) error
+}
+
+// ErrAggregationsChanged is returned by ReplaceBuckets when a rollup changed the aggregations
+// after the snapshot, so the replacement may miss its message and must be computed again
+var ErrAggregationsChanged = errors.New("aggregations changed since the snapshot")
+
+// AggregationSnapshot is the state of the aggregations of a device in [From, To) taken by
+// SnapshotBuckets
+type AggregationSnapshot struct {
+	DeviceID string
+	From, To time.Time
+	// documents holds the aggregations documents of the range that existed, by partition,
+	// the legacy document under the zero partition
+	documents map[models.RollupPartition]snapshotDocument
+}
+
+// snapshotDocument is the state of one aggregations document in a snapshot; aggregations is
+// only kept by the repositories that need it to build the replacement
+type snapshotDocument struct {
+	aggregations *models.ClientAggregations
+	version      int64
+}
+
+// changedSince reports whether the current documents of the snapshot range differ from the
+// snapshot, in their versions or in which of them exist
+func (s *AggregationSnapshot) changedSince(current map[models.RollupPartition]snapshotDocument) bool {
+	if len(current) != len(s.documents) {
+		return true
+	}
+	for partition, document := range current {
+		if snapshot, ok := s.documents[partition]; !ok || snapshot.version != document.version {
+			return true
+		}
+	}
+	return false
+}
+
+// validSnapshot checks that a snapshot was taken for a device
+func validSnapshot(snapshot *AggregationSnapshot) error {
+	if snapshot == nil || snapshot.DeviceID == "" {
+		return errors.New("a snapshot of the device aggregations is required")
+	}
+	return nil
+}
+
+// The buckets of a device are stored in one aggregations document per period and partition
+// (see models.RollupPartition). Devices aggregated before may also have a legacy document
+// holding all of their buckets: it is read together with the partitions, no longer
+// receives rollups, and its buckets are cleared when their range is replaced.
+const aggregationCollection = "aggregations"
+
+// aggregationLedgerCollection records the messages already applied to the aggregations
+const aggregationLedgerCollection = "aggregation_ledger"
+
+// rangePartitions returns the storage partitions of every period holding the buckets of
+// [from, to)
+func rangePartitions(from, to time.Time) []models.RollupPartition {
+	var partitions []models.RollupPartition
+	for _, period := range models.RollupPeriods {
+		partitions = append(partitions, period.Partitions(from, to)...)
+	}
+	return partitions
+}
+
+// deviceRollups are the rollups of a batch for one device
+type deviceRollups struct {
+	deviceID string
+	rollups  []models.MessageRollup
+}
+
+// groupRollups checks a batch of rollups and groups them by device, in the order the
+// devices first appear, leaving out the messages repeated in the batch
+func groupRollups(rollups []models.MessageRollup) ([]deviceRollups, error) {
+	var devices []deviceRollups
+	index := make(map[string]int)
+	seen := make(map[string]bool)
+	for _, rollup := range rollups {
+		if rollup.MessageID == "" || rollup.DeviceID == "" {
+			return nil, errors.New("rollup requires a message ID and a device ID")
+		}
+		for _, sample := range rollup.Samples {
+			if _, ok := sample.Period.PartitionOf(sample.Key); !ok {
+				return nil, fmt.Errorf("message %s: invalid %s bucket key %q", rollup.MessageID, sample.Period, sample.Key)
+			}
+		}
+		if seen[rollup.MessageID] {
+			continue
+		}
+		seen[rollup.MessageID] = true
+
+		i, ok := index[rollup.DeviceID]
+		if !ok {
+			i = len(devices)
+			index[rollup.DeviceID] = i
+			devices = append(devices, deviceRollups{deviceID: rollup.DeviceID})
+		}
+		devices[i].rollups = append(devices[i].rollups, rollup)
+	}
+	return devices, nil
+}
+
+// partitionedSamples are the samples of a rollup whose buckets are stored in one partition
+type partitionedSamples struct {
+	partition models.RollupPartition
+	samples   []models.RollupSample
+}
+
+// partitionSamples groups samples checked by groupRollups by the storage partition of
+// their bucket, in the order the partitions first appear
+func partitionSamples(samples []models.RollupSample) []partitionedSamples {
+	var groups []partitionedSamples
+	index := make(map[models.RollupPartition]int)
+	for _, sample := range samples {
+		partition, _ := sample.Period.PartitionOf(sample.Key)
+		i, ok := index[partition]
+		if !ok {
+			i = len(groups)
+			index[partition] = i
+			groups = append(groups, partitionedSamples{partition: partition})
+		}
+		groups[i].samples = append(groups[i].samples, sample)
+	}
+	return groups
+}
+
+// partitionRange bounds the partition keys of a period that can hold the buckets selected
+// by a filter; an empty bound is open
+type partitionRange struct {
+	period   models.RollupPeriod
+	from, to string
+}
+
+// selectedPartitions returns the partition keys of every selected period that can hold
+// the buckets of the time bounds of the filter
+func selectedPartitions(filter models.AggregationFilter) []partitionRange {
+	var ranges []partitionRange
+	for _, period := range models.RollupPeriods {
+		if !filter.MatchesPeriod(string(period)) {
+			continue
+		}
+		r := partitionRange{period: period}
+		if filter.FromTime != nil {
+			r.from = period.Partition(*filter.FromTime).Key
+		}
+		if filter.ToTime != nil {
+			r.to = period.Partition(*filter.ToTime).Key
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
@@ -0,0 +1,384 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+
+	"cloud.google.com/go/firestore"
+)
+
+type firestoreAggregationRepository struct {
+	client *firestore.Client
+}
+
+func NewFirestoreAggregationRepository(client *firestore.Client) AggregationRepository {
+	return &firestoreAggregationRepository{client: client}
+}
+
+// aggregationPartitionCollection is the subcollection of the aggregations document of a
+// device holding its partition documents
+const aggregationPartitionCollection = "partitions"
+
+// firestoreAggregationPartition is the stored form of the buckets of one period of a device
+// over one partition
+type firestoreAggregationPartition struct {
+	ClientID     string                                                             `firestore:"client_id"`
+	Period       string                                                             `firestore:"period"`
+	Partition    string                                                             `firestore:"partition"`
+	Aggregations map[string]map[string]map[string]map[string]*models.AggregatedData `firestore:"aggregations"`
+}
+
+// partitionRef returns the document of a device holding a partition. Its ID starts with the
+// period and ends with the partition key, so the IDs of a period sort chronologically.
+func (r *firestoreAggregationRepository) partitionRef(deviceID string, partition models.RollupPartition) *firestore.DocumentRef {
+	return r.client.Collection(aggregationCollection).Doc(deviceID).Collection(aggregationPartitionCollection).
+		Doc(string(partition.Period) + "_" + partition.Key)
+}
+
+// readAggregations decodes the buckets of an aggregations document, empty when it does not
+// exist
+func readAggregations(doc *firestore.DocumentSnapshot, deviceID string) (*models.ClientAggregations, error) {
+	agg := &models.ClientAggregations{ClientID: deviceID}
+	if !doc.Exists() {
+		return agg, nil
+	}
+	if err := doc.DataTo(agg); err != nil {
+		return nil, err
+	}
+	return agg, nil
+}
+
+// firestoreRollupChunk bounds the messages applied in one transaction, so their ledger
+// entries and the at most three partition documents of each stay within firestoreMaxWrites
+const firestoreRollupChunk = 100
+
+// ApplyRollups applies the messages of each device in one transaction per chunk of
+// messages, which checks their ledger entries, updates the partition documents holding
+// their buckets and records them. A failed device does not stop the others.
+func (r *firestoreAggregationRepository) ApplyRollups(ctx context.Context, rollups []models.MessageRollup) (int, error) {
+	devices, err := groupRollups(rollups)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	var errs []error
+	for _, device := range devices {
+		for start := 0; start < len(device.rollups); start += firestoreRollupChunk {
+			n, err := r.applyDeviceRollups(ctx, device.deviceID, device.rollups[start:min(start+firestoreRollupChunk, len(device.rollups))])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("device %s: %w", device.deviceID, err))
+				break
+			}
+			applied += n
+		}
+	}
+	return applied, errors.Join(errs...)
+}
+
+// applyDeviceRollups applies rollups of one device in a transaction and returns the number
+// of messages applied
+func (r *firestoreAggregationRepository) applyDeviceRollups(ctx context.Context, deviceID string, rollups []models.MessageRollup) (int, error) {
+	ledger := r.client.Collection(aggregationLedgerCollection)
+	refs := make([]*firestore.DocumentRef, 0, len(rollups))
+	var samples []models.RollupSample
+	for _, rollup := range rollups {
+		refs = append(refs, ledger.Doc(rollup.MessageID))
+		samples = append(samples, rollup.Samples...)
+	}
+	// The partitions of every message are read, since the messages already applied are
+	// only known within the transaction
+	groups := partitionSamples(samples)
+	index := make(map[models.RollupPartition]int, len(groups))
+	for i, group := range groups {
+		index[group.partition] = i
+		refs = append(refs, r.partitionRef(deviceID, group.partition))
+	}
+
+	var applied int
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		applied = 0
+		// All reads must happen before the writes of a transaction
+		docs, err := tx.GetAll(refs)
+		if err != nil {
+			return err
+		}
+		aggs := make([]*models.ClientAggregations, len(groups))
+		for i, doc := range docs[len(rollups):] {
+			if aggs[i], err = readAggregations(doc, deviceID); err != nil {
+				return err
+			}
+		}
+
+		changed := make([]bool, len(groups))
+		now := time.Now().UTC()
+		for i, rollup := range rollups {
+			if docs[i].Exists() {
+				continue
+			}
+			for _, sample := range rollup.Samples {
+				partition, _ := sample.Period.PartitionOf(sample.Key)
+				aggs[index[partition]].Apply(sample)
+				changed[index[partition]] = true
+			}
+			if err := tx.Create(refs[i], map[string]interface{}{"deviceId": deviceID, "appliedAt": now}); err != nil {
+				return err
+			}
+			applied++
+		}
+		for i, group := range groups {
+			if !changed[i] {
+				continue
+			}
+			if err := tx.Set(refs[len(rollups)+i], firestoreAggregationPartition{deviceID, string(group.partition.Period), group.partition.Key, aggs[i].Aggregations}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return applied, nil
+}
+
+// firestoreMaxWrites is the maximum number of writes in a single Firestore transaction
+const firestoreMaxWrites = 500
+
+// rangeRefs returns the legacy document of a device followed by the partition documents of
+// [from, to), with the partition of each, zero for the legacy document
+func (r *firestoreAggregationRepository) rangeRefs(deviceID string, from, to time.Time) ([]models.RollupPartition, []*firestore.DocumentRef) {
+	partitions := append([]models.RollupPartition{{}}, rangePartitions(from, to)...)
+	refs := []*firestore.DocumentRef{r.client.Collection(aggregationCollection).Doc(deviceID)}
+	for _, partition := range partitions[1:] {
+		refs = append(refs, r.partitionRef(deviceID, partition))
+	}
+	return partitions, refs
+}
+
+// snapshotVersions returns the documents that exist with their update time as version
+func snapshotVersions(partitions []models.RollupPartition, docs []*firestore.DocumentSnapshot) map[models.RollupPartition]snapshotDocument {
+	documents := make(map[models.RollupPartition]snapshotDocument)
+	for i, doc := range docs {
+		if doc.Exists() {
+			documents[partitions[i]] = snapshotDocument{version: doc.UpdateTime.UnixNano()}
+		}
+	}
+	return documents
+}
+
+// SnapshotBuckets reads the update times of the legacy document and the partition
+// documents of the range
+func (r *firestoreAggregationRepository) SnapshotBuckets(ctx context.Context, deviceID string, from, to time.Time) (*AggregationSnapshot, error) {
+	if deviceID == "" {
+		return nil, errors.New("device ID is required")
+	}
+
+	partitions, refs := r.rangeRefs(deviceID, from, to)
+	docs, err := r.client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+	return &AggregationSnapshot{DeviceID: deviceID, From: from, To: to, documents: snapshotVersions(partitions, docs)}, nil
+}
+
+// ReplaceBuckets records the ledger entries in chunks, then replaces the buckets of the
+// range with read-modify-write transactions on the legacy document and the partition
+// documents of the range, up to 500 documents per transaction. The update times of the
+// snapshot are checked before anything is written, and again by each transaction for its
+// documents. The ledger entries claimed here are released when the first transaction
+// fails, so the rollups still queued for their messages are not lost; once a document was
+// replaced they are kept, and the documents left unreplaced miss those messages rather
+// than counting them twice.
+func (r *firestoreAggregationRepository) ReplaceBuckets(ctx context.Context, snapshot *AggregationSnapshot, replacement *models.ClientAggregations, messageIDs []string) error {
+	if err := validSnapshot(snapshot); err != nil {
+		return err
+	}
+
+	deviceID, from, to := snapshot.DeviceID, snapshot.From, snapshot.To
+	claimed, err := r.claimMessages(ctx, deviceID, messageIDs)
+	if err != nil {
+		return err
+	}
+
+	// The legacy document comes first and is only cleared
+	partitions, refs := r.rangeRefs(deviceID, from, to)
+	docs, err := r.client.GetAll(ctx, refs)
+	if err == nil && snapshot.changedSince(snapshotVersions(partitions, docs)) {
+		err = fmt.Errorf("device %s: %w", deviceID, ErrAggregationsChanged)
+	}
+	if err != nil {
+		r.releaseMessages(ctx, deviceID, claimed)
+		return err
+	}
+	parts := replacement.Partitioned()
+
+	for start := 0; start < len(refs); start += firestoreMaxWrites {
+		end := min(start+firestoreMaxWrites, len(refs))
+		err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			docs, err := tx.GetAll(refs[start:end])
+			if err != nil {
+				return err
+			}
+			for i, doc := range docs {
+				partition := partitions[start+i]
+				document, found := snapshot.documents[partition]
+				if doc.Exists() != found || found && doc.UpdateTime.UnixNano() != document.version {
+					return fmt.Errorf("device %s: %w", deviceID, ErrAggregationsChanged)
+				}
+				part, ok := parts[partition]
+				if !ok {
+					part = &models.ClientAggregations{}
+				}
+				agg, err := readAggregations(doc, deviceID)
+				if err != nil {
+					return err
+				}
+				if len(part.Aggregations) == 0 && len(agg.BucketPaths(from, to)) == 0 {
+					continue
+				}
+				agg.ReplaceRange(from, to, part)
+				if start+i == 0 {
+					err = tx.Set(refs[start+i], agg)
+				} else {
+					err = tx.Set(refs[start+i], firestoreAggregationPartition{deviceID, string(partition.Period), partition.Key, agg.Aggregations})
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			if start == 0 {
+				r.releaseMessages(ctx, deviceID, claimed)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// claimMessages records the messages as applied, one transaction per chunk, and returns
+// those that were not yet. A failed chunk releases the entries claimed by the previous ones.
+func (r *firestoreAggregationRepository) claimMessages(ctx context.Context, deviceID string, messageIDs []string) ([]string, error) {
+	now := time.Now().UTC()
+	ledger := r.client.Collection(aggregationLedgerCollection)
+	var claimed []string
+	for start := 0; start < len(messageIDs); start += firestoreMaxWrites {
+		chunk := messageIDs[start:min(start+firestoreMaxWrites, len(messageIDs))]
+		var chunkClaimed []string
+		err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			chunkClaimed = nil
+			refs := make([]*firestore.DocumentRef, 0, len(chunk))
+			for _, id := range chunk {
+				refs = append(refs, ledger.Doc(id))
+			}
+			docs, err := tx.GetAll(refs)
+			if err != nil {
+				return err
+			}
+			for i, doc := range docs {
+				if doc.Exists() {
+					continue
+				}
+				if err := tx.Create(refs[i], map[string]interface{}{"deviceId": deviceID, "appliedAt": now}); err != nil {
+					return err
+				}
+				chunkClaimed = append(chunkClaimed, chunk[i])
+			}
+			return nil
+		})
+		if err != nil {
+			r.releaseMessages(ctx, deviceID, claimed)
+			return nil, err
+		}
+		claimed = append(claimed, chunkClaimed...)
+	}
+	return claimed, nil
+}
+
+// releaseMessages deletes the ledger entries of messages whose rollups were not applied
+func (r *firestoreAggregationRepository) releaseMessages(ctx context.Context, deviceID string, messageIDs []string) {
+	ledger := r.client.Collection(aggregationLedgerCollection)
+	for start := 0; start < len(messageIDs); start += firestoreMaxWrites {
+		chunk := messageIDs[start:min(start+firestoreMaxWrites, len(messageIDs))]
+		err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			for _, id := range chunk {
+				if err := tx.Delete(ledger.Doc(id)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to release %d rollup ledger entries of device %s: %v", len(chunk), deviceID, err)
+		}
+	}
+}
+
+// findFirestoreAggregations reads and merges the aggregations documents of a device that
+// can hold the buckets selected by the filter: the partitions of the selected periods and
+// time range, and the legacy document. When the filter names channels, variables or
+// periods only those nested map entries are read.
+func findFirestoreAggregations(ctx context.Context, client *firestore.Client, deviceID string, filter models.AggregationFilter) (*models.ClientAggregations, error) {
+	collection := client.Collection(aggregationCollection)
+	partitions := collection.Doc(deviceID).Collection(aggregationPartitionCollection)
+	// Queries support field masks, unlike DocumentRef.Get
+	queries := []firestore.Query{collection.Where(firestore.DocumentID, "==", collection.Doc(deviceID))}
+	for _, r := range selectedPartitions(filter) {
+		from, to := string(r.period)+"_"+r.from, string(r.period)+"_"+r.to
+		if r.to == "" {
+			to = string(r.period) + "_\uf8ff"
+		}
+		queries = append(queries, partitions.Where(firestore.DocumentID, ">=", partitions.Doc(from)).Where(firestore.DocumentID, "<=", partitions.Doc(to)))
+	}
+
+	var fieldPaths []firestore.FieldPath
+	if paths := aggregationProjectionPaths(filter); paths != nil {
+		fieldPaths = []firestore.FieldPath{{"client_id"}}
+		for _, path := range paths {
+			fieldPaths = append(fieldPaths, firestore.FieldPath(path))
+		}
+	}
+
+	var merged *models.ClientAggregations
+	for _, query := range queries {
+		if fieldPaths != nil {
+			query = query.SelectPaths(fieldPaths...)
+		}
+		docs, err := query.Documents(ctx).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			var agg models.ClientAggregations
+			if err := doc.DataTo(&agg); err != nil {
+				return nil, err
+			}
+			if merged == nil {
+				merged = &agg
+			} else {
+				merged.Merge(&agg)
+			}
+		}
+	}
+
+	if merged == nil {
+		// The documents of the device may all be outside the selection
+		docs, err := partitions.Limit(1).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(docs) == 0 {
+			return nil, ErrAggregationsNotFound
+		}
+		merged = &models.ClientAggregations{ClientID: deviceID}
+	}
+	return merged, nil
+}
@@ -0,0 +1,500 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type aggregationRepository struct {
+	aggregations *mongo.Collection
+	ledger       *mongo.Collection
+}
+
+// NewAggregationRepository creates an aggregation repository on MongoDB, creating the
+// unique index on the device, period and partition of the aggregations documents
+func NewAggregationRepository(db *mongo.Database) (AggregationRepository, error) {
+	collection := db.Collection(aggregationCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "client_id", Value: 1}, {Key: "period", Value: 1}, {Key: "partition", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating the partition index of the aggregations: %w", err)
+	}
+	return &aggregationRepository{
+		aggregations: collection,
+		ledger:       db.Collection(aggregationLedgerCollection),
+	}, nil
+}
+
+// mongoPartitionFilter selects the aggregations document of a device holding a partition
+func mongoPartitionFilter(deviceID string, partition models.RollupPartition) bson.M {
+	return bson.M{"client_id": deviceID, "period": string(partition.Period), "partition": partition.Key}
+}
+
+// mongoLegacyFilter selects the legacy aggregations document of a device
+func mongoLegacyFilter(deviceID string) bson.M {
+	return bson.M{"client_id": deviceID, "period": bson.M{"$exists": false}}
+}
+
+// ApplyRollups claims the messages of each device in the ledger, whose _id is the message
+// ID, before updating the aggregations, so concurrent or repeated deliveries are applied
+// once. The samples of the claimed messages are then added to each partition document of
+// the device in a single atomic update. The claims are released when no document could be
+// updated; a crash or a failure after that leaves the rest of the messages uncounted rather
+// than counting them twice. A failed device does not stop the others.
+func (r *aggregationRepository) ApplyRollups(ctx context.Context, rollups []models.MessageRollup) (int, error) {
+	devices, err := groupRollups(rollups)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	var errs []error
+	for _, device := range devices {
+		n, err := r.applyDeviceRollups(ctx, device)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("device %s: %w", device.deviceID, err))
+			continue
+		}
+		applied += n
+	}
+	return applied, errors.Join(errs...)
+}
+
+// applyDeviceRollups applies the rollups of one device and returns the number of messages
+// claimed
+func (r *aggregationRepository) applyDeviceRollups(ctx context.Context, device deviceRollups) (int, error) {
+	messageIDs := make([]string, 0, len(device.rollups))
+	for _, rollup := range device.rollups {
+		messageIDs = append(messageIDs, rollup.MessageID)
+	}
+	claimed, err := r.claimMessages(ctx, device.deviceID, messageIDs)
+	if err != nil {
+		return 0, err
+	}
+	isClaimed := make(map[interface{}]bool, len(claimed))
+	for _, id := range claimed {
+		isClaimed[id] = true
+	}
+	var samples []models.RollupSample
+	for _, rollup := range device.rollups {
+		if isClaimed[rollup.MessageID] {
+			samples = append(samples, rollup.Samples...)
+		}
+	}
+
+	opts := options.Update().SetUpsert(true)
+	for i, group := range partitionSamples(samples) {
+		delta := &models.ClientAggregations{}
+		for _, sample := range group.samples {
+			delta.Apply(sample)
+		}
+		set := append(buildRollupSet(delta), bson.E{Key: "version", Value: bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$version", 0}}, 1}}})
+		update := mongo.Pipeline{{{Key: "$set", Value: set}}}
+		if _, err := r.aggregations.UpdateOne(ctx, mongoPartitionFilter(device.deviceID, group.partition), update, opts); err != nil {
+			if i == 0 {
+				r.releaseMessages(ctx, device.deviceID, claimed)
+			}
+			return 0, err
+		}
+	}
+	return len(claimed), nil
+}
+
+// buildRollupSet returns the $set stage merging the buckets of delta into the stored
+// buckets. Every expression reads the document as it was before the update, which is what
+// keeps the rollup of a batch a single atomic update of each document.
+func buildRollupSet(delta *models.ClientAggregations) bson.D {
+	set := bson.D{}
+	for channel, variables := range delta.Aggregations {
+		for variable, periods := range variables {
+			for period, buckets := range periods {
+				for key, bucket := range buckets {
+					set = append(set, buildBucketSet(rollupPath(channel, variable, period, key), bucket)...)
+				}
+			}
+		}
+	}
+	return set
+}
+
+// buildBucketSet returns the fields of the $set stage merging a bucket into the stored
+// bucket at path
+func buildBucketSet(path string, bucket *models.AggregatedData) bson.D {
+	sum := bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$" + path + ".sum", 0}}, bucket.Sum}}
+	count := bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$" + path + ".count", 0}}, bucket.Count}}
+	set := bson.D{
+		{Key: path + ".sum", Value: sum},
+		{Key: path + ".count", Value: count},
+		// $min and $max ignore the missing values of a new bucket
+		{Key: path + ".min", Value: bson.M{"$min": bson.A{"$" + path + ".min", bucket.Min}}},
+		{Key: path + ".max", Value: bson.M{"$max": bson.A{"$" + path + ".max", bucket.Max}}},
+		{Key: path + ".avg", Value: bson.M{"$divide": bson.A{sum, count}}},
+		{Key: path + ".sum_sq", Value: bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$" + path + ".sum_sq", 0}}, bucket.SumSquares}}},
+	}
+	if bucket.FirstAt != nil {
+		// A new bucket has no first_at, which compares below any date
+		missingFirst := bson.M{"$lte": bson.A{bson.M{"$ifNull": bson.A{"$" + path + ".first_at", nil}}, nil}}
+		isFirst := bson.M{"$or": bson.A{missingFirst, bson.M{"$lt": bson.A{*bucket.FirstAt, "$" + path + ".first_at"}}}}
+		set = append(set,
+			bson.E{Key: path + ".first", Value: bson.M{"$cond": bson.A{isFirst, bucket.First, "$" + path + ".first"}}},
+			bson.E{Key: path + ".first_at", Value: bson.M{"$cond": bson.A{isFirst, *bucket.FirstAt, "$" + path + ".first_at"}}},
+		)
+	}
+	if bucket.LastAt != nil {
+		isLast := bson.M{"$gte": bson.A{*bucket.LastAt, bson.M{"$ifNull": bson.A{"$" + path + ".last_at", nil}}}}
+		set = append(set,
+			bson.E{Key: path + ".last", Value: bson.M{"$cond": bson.A{isLast, bucket.Last, "$" + path + ".last"}}},
+			bson.E{Key: path + ".last_at", Value: bson.M{"$cond": bson.A{isLast, *bucket.LastAt, "$" + path + ".last_at"}}},
+		)
+	}
+	for bin, n := range bucket.Sketch {
+		field := path + ".sketch." + bin
+		set = append(set, bson.E{Key: field, Value: bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$" + field, 0}}, n}}})
+	}
+	return set
+}
+
+// SnapshotBuckets reads the legacy document and the partition documents of the range with
+// their versions. Legacy documents that predate the versions are given one first.
+func (r *aggregationRepository) SnapshotBuckets(ctx context.Context, deviceID string, from, to time.Time) (*AggregationSnapshot, error) {
+	if deviceID == "" {
+		return nil, errors.New("device ID is required")
+	}
+
+	versioned := mongo.Pipeline{{{Key: "$set", Value: bson.M{"version": bson.M{"$ifNull": bson.A{"$version", 0}}}}}}
+	if _, err := r.aggregations.UpdateOne(ctx, mongoLegacyFilter(deviceID), versioned); err != nil {
+		return nil, err
+	}
+	documents, err := r.findRangeDocuments(ctx, deviceID, from, to, true)
+	if err != nil {
+		return nil, err
+	}
+	return &AggregationSnapshot{DeviceID: deviceID, From: from, To: to, documents: documents}, nil
+}
+
+// findRangeDocuments reads the versions of the legacy document and the partition documents
+// of a device holding the buckets of [from, to), and their buckets when withBuckets is set
+func (r *aggregationRepository) findRangeDocuments(ctx context.Context, deviceID string, from, to time.Time, withBuckets bool) (map[models.RollupPartition]snapshotDocument, error) {
+	selected := bson.A{bson.M{"period": bson.M{"$exists": false}}}
+	for _, period := range models.RollupPeriods {
+		partitions := period.Partitions(from, to)
+		if len(partitions) == 0 {
+			continue
+		}
+		selected = append(selected, bson.M{
+			"period":    string(period),
+			"partition": bson.M{"$gte": partitions[0].Key, "$lte": partitions[len(partitions)-1].Key},
+		})
+	}
+	projection := bson.M{"period": 1, "partition": 1, "version": 1}
+	if withBuckets {
+		projection["aggregations"] = 1
+	}
+	cursor, err := r.aggregations.Find(ctx, bson.M{"client_id": deviceID, "$or": selected}, options.Find().SetProjection(projection))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	documents := make(map[models.RollupPartition]snapshotDocument)
+	for cursor.Next(ctx) {
+		var doc struct {
+			models.ClientAggregations `bson:",inline"`
+			Period                    string `bson:"period"`
+			Partition                 string `bson:"partition"`
+			Version                   int64  `bson:"version"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		document := snapshotDocument{version: doc.Version}
+		if withBuckets {
+			document.aggregations = &doc.ClientAggregations
+		}
+		documents[models.RollupPartition{Period: models.RollupPeriod(doc.Period), Key: doc.Partition}] = document
+	}
+	return documents, cursor.Err()
+}
+
+// ReplaceBuckets replaces the buckets of the range document by document: every partition
+// of the range, then the legacy document. Each document is replaced in a single update, so
+// readers never see a partially replaced document, but can see some partitions of the
+// range replaced before the others. Every update of an aggregations document increments
+// its version: the versions of the snapshot are checked before anything is written, and
+// each update is a compare-and-set on the version of its document in the snapshot. The
+// ledger entries claimed here are released when no document could be replaced, so the
+// rollups still queued for their messages are not lost; once a document was replaced they
+// are kept, and the documents left unreplaced miss those messages rather than counting
+// them twice.
+func (r *aggregationRepository) ReplaceBuckets(ctx context.Context, snapshot *AggregationSnapshot, replacement *models.ClientAggregations, messageIDs []string) error {
+	if err := validSnapshot(snapshot); err != nil {
+		return err
+	}
+
+	claimed, err := r.claimMessages(ctx, snapshot.DeviceID, messageIDs)
+	if err != nil {
+		return err
+	}
+	if replaced, err := r.replaceBuckets(ctx, snapshot, replacement); err != nil {
+		if replaced == 0 {
+			r.releaseMessages(ctx, snapshot.DeviceID, claimed)
+		}
+		return err
+	}
+	return nil
+}
+
+// claimMessages records the messages as applied and returns those that were not yet
+func (r *aggregationRepository) claimMessages(ctx context.Context, deviceID string, messageIDs []string) ([]interface{}, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+	now := time.Now().UTC()
+	writes := make([]mongo.WriteModel, 0, len(messageIDs))
+	for _, id := range messageIDs {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": id}).
+			SetUpdate(bson.M{"$setOnInsert": bson.M{"deviceId": deviceID, "appliedAt": now}}).
+			SetUpsert(true))
+	}
+	result, err := r.ledger.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	var claimed []interface{}
+	if result != nil {
+		for _, id := range result.UpsertedIDs {
+			claimed = append(claimed, id)
+		}
+	}
+	if err != nil {
+		// Some of the messages may have been claimed before the failure
+		r.releaseMessages(ctx, deviceID, claimed)
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// releaseMessages deletes the ledger entries of messages whose rollups were not applied
+func (r *aggregationRepository) releaseMessages(ctx context.Context, deviceID string, claimed []interface{}) {
+	if len(claimed) == 0 {
+		return
+	}
+	if _, err := r.ledger.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": claimed}}); err != nil {
+		log.Printf("Failed to release %d rollup ledger entries of device %s: %v", len(claimed), deviceID, err)
+	}
+}
+
+// replaceBuckets replaces the buckets of the snapshot range in every document holding them
+// and returns the number of documents written
+func (r *aggregationRepository) replaceBuckets(ctx context.Context, snapshot *AggregationSnapshot, replacement *models.ClientAggregations) (int, error) {
+	current, err := r.findRangeDocuments(ctx, snapshot.DeviceID, snapshot.From, snapshot.To, false)
+	if err != nil {
+		return 0, err
+	}
+	if snapshot.changedSince(current) {
+		return 0, fmt.Errorf("device %s: %w", snapshot.DeviceID, ErrAggregationsChanged)
+	}
+
+	parts := replacement.Partitioned()
+	replaced := 0
+	for _, partition := range rangePartitions(snapshot.From, snapshot.To) {
+		part, ok := parts[partition]
+		if !ok {
+			part = &models.ClientAggregations{}
+		}
+		written, err := r.replaceDocumentBuckets(ctx, snapshot, partition, part)
+		if err != nil {
+			return replaced, err
+		}
+		if written {
+			replaced++
+		}
+	}
+
+	written, err := r.replaceDocumentBuckets(ctx, snapshot, models.RollupPartition{}, &models.ClientAggregations{})
+	if written {
+		replaced++
+	}
+	return replaced, err
+}
+
+// replaceDocumentBuckets replaces the buckets of the range in the document of a partition,
+// the legacy document for the zero partition, with a compare-and-set on its version in the
+// snapshot; written reports whether it was updated. A partition missing from the snapshot
+// is created unless a rollup created it meanwhile, and a missing legacy document is left
+// missing.
+func (r *aggregationRepository) replaceDocumentBuckets(ctx context.Context, snapshot *AggregationSnapshot, partition models.RollupPartition, replacement *models.ClientAggregations) (bool, error) {
+	legacy := partition == models.RollupPartition{}
+	document, found := snapshot.documents[partition]
+	if !found && legacy {
+		return false, nil
+	}
+	existing := document.aggregations
+	if existing == nil {
+		existing = &models.ClientAggregations{}
+	}
+	update := buildReplaceUpdate(existing, snapshot.From, snapshot.To, replacement)
+	if update == nil {
+		return false, nil
+	}
+	update["$inc"] = bson.M{"version": 1}
+
+	filter := mongoPartitionFilter(snapshot.DeviceID, partition)
+	if legacy {
+		filter = mongoLegacyFilter(snapshot.DeviceID)
+	}
+	filter["version"] = document.version
+	result, err := r.aggregations.UpdateOne(ctx, filter, update, options.Update().SetUpsert(!found))
+	if mongo.IsDuplicateKeyError(err) || err == nil && result.MatchedCount == 0 && result.UpsertedCount == 0 {
+		return false, fmt.Errorf("device %s: %w", snapshot.DeviceID, ErrAggregationsChanged)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// buildReplaceUpdate returns the update replacing the buckets of the range of the existing
+// aggregations, nil when there is nothing to change
+func buildReplaceUpdate(existing *models.ClientAggregations, from, to time.Time, replacement *models.ClientAggregations) bson.M {
+	set := bson.M{}
+	for channel, variables := range replacement.Aggregations {
+		for variable, periods := range variables {
+			for period, buckets := range periods {
+				for key, bucket := range buckets {
+					set[rollupPath(channel, variable, period, key)] = rollupBucketDocument(bucket)
+				}
+			}
+		}
+	}
+	unset := bson.M{}
+	for _, path := range existing.BucketPaths(from, to) {
+		if !models.IsRollupKey(path[0]) || !models.IsRollupKey(path[1]) {
+			// Keys that cannot be addressed with a dotted path are left in place
+			continue
+		}
+		field := rollupPath(path...)
+		if _, ok := set[field]; !ok {
+			unset[field] = ""
+		}
+	}
+
+	if len(set) == 0 && len(unset) == 0 {
+		return nil
+	}
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update
+}
+
+// rollupBucketDocument returns the stored form of a bucket, without the fields that are
+// implied by its position in the aggregations document
+func rollupBucketDocument(bucket *models.AggregatedData) bson.M {
+	doc := bson.M{
+		"sum":   bucket.Sum,
+		"count": bucket.Count,
+		"min":   bucket.Min,
+		"max":   bucket.Max,
+		"avg":   bucket.Avg,
+	}
+	if bucket.SumSquares != 0 {
+		doc["sum_sq"] = bucket.SumSquares
+	}
+	if bucket.FirstAt != nil {
+		doc["first"], doc["first_at"] = bucket.First, *bucket.FirstAt
+	}
+	if bucket.LastAt != nil {
+		doc["last"], doc["last_at"] = bucket.Last, *bucket.LastAt
+	}
+	if len(bucket.Sketch) > 0 {
+		doc["sketch"] = bucket.Sketch
+	}
+	return doc
+}
+
+// rollupPath returns the dotted path of a bucket in an aggregations document
+func rollupPath(levels ...string) string {
+	return "aggregations." + strings.Join(levels, ".")
+}
+
+// findMongoAggregations reads and merges the aggregations documents of a device that can
+// hold the buckets selected by the filter: the partitions of the selected periods and time
+// range, and the legacy document. When the filter names channels, variables or periods
+// only those nested map entries are projected.
+func findMongoAggregations(ctx context.Context, collection *mongo.Collection, deviceID string, filter models.AggregationFilter) (*models.ClientAggregations, error) {
+	documents := bson.A{bson.M{"period": bson.M{"$exists": false}}}
+	for _, r := range selectedPartitions(filter) {
+		document := bson.M{"period": string(r.period)}
+		keys := bson.M{}
+		if r.from != "" {
+			keys["$gte"] = r.from
+		}
+		if r.to != "" {
+			keys["$lte"] = r.to
+		}
+		if len(keys) > 0 {
+			document["partition"] = keys
+		}
+		documents = append(documents, document)
+	}
+
+	opts := options.Find()
+	if paths := aggregationProjectionPaths(filter); paths != nil {
+		projection := bson.M{"client_id": 1}
+		for _, path := range paths {
+			projection[strings.Join(path, ".")] = 1
+		}
+		opts.SetProjection(projection)
+	}
+	cursor, err := collection.Find(ctx, bson.M{"client_id": deviceID, "$or": documents}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var merged *models.ClientAggregations
+	for cursor.Next(ctx) {
+		var agg models.ClientAggregations
+		if err := cursor.Decode(&agg); err != nil {
+			return nil, err
+		}
+		if merged == nil {
+			merged = &agg
+		} else {
+			merged.Merge(&agg)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	if merged == nil {
+		// The documents of the device may all be outside the selection
+		count, err := collection.CountDocuments(ctx, bson.M{"client_id": deviceID}, options.Count().SetLimit(1))
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, ErrAggregationsNotFound
+		}
+		merged = &models.ClientAggregations{ClientID: deviceID}
+	}
+	return merged, nil
+}
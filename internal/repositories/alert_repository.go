@@ -0,0 +1,48 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+// AlertRepository stores the alert rules and the alerts they raise
+type AlertRepository interface {
+	// CreateRule stores a new rule under its ID
+	CreateRule(ctx context.Context, rule *models.AlertRule) error
+	FindRule(ctx context.Context, id string) (*models.AlertRule, error)
+	// ListRules returns the rules of a project, or of every project when projectID is empty,
+	// ordered by creation time
+	ListRules(ctx context.Context, projectID string) ([]*models.AlertRule, error)
+	// UpdateRule replaces a stored rule
+	UpdateRule(ctx context.Context, rule *models.AlertRule) error
+	DeleteRule(ctx context.Context, id string) error
+
+	// CreateAlert stores a new alert under its ID
+	CreateAlert(ctx context.Context, alert *models.Alert) error
+	FindAlert(ctx context.Context, id string) (*models.Alert, error)
+	// FindActiveAlert returns the open or acknowledged alert of a rule for a device
+	FindActiveAlert(ctx context.Context, ruleID, deviceID string) (*models.Alert, error)
+	// UpdateAlert replaces a stored alert
+	UpdateAlert(ctx context.Context, alert *models.Alert) error
+	// ListAlerts returns a page of the alerts selected by the filter, most recently opened
+	// first, with the total number of selected alerts
+	ListAlerts(ctx context.Context, filter models.AlertFilter, skip, limit int) ([]*models.Alert, int, error)
+}
+
+var (
+	// ErrAlertRuleNotFound is returned by every AlertRepository implementation for unknown rules
+	ErrAlertRuleNotFound = errors.New("alert rule not found")
+	// ErrAlertNotFound is returned by every AlertRepository implementation for unknown alerts
+	ErrAlertNotFound = errors.New("alert not found")
+)
+
+// Collections of the alert repositories
+const (
+	alertRuleCollection = "alert_rules"
+	alertCollection     = "alerts"
+)
+
+// activeAlertStates lists the states of the alerts FindActiveAlert returns
+var activeAlertStates = []models.AlertState{models.AlertStateOpen, models.AlertStateAcknowledged}
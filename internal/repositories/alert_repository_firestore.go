@@ -0,0 +1,218 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"sit-iot-message-mng-api/internal/models"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type firestoreAlertRepository struct {
+	client *firestore.Client
+}
+
+// NewFirestoreAlertRepository creates an alert repository on Firestore. Listing filtered
+// alerts needs composite indexes on the filtered fields and openedAt.
+func NewFirestoreAlertRepository(client *firestore.Client) AlertRepository {
+	return &firestoreAlertRepository{client: client}
+}
+
+func (r *firestoreAlertRepository) CreateRule(ctx context.Context, rule *models.AlertRule) error {
+	if rule.ID == "" {
+		return errors.New("alert rule ID is required")
+	}
+	_, err := r.client.Collection(alertRuleCollection).Doc(rule.ID).Create(ctx, rule)
+	return err
+}
+
+func (r *firestoreAlertRepository) FindRule(ctx context.Context, id string) (*models.AlertRule, error) {
+	if id == "" {
+		return nil, ErrAlertRuleNotFound
+	}
+	doc, err := r.client.Collection(alertRuleCollection).Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrAlertRuleNotFound
+		}
+		return nil, err
+	}
+	return decodeAlertRule(doc)
+}
+
+func (r *firestoreAlertRepository) ListRules(ctx context.Context, projectID string) ([]*models.AlertRule, error) {
+	query := r.client.Collection(alertRuleCollection).Query
+	if projectID != "" {
+		query = query.Where("projectId", "==", projectID)
+	}
+	iter := query.OrderBy("createdAt", firestore.Asc).OrderBy(firestore.DocumentID, firestore.Asc).Documents(ctx)
+	defer iter.Stop()
+
+	var rules []*models.AlertRule
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			return rules, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rule, err := decodeAlertRule(doc)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+}
+
+func (r *firestoreAlertRepository) UpdateRule(ctx context.Context, rule *models.AlertRule) error {
+	return r.replace(ctx, r.client.Collection(alertRuleCollection).Doc(rule.ID), rule, ErrAlertRuleNotFound)
+}
+
+func (r *firestoreAlertRepository) DeleteRule(ctx context.Context, id string) error {
+	if id == "" {
+		return ErrAlertRuleNotFound
+	}
+	_, err := r.client.Collection(alertRuleCollection).Doc(id).Delete(ctx, firestore.Exists)
+	if status.Code(err) == codes.NotFound {
+		return ErrAlertRuleNotFound
+	}
+	return err
+}
+
+func (r *firestoreAlertRepository) CreateAlert(ctx context.Context, alert *models.Alert) error {
+	if alert.ID == "" {
+		return errors.New("alert ID is required")
+	}
+	_, err := r.client.Collection(alertCollection).Doc(alert.ID).Create(ctx, alert)
+	return err
+}
+
+func (r *firestoreAlertRepository) FindAlert(ctx context.Context, id string) (*models.Alert, error) {
+	if id == "" {
+		return nil, ErrAlertNotFound
+	}
+	doc, err := r.client.Collection(alertCollection).Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrAlertNotFound
+		}
+		return nil, err
+	}
+	return decodeAlert(doc)
+}
+
+func (r *firestoreAlertRepository) FindActiveAlert(ctx context.Context, ruleID, deviceID string) (*models.Alert, error) {
+	query := r.alertQuery(models.AlertFilter{RuleID: ruleID, DeviceID: deviceID, States: activeAlertStates})
+	iter := query.Limit(1).Documents(ctx)
+	defer iter.Stop()
+
+	doc, err := iter.Next()
+	if errors.Is(err, iterator.Done) {
+		return nil, ErrAlertNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeAlert(doc)
+}
+
+func (r *firestoreAlertRepository) UpdateAlert(ctx context.Context, alert *models.Alert) error {
+	return r.replace(ctx, r.client.Collection(alertCollection).Doc(alert.ID), alert, ErrAlertNotFound)
+}
+
+func (r *firestoreAlertRepository) ListAlerts(ctx context.Context, filter models.AlertFilter, skip, limit int) ([]*models.Alert, int, error) {
+	query := r.alertQuery(filter)
+	result, err := query.NewAggregationQuery().WithCount("total").Get(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, ok := result["total"].(*firestorepb.Value)
+	if !ok {
+		return nil, 0, errors.New("unexpected count aggregation result")
+	}
+
+	query = query.OrderBy("openedAt", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc).Offset(skip)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+
+	var alerts []*models.Alert
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		alert, err := decodeAlert(doc)
+		if err != nil {
+			return nil, 0, err
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, int(total.GetIntegerValue()), nil
+}
+
+// alertQuery translates an alert filter into a Firestore query
+func (r *firestoreAlertRepository) alertQuery(filter models.AlertFilter) firestore.Query {
+	query := r.client.Collection(alertCollection).Query
+	if filter.ProjectID != "" {
+		query = query.Where("projectId", "==", filter.ProjectID)
+	}
+	if filter.DeviceID != "" {
+		query = query.Where("deviceId", "==", filter.DeviceID)
+	}
+	if filter.RuleID != "" {
+		query = query.Where("ruleId", "==", filter.RuleID)
+	}
+	if len(filter.States) > 0 {
+		states := make([]string, len(filter.States))
+		for i, state := range filter.States {
+			states[i] = string(state)
+		}
+		query = query.Where("state", "in", states)
+	}
+	return query
+}
+
+// replace overwrites an existing document, returning notFound when it does not exist
+func (r *firestoreAlertRepository) replace(ctx context.Context, ref *firestore.DocumentRef, data interface{}, notFound error) error {
+	return r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(ref); err != nil {
+			if status.Code(err) == codes.NotFound {
+				return notFound
+			}
+			return err
+		}
+		return tx.Set(ref, data)
+	})
+}
+
+// decodeAlertRule reads an alert rule document, taking its ID from the document ID
+func decodeAlertRule(doc *firestore.DocumentSnapshot) (*models.AlertRule, error) {
+	var rule models.AlertRule
+	if err := doc.DataTo(&rule); err != nil {
+		return nil, err
+	}
+	rule.ID = doc.Ref.ID
+	return &rule, nil
+}
+
+// decodeAlert reads an alert document, taking its ID from the document ID
+func decodeAlert(doc *firestore.DocumentSnapshot) (*models.Alert, error) {
+	var alert models.Alert
+	if err := doc.DataTo(&alert); err != nil {
+		return nil, err
+	}
+	alert.ID = doc.Ref.ID
+	return &alert, nil
+}
@@ -0,0 +1,158 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+type memoryAlertRepository struct {
+	mu     sync.RWMutex
+	rules  map[string]models.AlertRule
+	alerts map[string]models.Alert
+}
+
+// NewMemoryAlertRepository creates an empty in-memory alert repository
+func NewMemoryAlertRepository() AlertRepository {
+	return &memoryAlertRepository{
+		rules:  make(map[string]models.AlertRule),
+		alerts: make(map[string]models.Alert),
+	}
+}
+
+func (r *memoryAlertRepository) CreateRule(ctx context.Context, rule *models.AlertRule) error {
+	if rule.ID == "" {
+		return errors.New("alert rule ID is required")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.rules[rule.ID]; ok {
+		return errors.New("alert rule already exists: " + rule.ID)
+	}
+	r.rules[rule.ID] = *rule
+	return nil
+}
+
+func (r *memoryAlertRepository) FindRule(ctx context.Context, id string) (*models.AlertRule, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rule, ok := r.rules[id]
+	if !ok {
+		return nil, ErrAlertRuleNotFound
+	}
+	return &rule, nil
+}
+
+func (r *memoryAlertRepository) ListRules(ctx context.Context, projectID string) ([]*models.AlertRule, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var rules []*models.AlertRule
+	for _, rule := range r.rules {
+		if projectID == "" || rule.ProjectID == projectID {
+			rule := rule
+			rules = append(rules, &rule)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if !rules[i].CreatedAt.Equal(rules[j].CreatedAt) {
+			return rules[i].CreatedAt.Before(rules[j].CreatedAt)
+		}
+		return rules[i].ID < rules[j].ID
+	})
+	return rules, nil
+}
+
+func (r *memoryAlertRepository) UpdateRule(ctx context.Context, rule *models.AlertRule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.rules[rule.ID]; !ok {
+		return ErrAlertRuleNotFound
+	}
+	r.rules[rule.ID] = *rule
+	return nil
+}
+
+func (r *memoryAlertRepository) DeleteRule(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.rules[id]; !ok {
+		return ErrAlertRuleNotFound
+	}
+	delete(r.rules, id)
+	return nil
+}
+
+func (r *memoryAlertRepository) CreateAlert(ctx context.Context, alert *models.Alert) error {
+	if alert.ID == "" {
+		return errors.New("alert ID is required")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.alerts[alert.ID]; ok {
+		return errors.New("alert already exists: " + alert.ID)
+	}
+	r.alerts[alert.ID] = *alert
+	return nil
+}
+
+func (r *memoryAlertRepository) FindAlert(ctx context.Context, id string) (*models.Alert, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	alert, ok := r.alerts[id]
+	if !ok {
+		return nil, ErrAlertNotFound
+	}
+	return &alert, nil
+}
+
+func (r *memoryAlertRepository) FindActiveAlert(ctx context.Context, ruleID, deviceID string) (*models.Alert, error) {
+	alerts, _, err := r.ListAlerts(ctx, models.AlertFilter{RuleID: ruleID, DeviceID: deviceID, States: activeAlertStates}, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(alerts) == 0 {
+		return nil, ErrAlertNotFound
+	}
+	return alerts[0], nil
+}
+
+func (r *memoryAlertRepository) UpdateAlert(ctx context.Context, alert *models.Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.alerts[alert.ID]; !ok {
+		return ErrAlertNotFound
+	}
+	r.alerts[alert.ID] = *alert
+	return nil
+}
+
+func (r *memoryAlertRepository) ListAlerts(ctx context.Context, filter models.AlertFilter, skip, limit int) ([]*models.Alert, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var alerts []*models.Alert
+	for _, alert := range r.alerts {
+		if filter.Matches(&alert) {
+			alert := alert
+			alerts = append(alerts, &alert)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if !alerts[i].OpenedAt.Equal(alerts[j].OpenedAt) {
+			return alerts[i].OpenedAt.After(alerts[j].OpenedAt)
+		}
+		return alerts[i].ID > alerts[j].ID
+	})
+
+	total := len(alerts)
+	if skip >= total {
+		return nil, total, nil
+	}
+	alerts = alerts[skip:]
+	if limit > 0 && len(alerts) > limit {
+		alerts = alerts[:limit]
+	}
+	return alerts, total, nil
+}
@@ -0,0 +1,162 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"sit-iot-message-mng-api/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type alertRepository struct {
+	rules  *mongo.Collection
+	alerts *mongo.Collection
+}
+
+func NewAlertRepository(db *mongo.Database) AlertRepository {
+	return &alertRepository{
+		rules:  db.Collection(alertRuleCollection),
+		alerts: db.Collection(alertCollection),
+	}
+}
+
+func (r *alertRepository) CreateRule(ctx context.Context, rule *models.AlertRule) error {
+	if rule.ID == "" {
+		return errors.New("alert rule ID is required")
+	}
+	_, err := r.rules.InsertOne(ctx, rule)
+	return err
+}
+
+func (r *alertRepository) FindRule(ctx context.Context, id string) (*models.AlertRule, error) {
+	var rule models.AlertRule
+	if err := r.rules.FindOne(ctx, bson.M{"_id": id}).Decode(&rule); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrAlertRuleNotFound
+		}
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (r *alertRepository) ListRules(ctx context.Context, projectID string) ([]*models.AlertRule, error) {
+	filter := bson.M{}
+	if projectID != "" {
+		filter["projectId"] = projectID
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.rules.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*models.AlertRule
+	if err := cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (r *alertRepository) UpdateRule(ctx context.Context, rule *models.AlertRule) error {
+	result, err := r.rules.ReplaceOne(ctx, bson.M{"_id": rule.ID}, rule)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrAlertRuleNotFound
+	}
+	return nil
+}
+
+func (r *alertRepository) DeleteRule(ctx context.Context, id string) error {
+	result, err := r.rules.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrAlertRuleNotFound
+	}
+	return nil
+}
+
+func (r *alertRepository) CreateAlert(ctx context.Context, alert *models.Alert) error {
+	if alert.ID == "" {
+		return errors.New("alert ID is required")
+	}
+	_, err := r.alerts.InsertOne(ctx, alert)
+	return err
+}
+
+func (r *alertRepository) FindAlert(ctx context.Context, id string) (*models.Alert, error) {
+	return r.findAlert(ctx, bson.M{"_id": id})
+}
+
+func (r *alertRepository) FindActiveAlert(ctx context.Context, ruleID, deviceID string) (*models.Alert, error) {
+	return r.findAlert(ctx, alertQuery(models.AlertFilter{RuleID: ruleID, DeviceID: deviceID, States: activeAlertStates}))
+}
+
+func (r *alertRepository) findAlert(ctx context.Context, filter bson.M) (*models.Alert, error) {
+	var alert models.Alert
+	if err := r.alerts.FindOne(ctx, filter).Decode(&alert); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrAlertNotFound
+		}
+		return nil, err
+	}
+	return &alert, nil
+}
+
+func (r *alertRepository) UpdateAlert(ctx context.Context, alert *models.Alert) error {
+	result, err := r.alerts.ReplaceOne(ctx, bson.M{"_id": alert.ID}, alert)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrAlertNotFound
+	}
+	return nil
+}
+
+func (r *alertRepository) ListAlerts(ctx context.Context, filter models.AlertFilter, skip, limit int) ([]*models.Alert, int, error) {
+	query := alertQuery(filter)
+	total, err := r.alerts.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "openedAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(skip))
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := r.alerts.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	var alerts []*models.Alert
+	if err := cursor.All(ctx, &alerts); err != nil {
+		return nil, 0, err
+	}
+	return alerts, int(total), nil
+}
+
+// alertQuery translates an alert filter into a MongoDB query
+func alertQuery(filter models.AlertFilter) bson.M {
+	query := bson.M{}
+	if filter.ProjectID != "" {
+		query["projectId"] = filter.ProjectID
+	}
+	if filter.DeviceID != "" {
+		query["deviceId"] = filter.DeviceID
+	}
+	if filter.RuleID != "" {
+		query["ruleId"] = filter.RuleID
+	}
+	if len(filter.States) > 0 {
+		query["state"] = bson.M{"$in": filter.States}
+	}
+	return query
+}
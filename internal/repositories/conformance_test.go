@@ -0,0 +1,340 @@
+package repositories_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+	"sit-iot-message-mng-api/internal/repositories/repositorytest"
+
+	"cloud.google.com/go/firestore"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMemoryMessageRepositoryConformance(t *testing.T) {
+	newRepo := func(t *testing.T, messages []*models.Message, aggregations []*models.ClientAggregations) repositories.MessageRepository {
+		return repositories.NewMemoryMessageRepository(messages, aggregations)
+	}
+	repositorytest.RunMessageRepositoryConformance(t, newRepo)
+	repositorytest.RunMessageRepositoryWriteConformance(t, newRepo)
+	repositorytest.RunDerivedMessageConformance(t, newRepo)
+}
+
+func TestMemoryAggregationRepositoryConformance(t *testing.T) {
+	repositorytest.RunAggregationRepositoryConformance(t, func(t *testing.T, legacy []*models.ClientAggregations) (repositories.AggregationRepository, repositories.MessageRepository) {
+		repo := repositories.NewMemoryMessageRepository(nil, legacy)
+		return repo.(repositories.AggregationRepository), repo
+	})
+}
+
+func TestMongoAggregationRepositoryConformance(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+
+	repositorytest.RunAggregationRepositoryConformance(t, func(t *testing.T, legacy []*models.ClientAggregations) (repositories.AggregationRepository, repositories.MessageRepository) {
+		ctx := context.Background()
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+		if err != nil {
+			t.Fatalf("failed to connect to MongoDB: %v", err)
+		}
+
+		db := client.Database(fmt.Sprintf("conformance_%d", time.Now().UnixNano()))
+		t.Cleanup(func() {
+			db.Drop(ctx)
+			client.Disconnect(ctx)
+		})
+		for _, agg := range legacy {
+			if _, err := db.Collection("aggregations").InsertOne(ctx, agg); err != nil {
+				t.Fatalf("failed to seed aggregations: %v", err)
+			}
+		}
+		aggRepo, err := repositories.NewAggregationRepository(db)
+		if err != nil {
+			t.Fatalf("NewAggregationRepository() unexpected error: %v", err)
+		}
+		return aggRepo, repositories.NewMessageRepository(db)
+	})
+}
+
+func TestFirestoreAggregationRepositoryConformance(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST not set")
+	}
+
+	repositorytest.RunAggregationRepositoryConformance(t, func(t *testing.T, legacy []*models.ClientAggregations) (repositories.AggregationRepository, repositories.MessageRepository) {
+		ctx := context.Background()
+		client, err := firestore.NewClient(ctx, fmt.Sprintf("conformance-%d", time.Now().UnixNano()))
+		if err != nil {
+			t.Fatalf("failed to create Firestore client: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		for _, agg := range legacy {
+			if _, err := client.Collection("aggregations").Doc(agg.ClientID).Set(ctx, agg); err != nil {
+				t.Fatalf("failed to seed aggregations: %v", err)
+			}
+		}
+		return repositories.NewFirestoreAggregationRepository(client), repositories.NewFirestoreMessageRepository(client)
+	})
+}
+
+// TestMongoMessageRepositoryConformance runs against the MongoDB server at MONGO_TEST_URI, e.g.
+// MONGO_TEST_URI=mongodb://localhost:27017 go test ./internal/repositories/...
+func TestMongoMessageRepositoryConformance(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+
+	newRepo := func(t *testing.T, messages []*models.Message, aggregations []*models.ClientAggregations) repositories.MessageRepository {
+		ctx := context.Background()
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+		if err != nil {
+			t.Fatalf("failed to connect to MongoDB: %v", err)
+		}
+
+		db := client.Database(fmt.Sprintf("conformance_%d", time.Now().UnixNano()))
+		t.Cleanup(func() {
+			db.Drop(ctx)
+			client.Disconnect(ctx)
+		})
+
+		for _, message := range messages {
+			stored := *message
+			objectID, err := primitive.ObjectIDFromHex(message.GetIDAsString())
+			if err != nil {
+				t.Fatalf("fixture ID is not an ObjectID: %v", err)
+			}
+			stored.SetIDFromObjectID(objectID)
+			if _, err := db.Collection("messages").InsertOne(ctx, &stored); err != nil {
+				t.Fatalf("failed to seed message: %v", err)
+			}
+		}
+		for _, agg := range aggregations {
+			if _, err := db.Collection("aggregations").InsertOne(ctx, agg); err != nil {
+				t.Fatalf("failed to seed aggregations: %v", err)
+			}
+		}
+
+		return repositories.NewMessageRepository(db)
+	}
+	repositorytest.RunMessageRepositoryConformance(t, newRepo)
+	repositorytest.RunMessageRepositoryWriteConformance(t, newRepo)
+	repositorytest.RunDerivedMessageConformance(t, newRepo)
+}
+
+// TestFirestoreMessageRepositoryConformance runs against the Firestore emulator, e.g.
+// FIRESTORE_EMULATOR_HOST=localhost:8081 go test ./internal/repositories/...
+func TestFirestoreMessageRepositoryConformance(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST not set")
+	}
+
+	newRepo := func(t *testing.T, messages []*models.Message, aggregations []*models.ClientAggregations) repositories.MessageRepository {
+		ctx := context.Background()
+		client, err := firestore.NewClient(ctx, fmt.Sprintf("conformance-%d", time.Now().UnixNano()))
+		if err != nil {
+			t.Fatalf("failed to create Firestore client: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+
+		for _, message := range messages {
+			if _, err := client.Collection("messages").Doc(message.GetIDAsString()).Set(ctx, message); err != nil {
+				t.Fatalf("failed to seed message: %v", err)
+			}
+		}
+		for _, agg := range aggregations {
+			if _, err := client.Collection("aggregations").Doc(agg.ClientID).Set(ctx, agg); err != nil {
+				t.Fatalf("failed to seed aggregations: %v", err)
+			}
+		}
+
+		return repositories.NewFirestoreMessageRepository(client)
+	}
+	repositorytest.RunMessageRepositoryConformance(t, newRepo)
+	repositorytest.RunMessageRepositoryWriteConformance(t, newRepo)
+	repositorytest.RunDerivedMessageConformance(t, newRepo)
+}
+
+func TestMemoryWebhookRepositoryConformance(t *testing.T) {
+	repositorytest.RunWebhookRepositoryConformance(t, func(t *testing.T) repositories.WebhookRepository {
+		return repositories.NewMemoryWebhookRepository()
+	})
+}
+
+func TestMongoWebhookRepositoryConformance(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+
+	repositorytest.RunWebhookRepositoryConformance(t, func(t *testing.T) repositories.WebhookRepository {
+		ctx := context.Background()
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+		if err != nil {
+			t.Fatalf("failed to connect to MongoDB: %v", err)
+		}
+
+		db := client.Database(fmt.Sprintf("conformance_%d", time.Now().UnixNano()))
+		t.Cleanup(func() {
+			db.Drop(ctx)
+			client.Disconnect(ctx)
+		})
+		return repositories.NewWebhookRepository(db)
+	})
+}
+
+func TestFirestoreWebhookRepositoryConformance(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST not set")
+	}
+
+	repositorytest.RunWebhookRepositoryConformance(t, func(t *testing.T) repositories.WebhookRepository {
+		client, err := firestore.NewClient(context.Background(), fmt.Sprintf("conformance-%d", time.Now().UnixNano()))
+		if err != nil {
+			t.Fatalf("failed to create Firestore client: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return repositories.NewFirestoreWebhookRepository(client)
+	})
+}
+
+func TestMemoryAlertRepositoryConformance(t *testing.T) {
+	repositorytest.RunAlertRepositoryConformance(t, func(t *testing.T) repositories.AlertRepository {
+		return repositories.NewMemoryAlertRepository()
+	})
+}
+
+func TestMongoAlertRepositoryConformance(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+
+	repositorytest.RunAlertRepositoryConformance(t, func(t *testing.T) repositories.AlertRepository {
+		ctx := context.Background()
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+		if err != nil {
+			t.Fatalf("failed to connect to MongoDB: %v", err)
+		}
+
+		db := client.Database(fmt.Sprintf("conformance_%d", time.Now().UnixNano()))
+		t.Cleanup(func() {
+			db.Drop(ctx)
+			client.Disconnect(ctx)
+		})
+		return repositories.NewAlertRepository(db)
+	})
+}
+
+func TestFirestoreAlertRepositoryConformance(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST not set")
+	}
+
+	repositorytest.RunAlertRepositoryConformance(t, func(t *testing.T) repositories.AlertRepository {
+		client, err := firestore.NewClient(context.Background(), fmt.Sprintf("conformance-%d", time.Now().UnixNano()))
+		if err != nil {
+			t.Fatalf("failed to create Firestore client: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return repositories.NewFirestoreAlertRepository(client)
+	})
+}
+
+func TestMemoryPresenceRepositoryConformance(t *testing.T) {
+	repositorytest.RunPresenceRepositoryConformance(t, func(t *testing.T) repositories.PresenceRepository {
+		return repositories.NewMemoryPresenceRepository()
+	})
+}
+
+func TestMongoPresenceRepositoryConformance(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+
+	repositorytest.RunPresenceRepositoryConformance(t, func(t *testing.T) repositories.PresenceRepository {
+		ctx := context.Background()
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+		if err != nil {
+			t.Fatalf("failed to connect to MongoDB: %v", err)
+		}
+
+		db := client.Database(fmt.Sprintf("conformance_%d", time.Now().UnixNano()))
+		t.Cleanup(func() {
+			db.Drop(ctx)
+			client.Disconnect(ctx)
+		})
+		return repositories.NewPresenceRepository(db)
+	})
+}
+
+func TestFirestorePresenceRepositoryConformance(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST not set")
+	}
+
+	repositorytest.RunPresenceRepositoryConformance(t, func(t *testing.T) repositories.PresenceRepository {
+		client, err := firestore.NewClient(context.Background(), fmt.Sprintf("conformance-%d", time.Now().UnixNano()))
+		if err != nil {
+			t.Fatalf("failed to create Firestore client: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return repositories.NewFirestorePresenceRepository(client)
+	})
+}
+
+func TestMemoryDeviceRepositoryConformance(t *testing.T) {
+	repositorytest.RunDeviceRepositoryConformance(t, func(t *testing.T) repositories.DeviceRepository {
+		return repositories.NewMemoryDeviceRepository()
+	})
+}
+
+func TestMongoDeviceRepositoryConformance(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+
+	repositorytest.RunDeviceRepositoryConformance(t, func(t *testing.T) repositories.DeviceRepository {
+		ctx := context.Background()
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+		if err != nil {
+			t.Fatalf("failed to connect to MongoDB: %v", err)
+		}
+
+		db := client.Database(fmt.Sprintf("conformance_%d", time.Now().UnixNano()))
+		t.Cleanup(func() {
+			db.Drop(ctx)
+			client.Disconnect(ctx)
+		})
+		repo, err := repositories.NewDeviceRepository(db)
+		if err != nil {
+			t.Fatalf("NewDeviceRepository() unexpected error: %v", err)
+		}
+		return repo
+	})
+}
+
+func TestFirestoreDeviceRepositoryConformance(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST not set")
+	}
+
+	repositorytest.RunDeviceRepositoryConformance(t, func(t *testing.T) repositories.DeviceRepository {
+		client, err := firestore.NewClient(context.Background(), fmt.Sprintf("conformance-%d", time.Now().UnixNano()))
+		if err != nil {
+			t.Fatalf("failed to create Firestore client: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return repositories.NewFirestoreDeviceRepository(client)
+	})
+}
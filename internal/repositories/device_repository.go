@@ -0,0 +1,48 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+// DeviceRepository stores the device registry. A client ID is registered to one device at
+// most, so that messages can be resolved to the project of their device.
+type DeviceRepository interface {
+	// Create stores a new device under its ID
+	Create(ctx context.Context, device *models.Device) error
+	FindByID(ctx context.Context, id string) (*models.Device, error)
+	// FindByClientID returns the device registered with an MQTT client ID
+	FindByClientID(ctx context.Context, clientID string) (*models.Device, error)
+	// List returns a page of the devices selected by the filter sorted on a stored field
+	// (_id, name, type, status, clientId, lastSeen, createdAt or updatedAt), ties broken by
+	// ID, with the total number of selected devices
+	List(ctx context.Context, filter models.DeviceFilter, sortField, sortOrder string, skip, limit int) ([]*models.Device, int, error)
+	// Update sets the fields of the update on a stored device, leaving the others, such as
+	// the presence set concurrently by SetPresence, unchanged, and returns the updated device
+	Update(ctx context.Context, id string, update models.DeviceUpdate) (*models.Device, error)
+	// SetPresence sets the status and LastSeen of the device registered with a client ID,
+	// leaving its other fields unchanged
+	SetPresence(ctx context.Context, clientID, status string, lastSeen time.Time) error
+	Delete(ctx context.Context, id string) error
+}
+
+var (
+	// ErrDeviceNotFound is returned by every DeviceRepository implementation for unknown or
+	// malformed device IDs
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrDeviceClientIDTaken is returned when creating or updating a device would register a
+	// client ID already registered to another device
+	ErrDeviceClientIDTaken = errors.New("device client ID already registered")
+)
+
+// deviceCollection is the collection of the device repositories
+const deviceCollection = "devices"
+
+// deviceSortFields lists the stored fields the devices can be sorted on
+var deviceSortFields = map[string]bool{
+	"_id": true, "name": true, "type": true, "status": true, "clientId": true,
+	"lastSeen": true, "createdAt": true, "updatedAt": true,
+}
@@ -0,0 +1,230 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+
+	"cloud.google.com/go/firestore"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type firestoreDeviceRepository struct {
+	client *firestore.Client
+}
+
+// NewFirestoreDeviceRepository creates a device repository on Firestore, storing each device
+// under its hex ID. Writes check the client ID in a transaction. Listing filtered devices
+// sorted on another field needs composite indexes on the filtered fields and the sort field.
+func NewFirestoreDeviceRepository(client *firestore.Client) DeviceRepository {
+	return &firestoreDeviceRepository{client: client}
+}
+
+func (r *firestoreDeviceRepository) Create(ctx context.Context, device *models.Device) error {
+	if device.ID.IsZero() {
+		return errors.New("device ID is required")
+	}
+	ref := r.client.Collection(deviceCollection).Doc(device.ID.Hex())
+	return r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if err := r.checkClientID(tx, device); err != nil {
+			return err
+		}
+		return tx.Create(ref, device)
+	})
+}
+
+func (r *firestoreDeviceRepository) FindByID(ctx context.Context, id string) (*models.Device, error) {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return nil, ErrDeviceNotFound
+	}
+	doc, err := r.client.Collection(deviceCollection).Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrDeviceNotFound
+		}
+		return nil, err
+	}
+	return decodeDevice(doc)
+}
+
+func (r *firestoreDeviceRepository) FindByClientID(ctx context.Context, clientID string) (*models.Device, error) {
+	iter := r.client.Collection(deviceCollection).Where("clientId", "==", clientID).Limit(1).Documents(ctx)
+	defer iter.Stop()
+
+	doc, err := iter.Next()
+	if errors.Is(err, iterator.Done) {
+		return nil, ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeDevice(doc)
+}
+
+func (r *firestoreDeviceRepository) List(ctx context.Context, filter models.DeviceFilter, sortField, sortOrder string, skip, limit int) ([]*models.Device, int, error) {
+	if !deviceSortFields[sortField] {
+		return nil, 0, errors.New("unsupported sort field: " + sortField)
+	}
+	query := r.deviceQuery(filter)
+	total, err := countQuery(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	direction := firestore.Desc
+	if sortOrder == "ASC" {
+		direction = firestore.Asc
+	}
+	if sortField != "_id" {
+		query = query.OrderBy(sortField, direction)
+	}
+	query = query.OrderBy(firestore.DocumentID, direction).Offset(skip)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+
+	var devices []*models.Device
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			return devices, total, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		device, err := decodeDevice(doc)
+		if err != nil {
+			return nil, 0, err
+		}
+		devices = append(devices, device)
+	}
+}
+
+func (r *firestoreDeviceRepository) Update(ctx context.Context, id string, update models.DeviceUpdate) (*models.Device, error) {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return nil, ErrDeviceNotFound
+	}
+
+	updates := []firestore.Update{{Path: "updatedAt", Value: update.UpdatedAt}}
+	if update.Name != nil {
+		updates = append(updates, firestore.Update{Path: "name", Value: *update.Name})
+	}
+	if update.Type != nil {
+		updates = append(updates, firestore.Update{Path: "type", Value: *update.Type})
+	}
+	if update.Status != nil {
+		updates = append(updates, firestore.Update{Path: "status", Value: *update.Status})
+	}
+	if update.ClientID != nil {
+		updates = append(updates, firestore.Update{Path: "clientId", Value: *update.ClientID})
+	}
+	if update.Metadata != nil {
+		updates = append(updates, firestore.Update{Path: "metadata", Value: update.Metadata})
+	}
+
+	ref := r.client.Collection(deviceCollection).Doc(id)
+	var device *models.Device
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return ErrDeviceNotFound
+			}
+			return err
+		}
+		if device, err = decodeDevice(doc); err != nil {
+			return err
+		}
+		update.Apply(device)
+		if update.ClientID != nil {
+			if err := r.checkClientID(tx, device); err != nil {
+				return err
+			}
+		}
+		return tx.Update(ref, updates)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+func (r *firestoreDeviceRepository) SetPresence(ctx context.Context, clientID, deviceStatus string, lastSeen time.Time) error {
+	device, err := r.FindByClientID(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.Collection(deviceCollection).Doc(device.ID.Hex()).Update(ctx, []firestore.Update{
+		{Path: "status", Value: deviceStatus},
+		{Path: "lastSeen", Value: lastSeen},
+	})
+	if status.Code(err) == codes.NotFound {
+		return ErrDeviceNotFound
+	}
+	return err
+}
+
+func (r *firestoreDeviceRepository) Delete(ctx context.Context, id string) error {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return ErrDeviceNotFound
+	}
+	_, err := r.client.Collection(deviceCollection).Doc(id).Delete(ctx, firestore.Exists)
+	if status.Code(err) == codes.NotFound {
+		return ErrDeviceNotFound
+	}
+	return err
+}
+
+// checkClientID returns ErrDeviceClientIDTaken when the client ID of a device is registered
+// to another device
+func (r *firestoreDeviceRepository) checkClientID(tx *firestore.Transaction, device *models.Device) error {
+	docs, err := tx.Documents(r.client.Collection(deviceCollection).Where("clientId", "==", device.ClientID).Limit(2)).GetAll()
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if doc.Ref.ID != device.ID.Hex() {
+			return ErrDeviceClientIDTaken
+		}
+	}
+	return nil
+}
+
+// deviceQuery translates a device filter into a Firestore query
+func (r *firestoreDeviceRepository) deviceQuery(filter models.DeviceFilter) firestore.Query {
+	query := r.client.Collection(deviceCollection).Query
+	if filter.ProjectID != "" {
+		query = query.Where("projectId", "==", filter.ProjectID)
+	}
+	if filter.Type != "" {
+		query = query.Where("type", "==", filter.Type)
+	}
+	if filter.Status != "" {
+		query = query.Where("status", "==", filter.Status)
+	}
+	if filter.ClientID != "" {
+		query = query.Where("clientId", "==", filter.ClientID)
+	}
+	return query
+}
+
+// decodeDevice reads a device document, taking its ID from the document ID
+func decodeDevice(doc *firestore.DocumentSnapshot) (*models.Device, error) {
+	var device models.Device
+	if err := doc.DataTo(&device); err != nil {
+		return nil, err
+	}
+	id, err := primitive.ObjectIDFromHex(doc.Ref.ID)
+	if err != nil {
+		return nil, err
+	}
+	device.ID = id
+	return &device, nil
+}
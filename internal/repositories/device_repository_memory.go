@@ -0,0 +1,202 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type memoryDeviceRepository struct {
+	mu      sync.RWMutex
+	devices map[primitive.ObjectID]*models.Device
+}
+
+// NewMemoryDeviceRepository creates an empty in-memory device repository
+func NewMemoryDeviceRepository() DeviceRepository {
+	return &memoryDeviceRepository{
+		devices: make(map[primitive.ObjectID]*models.Device),
+	}
+}
+
+func (r *memoryDeviceRepository) Create(ctx context.Context, device *models.Device) error {
+	if device.ID.IsZero() {
+		return errors.New("device ID is required")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.devices[device.ID]; ok {
+		return errors.New("device already exists: " + device.ID.Hex())
+	}
+	if r.clientIDTaken(device) {
+		return ErrDeviceClientIDTaken
+	}
+	r.devices[device.ID] = copyDevice(device)
+	return nil
+}
+
+func (r *memoryDeviceRepository) FindByID(ctx context.Context, id string) (*models.Device, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrDeviceNotFound
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	device, ok := r.devices[objectID]
+	if !ok {
+		return nil, ErrDeviceNotFound
+	}
+	return copyDevice(device), nil
+}
+
+func (r *memoryDeviceRepository) FindByClientID(ctx context.Context, clientID string) (*models.Device, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, device := range r.devices {
+		if device.ClientID == clientID {
+			return copyDevice(device), nil
+		}
+	}
+	return nil, ErrDeviceNotFound
+}
+
+func (r *memoryDeviceRepository) List(ctx context.Context, filter models.DeviceFilter, sortField, sortOrder string, skip, limit int) ([]*models.Device, int, error) {
+	if !deviceSortFields[sortField] {
+		return nil, 0, errors.New("unsupported sort field: " + sortField)
+	}
+
+	r.mu.RLock()
+	var matched []*models.Device
+	for _, device := range r.devices {
+		if filter.Matches(device) {
+			matched = append(matched, copyDevice(device))
+		}
+	}
+	r.mu.RUnlock()
+
+	ascending := sortOrder == "ASC"
+	sort.Slice(matched, func(i, j int) bool {
+		cmp := memoryCompare(memoryDeviceField(matched[i], sortField), memoryDeviceField(matched[j], sortField))
+		if cmp == 0 {
+			cmp = memoryCompare(matched[i].ID.Hex(), matched[j].ID.Hex())
+		}
+		if ascending {
+			return cmp < 0
+		}
+		return cmp > 0
+	})
+
+	total := len(matched)
+	if skip >= len(matched) {
+		return nil, total, nil
+	}
+	matched = matched[skip:]
+	if limit > 0 && limit < len(matched) {
+		matched = matched[:limit]
+	}
+	return matched, total, nil
+}
+
+func (r *memoryDeviceRepository) Update(ctx context.Context, id string, update models.DeviceUpdate) (*models.Device, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrDeviceNotFound
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.devices[objectID]
+	if !ok {
+		return nil, ErrDeviceNotFound
+	}
+	device := copyDevice(stored)
+	update.Apply(device)
+	if r.clientIDTaken(device) {
+		return nil, ErrDeviceClientIDTaken
+	}
+	r.devices[objectID] = device
+	return copyDevice(device), nil
+}
+
+func (r *memoryDeviceRepository) SetPresence(ctx context.Context, clientID, status string, lastSeen time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, device := range r.devices {
+		if device.ClientID == clientID {
+			device.Status = status
+			device.LastSeen = &lastSeen
+			return nil
+		}
+	}
+	return ErrDeviceNotFound
+}
+
+func (r *memoryDeviceRepository) Delete(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrDeviceNotFound
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.devices[objectID]; !ok {
+		return ErrDeviceNotFound
+	}
+	delete(r.devices, objectID)
+	return nil
+}
+
+// clientIDTaken reports whether the client ID of a device is registered to another device
+func (r *memoryDeviceRepository) clientIDTaken(device *models.Device) bool {
+	for id, existing := range r.devices {
+		if id != device.ID && existing.ClientID == device.ClientID {
+			return true
+		}
+	}
+	return false
+}
+
+// copyDevice returns a copy of a device that shares no map or pointer with it
+func copyDevice(device *models.Device) *models.Device {
+	copied := *device
+	if device.LastSeen != nil {
+		lastSeen := *device.LastSeen
+		copied.LastSeen = &lastSeen
+	}
+	if device.Metadata != nil {
+		copied.Metadata = make(map[string]string, len(device.Metadata))
+		for key, value := range device.Metadata {
+			copied.Metadata[key] = value
+		}
+	}
+	return &copied
+}
+
+// memoryDeviceField returns the value of a device field addressed by its stored name; devices
+// never seen sort before the others like missing fields in the databases
+func memoryDeviceField(device *models.Device, field string) interface{} {
+	switch field {
+	case "name":
+		return device.Name
+	case "type":
+		return device.Type
+	case "status":
+		return device.Status
+	case "clientId":
+		return device.ClientID
+	case "lastSeen":
+		if device.LastSeen == nil {
+			return time.Time{}
+		}
+		return *device.LastSeen
+	case "createdAt":
+		return device.CreatedAt
+	case "updatedAt":
+		return device.UpdatedAt
+	default:
+		return device.ID.Hex()
+	}
+}
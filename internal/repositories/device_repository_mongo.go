@@ -0,0 +1,190 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type deviceRepository struct {
+	collection *mongo.Collection
+}
+
+// NewDeviceRepository creates a device repository on MongoDB, creating the unique index on
+// clientId that rejects the registrations of a client ID already registered
+func NewDeviceRepository(db *mongo.Database) (DeviceRepository, error) {
+	collection := db.Collection(deviceCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "clientId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating the clientId index of the devices: %w", err)
+	}
+	return &deviceRepository{collection: collection}, nil
+}
+
+func (r *deviceRepository) Create(ctx context.Context, device *models.Device) error {
+	if device.ID.IsZero() {
+		return errors.New("device ID is required")
+	}
+	_, err := r.collection.InsertOne(ctx, device)
+	if isClientIDConflict(err) {
+		return ErrDeviceClientIDTaken
+	}
+	return err
+}
+
+func (r *deviceRepository) FindByID(ctx context.Context, id string) (*models.Device, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrDeviceNotFound
+	}
+	return r.find(ctx, bson.M{"_id": objectID})
+}
+
+func (r *deviceRepository) FindByClientID(ctx context.Context, clientID string) (*models.Device, error) {
+	return r.find(ctx, bson.M{"clientId": clientID})
+}
+
+func (r *deviceRepository) find(ctx context.Context, filter bson.M) (*models.Device, error) {
+	var device models.Device
+	if err := r.collection.FindOne(ctx, filter).Decode(&device); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrDeviceNotFound
+		}
+		return nil, err
+	}
+	return &device, nil
+}
+
+func (r *deviceRepository) List(ctx context.Context, filter models.DeviceFilter, sortField, sortOrder string, skip, limit int) ([]*models.Device, int, error) {
+	if !deviceSortFields[sortField] {
+		return nil, 0, errors.New("unsupported sort field: " + sortField)
+	}
+	query := deviceQuery(filter)
+	total, err := r.collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	direction := -1
+	if sortOrder == "ASC" {
+		direction = 1
+	}
+	sort := bson.D{{Key: sortField, Value: direction}}
+	if sortField != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: direction}) // _id keeps pages stable on ties
+	}
+	opts := options.Find().SetSort(sort).SetSkip(int64(skip))
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	var devices []*models.Device
+	if err := cursor.All(ctx, &devices); err != nil {
+		return nil, 0, err
+	}
+	return devices, int(total), nil
+}
+
+func (r *deviceRepository) Update(ctx context.Context, id string, update models.DeviceUpdate) (*models.Device, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrDeviceNotFound
+	}
+
+	set := bson.M{"updatedAt": update.UpdatedAt}
+	if update.Name != nil {
+		set["name"] = *update.Name
+	}
+	if update.Type != nil {
+		set["type"] = *update.Type
+	}
+	if update.Status != nil {
+		set["status"] = *update.Status
+	}
+	if update.ClientID != nil {
+		set["clientId"] = *update.ClientID
+	}
+	if update.Metadata != nil {
+		set["metadata"] = update.Metadata
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var device models.Device
+	err = r.collection.FindOneAndUpdate(ctx, bson.M{"_id": objectID}, bson.M{"$set": set}, opts).Decode(&device)
+	if isClientIDConflict(err) {
+		return nil, ErrDeviceClientIDTaken
+	}
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrDeviceNotFound
+		}
+		return nil, err
+	}
+	return &device, nil
+}
+
+func (r *deviceRepository) SetPresence(ctx context.Context, clientID, status string, lastSeen time.Time) error {
+	result, err := r.collection.UpdateOne(ctx, bson.M{"clientId": clientID}, bson.M{"$set": bson.M{"status": status, "lastSeen": lastSeen}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrDeviceNotFound
+	}
+	return nil
+}
+
+func (r *deviceRepository) Delete(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrDeviceNotFound
+	}
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrDeviceNotFound
+	}
+	return nil
+}
+
+// isClientIDConflict reports whether a write failed on a unique index, which only the client
+// ID may violate since the IDs are generated
+func isClientIDConflict(err error) bool {
+	return err != nil && mongo.IsDuplicateKeyError(err)
+}
+
+// deviceQuery translates a device filter into a MongoDB query
+func deviceQuery(filter models.DeviceFilter) bson.M {
+	query := bson.M{}
+	if filter.ProjectID != "" {
+		query["projectId"] = filter.ProjectID
+	}
+	if filter.Type != "" {
+		query["type"] = filter.Type
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if filter.ClientID != "" {
+		query["clientId"] = filter.ClientID
+	}
+	return query
+}
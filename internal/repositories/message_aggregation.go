@@ -0,0 +1,82 @@
+package repositories
+
+import (
+	"sort"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+// bucketKey identifies one on-demand aggregation bucket
+type bucketKey struct {
+	timestamp time.Time
+	channel   string
+	variable  string
+}
+
+// bucketAggregator computes on-demand aggregations in Go for the backends without an
+// aggregation pipeline. It must give the same results as the MongoDB pipeline.
+type bucketAggregator struct {
+	query   models.AggregationQuery
+	buckets map[bucketKey]*models.AggregatedData
+}
+
+func newBucketAggregator(query models.AggregationQuery) *bucketAggregator {
+	return &bucketAggregator{
+		query:   query,
+		buckets: make(map[bucketKey]*models.AggregatedData),
+	}
+}
+
+// add accumulates the numeric payload fields of a message already selected by the query;
+// derived messages such as alerts are not device data and are skipped
+func (a *bucketAggregator) add(message *models.Message) {
+	if message.IsDerived() {
+		return
+	}
+	timestamp := a.query.Bucket.Truncate(message.Timestamp)
+	channel := message.Channel()
+
+	for variable, value := range models.NumericFields(message.Marshalled, a.query.Fields) {
+		key := bucketKey{timestamp: timestamp, channel: channel, variable: variable}
+		bucket, ok := a.buckets[key]
+		if !ok {
+			bucket = &models.AggregatedData{
+				ClientID:  a.query.DeviceID,
+				Channel:   channel,
+				Variable:  variable,
+				Period:    string(a.query.Bucket),
+				Timestamp: timestamp,
+			}
+			a.buckets[key] = bucket
+		}
+		bucket.Add(value, message.Timestamp)
+	}
+}
+
+// results returns the buckets ordered by timestamp, channel and variable
+func (a *bucketAggregator) results() []*models.AggregatedData {
+	results := make([]*models.AggregatedData, 0, len(a.buckets))
+	for _, bucket := range a.buckets {
+		results = append(results, bucket)
+	}
+	sortAggregatedData(results)
+	return results
+}
+
+// sortAggregatedData orders buckets by timestamp, channel, variable and period
+func sortAggregatedData(results []*models.AggregatedData) {
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		if a.Channel != b.Channel {
+			return a.Channel < b.Channel
+		}
+		if a.Variable != b.Variable {
+			return a.Variable < b.Variable
+		}
+		return a.Period < b.Period
+	})
+}
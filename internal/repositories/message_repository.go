@@ -2,16 +2,182 @@ package repositories
 
 import (
 	"context"
+	"errors"
+	"log"
 	"sit-iot-message-mng-api/internal/models"
+	"strings"
 
 	"time"
 )
 
 type MessageRepository interface {
 	FindByID(ctx context.Context, id string) (*models.Message, error)
-	List(ctx context.Context, filter map[string]interface{}, sortField, sortOrder string, skip, limit int) ([]*models.Message, int, error)
+	List(ctx context.Context, filter models.MessageFilter, sortField, sortOrder string, skip, limit int) ([]*models.Message, int, error)
+	// ListByCursor returns up to limit messages ordered by timestamp and ID that come strictly after
+	// the cursor (nil for the first page), plus the cursor of the next page or nil when there is none.
+	ListByCursor(ctx context.Context, filter models.MessageFilter, after *models.MessageCursor, sortOrder string, limit int) ([]*models.Message, *models.MessageCursor, error)
 	FindByTopic(ctx context.Context, topic string, limit int) ([]*models.Message, error)
 	FindByDeviceID(ctx context.Context, deviceID string, limit int) ([]*models.Message, error)
 	FindByTimeRange(ctx context.Context, from, to time.Time, limit int) ([]*models.Message, error)
-	GetAggregatedDataByDeviceID(ctx context.Context, deviceID string) ([]map[string]interface{}, error)
+	// DistinctDeviceIDs returns the sorted IDs of the devices that sent messages matching the filter
+	DistinctDeviceIDs(ctx context.Context, filter models.MessageFilter) ([]string, error)
+	// GetAggregatedDataByDeviceID returns the precomputed aggregations of a device selected by
+	// the filter, ordered by timestamp, reading only the selected part of the stored document
+	GetAggregatedDataByDeviceID(ctx context.Context, deviceID string, filter models.AggregationFilter) ([]*models.AggregatedData, error)
+	// AggregateByDeviceID computes min/max/avg/sum/count of the numeric payload fields of a
+	// device's messages in time buckets, ordered by bucket timestamp, channel and variable
+	AggregateByDeviceID(ctx context.Context, query models.AggregationQuery) ([]*models.AggregatedData, error)
+	Create(ctx context.Context, message *models.Message) (*models.Message, error)
+	// CreateMany inserts a batch of messages atomically: when one of them cannot be stored,
+	// none of them is
+	CreateMany(ctx context.Context, messages []*models.Message) ([]*models.Message, error)
+	Update(ctx context.Context, id string, update models.MessageUpdate) (*models.Message, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// flattenAggregations flattens the nested aggregation structure into typed buckets,
+// keeping the entries selected by the filter ordered by timestamp, channel and variable.
+// Entries whose timestamp key cannot be parsed are skipped.
+func flattenAggregations(agg *models.ClientAggregations, filter models.AggregationFilter) []*models.AggregatedData {
+	result := []*models.AggregatedData{}
+	for channel, variables := range agg.Aggregations {
+		for variable, periods := range variables {
+			for period, timestamps := range periods {
+				if !filter.MatchesKey(channel, variable, period) {
+					continue
+				}
+				for ts, data := range timestamps {
+					if data == nil {
+						continue
+					}
+					timestamp, ok := models.ParseAggregationTimestamp(ts)
+					if !ok {
+						log.Printf("Skipping aggregation %s/%s/%s of %s with invalid timestamp %q", channel, variable, period, agg.ClientID, ts)
+						continue
+					}
+					if !filter.MatchesTime(timestamp) {
+						continue
+					}
+					row := *data
+					row.ClientID = agg.ClientID
+					row.Channel = channel
+					row.Variable = variable
+					row.Period = period
+					row.Timestamp = timestamp
+					result = append(result, &row)
+				}
+			}
+		}
+	}
+
+	sortAggregatedData(result)
+	return result
+}
+
+// maxAggregationProjectionPaths caps the number of nested map paths read from a stored
+// aggregations document; larger selections read the enclosing level instead
+const maxAggregationProjectionPaths = 100
+
+// aggregationProjectionPaths returns the nested map paths of the aggregations document the
+// filter restricts the read to, or nil when the whole map must be read. Levels are pushed
+// down in order (channel, variable, period) while every value of the level is known and
+// safe to use in a field path.
+func aggregationProjectionPaths(filter models.AggregationFilter) [][]string {
+	paths := [][]string{{"aggregations"}}
+	for _, level := range [][]string{filter.Channels, filter.Variables, filter.Periods} {
+		if len(level) == 0 || len(paths)*len(level) > maxAggregationProjectionPaths {
+			break
+		}
+		safe := true
+		for _, key := range level {
+			if key == "" || strings.Contains(key, ".") || strings.HasPrefix(key, "$") {
+				safe = false
+			}
+		}
+		if !safe {
+			break
+		}
+
+		next := make([][]string, 0, len(paths)*len(level))
+		for _, path := range paths {
+			for _, key := range level {
+				next = append(next, append(append([]string{}, path...), key))
+			}
+		}
+		paths = next
+	}
+
+	if len(paths[0]) == 1 {
+		return nil
+	}
+	return paths
+}
+
+// Errors shared by every MessageRepository implementation so callers can
+// handle them the same way regardless of the configured database provider
+var (
+	ErrInvalidMessageID     = errors.New("invalid message ID format")
+	ErrMessageNotFound      = errors.New("message not found")
+	ErrAggregationsNotFound = errors.New("aggregated data not found")
+)
+
+// ErrUnsupportedFilter is returned when a backend cannot express a filter clause natively
+var ErrUnsupportedFilter = errors.New("filter not supported by the database provider")
+
+// filterField is an equality or "in" criterion on a stored message field
+type filterField struct {
+	name   string
+	values []string
+}
+
+// equalityFields lists the equality and "in" criteria of a filter keyed by
+// their stored field name. A field with no values can never match.
+func equalityFields(filter models.MessageFilter) []filterField {
+	var fields []filterField
+	add := func(name, scalar string, list []string) {
+		if values, ok := models.FieldValues(scalar, list); ok {
+			fields = append(fields, filterField{name: name, values: values})
+		}
+	}
+
+	types := make([]string, 0, len(filter.Types))
+	for _, t := range filter.Types {
+		types = append(types, string(t))
+	}
+	statuses := make([]string, 0, len(filter.Statuses))
+	for _, s := range filter.Statuses {
+		statuses = append(statuses, string(s))
+	}
+
+	add("projectId", filter.ProjectID, filter.ProjectIDs)
+	add("deviceId", filter.DeviceID, filter.DeviceIDs)
+	add("client_id", filter.ClientID, filter.ClientIDs)
+	add("type", string(filter.Type), types)
+	add("status", string(filter.Status), statuses)
+	return fields
+}
+
+// withoutTypes drops the messages of the excluded types, for the queries that cannot
+// exclude them server side
+func withoutTypes(messages []*models.Message, excluded []models.MessageType) []*models.Message {
+	if len(excluded) == 0 {
+		return messages
+	}
+	kept := messages[:0]
+	for _, message := range messages {
+		if (models.MessageFilter{ExcludeTypes: excluded}).Matches(message) {
+			kept = append(kept, message)
+		}
+	}
+	return kept
+}
+
+// nextPage trims a result fetched with limit+1 rows to limit rows and returns
+// the cursor of the next page, or nil when the extra row was not found
+func nextPage(messages []*models.Message, limit int) ([]*models.Message, *models.MessageCursor) {
+	if len(messages) <= limit {
+		return messages, nil
+	}
+	messages = messages[:limit]
+	return messages, models.CursorFromMessage(messages[len(messages)-1])
 }
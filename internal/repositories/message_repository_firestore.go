@@ -3,12 +3,18 @@ package repositories
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"sit-iot-message-mng-api/internal/models"
+	"sort"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type firestoreMessageRepository struct {
@@ -25,13 +31,13 @@ func NewFirestoreMessageRepository(client *firestore.Client) MessageRepository {
 
 func (r *firestoreMessageRepository) FindByID(ctx context.Context, id string) (*models.Message, error) {
 	if id == "" {
-		return nil, errors.New("invalid message ID format")
+		return nil, ErrInvalidMessageID
 	}
 
 	doc, err := r.client.Collection(r.collection).Doc(id).Get(ctx)
 	if err != nil {
-		if doc != nil && !doc.Exists() {
-			return nil, errors.New("message not found")
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrMessageNotFound
 		}
 		return nil, err
 	}
@@ -46,17 +52,17 @@ func (r *firestoreMessageRepository) FindByID(ctx context.Context, id string) (*
 	return &message, nil
 }
 
-func (r *firestoreMessageRepository) List(ctx context.Context, filter map[string]interface{}, sortField, sortOrder string, skip, limit int) ([]*models.Message, int, error) {
-	query := r.client.Collection(r.collection).Query
-
-	// Apply filters
-	for key, value := range filter {
-		if key == "_id" || key == "id" {
-			// For Firestore, we need to use the document ID filter differently
-			// This would require a separate query or handling
-			continue
-		}
-		query = query.Where(key, "==", value)
+func (r *firestoreMessageRepository) List(ctx context.Context, filter models.MessageFilter, sortField, sortOrder string, skip, limit int) ([]*models.Message, int, error) {
+	if len(filter.ExcludeTypes) > 0 {
+		// A "not-in" clause is a range on the type, which cannot be combined with the others
+		return nil, 0, fmt.Errorf("%w: excluded types require cursor pagination", ErrUnsupportedFilter)
+	}
+	query, matchable, inequalityField, err := r.applyFilter(r.client.Collection(r.collection).Query, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !matchable {
+		return nil, 0, nil
 	}
 
 	// Sorting - default to timestamp descending for recent messages first
@@ -64,29 +70,83 @@ func (r *firestoreMessageRepository) List(ctx context.Context, filter map[string
 	if sortField == "" {
 		sortField = "timestamp"
 	}
+	if inequalityField != "" && inequalityField != sortField {
+		return nil, 0, fmt.Errorf("%w: a range on %s requires sorting by %s", ErrUnsupportedFilter, inequalityField, inequalityField)
+	}
 	if sortOrder == "ASC" {
 		direction = firestore.Asc
 	}
 	query = query.OrderBy(sortField, direction)
 
-	// Get total count (this is a simplified approach, in production you might want to cache this)
-	totalIter := query.Documents(ctx)
-	totalCount := 0
-	for {
-		_, err := totalIter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, 0, err
-		}
-		totalCount++
+	// Count matching documents server-side instead of iterating the whole result set
+	totalCount, err := r.count(ctx, query)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	// Apply pagination
-	query = query.Offset(skip).Limit(limit)
+	// Apply pagination - a non-positive limit means no limit, as with MongoDB
+	query = query.Offset(skip)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
 
-	iter := query.Documents(ctx)
+	messages, err := r.collect(query.Documents(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return messages, totalCount, nil
+}
+
+func (r *firestoreMessageRepository) ListByCursor(ctx context.Context, filter models.MessageFilter, after *models.MessageCursor, sortOrder string, limit int) ([]*models.Message, *models.MessageCursor, error) {
+	query, matchable, inequalityField, err := r.applyFilter(r.client.Collection(r.collection).Query, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !matchable {
+		return nil, nil, nil
+	}
+	if inequalityField != "" && inequalityField != "timestamp" {
+		return nil, nil, fmt.Errorf("%w: cursor pagination cannot be combined with a range on %s", ErrUnsupportedFilter, inequalityField)
+	}
+
+	direction := firestore.Desc
+	if sortOrder == "ASC" {
+		direction = firestore.Asc
+	}
+	query = query.OrderBy("timestamp", direction).OrderBy(firestore.DocumentID, direction)
+	if after != nil {
+		query = query.StartAfter(after.Timestamp, after.ID)
+	}
+	query = query.Limit(limit + 1)
+
+	messages, err := r.collect(query.Documents(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The excluded types are dropped from the page read, which may then hold fewer than
+	// limit messages; the cursor still follows the last message read
+	messages, next := nextPage(messages, limit)
+	return withoutTypes(messages, filter.ExcludeTypes), next, nil
+}
+
+// count returns the number of documents matching a query using a server-side aggregation
+func (r *firestoreMessageRepository) count(ctx context.Context, query firestore.Query) (int, error) {
+	result, err := query.NewAggregationQuery().WithCount("total").Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	total, ok := result["total"].(*firestorepb.Value)
+	if !ok {
+		return 0, errors.New("unexpected count aggregation result")
+	}
+	return int(total.GetIntegerValue()), nil
+}
+
+// collect decodes every document returned by an iterator
+func (r *firestoreMessageRepository) collect(iter *firestore.DocumentIterator) ([]*models.Message, error) {
 	defer iter.Stop()
 
 	var messages []*models.Message
@@ -97,13 +157,13 @@ func (r *firestoreMessageRepository) List(ctx context.Context, filter map[string
 		}
 		if err != nil {
 			log.Printf("Error iterating documents: %v", err)
-			return nil, 0, err
+			return nil, err
 		}
 
 		var message models.Message
 		if err := doc.DataTo(&message); err != nil {
 			log.Printf("Error decoding document: %v", err)
-			return nil, 0, err
+			return nil, err
 		}
 
 		// Set the ID from the document ID
@@ -111,14 +171,16 @@ func (r *firestoreMessageRepository) List(ctx context.Context, filter map[string
 		messages = append(messages, &message)
 	}
 
-	return messages, totalCount, nil
+	return messages, nil
 }
 
 func (r *firestoreMessageRepository) FindByTopic(ctx context.Context, topic string, limit int) ([]*models.Message, error) {
 	query := r.client.Collection(r.collection).
 		Where("topic", "==", topic).
-		OrderBy("timestamp", firestore.Desc).
-		Limit(limit)
+		OrderBy("timestamp", firestore.Desc)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
 
 	iter := query.Documents(ctx)
 	defer iter.Stop()
@@ -148,9 +210,11 @@ func (r *firestoreMessageRepository) FindByTopic(ctx context.Context, topic stri
 
 func (r *firestoreMessageRepository) FindByDeviceID(ctx context.Context, deviceID string, limit int) ([]*models.Message, error) {
 	query := r.client.Collection(r.collection).
-		Where("device_id", "==", deviceID).
-		OrderBy("timestamp", firestore.Desc).
-		Limit(limit)
+		Where("deviceId", "==", deviceID).
+		OrderBy("timestamp", firestore.Desc)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
 
 	iter := query.Documents(ctx)
 	defer iter.Stop()
@@ -182,8 +246,10 @@ func (r *firestoreMessageRepository) FindByTimeRange(ctx context.Context, from,
 	query := r.client.Collection(r.collection).
 		Where("timestamp", ">=", from).
 		Where("timestamp", "<=", to).
-		OrderBy("timestamp", firestore.Desc).
-		Limit(limit)
+		OrderBy("timestamp", firestore.Desc)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
 
 	iter := query.Documents(ctx)
 	defer iter.Stop()
@@ -211,42 +277,264 @@ func (r *firestoreMessageRepository) FindByTimeRange(ctx context.Context, from,
 	return messages, nil
 }
 
-// GetAggregatedDataByDeviceID returns aggregated data for a device (placeholder implementation)
-func (r *firestoreMessageRepository) GetAggregatedDataByDeviceID(ctx context.Context, deviceID string) ([]map[string]interface{}, error) {
-	// Fetch the aggregated document for the device
-	doc, err := r.client.Collection("aggregations").Doc(deviceID).Get(ctx)
+// DistinctDeviceIDs projects the matching documents onto the device ID, since Firestore has no distinct query
+func (r *firestoreMessageRepository) DistinctDeviceIDs(ctx context.Context, filter models.MessageFilter) ([]string, error) {
+	query, matchable, _, err := r.applyFilter(r.client.Collection(r.collection).Query, filter)
 	if err != nil {
-		if doc != nil && !doc.Exists() {
-			return nil, errors.New("aggregated data not found")
+		return nil, err
+	}
+	if !matchable {
+		return []string{}, nil
+	}
+
+	iter := query.Select("deviceId", "type").Documents(ctx)
+	defer iter.Stop()
+
+	seen := make(map[string]bool)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("Error iterating documents: %v", err)
+			return nil, err
+		}
+		data := doc.Data()
+		messageType, _ := data["type"].(string)
+		if !(models.MessageFilter{ExcludeTypes: filter.ExcludeTypes}).Matches(&models.Message{Type: models.MessageType(messageType)}) {
+			continue
+		}
+		if deviceID, ok := data["deviceId"].(string); ok && deviceID != "" {
+			seen[deviceID] = true
+		}
+	}
+
+	deviceIDs := make([]string, 0, len(seen))
+	for deviceID := range seen {
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	sort.Strings(deviceIDs)
+	return deviceIDs, nil
+}
+
+// AggregateByDeviceID streams the device's messages in the time range, reading only the
+// fields needed, and aggregates them in Go since Firestore has no grouping queries
+func (r *firestoreMessageRepository) AggregateByDeviceID(ctx context.Context, query models.AggregationQuery) ([]*models.AggregatedData, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	iter := r.client.Collection(r.collection).
+		Select("timestamp", "type", "marshalled", "metadata").
+		Where("deviceId", "==", query.DeviceID).
+		Where("timestamp", ">=", query.From).
+		Where("timestamp", "<=", query.To).
+		Documents(ctx)
+	defer iter.Stop()
+
+	aggregator := newBucketAggregator(query)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
 		}
+
+		var message models.Message
+		if err := doc.DataTo(&message); err != nil {
+			return nil, err
+		}
+		aggregator.add(&message)
+	}
+	return aggregator.results(), nil
+}
+
+// GetAggregatedDataByDeviceID returns the selected aggregated data of a device, merged from
+// the aggregations documents holding it
+func (r *firestoreMessageRepository) GetAggregatedDataByDeviceID(ctx context.Context, deviceID string, filter models.AggregationFilter) ([]*models.AggregatedData, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	if deviceID == "" {
+		return nil, ErrAggregationsNotFound
+	}
+
+	agg, err := findFirestoreAggregations(ctx, r.client, deviceID, filter)
+	if err != nil {
 		return nil, err
 	}
+	return flattenAggregations(agg, filter), nil
+}
+
+// firestoreMaxDisjunctions is the maximum number of "in" values Firestore accepts in a single query
+const firestoreMaxDisjunctions = 30
+
+// applyFilter translates a typed message filter into Firestore where clauses.
+// matchable is false when the filter can never match, since Firestore rejects empty "in" lists.
+// inequalityField names the field carrying a range clause, which Firestore requires to be sorted first.
+func (r *firestoreMessageRepository) applyFilter(query firestore.Query, filter models.MessageFilter) (firestore.Query, bool, string, error) {
+	if err := filter.Validate(); err != nil {
+		return query, false, "", err
+	}
+
+	disjunctions := 1
+	if len(filter.IDs) > 0 {
+		refs := make([]*firestore.DocumentRef, 0, len(filter.IDs))
+		for _, id := range filter.IDs {
+			if id == "" {
+				return query, false, "", ErrInvalidMessageID
+			}
+			refs = append(refs, r.client.Collection(r.collection).Doc(id))
+		}
+		disjunctions *= len(refs)
+		if len(refs) == 1 {
+			query = query.Where(firestore.DocumentID, "==", refs[0])
+		} else {
+			query = query.Where(firestore.DocumentID, "in", refs)
+		}
+	}
+
+	for _, field := range equalityFields(filter) {
+		switch len(field.values) {
+		case 0:
+			return query, false, "", nil
+		case 1:
+			query = query.Where(field.name, "==", field.values[0])
+		default:
+			disjunctions *= len(field.values)
+			query = query.Where(field.name, "in", field.values)
+		}
+	}
+	if disjunctions > firestoreMaxDisjunctions {
+		return query, false, "", fmt.Errorf("%w: more than %d combined \"in\" values", ErrUnsupportedFilter, firestoreMaxDisjunctions)
+	}
+
+	if filter.TopicPattern != "" && filter.TopicPrefix != "" {
+		return query, false, "", fmt.Errorf("%w: topic pattern cannot be combined with a topic prefix", ErrUnsupportedFilter)
+	}
+	hasTimeRange := filter.FromTime != nil || filter.ToTime != nil
+	inequalityField := ""
+	if hasTimeRange {
+		inequalityField = "timestamp"
+	}
+
+	switch {
+	case filter.TopicPattern == "" && filter.TopicPrefix == "":
+	case filter.TopicPattern != "" && !models.IsTopicWildcard(filter.TopicPattern):
+		query = query.Where("topic", "==", filter.TopicPattern)
+	case filter.TopicPattern == "#":
+		// Matches every topic
+	case strings.Contains(filter.TopicPattern, "+"):
+		return query, false, "", fmt.Errorf("%w: topic pattern %q uses the '+' wildcard", ErrUnsupportedFilter, filter.TopicPattern)
+	default:
+		if hasTimeRange {
+			return query, false, "", fmt.Errorf("%w: topic prefix or '#' pattern cannot be combined with a time range", ErrUnsupportedFilter)
+		}
+		inequalityField = "topic"
+		if filter.TopicPrefix != "" {
+			query = query.Where("topic", ">=", filter.TopicPrefix).Where("topic", "<", filter.TopicPrefix+"\uf8ff")
+			break
+		}
+		// "a/b/#" matches the parent topic "a/b" as well as every topic below it
+		parent := strings.TrimSuffix(filter.TopicPattern, "/#")
+		query = query.WhereEntity(firestore.OrFilter{Filters: []firestore.EntityFilter{
+			firestore.PropertyFilter{Path: "topic", Operator: "==", Value: parent},
+			firestore.AndFilter{Filters: []firestore.EntityFilter{
+				firestore.PropertyFilter{Path: "topic", Operator: ">=", Value: parent + "/"},
+				firestore.PropertyFilter{Path: "topic", Operator: "<", Value: parent + "/\uf8ff"},
+			}},
+		}})
+	}
 
-	var agg models.ClientAggregations
-	if err := doc.DataTo(&agg); err != nil {
+	if filter.FromTime != nil {
+		query = query.Where("timestamp", ">=", *filter.FromTime)
+	}
+	if filter.ToTime != nil {
+		query = query.Where("timestamp", "<=", *filter.ToTime)
+	}
+
+	return query, true, inequalityField, nil
+}
+
+func (r *firestoreMessageRepository) Create(ctx context.Context, message *models.Message) (*models.Message, error) {
+	created, err := r.CreateMany(ctx, []*models.Message{message})
+	if err != nil {
 		return nil, err
 	}
+	return created[0], nil
+}
 
-	// Flatten the nested aggregation structure for API response
-	var result []map[string]interface{}
-	for channel, variables := range agg.Aggregations {
-		for variable, periods := range variables {
-			for period, timestamps := range periods {
-				for ts, data := range timestamps {
-					result = append(result, map[string]interface{}{
-						"channel":   channel,
-						"variable":  variable,
-						"period":    period,
-						"timestamp": ts,
-						"min":       data.Min,
-						"max":       data.Max,
-						"avg":       data.Avg,
-						"sum":       data.Sum,
-						"count":     data.Count,
-					})
-				}
+// CreateMany writes all messages in a single transaction, so either every message is stored or none is
+func (r *firestoreMessageRepository) CreateMany(ctx context.Context, messages []*models.Message) ([]*models.Message, error) {
+	created := make([]*models.Message, 0, len(messages))
+	refs := make([]*firestore.DocumentRef, 0, len(messages))
+	for _, message := range messages {
+		stored := *message
+		ref := r.client.Collection(r.collection).NewDoc()
+		if id := stored.GetIDAsString(); id != "" {
+			ref = r.client.Collection(r.collection).Doc(id)
+		}
+		stored.SetIDFromString(ref.ID)
+		created = append(created, &stored)
+		refs = append(refs, ref)
+	}
+
+	err := r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		for i, ref := range refs {
+			if err := tx.Create(ref, created[i]); err != nil {
+				return err
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error creating documents: %v", err)
+		return nil, err
+	}
+	return created, nil
+}
+
+func (r *firestoreMessageRepository) Update(ctx context.Context, id string, update models.MessageUpdate) (*models.Message, error) {
+	if id == "" {
+		return nil, ErrInvalidMessageID
+	}
+
+	updates := []firestore.Update{{Path: "updatedAt", Value: update.UpdatedAt}}
+	if update.Status != nil {
+		updates = append(updates, firestore.Update{Path: "status", Value: string(*update.Status)})
+	}
+	if update.ProcessedAt != nil {
+		updates = append(updates, firestore.Update{Path: "processedAt", Value: *update.ProcessedAt})
+	}
+	if update.Metadata != nil {
+		updates = append(updates, firestore.Update{Path: "metadata", Value: update.Metadata})
+	}
+	if update.DeviceID != nil {
+		updates = append(updates, firestore.Update{Path: "deviceId", Value: *update.DeviceID})
+	}
+
+	if _, err := r.client.Collection(r.collection).Doc(id).Update(ctx, updates); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	return r.FindByID(ctx, id)
+}
+
+func (r *firestoreMessageRepository) Delete(ctx context.Context, id string) error {
+	if id == "" {
+		return ErrInvalidMessageID
+	}
+
+	if _, err := r.client.Collection(r.collection).Doc(id).Delete(ctx, firestore.Exists); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return ErrMessageNotFound
+		}
+		return err
 	}
-	return result, nil
+	return nil
 }
@@ -0,0 +1,478 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryMessageRepository keeps messages and aggregations in process memory.
+// It is intended for local development and tests where no MongoDB or
+// Firestore instance is available.
+type memoryMessageRepository struct {
+	mu           sync.RWMutex
+	messages     []*models.Message
+	aggregations map[string]*models.ClientAggregations
+	applied      map[string]bool  // Message IDs already applied to the aggregations
+	versions     map[string]int64 // Incremented on every change of the aggregations of a device
+}
+
+// NewMemoryMessageRepository creates an in-memory repository seeded with the given
+// messages and aggregation documents. Messages without an ID get a generated one.
+func NewMemoryMessageRepository(messages []*models.Message, aggregations []*models.ClientAggregations) MessageRepository {
+	r := &memoryMessageRepository{
+		aggregations: make(map[string]*models.ClientAggregations),
+		applied:      make(map[string]bool),
+		versions:     make(map[string]int64),
+	}
+	for _, message := range messages {
+		stored := *message
+		if stored.GetIDAsString() == "" {
+			stored.SetIDFromString(primitive.NewObjectID().Hex())
+		} else {
+			stored.SetIDFromString(stored.GetIDAsString())
+		}
+		r.messages = append(r.messages, &stored)
+	}
+	for _, agg := range aggregations {
+		r.aggregations[agg.ClientID] = agg
+	}
+	return r
+}
+
+func (r *memoryMessageRepository) FindByID(ctx context.Context, id string) (*models.Message, error) {
+	if id == "" {
+		return nil, ErrInvalidMessageID
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, message := range r.messages {
+		if message.GetIDAsString() == id {
+			found := *message
+			return &found, nil
+		}
+	}
+	return nil, ErrMessageNotFound
+}
+
+func (r *memoryMessageRepository) List(ctx context.Context, filter models.MessageFilter, sortField, sortOrder string, skip, limit int) ([]*models.Message, int, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, 0, err
+	}
+
+	// Sorting - default to timestamp descending for recent messages first
+	if sortField == "" {
+		sortField = "timestamp"
+	}
+	if _, ok := memoryMessageField(&models.Message{}, sortField); !ok {
+		return nil, 0, errors.New("unsupported sort field: " + sortField)
+	}
+
+	r.mu.RLock()
+	var matched []*models.Message
+	for _, message := range r.messages {
+		if filter.Matches(message) {
+			found := *message
+			matched = append(matched, &found)
+		}
+	}
+	r.mu.RUnlock()
+
+	ascending := sortOrder == "ASC"
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, _ := memoryMessageField(matched[i], sortField)
+		b, _ := memoryMessageField(matched[j], sortField)
+		cmp := memoryCompare(a, b)
+		if cmp == 0 {
+			// Break ties on ID like the database backends so pages stay stable
+			cmp = memoryCompare(matched[i].GetIDAsString(), matched[j].GetIDAsString())
+		}
+		if ascending {
+			return cmp < 0
+		}
+		return cmp > 0
+	})
+
+	return memoryPage(matched, skip, limit), len(matched), nil
+}
+
+func (r *memoryMessageRepository) ListByCursor(ctx context.Context, filter models.MessageFilter, after *models.MessageCursor, sortOrder string, limit int) ([]*models.Message, *models.MessageCursor, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	ascending := sortOrder == "ASC"
+	// before reports whether a sorts before b in the requested direction
+	before := func(aTime time.Time, aID string, bTime time.Time, bID string) bool {
+		if !aTime.Equal(bTime) {
+			return aTime.Before(bTime) == ascending
+		}
+		return aID != bID && (aID < bID) == ascending
+	}
+
+	r.mu.RLock()
+	var matched []*models.Message
+	for _, message := range r.messages {
+		if !filter.Matches(message) {
+			continue
+		}
+		if after != nil && !before(after.Timestamp, after.ID, message.Timestamp, message.GetIDAsString()) {
+			continue
+		}
+		found := *message
+		matched = append(matched, &found)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		return before(matched[i].Timestamp, matched[i].GetIDAsString(), matched[j].Timestamp, matched[j].GetIDAsString())
+	})
+
+	messages, next := nextPage(memoryPage(matched, 0, limit+1), limit)
+	return messages, next, nil
+}
+
+func (r *memoryMessageRepository) FindByTopic(ctx context.Context, topic string, limit int) ([]*models.Message, error) {
+	return r.findRecent(func(m *models.Message) bool { return m.Topic == topic }, limit), nil
+}
+
+func (r *memoryMessageRepository) FindByDeviceID(ctx context.Context, deviceID string, limit int) ([]*models.Message, error) {
+	return r.findRecent(func(m *models.Message) bool { return m.DeviceID == deviceID }, limit), nil
+}
+
+func (r *memoryMessageRepository) FindByTimeRange(ctx context.Context, from, to time.Time, limit int) ([]*models.Message, error) {
+	return r.findRecent(func(m *models.Message) bool {
+		return !m.Timestamp.Before(from) && !m.Timestamp.After(to)
+	}, limit), nil
+}
+
+func (r *memoryMessageRepository) DistinctDeviceIDs(ctx context.Context, filter models.MessageFilter) ([]string, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	seen := make(map[string]bool)
+	for _, message := range r.messages {
+		if message.DeviceID != "" && filter.Matches(message) {
+			seen[message.DeviceID] = true
+		}
+	}
+	r.mu.RUnlock()
+
+	deviceIDs := make([]string, 0, len(seen))
+	for deviceID := range seen {
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	sort.Strings(deviceIDs)
+	return deviceIDs, nil
+}
+
+// GetAggregatedDataByDeviceID returns the flattened aggregated data stored for a device
+func (r *memoryMessageRepository) GetAggregatedDataByDeviceID(ctx context.Context, deviceID string, filter models.AggregationFilter) ([]*models.AggregatedData, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	agg, ok := r.aggregations[deviceID]
+	if !ok {
+		return nil, ErrAggregationsNotFound
+	}
+	return flattenAggregations(agg, filter), nil
+}
+
+// ApplyRollups implements AggregationRepository, so the rollups are visible to
+// GetAggregatedDataByDeviceID of the same repository
+func (r *memoryMessageRepository) ApplyRollups(ctx context.Context, rollups []models.MessageRollup) (int, error) {
+	devices, err := groupRollups(rollups)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	applied := 0
+	for _, device := range devices {
+		for _, rollup := range device.rollups {
+			if r.applied[rollup.MessageID] {
+				continue
+			}
+			r.applied[rollup.MessageID] = true
+			applied++
+			if len(rollup.Samples) == 0 {
+				continue
+			}
+
+			agg, ok := r.aggregations[rollup.DeviceID]
+			if !ok {
+				agg = &models.ClientAggregations{ClientID: rollup.DeviceID}
+				r.aggregations[rollup.DeviceID] = agg
+			}
+			for _, sample := range rollup.Samples {
+				agg.Apply(sample)
+			}
+			r.versions[rollup.DeviceID]++
+		}
+	}
+	return applied, nil
+}
+
+// SnapshotBuckets implements AggregationRepository with the version of the whole
+// aggregations of the device, so a rollup outside the range also fails the replacement
+func (r *memoryMessageRepository) SnapshotBuckets(ctx context.Context, deviceID string, from, to time.Time) (*AggregationSnapshot, error) {
+	if deviceID == "" {
+		return nil, errors.New("device ID is required")
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return &AggregationSnapshot{
+		DeviceID:  deviceID,
+		From:      from,
+		To:        to,
+		documents: map[models.RollupPartition]snapshotDocument{{}: {version: r.versions[deviceID]}},
+	}, nil
+}
+
+// ReplaceBuckets implements AggregationRepository
+func (r *memoryMessageRepository) ReplaceBuckets(ctx context.Context, snapshot *AggregationSnapshot, replacement *models.ClientAggregations, messageIDs []string) error {
+	if err := validSnapshot(snapshot); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deviceID := snapshot.DeviceID
+	if snapshot.changedSince(map[models.RollupPartition]snapshotDocument{{}: {version: r.versions[deviceID]}}) {
+		return fmt.Errorf("device %s: %w", deviceID, ErrAggregationsChanged)
+	}
+	for _, id := range messageIDs {
+		r.applied[id] = true
+	}
+	agg, ok := r.aggregations[deviceID]
+	if !ok {
+		agg = &models.ClientAggregations{ClientID: deviceID}
+		r.aggregations[deviceID] = agg
+	}
+	agg.ReplaceRange(snapshot.From, snapshot.To, replacement)
+	r.versions[deviceID]++
+	return nil
+}
+
+func (r *memoryMessageRepository) AggregateByDeviceID(ctx context.Context, query models.AggregationQuery) ([]*models.AggregatedData, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	aggregator := newBucketAggregator(query)
+	for _, message := range r.messages {
+		if message.DeviceID == query.DeviceID && !message.Timestamp.Before(query.From) && !message.Timestamp.After(query.To) {
+			aggregator.add(message)
+		}
+	}
+	return aggregator.results(), nil
+}
+
+func (r *memoryMessageRepository) Create(ctx context.Context, message *models.Message) (*models.Message, error) {
+	created, err := r.CreateMany(ctx, []*models.Message{message})
+	if err != nil {
+		return nil, err
+	}
+	return created[0], nil
+}
+
+func (r *memoryMessageRepository) CreateMany(ctx context.Context, messages []*models.Message) ([]*models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	created := make([]*models.Message, 0, len(messages))
+	batchIDs := make(map[string]bool, len(messages))
+	for _, message := range messages {
+		stored := *message
+		id := stored.GetIDAsString()
+		if id == "" {
+			id = primitive.NewObjectID().Hex()
+		}
+		if batchIDs[id] {
+			return nil, errors.New("message already exists: " + id)
+		}
+		batchIDs[id] = true
+		for _, existing := range r.messages {
+			if existing.GetIDAsString() == id {
+				return nil, errors.New("message already exists: " + id)
+			}
+		}
+		stored.SetIDFromString(id)
+		created = append(created, &stored)
+	}
+
+	for _, message := range created {
+		stored := *message
+		r.messages = append(r.messages, &stored)
+	}
+	return created, nil
+}
+
+func (r *memoryMessageRepository) Update(ctx context.Context, id string, update models.MessageUpdate) (*models.Message, error) {
+	if id == "" {
+		return nil, ErrInvalidMessageID
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, message := range r.messages {
+		if message.GetIDAsString() != id {
+			continue
+		}
+		message.UpdatedAt = update.UpdatedAt
+		if update.Status != nil {
+			message.Status = *update.Status
+		}
+		if update.ProcessedAt != nil {
+			processedAt := *update.ProcessedAt
+			message.ProcessedAt = &processedAt
+		}
+		if update.Metadata != nil {
+			message.Metadata = make(map[string]string, len(update.Metadata))
+			for k, v := range update.Metadata {
+				message.Metadata[k] = v
+			}
+		}
+		if update.DeviceID != nil {
+			message.DeviceID = *update.DeviceID
+		}
+		updated := *message
+		return &updated, nil
+	}
+	return nil, ErrMessageNotFound
+}
+
+func (r *memoryMessageRepository) Delete(ctx context.Context, id string) error {
+	if id == "" {
+		return ErrInvalidMessageID
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, message := range r.messages {
+		if message.GetIDAsString() == id {
+			r.messages = append(r.messages[:i], r.messages[i+1:]...)
+			return nil
+		}
+	}
+	return ErrMessageNotFound
+}
+
+// findRecent returns the messages matching fn, most recent first
+func (r *memoryMessageRepository) findRecent(fn func(*models.Message) bool, limit int) []*models.Message {
+	r.mu.RLock()
+	var matched []*models.Message
+	for _, message := range r.messages {
+		if fn(message) {
+			found := *message
+			matched = append(matched, &found)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Timestamp.After(matched[j].Timestamp)
+	})
+	return memoryPage(matched, 0, limit)
+}
+
+// memoryPage applies skip/limit the same way MongoDB does: a non-positive limit means no limit
+func memoryPage(messages []*models.Message, skip, limit int) []*models.Message {
+	if skip < 0 {
+		skip = 0
+	}
+	if skip >= len(messages) {
+		return nil
+	}
+	messages = messages[skip:]
+	if limit > 0 && limit < len(messages) {
+		messages = messages[:limit]
+	}
+	return messages
+}
+
+// memoryMessageField returns the value of a message field addressed by its stored (bson) name
+func memoryMessageField(message *models.Message, field string) (interface{}, bool) {
+	switch field {
+	case "_id", "id":
+		return message.GetIDAsString(), true
+	case "topic":
+		return message.Topic, true
+	case "payload":
+		return message.Payload, true
+	case "timestamp":
+		return message.Timestamp, true
+	case "client_id":
+		return message.ClientID, true
+	case "type":
+		return string(message.Type), true
+	case "status":
+		return string(message.Status), true
+	case "deviceId":
+		return message.DeviceID, true
+	case "projectId":
+		return message.ProjectID, true
+	case "createdAt":
+		return message.CreatedAt, true
+	case "updatedAt":
+		return message.UpdatedAt, true
+	case "createdBy":
+		return message.CreatedBy, true
+	default:
+		return nil, false
+	}
+}
+
+// memoryCompare orders two field values; times compare chronologically and strings lexically
+func memoryCompare(a, b interface{}) int {
+	if at, ok := a.(time.Time); ok {
+		bt, ok := b.(time.Time)
+		if !ok {
+			return -1
+		}
+		switch {
+		case at.Before(bt):
+			return -1
+		case at.After(bt):
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	as, _ := a.(string)
+	bs, _ := b.(string)
+	switch {
+	case as < bs:
+		return -1
+	case as > bs:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,158 @@
+package repositories
+
+import (
+	"context"
+	"sit-iot-message-mng-api/internal/models"
+	"testing"
+	"time"
+)
+
+func newMemoryTestRepository() MessageRepository {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	messages := []*models.Message{
+		{ID: "m1", Topic: "site/dev-1/telemetry", ClientID: "dev-1", DeviceID: "dev-1", Type: models.MessageTypeTelemetry, Timestamp: base},
+		{ID: "m2", Topic: "site/dev-1/status/", ClientID: "dev-1", DeviceID: "dev-1", Type: models.MessageTypeStatus, Timestamp: base.Add(time.Minute)},
+		{ID: "m3", Topic: "site/dev-2/telemetry", ClientID: "dev-2", DeviceID: "dev-2", Type: models.MessageTypeTelemetry, Timestamp: base.Add(2 * time.Minute)},
+		{ID: "m4", Topic: "site/dev-3/telemetry", ClientID: "dev-3", DeviceID: "dev-3", Type: models.MessageTypeTelemetry, Timestamp: base.Add(3 * time.Minute)},
+	}
+	aggregations := []*models.ClientAggregations{
+		{
+			ClientID: "dev-1",
+			Aggregations: map[string]map[string]map[string]map[string]*models.AggregatedData{
+				"ch1": {"temperature": {"hourly": {"2024-01-01T12": {Sum: 42, Count: 2, Min: 20, Max: 22, Avg: 21}}}},
+			},
+		},
+	}
+	return NewMemoryMessageRepository(messages, aggregations)
+}
+
+func TestMemoryMessageRepositoryList(t *testing.T) {
+	repo := newMemoryTestRepository()
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		filter    models.MessageFilter
+		sortField string
+		sortOrder string
+		skip      int
+		limit     int
+		wantIDs   []string
+		wantTotal int
+	}{
+		{
+			name:      "default sort is timestamp descending",
+			limit:     10,
+			wantIDs:   []string{"m4", "m3", "m2", "m1"},
+			wantTotal: 4,
+		},
+		{
+			name:      "equality filter with ascending sort",
+			filter:    models.MessageFilter{Type: models.MessageTypeTelemetry},
+			sortOrder: "ASC",
+			limit:     10,
+			wantIDs:   []string{"m1", "m3", "m4"},
+			wantTotal: 3,
+		},
+		{
+			name:      "in filter with skip and limit",
+			filter:    models.MessageFilter{ClientIDs: []string{"dev-1", "dev-2"}},
+			skip:      1,
+			limit:     1,
+			wantIDs:   []string{"m2"},
+			wantTotal: 3,
+		},
+		{
+			name:      "scalar outside its list matches nothing",
+			filter:    models.MessageFilter{ClientID: "dev-3", ClientIDs: []string{"dev-1", "dev-2"}},
+			limit:     10,
+			wantIDs:   nil,
+			wantTotal: 0,
+		},
+		{
+			name:      "topic wildcard pattern",
+			filter:    models.MessageFilter{TopicPattern: "site/+/telemetry"},
+			limit:     10,
+			wantIDs:   []string{"m4", "m3", "m1"},
+			wantTotal: 3,
+		},
+		{
+			name:      "skip past the end",
+			skip:      10,
+			limit:     10,
+			wantIDs:   nil,
+			wantTotal: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, total, err := repo.List(ctx, tt.filter, tt.sortField, tt.sortOrder, tt.skip, tt.limit)
+			if err != nil {
+				t.Fatalf("List() unexpected error: %v", err)
+			}
+			if total != tt.wantTotal {
+				t.Errorf("List() total = %d, want %d", total, tt.wantTotal)
+			}
+			if len(messages) != len(tt.wantIDs) {
+				t.Fatalf("List() returned %d messages, want %d", len(messages), len(tt.wantIDs))
+			}
+			for i, message := range messages {
+				if message.GetIDAsString() != tt.wantIDs[i] {
+					t.Errorf("List()[%d] = %s, want %s", i, message.GetIDAsString(), tt.wantIDs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryMessageRepositoryFind(t *testing.T) {
+	repo := newMemoryTestRepository()
+	ctx := context.Background()
+
+	if _, err := repo.FindByID(ctx, "missing"); err == nil {
+		t.Errorf("FindByID() expected error for missing message")
+	}
+
+	message, err := repo.FindByID(ctx, "m2")
+	if err != nil {
+		t.Fatalf("FindByID() unexpected error: %v", err)
+	}
+	if message.Topic != "site/dev-1/status/" {
+		t.Errorf("FindByID() topic = %s, want site/dev-1/status/", message.Topic)
+	}
+
+	byDevice, err := repo.FindByDeviceID(ctx, "dev-1", 1)
+	if err != nil {
+		t.Fatalf("FindByDeviceID() unexpected error: %v", err)
+	}
+	if len(byDevice) != 1 || byDevice[0].GetIDAsString() != "m2" {
+		t.Errorf("FindByDeviceID() should return the most recent message first")
+	}
+
+	from := time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC)
+	byTime, err := repo.FindByTimeRange(ctx, from, from.Add(time.Minute), 10)
+	if err != nil {
+		t.Fatalf("FindByTimeRange() unexpected error: %v", err)
+	}
+	if len(byTime) != 2 {
+		t.Errorf("FindByTimeRange() returned %d messages, want 2", len(byTime))
+	}
+}
+
+func TestMemoryMessageRepositoryAggregations(t *testing.T) {
+	repo := newMemoryTestRepository()
+	ctx := context.Background()
+
+	rows, err := repo.GetAggregatedDataByDeviceID(ctx, "dev-1", models.AggregationFilter{})
+	if err != nil {
+		t.Fatalf("GetAggregatedDataByDeviceID() unexpected error: %v", err)
+	}
+	if len(rows) != 1 || rows[0].Variable != "temperature" || rows[0].Count != 2 || !rows[0].Timestamp.Equal(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("GetAggregatedDataByDeviceID() = %v", rows)
+	}
+
+	if _, err := repo.GetAggregatedDataByDeviceID(ctx, "dev-2", models.AggregationFilter{}); err == nil {
+		t.Errorf("GetAggregatedDataByDeviceID() expected error for device without aggregations")
+	}
+}
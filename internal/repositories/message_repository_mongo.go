@@ -3,7 +3,11 @@ package repositories
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"sit-iot-message-mng-api/internal/models"
@@ -27,38 +31,24 @@ func NewMessageRepository(db *mongo.Database) MessageRepository {
 func (r *messageRepository) FindByID(ctx context.Context, id string) (*models.Message, error) {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return nil, errors.New("invalid message ID format")
+		return nil, ErrInvalidMessageID
 	}
 
 	var message models.Message
 	err = r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&message)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("message not found")
+			return nil, ErrMessageNotFound
 		}
 		return nil, err
 	}
 	return &message, nil
 }
 
-func (r *messageRepository) List(ctx context.Context, filter map[string]interface{}, sortField, sortOrder string, skip, limit int) ([]*models.Message, int, error) {
-	// Convert filter to bson.M
-	bsonFilter := bson.M{}
-	for key, value := range filter {
-		if key == "_id" {
-			idStr, ok := value.(string)
-			if ok {
-				objectID, err := primitive.ObjectIDFromHex(idStr)
-				if err != nil {
-					return nil, 0, errors.New("invalid ObjectID format in filter")
-				}
-				bsonFilter[key] = objectID
-			} else {
-				return nil, 0, errors.New("invalid filter value for _id")
-			}
-		} else {
-			bsonFilter[key] = value
-		}
+func (r *messageRepository) List(ctx context.Context, filter models.MessageFilter, sortField, sortOrder string, skip, limit int) ([]*models.Message, int, error) {
+	bsonFilter, err := buildMongoFilter(filter)
+	if err != nil {
+		return nil, 0, err
 	}
 
 	// Sorting - default to timestamp descending for recent messages first
@@ -73,7 +63,7 @@ func (r *messageRepository) List(ctx context.Context, filter map[string]interfac
 	opts := options.Find()
 	opts.SetSkip(int64(skip))
 	opts.SetLimit(int64(limit))
-	opts.SetSort(bson.D{{Key: sortField, Value: sort}})
+	opts.SetSort(buildMongoSort(sortField, sort))
 
 	// Count total documents matching the filter
 	total, err := r.collection.CountDocuments(ctx, bsonFilter)
@@ -108,6 +98,60 @@ func (r *messageRepository) List(ctx context.Context, filter map[string]interfac
 	return messages, int(total), nil
 }
 
+func (r *messageRepository) ListByCursor(ctx context.Context, filter models.MessageFilter, after *models.MessageCursor, sortOrder string, limit int) ([]*models.Message, *models.MessageCursor, error) {
+	bsonFilter, err := buildMongoFilter(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort, comparison := -1, "$lt"
+	if sortOrder == "ASC" {
+		sort, comparison = 1, "$gt"
+	}
+
+	if after != nil {
+		objectID, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w in cursor: %s", ErrInvalidMessageID, after.ID)
+		}
+		// Keyset condition: strictly after (timestamp, _id) in the sort direction
+		keyset := bson.M{"$or": []bson.M{
+			{"timestamp": bson.M{comparison: after.Timestamp}},
+			{"timestamp": after.Timestamp, "_id": bson.M{comparison: objectID}},
+		}}
+		bsonFilter = bson.M{"$and": []bson.M{bsonFilter, keyset}}
+	}
+
+	opts := options.Find()
+	opts.SetLimit(int64(limit + 1))
+	opts.SetSort(bson.D{{Key: "timestamp", Value: sort}, {Key: "_id", Value: sort}})
+
+	cursor, err := r.collection.Find(ctx, bsonFilter, opts)
+	if err != nil {
+		log.Printf("Error finding documents: %v", err)
+		return nil, nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []*models.Message
+	for cursor.Next(ctx) {
+		var message models.Message
+		if err := cursor.Decode(&message); err != nil {
+			log.Printf("Error decoding document: %v", err)
+			return nil, nil, err
+		}
+		messages = append(messages, &message)
+	}
+
+	if err := cursor.Err(); err != nil {
+		log.Printf("Cursor error: %v", err)
+		return nil, nil, err
+	}
+
+	messages, next := nextPage(messages, limit)
+	return messages, next, nil
+}
+
 func (r *messageRepository) FindByTopic(ctx context.Context, topic string, limit int) ([]*models.Message, error) {
 	opts := options.Find()
 	opts.SetLimit(int64(limit))
@@ -137,7 +181,7 @@ func (r *messageRepository) FindByDeviceID(ctx context.Context, deviceID string,
 	opts.SetLimit(int64(limit))
 	opts.SetSort(bson.D{{Key: "timestamp", Value: -1}}) // Most recent first
 
-	filter := bson.M{"device_id": deviceID}
+	filter := bson.M{"deviceId": deviceID}
 	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
@@ -186,39 +230,470 @@ func (r *messageRepository) FindByTimeRange(ctx context.Context, from, to time.T
 	return messages, cursor.Err()
 }
 
-// GetAggregatedDataByDeviceID returns aggregated data for a device (placeholder implementation)
-func (r *messageRepository) GetAggregatedDataByDeviceID(ctx context.Context, deviceID string) ([]map[string]interface{}, error) {
-	// Fetch the aggregated document for the device
-	filter := bson.M{"client_id": deviceID}
-	var agg models.ClientAggregations
-	err := r.collection.Database().Collection("aggregations").FindOne(ctx, filter).Decode(&agg)
+func (r *messageRepository) DistinctDeviceIDs(ctx context.Context, filter models.MessageFilter) ([]string, error) {
+	bsonFilter, err := buildMongoFilter(filter)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("aggregated data not found")
+		return nil, err
+	}
+
+	values, err := r.collection.Distinct(ctx, "deviceId", bsonFilter)
+	if err != nil {
+		log.Printf("Error listing distinct device IDs: %v", err)
+		return nil, err
+	}
+
+	deviceIDs := make([]string, 0, len(values))
+	for _, value := range values {
+		if deviceID, ok := value.(string); ok && deviceID != "" {
+			deviceIDs = append(deviceIDs, deviceID)
+		}
+	}
+	sort.Strings(deviceIDs)
+	return deviceIDs, nil
+}
+
+// buildMongoSort sorts on the field, then on _id so pages stay stable on ties
+func buildMongoSort(sortField string, direction int) bson.D {
+	sort := bson.D{{Key: sortField, Value: direction}}
+	if sortField != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: direction})
+	}
+	return sort
+}
+
+// GetAggregatedDataByDeviceID returns the selected aggregated data of a device, merged from
+// the aggregations documents holding it
+func (r *messageRepository) GetAggregatedDataByDeviceID(ctx context.Context, deviceID string, filter models.AggregationFilter) ([]*models.AggregatedData, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	agg, err := findMongoAggregations(ctx, r.collection.Database().Collection(aggregationCollection), deviceID, filter)
+	if err != nil {
+		return nil, err
+	}
+	return flattenAggregations(agg, filter), nil
+}
+
+// mongoBucketUnits maps bucket sizes to the unit and bin size of $dateTrunc
+var mongoBucketUnits = map[models.BucketSize]struct {
+	unit    string
+	binSize int
+}{
+	models.Bucket1Minute:  {"minute", 1},
+	models.Bucket5Minutes: {"minute", 5},
+	models.Bucket1Hour:    {"hour", 1},
+	models.Bucket1Day:     {"day", 1},
+}
+
+// aggregationResult is a bucket as produced by the aggregation pipeline
+type aggregationResult struct {
+	ID struct {
+		Channel   string    `bson:"channel"`
+		Variable  string    `bson:"variable"`
+		Timestamp time.Time `bson:"timestamp"`
+	} `bson:"_id"`
+	Sum        float64   `bson:"sum"`
+	Count      int       `bson:"count"`
+	Min        float64   `bson:"min"`
+	Max        float64   `bson:"max"`
+	SumSquares float64   `bson:"sumSq"`
+	First      float64   `bson:"first"`
+	FirstAt    time.Time `bson:"firstAt"`
+	Last       float64   `bson:"last"`
+	LastAt     time.Time `bson:"lastAt"`
+	// Only counted for extended statistics
+	Sketch models.QuantileSketch `bson:"sketch"`
+}
+
+// AggregateByDeviceID computes the buckets server side with an aggregation pipeline (MongoDB 5.0+)
+func (r *messageRepository) AggregateByDeviceID(ctx context.Context, query models.AggregationQuery) ([]*models.AggregatedData, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, buildAggregationPipeline(query))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []aggregationResult
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	results := make([]*models.AggregatedData, 0, len(rows))
+	for _, row := range rows {
+		firstAt, lastAt := row.FirstAt.UTC(), row.LastAt.UTC()
+		result := &models.AggregatedData{
+			ClientID:  query.DeviceID,
+			Channel:   row.ID.Channel,
+			Variable:  row.ID.Variable,
+			Period:    string(query.Bucket),
+			Timestamp: row.ID.Timestamp.UTC(),
+			Sum:       row.Sum,
+			Count:     row.Count,
+			Min:       row.Min,
+			Max:       row.Max,
+			// Computed like the Go aggregation so every backend returns the same average
+			Avg:        row.Sum / float64(row.Count),
+			SumSquares: row.SumSquares,
+			First:      row.First,
+			FirstAt:    &firstAt,
+			Last:       row.Last,
+			LastAt:     &lastAt,
+		}
+		if len(row.Sketch) > 0 {
+			result.Sketch = row.Sketch
+		}
+		results = append(results, result)
+	}
+	sortAggregatedData(results)
+	return results, nil
+}
+
+// buildAggregationPipeline unwinds the numeric payload fields of the selected messages
+// into (variable, value) pairs and groups them by bucket, channel and variable. With
+// extended statistics, the pairs are first grouped by sketch bin as well, and the bins of a
+// bucket are then merged, so only the count of every bin leaves the database.
+func buildAggregationPipeline(query models.AggregationQuery) mongo.Pipeline {
+	// Every top-level field, or the requested (possibly dotted) fields
+	var fields interface{} = bson.M{"$objectToArray": "$marshalled"}
+	if len(query.Fields) > 0 {
+		pairs := bson.A{}
+		for _, field := range query.Fields {
+			pairs = append(pairs, bson.M{"k": bson.M{"$literal": field}, "v": "$marshalled." + field})
+		}
+		fields = pairs
+	}
+
+	group := bson.M{
+		"_id": bson.M{
+			"channel":  "$channel",
+			"variable": "$fields.k",
+			"timestamp": bson.M{"$dateTrunc": bson.M{
+				"date":    "$timestamp",
+				"unit":    mongoBucketUnits[query.Bucket].unit,
+				"binSize": mongoBucketUnits[query.Bucket].binSize,
+			}},
+		},
+		"sum":   bson.M{"$sum": "$fields.v"},
+		"count": bson.M{"$sum": 1},
+		"min":   bson.M{"$min": "$fields.v"},
+		"max":   bson.M{"$max": "$fields.v"},
+		"sumSq": bson.M{"$sum": bson.M{"$multiply": bson.A{"$fields.v", "$fields.v"}}},
+		// The messages are sorted by timestamp before grouping
+		"first":   bson.M{"$first": "$fields.v"},
+		"firstAt": bson.M{"$first": "$timestamp"},
+		"last":    bson.M{"$last": "$fields.v"},
+		"lastAt":  bson.M{"$last": "$timestamp"},
+	}
+	groups := mongo.Pipeline{{{Key: "$group", Value: group}}}
+	if query.Extended {
+		group["_id"].(bson.M)["bin"] = mongoSketchBin("$fields.v")
+		groups = append(groups,
+			bson.D{{Key: "$group", Value: bson.M{
+				"_id":   bson.M{"channel": "$_id.channel", "variable": "$_id.variable", "timestamp": "$_id.timestamp"},
+				"sum":   bson.M{"$sum": "$sum"},
+				"count": bson.M{"$sum": "$count"},
+				"min":   bson.M{"$min": "$min"},
+				"max":   bson.M{"$max": "$max"},
+				"sumSq": bson.M{"$sum": "$sumSq"},
+				// Documents compare field by field, so the earliest first and latest last win
+				"firstSample": bson.M{"$min": bson.M{"at": "$firstAt", "value": "$first"}},
+				"lastSample":  bson.M{"$max": bson.M{"at": "$lastAt", "value": "$last"}},
+				"sketch":      bson.M{"$push": bson.M{"k": "$_id.bin", "v": "$count"}},
+			}}},
+			bson.D{{Key: "$set", Value: bson.M{
+				"first":   "$firstSample.value",
+				"firstAt": "$firstSample.at",
+				"last":    "$lastSample.value",
+				"lastAt":  "$lastSample.at",
+				"sketch":  bson.M{"$arrayToObject": "$sketch"},
+			}}},
+		)
+	}
+
+	return append(mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"deviceId":  query.DeviceID,
+			"timestamp": bson.M{"$gte": query.From, "$lte": query.To},
+			// Derived messages such as alerts are not device data
+			"type": bson.M{"$nin": derivedTypes()},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "timestamp", Value: 1}}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":       0,
+			"timestamp": 1,
+			"channel": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$metadata." + models.ChannelMetadataKey, ""}},
+				"$metadata." + models.ChannelMetadataKey,
+				models.DefaultChannel,
+			}},
+			"fields": fields,
+		}}},
+		{{Key: "$unwind", Value: "$fields"}},
+		{{Key: "$match", Value: bson.M{"fields.v": bson.M{"$type": "number"}}}},
+	}, groups...)
+}
+
+// derivedTypes returns the derived message types as stored
+func derivedTypes() []string {
+	types := make([]string, 0, len(models.DerivedMessageTypes))
+	for _, t := range models.DerivedMessageTypes {
+		types = append(types, string(t))
+	}
+	return types
+}
+
+// mongoSketchBin returns the expression of the QuantileSketch bin of a value, computed
+// like models.SketchBin
+func mongoSketchBin(value string) bson.M {
+	magnitude := bson.M{"$abs": value}
+	index := bson.M{"$toString": bson.M{"$toLong": bson.M{"$ceil": bson.M{"$divide": bson.A{bson.M{"$ln": magnitude}, models.SketchLogGamma}}}}}
+	return bson.M{"$cond": bson.A{
+		bson.M{"$lt": bson.A{magnitude, models.SketchMinValue}},
+		"z",
+		bson.M{"$concat": bson.A{bson.M{"$cond": bson.A{bson.M{"$lt": bson.A{value, 0}}, "n", "p"}}, index}},
+	}}
+}
+
+// buildMongoFilter translates a typed message filter into a MongoDB query document
+func buildMongoFilter(filter models.MessageFilter) (bson.M, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	bsonFilter := bson.M{}
+	if len(filter.IDs) > 0 {
+		objectIDs := make([]primitive.ObjectID, 0, len(filter.IDs))
+		for _, id := range filter.IDs {
+			objectID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return nil, fmt.Errorf("%w in filter: %s", ErrInvalidMessageID, id)
+			}
+			objectIDs = append(objectIDs, objectID)
 		}
+		bsonFilter["_id"] = bson.M{"$in": objectIDs}
+	}
+
+	for _, field := range equalityFields(filter) {
+		if len(field.values) == 1 {
+			bsonFilter[field.name] = field.values[0]
+		} else {
+			bsonFilter[field.name] = bson.M{"$in": field.values}
+		}
+	}
+
+	if len(filter.ExcludeTypes) > 0 {
+		excluded := make([]string, 0, len(filter.ExcludeTypes))
+		for _, t := range filter.ExcludeTypes {
+			excluded = append(excluded, string(t))
+		}
+		switch included := bsonFilter["type"].(type) {
+		case nil:
+			bsonFilter["type"] = bson.M{"$nin": excluded}
+		case bson.M:
+			included["$nin"] = excluded
+		default:
+			bsonFilter["type"] = bson.M{"$eq": included, "$nin": excluded}
+		}
+	}
+
+	var topicClauses []bson.M
+	if filter.TopicPattern != "" {
+		if models.IsTopicWildcard(filter.TopicPattern) {
+			topicClauses = append(topicClauses, bson.M{"topic": bson.M{"$regex": topicPatternRegex(filter.TopicPattern)}})
+		} else {
+			topicClauses = append(topicClauses, bson.M{"topic": filter.TopicPattern})
+		}
+	}
+	if filter.TopicPrefix != "" {
+		topicClauses = append(topicClauses, bson.M{"topic": bson.M{"$regex": "^" + regexp.QuoteMeta(filter.TopicPrefix)}})
+	}
+	switch len(topicClauses) {
+	case 1:
+		bsonFilter["topic"] = topicClauses[0]["topic"]
+	case 2:
+		bsonFilter["$and"] = topicClauses
+	}
+
+	if filter.FromTime != nil || filter.ToTime != nil {
+		timeRange := bson.M{}
+		if filter.FromTime != nil {
+			timeRange["$gte"] = *filter.FromTime
+		}
+		if filter.ToTime != nil {
+			timeRange["$lte"] = *filter.ToTime
+		}
+		bsonFilter["timestamp"] = timeRange
+	}
+
+	return bsonFilter, nil
+}
+
+// topicPatternRegex converts an MQTT topic pattern into an anchored regular expression
+func topicPatternRegex(pattern string) string {
+	levels := strings.Split(pattern, "/")
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i, level := range levels {
+		switch level {
+		case "#":
+			if i == 0 {
+				sb.WriteString(".*")
+			} else {
+				sb.WriteString("(/.*)?")
+			}
+			sb.WriteString("$")
+			return sb.String()
+		case "+":
+			if i > 0 {
+				sb.WriteString("/")
+			}
+			sb.WriteString("[^/]*")
+		default:
+			if i > 0 {
+				sb.WriteString("/")
+			}
+			sb.WriteString(regexp.QuoteMeta(level))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+func (r *messageRepository) Create(ctx context.Context, message *models.Message) (*models.Message, error) {
+	created, err := r.CreateMany(ctx, []*models.Message{message})
+	if err != nil {
 		return nil, err
 	}
+	return created[0], nil
+}
 
-	// Flatten the nested aggregation structure for API response
-	var result []map[string]interface{}
-	for channel, variables := range agg.Aggregations {
-		for variable, periods := range variables {
-			for period, timestamps := range periods {
-				for ts, data := range timestamps {
-					result = append(result, map[string]interface{}{
-						"channel":   channel,
-						"variable":  variable,
-						"period":    period,
-						"timestamp": ts,
-						"min":       data.Min,
-						"max":       data.Max,
-						"avg":       data.Avg,
-						"sum":       data.Sum,
-						"count":     data.Count,
-					})
-				}
+func (r *messageRepository) CreateMany(ctx context.Context, messages []*models.Message) ([]*models.Message, error) {
+	documents := make([]interface{}, 0, len(messages))
+	created := make([]*models.Message, 0, len(messages))
+	generated := make([]bool, 0, len(messages))
+	for _, message := range messages {
+		stored := *message
+		generated = append(generated, stored.GetIDAsString() == "")
+		objectID, err := mongoObjectID(stored.ID)
+		if err != nil {
+			return nil, err
+		}
+		stored.SetIDFromObjectID(objectID)
+		documents = append(documents, &stored)
+		created = append(created, &stored)
+	}
+
+	if _, err := r.collection.InsertMany(ctx, documents, options.InsertMany().SetOrdered(true)); err != nil {
+		log.Printf("Error inserting documents: %v", err)
+		r.removeInserted(created, generated, err)
+		return nil, err
+	}
+	return created, nil
+}
+
+// removeInserted deletes the messages of a batch stored before its insertion failed, which
+// keeps CreateMany atomic without requiring a replica set for transactions. An ordered insert
+// stores the messages before the first failing one; when the failure is not known, only the
+// messages whose ID was generated here are deleted, as the others may belong to older messages.
+func (r *messageRepository) removeInserted(created []*models.Message, generated []bool, insertErr error) {
+	ids := make([]interface{}, 0, len(created))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(insertErr, &bulkErr) && len(bulkErr.WriteErrors) > 0 {
+		for _, message := range created[:bulkErr.WriteErrors[0].Index] {
+			ids = append(ids, message.ID)
+		}
+	} else {
+		for i, message := range created {
+			if generated[i] {
+				ids = append(ids, message.ID)
 			}
 		}
 	}
-	return result, nil
+	if len(ids) == 0 {
+		return
+	}
+
+	// The caller's context may be the one that expired
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := r.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		log.Printf("Error removing the %d messages of a failed batch: %v", len(ids), err)
+	}
+}
+
+func (r *messageRepository) Update(ctx context.Context, id string, update models.MessageUpdate) (*models.Message, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidMessageID
+	}
+
+	set := bson.M{"updatedAt": update.UpdatedAt}
+	if update.Status != nil {
+		set["status"] = *update.Status
+	}
+	if update.ProcessedAt != nil {
+		set["processedAt"] = *update.ProcessedAt
+	}
+	if update.Metadata != nil {
+		set["metadata"] = update.Metadata
+	}
+	if update.DeviceID != nil {
+		set["deviceId"] = *update.DeviceID
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var message models.Message
+	err = r.collection.FindOneAndUpdate(ctx, bson.M{"_id": objectID}, bson.M{"$set": set}, opts).Decode(&message)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	return &message, nil
+}
+
+func (r *messageRepository) Delete(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidMessageID
+	}
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+// mongoObjectID returns the ObjectID to store for a message ID, generating one when the ID is empty
+func mongoObjectID(id interface{}) (primitive.ObjectID, error) {
+	switch v := id.(type) {
+	case nil:
+		return primitive.NewObjectID(), nil
+	case primitive.ObjectID:
+		if v.IsZero() {
+			return primitive.NewObjectID(), nil
+		}
+		return v, nil
+	case string:
+		if v == "" {
+			return primitive.NewObjectID(), nil
+		}
+		objectID, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			return primitive.NilObjectID, ErrInvalidMessageID
+		}
+		return objectID, nil
+	default:
+		return primitive.NilObjectID, ErrInvalidMessageID
+	}
 }
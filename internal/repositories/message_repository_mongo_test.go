@@ -0,0 +1,129 @@
+package repositories
+
+import (
+	"fmt"
+	"regexp"
+	"sit-iot-message-mng-api/internal/models"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTopicPatternRegexMatchesMatchTopic(t *testing.T) {
+	patterns := []string{"#", "site/#", "site/+/telemetry", "site/+/+", "+/dev-1/#", "site/dev-1"}
+	topics := []string{"site", "site/", "site/dev-1", "site/dev-1/telemetry", "site/dev-1/telemetry/x", "other/dev-1/status", "site/rpc-status/x"}
+
+	for _, pattern := range patterns {
+		re := regexp.MustCompile(topicPatternRegex(pattern))
+		for _, topic := range topics {
+			if got, want := re.MatchString(topic), models.MatchTopic(pattern, topic); got != want {
+				t.Errorf("pattern %q topic %q: regex match = %v, MatchTopic = %v", pattern, topic, got, want)
+			}
+		}
+	}
+}
+
+func TestBuildMongoFilter(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	filter := models.MessageFilter{
+		ClientIDs: []string{"dev-1", "dev-2"},
+		Type:      models.MessageTypeTelemetry,
+		FromTime:  &from,
+	}
+
+	got, err := buildMongoFilter(filter)
+	if err != nil {
+		t.Fatalf("buildMongoFilter() unexpected error: %v", err)
+	}
+	if got["type"] != "telemetry" {
+		t.Errorf("buildMongoFilter() type = %v, want telemetry", got["type"])
+	}
+	if in, ok := got["client_id"].(bson.M)["$in"].([]string); !ok || len(in) != 2 {
+		t.Errorf("buildMongoFilter() client_id = %v, want $in of two values", got["client_id"])
+	}
+	if gte := got["timestamp"].(bson.M)["$gte"]; gte != from {
+		t.Errorf("buildMongoFilter() timestamp = %v", got["timestamp"])
+	}
+
+	if _, err := buildMongoFilter(models.MessageFilter{IDs: []string{"not-an-object-id"}}); err == nil {
+		t.Errorf("buildMongoFilter() expected error for invalid ObjectID")
+	}
+	if _, err := buildMongoFilter(models.MessageFilter{TopicPattern: "site/#/x"}); err == nil {
+		t.Errorf("buildMongoFilter() expected error for invalid topic pattern")
+	}
+}
+
+func TestBuildMongoSort(t *testing.T) {
+	tests := []struct {
+		sortField string
+		want      string
+	}{
+		{"timestamp", "[{timestamp -1} {_id -1}]"},
+		{"_id", "[{_id -1}]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(buildMongoSort(tt.sortField, -1)); got != tt.want {
+			t.Errorf("buildMongoSort(%s) = %s, want %s", tt.sortField, got, tt.want)
+		}
+	}
+}
+
+func TestBuildAggregationPipelineCountsSketchBins(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	query := models.AggregationQuery{DeviceID: "dev-1", From: from, To: from.Add(time.Hour), Bucket: models.Bucket1Hour, Extended: true}
+
+	var groups []bson.M
+	for _, stage := range buildAggregationPipeline(query) {
+		if stage[0].Key == "$group" {
+			groups = append(groups, stage[0].Value.(bson.M))
+		}
+	}
+	if len(groups) != 2 {
+		t.Fatalf("buildAggregationPipeline() has %d $group stages, want one per bin and one per bucket", len(groups))
+	}
+	if _, ok := groups[0]["_id"].(bson.M)["bin"]; !ok {
+		t.Errorf("buildAggregationPipeline() first $group is not keyed by sketch bin: %v", groups[0]["_id"])
+	}
+	for _, group := range groups {
+		if _, ok := group["values"]; ok {
+			t.Errorf("buildAggregationPipeline() collects the raw values: %v", group)
+		}
+	}
+
+	query.Extended = false
+	for _, stage := range buildAggregationPipeline(query) {
+		if stage[0].Key == "$group" {
+			if _, ok := stage[0].Value.(bson.M)["_id"].(bson.M)["bin"]; ok {
+				t.Errorf("buildAggregationPipeline() without extended statistics groups by sketch bin")
+			}
+		}
+	}
+}
+
+func TestBuildMongoFilterExcludesTypes(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter models.MessageFilter
+		want   string
+	}{
+		{"excluded only", models.MessageFilter{ExcludeTypes: models.DerivedMessageTypes}, "map[$nin:[alert]]"},
+		{"with a type", models.MessageFilter{Type: models.MessageTypeTelemetry, ExcludeTypes: models.DerivedMessageTypes}, "map[$eq:telemetry $nin:[alert]]"},
+		{"with types", models.MessageFilter{Types: []models.MessageType{models.MessageTypeTelemetry, models.MessageTypeAlert}, ExcludeTypes: models.DerivedMessageTypes}, "map[$in:[telemetry alert] $nin:[alert]]"},
+	}
+	for _, tt := range tests {
+		got, err := buildMongoFilter(tt.filter)
+		if err != nil {
+			t.Fatalf("buildMongoFilter(%s) unexpected error: %v", tt.name, err)
+		}
+		if fmt.Sprint(got["type"]) != tt.want {
+			t.Errorf("buildMongoFilter(%s) type = %v, want %s", tt.name, got["type"], tt.want)
+		}
+	}
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	match := buildAggregationPipeline(models.AggregationQuery{DeviceID: "dev-1", From: from, To: from.Add(time.Hour), Bucket: models.Bucket1Hour})[0][0].Value.(bson.M)
+	if fmt.Sprint(match["type"]) != "map[$nin:[alert]]" {
+		t.Errorf("buildAggregationPipeline() $match type = %v, want the alerts excluded", match["type"])
+	}
+}
@@ -0,0 +1,38 @@
+package repositories
+
+import (
+	"fmt"
+	"testing"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+func TestAggregationProjectionPaths(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter models.AggregationFilter
+		want   string
+	}{
+		{name: "no filter", want: "[]"},
+		{name: "period only cannot be pushed down", filter: models.AggregationFilter{Periods: []string{"hourly"}}, want: "[]"},
+		{name: "channel", filter: models.AggregationFilter{Channels: []string{"ch1"}}, want: "[[aggregations ch1]]"},
+		{
+			name:   "every level",
+			filter: models.AggregationFilter{Channels: []string{"ch1", "ch2"}, Variables: []string{"temperature"}, Periods: []string{"hourly"}},
+			want:   "[[aggregations ch1 temperature hourly] [aggregations ch2 temperature hourly]]",
+		},
+		{
+			name:   "stops at an unsafe key",
+			filter: models.AggregationFilter{Channels: []string{"ch1"}, Variables: []string{"temperature.tC"}},
+			want:   "[[aggregations ch1]]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fmt.Sprint(aggregationProjectionPaths(tt.filter)); got != tt.want {
+				t.Errorf("aggregationProjectionPaths() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+// PresenceRepository stores the current presence of the devices and its transitions
+type PresenceRepository interface {
+	FindPresence(ctx context.Context, deviceID string) (*models.DevicePresence, error)
+	// SavePresence creates or replaces the presence of a device
+	SavePresence(ctx context.Context, presence *models.DevicePresence) error
+	// ListPresence returns a page of the presences selected by the filter, most recently seen
+	// first, with the total number of selected presences
+	ListPresence(ctx context.Context, filter models.PresenceFilter, skip, limit int) ([]*models.DevicePresence, int, error)
+	// AddTransition stores a new transition under its ID
+	AddTransition(ctx context.Context, transition *models.PresenceTransition) error
+	// ListTransitions returns a page of the transitions of a device, newest first, optionally
+	// restricted to [from, to), with the total number of matching transitions
+	ListTransitions(ctx context.Context, deviceID string, from, to *time.Time, skip, limit int) ([]*models.PresenceTransition, int, error)
+}
+
+// ErrPresenceNotFound is returned by every PresenceRepository implementation for devices
+// that never sent a presence message
+var ErrPresenceNotFound = errors.New("device presence not found")
+
+// Collections of the presence repositories
+const (
+	presenceCollection           = "device_presence"
+	presenceTransitionCollection = "presence_transitions"
+)
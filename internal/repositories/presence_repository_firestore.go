@@ -0,0 +1,156 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type firestorePresenceRepository struct {
+	client *firestore.Client
+}
+
+// NewFirestorePresenceRepository creates a presence repository on Firestore. Listing the
+// presences of a project or state needs composite indexes on the filtered fields and lastSeen.
+func NewFirestorePresenceRepository(client *firestore.Client) PresenceRepository {
+	return &firestorePresenceRepository{client: client}
+}
+
+func (r *firestorePresenceRepository) FindPresence(ctx context.Context, deviceID string) (*models.DevicePresence, error) {
+	if deviceID == "" {
+		return nil, ErrPresenceNotFound
+	}
+	doc, err := r.client.Collection(presenceCollection).Doc(deviceID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrPresenceNotFound
+		}
+		return nil, err
+	}
+	var presence models.DevicePresence
+	if err := doc.DataTo(&presence); err != nil {
+		return nil, err
+	}
+	presence.DeviceID = doc.Ref.ID
+	return &presence, nil
+}
+
+func (r *firestorePresenceRepository) SavePresence(ctx context.Context, presence *models.DevicePresence) error {
+	if presence.DeviceID == "" {
+		return errors.New("device ID is required")
+	}
+	_, err := r.client.Collection(presenceCollection).Doc(presence.DeviceID).Set(ctx, presence)
+	return err
+}
+
+func (r *firestorePresenceRepository) ListPresence(ctx context.Context, filter models.PresenceFilter, skip, limit int) ([]*models.DevicePresence, int, error) {
+	query := r.client.Collection(presenceCollection).Query
+	if filter.ProjectID != "" {
+		query = query.Where("projectId", "==", filter.ProjectID)
+	}
+	if filter.State != "" {
+		query = query.Where("state", "==", string(filter.State))
+	}
+	if filter.SeenBefore != nil {
+		query = query.Where("lastSeen", "<", *filter.SeenBefore)
+	}
+
+	total, err := countQuery(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query = query.OrderBy("lastSeen", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc).Offset(skip)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+
+	var presences []*models.DevicePresence
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		var presence models.DevicePresence
+		if err := doc.DataTo(&presence); err != nil {
+			return nil, 0, err
+		}
+		presence.DeviceID = doc.Ref.ID
+		presences = append(presences, &presence)
+	}
+	return presences, total, nil
+}
+
+func (r *firestorePresenceRepository) AddTransition(ctx context.Context, transition *models.PresenceTransition) error {
+	if transition.ID == "" {
+		return errors.New("transition ID is required")
+	}
+	_, err := r.client.Collection(presenceTransitionCollection).Doc(transition.ID).Create(ctx, transition)
+	return err
+}
+
+func (r *firestorePresenceRepository) ListTransitions(ctx context.Context, deviceID string, from, to *time.Time, skip, limit int) ([]*models.PresenceTransition, int, error) {
+	query := r.client.Collection(presenceTransitionCollection).Where("deviceId", "==", deviceID)
+	if from != nil {
+		query = query.Where("at", ">=", *from)
+	}
+	if to != nil {
+		query = query.Where("at", "<", *to)
+	}
+
+	total, err := countQuery(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query = query.OrderBy("at", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc).Offset(skip)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+
+	var transitions []*models.PresenceTransition
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		var transition models.PresenceTransition
+		if err := doc.DataTo(&transition); err != nil {
+			return nil, 0, err
+		}
+		transition.ID = doc.Ref.ID
+		transitions = append(transitions, &transition)
+	}
+	return transitions, total, nil
+}
+
+// countQuery returns the number of documents selected by a query
+func countQuery(ctx context.Context, query firestore.Query) (int, error) {
+	result, err := query.NewAggregationQuery().WithCount("total").Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	total, ok := result["total"].(*firestorepb.Value)
+	if !ok {
+		return 0, errors.New("unexpected count aggregation result")
+	}
+	return int(total.GetIntegerValue()), nil
+}
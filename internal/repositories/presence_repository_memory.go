@@ -0,0 +1,115 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+type memoryPresenceRepository struct {
+	mu          sync.RWMutex
+	presences   map[string]models.DevicePresence
+	transitions map[string]models.PresenceTransition
+}
+
+// NewMemoryPresenceRepository creates an empty in-memory presence repository
+func NewMemoryPresenceRepository() PresenceRepository {
+	return &memoryPresenceRepository{
+		presences:   make(map[string]models.DevicePresence),
+		transitions: make(map[string]models.PresenceTransition),
+	}
+}
+
+func (r *memoryPresenceRepository) FindPresence(ctx context.Context, deviceID string) (*models.DevicePresence, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	presence, ok := r.presences[deviceID]
+	if !ok {
+		return nil, ErrPresenceNotFound
+	}
+	return &presence, nil
+}
+
+func (r *memoryPresenceRepository) SavePresence(ctx context.Context, presence *models.DevicePresence) error {
+	if presence.DeviceID == "" {
+		return errors.New("device ID is required")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.presences[presence.DeviceID] = *presence
+	return nil
+}
+
+func (r *memoryPresenceRepository) ListPresence(ctx context.Context, filter models.PresenceFilter, skip, limit int) ([]*models.DevicePresence, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var presences []*models.DevicePresence
+	for _, presence := range r.presences {
+		if filter.Matches(&presence) {
+			presence := presence
+			presences = append(presences, &presence)
+		}
+	}
+	sort.Slice(presences, func(i, j int) bool {
+		if !presences[i].LastSeen.Equal(presences[j].LastSeen) {
+			return presences[i].LastSeen.After(presences[j].LastSeen)
+		}
+		return presences[i].DeviceID > presences[j].DeviceID
+	})
+
+	total := len(presences)
+	if skip >= total {
+		return nil, total, nil
+	}
+	presences = presences[skip:]
+	if limit > 0 && len(presences) > limit {
+		presences = presences[:limit]
+	}
+	return presences, total, nil
+}
+
+func (r *memoryPresenceRepository) AddTransition(ctx context.Context, transition *models.PresenceTransition) error {
+	if transition.ID == "" {
+		return errors.New("transition ID is required")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.transitions[transition.ID]; ok {
+		return errors.New("transition already exists: " + transition.ID)
+	}
+	r.transitions[transition.ID] = *transition
+	return nil
+}
+
+func (r *memoryPresenceRepository) ListTransitions(ctx context.Context, deviceID string, from, to *time.Time, skip, limit int) ([]*models.PresenceTransition, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var transitions []*models.PresenceTransition
+	for _, transition := range r.transitions {
+		if transition.DeviceID != deviceID || (from != nil && transition.At.Before(*from)) || (to != nil && !transition.At.Before(*to)) {
+			continue
+		}
+		transition := transition
+		transitions = append(transitions, &transition)
+	}
+	sort.Slice(transitions, func(i, j int) bool {
+		if !transitions[i].At.Equal(transitions[j].At) {
+			return transitions[i].At.After(transitions[j].At)
+		}
+		return transitions[i].ID > transitions[j].ID
+	})
+
+	total := len(transitions)
+	if skip >= total {
+		return nil, total, nil
+	}
+	transitions = transitions[skip:]
+	if limit > 0 && len(transitions) > limit {
+		transitions = transitions[:limit]
+	}
+	return transitions, total, nil
+}
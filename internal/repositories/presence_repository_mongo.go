@@ -0,0 +1,121 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type presenceRepository struct {
+	presences   *mongo.Collection
+	transitions *mongo.Collection
+}
+
+func NewPresenceRepository(db *mongo.Database) PresenceRepository {
+	return &presenceRepository{
+		presences:   db.Collection(presenceCollection),
+		transitions: db.Collection(presenceTransitionCollection),
+	}
+}
+
+func (r *presenceRepository) FindPresence(ctx context.Context, deviceID string) (*models.DevicePresence, error) {
+	var presence models.DevicePresence
+	if err := r.presences.FindOne(ctx, bson.M{"_id": deviceID}).Decode(&presence); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrPresenceNotFound
+		}
+		return nil, err
+	}
+	return &presence, nil
+}
+
+func (r *presenceRepository) SavePresence(ctx context.Context, presence *models.DevicePresence) error {
+	if presence.DeviceID == "" {
+		return errors.New("device ID is required")
+	}
+	_, err := r.presences.ReplaceOne(ctx, bson.M{"_id": presence.DeviceID}, presence, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r *presenceRepository) ListPresence(ctx context.Context, filter models.PresenceFilter, skip, limit int) ([]*models.DevicePresence, int, error) {
+	query := bson.M{}
+	if filter.ProjectID != "" {
+		query["projectId"] = filter.ProjectID
+	}
+	if filter.State != "" {
+		query["state"] = filter.State
+	}
+	if filter.SeenBefore != nil {
+		query["lastSeen"] = bson.M{"$lt": *filter.SeenBefore}
+	}
+
+	total, err := r.presences.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "lastSeen", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(skip))
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := r.presences.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	var presences []*models.DevicePresence
+	if err := cursor.All(ctx, &presences); err != nil {
+		return nil, 0, err
+	}
+	return presences, int(total), nil
+}
+
+func (r *presenceRepository) AddTransition(ctx context.Context, transition *models.PresenceTransition) error {
+	if transition.ID == "" {
+		return errors.New("transition ID is required")
+	}
+	_, err := r.transitions.InsertOne(ctx, transition)
+	return err
+}
+
+func (r *presenceRepository) ListTransitions(ctx context.Context, deviceID string, from, to *time.Time, skip, limit int) ([]*models.PresenceTransition, int, error) {
+	query := bson.M{"deviceId": deviceID}
+	if from != nil || to != nil {
+		at := bson.M{}
+		if from != nil {
+			at["$gte"] = *from
+		}
+		if to != nil {
+			at["$lt"] = *to
+		}
+		query["at"] = at
+	}
+
+	total, err := r.transitions.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(skip))
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := r.transitions.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	var transitions []*models.PresenceTransition
+	if err := cursor.All(ctx, &transitions); err != nil {
+		return nil, 0, err
+	}
+	return transitions, int(total), nil
+}
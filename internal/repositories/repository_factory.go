@@ -11,6 +11,7 @@ import (
 // RepositoryFactory provides a way to create repositories based on configuration
 type RepositoryFactory struct {
 	config *config.Config
+	memory *memoryMessageRepository // Shared by the in-memory message and aggregation repositories
 }
 
 // NewRepositoryFactory creates a new repository factory
@@ -33,11 +34,128 @@ func (f *RepositoryFactory) CreateMessageRepository(mongoClient *mongo.Database,
 			return nil, errors.New("Firestore client is required when using Firestore provider")
 		}
 		return NewFirestoreMessageRepository(firestoreClient), nil
+	case "memory":
+		// The in-memory repository needs no client and starts empty
+		return f.memoryRepository(), nil
 	default:
 		return nil, errors.New("unsupported database provider: " + f.config.DatabaseProvider)
 	}
 }
 
+// CreateAggregationRepository creates the repository maintaining the precomputed aggregations
+// with the configured database provider
+func (f *RepositoryFactory) CreateAggregationRepository(mongoClient *mongo.Database, firestoreClient *firestore.Client) (AggregationRepository, error) {
+	switch f.config.DatabaseProvider {
+	case "mongo", "mongodb":
+		if mongoClient == nil {
+			return nil, errors.New("MongoDB client is required when using MongoDB provider")
+		}
+		return NewAggregationRepository(mongoClient)
+	case "firestore":
+		if firestoreClient == nil {
+			return nil, errors.New("Firestore client is required when using Firestore provider")
+		}
+		return NewFirestoreAggregationRepository(firestoreClient), nil
+	case "memory":
+		// Rollups must land in the store read by the message repository
+		return f.memoryRepository(), nil
+	default:
+		return nil, errors.New("unsupported database provider: " + f.config.DatabaseProvider)
+	}
+}
+
+// CreateWebhookRepository creates the repository of the webhook subscriptions and their
+// deliveries with the configured database provider
+func (f *RepositoryFactory) CreateWebhookRepository(mongoClient *mongo.Database, firestoreClient *firestore.Client) (WebhookRepository, error) {
+	switch f.config.DatabaseProvider {
+	case "mongo", "mongodb":
+		if mongoClient == nil {
+			return nil, errors.New("MongoDB client is required when using MongoDB provider")
+		}
+		return NewWebhookRepository(mongoClient), nil
+	case "firestore":
+		if firestoreClient == nil {
+			return nil, errors.New("Firestore client is required when using Firestore provider")
+		}
+		return NewFirestoreWebhookRepository(firestoreClient), nil
+	case "memory":
+		return NewMemoryWebhookRepository(), nil
+	default:
+		return nil, errors.New("unsupported database provider: " + f.config.DatabaseProvider)
+	}
+}
+
+// CreateAlertRepository creates the repository of the alert rules and their alerts with the
+// configured database provider
+func (f *RepositoryFactory) CreateAlertRepository(mongoClient *mongo.Database, firestoreClient *firestore.Client) (AlertRepository, error) {
+	switch f.config.DatabaseProvider {
+	case "mongo", "mongodb":
+		if mongoClient == nil {
+			return nil, errors.New("MongoDB client is required when using MongoDB provider")
+		}
+		return NewAlertRepository(mongoClient), nil
+	case "firestore":
+		if firestoreClient == nil {
+			return nil, errors.New("Firestore client is required when using Firestore provider")
+		}
+		return NewFirestoreAlertRepository(firestoreClient), nil
+	case "memory":
+		return NewMemoryAlertRepository(), nil
+	default:
+		return nil, errors.New("unsupported database provider: " + f.config.DatabaseProvider)
+	}
+}
+
+// CreatePresenceRepository creates the repository of the presence of the devices with the
+// configured database provider
+func (f *RepositoryFactory) CreatePresenceRepository(mongoClient *mongo.Database, firestoreClient *firestore.Client) (PresenceRepository, error) {
+	switch f.config.DatabaseProvider {
+	case "mongo", "mongodb":
+		if mongoClient == nil {
+			return nil, errors.New("MongoDB client is required when using MongoDB provider")
+		}
+		return NewPresenceRepository(mongoClient), nil
+	case "firestore":
+		if firestoreClient == nil {
+			return nil, errors.New("Firestore client is required when using Firestore provider")
+		}
+		return NewFirestorePresenceRepository(firestoreClient), nil
+	case "memory":
+		return NewMemoryPresenceRepository(), nil
+	default:
+		return nil, errors.New("unsupported database provider: " + f.config.DatabaseProvider)
+	}
+}
+
+// CreateDeviceRepository creates the repository of the device registry with the configured
+// database provider
+func (f *RepositoryFactory) CreateDeviceRepository(mongoClient *mongo.Database, firestoreClient *firestore.Client) (DeviceRepository, error) {
+	switch f.config.DatabaseProvider {
+	case "mongo", "mongodb":
+		if mongoClient == nil {
+			return nil, errors.New("MongoDB client is required when using MongoDB provider")
+		}
+		return NewDeviceRepository(mongoClient)
+	case "firestore":
+		if firestoreClient == nil {
+			return nil, errors.New("Firestore client is required when using Firestore provider")
+		}
+		return NewFirestoreDeviceRepository(firestoreClient), nil
+	case "memory":
+		return NewMemoryDeviceRepository(), nil
+	default:
+		return nil, errors.New("unsupported database provider: " + f.config.DatabaseProvider)
+	}
+}
+
+// memoryRepository returns the in-memory repository of this factory, creating it empty
+func (f *RepositoryFactory) memoryRepository() *memoryMessageRepository {
+	if f.memory == nil {
+		f.memory = NewMemoryMessageRepository(nil, nil).(*memoryMessageRepository)
+	}
+	return f.memory
+}
+
 // GetDatabaseProvider returns the configured database provider
 func (f *RepositoryFactory) GetDatabaseProvider() string {
 	return f.config.DatabaseProvider
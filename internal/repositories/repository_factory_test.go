@@ -7,9 +7,10 @@ import (
 
 func TestRepositoryFactory(t *testing.T) {
 	tests := []struct {
-		name     string
-		provider string
-		wantErr  bool
+		name       string
+		provider   string
+		wantErr    bool
+		clientless bool
 	}{
 		{
 			name:     "MongoDB provider",
@@ -26,6 +27,12 @@ func TestRepositoryFactory(t *testing.T) {
 			provider: "firestore",
 			wantErr:  false,
 		},
+		{
+			name:       "Memory provider",
+			provider:   "memory",
+			wantErr:    false,
+			clientless: true,
+		},
 		{
 			name:     "Invalid provider",
 			provider: "invalid",
@@ -51,9 +58,44 @@ func TestRepositoryFactory(t *testing.T) {
 				t.Errorf("CreateMessageRepository() expected error but got none")
 			}
 
-			if !tt.wantErr && err == nil {
+			if !tt.wantErr && !tt.clientless && err == nil {
 				t.Errorf("CreateMessageRepository() should fail without DB clients")
 			}
+
+			if tt.clientless && err != nil {
+				t.Errorf("CreateMessageRepository() unexpected error: %v", err)
+			}
+
+			aggRepo, err := factory.CreateAggregationRepository(nil, nil)
+			if (err != nil) != (tt.wantErr || !tt.clientless) {
+				t.Errorf("CreateAggregationRepository() error = %v", err)
+			}
+			if tt.clientless {
+				// Rollups must be read back by the message repository
+				if messageRepo, _ := factory.CreateMessageRepository(nil, nil); messageRepo != MessageRepository(aggRepo.(*memoryMessageRepository)) {
+					t.Errorf("CreateAggregationRepository() does not share the memory message repository")
+				}
+			}
+
+			_, err = factory.CreateWebhookRepository(nil, nil)
+			if (err != nil) != (tt.wantErr || !tt.clientless) {
+				t.Errorf("CreateWebhookRepository() error = %v", err)
+			}
+
+			_, err = factory.CreateAlertRepository(nil, nil)
+			if (err != nil) != (tt.wantErr || !tt.clientless) {
+				t.Errorf("CreateAlertRepository() error = %v", err)
+			}
+
+			_, err = factory.CreatePresenceRepository(nil, nil)
+			if (err != nil) != (tt.wantErr || !tt.clientless) {
+				t.Errorf("CreatePresenceRepository() error = %v", err)
+			}
+
+			_, err = factory.CreateDeviceRepository(nil, nil)
+			if (err != nil) != (tt.wantErr || !tt.clientless) {
+				t.Errorf("CreateDeviceRepository() error = %v", err)
+			}
 		})
 	}
 }
@@ -0,0 +1,300 @@
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+// NewAggregationRepositoryFunc builds an aggregation repository together with a message
+// repository reading the same aggregations, seeded with legacy aggregations documents
+// holding every bucket of their device
+type NewAggregationRepositoryFunc func(t *testing.T, legacy []*models.ClientAggregations) (repositories.AggregationRepository, repositories.MessageRepository)
+
+// RunAggregationRepositoryConformance checks that rollups are applied once and read back
+// through GetAggregatedDataByDeviceID, merged with the legacy documents
+func RunAggregationRepositoryConformance(t *testing.T, newRepos NewAggregationRepositoryFunc) {
+	ctx := context.Background()
+
+	t.Run("ApplyRollups", func(t *testing.T) {
+		aggRepo, messageRepo := newRepos(t, nil)
+
+		base := time.Date(2024, 3, 5, 10, 15, 0, 0, time.UTC)
+		messages := []struct {
+			id      string
+			offset  time.Duration
+			payload map[string]interface{}
+		}{
+			{fixtureID1, 0, map[string]interface{}{"temperature": 20.0, "label": "a"}},
+			{fixtureID2, 30 * time.Minute, map[string]interface{}{"temperature": 30.0}},
+			{fixtureID3, 55 * time.Minute, map[string]interface{}{"temperature": 25.0, "humidity": 50.0}},
+		}
+		var rollups []models.MessageRollup
+		for _, m := range messages {
+			message := &models.Message{
+				DeviceID:   "dev-9",
+				Timestamp:  base.Add(m.offset),
+				Metadata:   map[string]string{"channel": "ch1"},
+				Marshalled: m.payload,
+			}
+			message.SetIDFromString(m.id)
+			rollups = append(rollups, models.NewMessageRollup(message))
+		}
+		other := models.MessageRollup{MessageID: fixtureID4, DeviceID: "dev-8", Samples: []models.RollupSample{
+			{Channel: "ch1", Variable: "temperature", Period: models.PeriodHourly, Key: "2024-03-05T10", Value: 99, Timestamp: base},
+		}}
+
+		if applied, err := aggRepo.ApplyRollups(ctx, rollups[:1]); err != nil || applied != 1 {
+			t.Fatalf("ApplyRollups(first) = %d, %v; want 1, nil", applied, err)
+		}
+		// The first message is already applied and the second one repeated in the batch
+		batch := []models.MessageRollup{rollups[1], rollups[0], other, rollups[2], rollups[1]}
+		if applied, err := aggRepo.ApplyRollups(ctx, batch); err != nil || applied != 3 {
+			t.Fatalf("ApplyRollups(batch) = %d, %v; want 3, nil", applied, err)
+		}
+		// Reprocessing messages must not count them twice
+		if applied, err := aggRepo.ApplyRollups(ctx, batch); err != nil || applied != 0 {
+			t.Fatalf("ApplyRollups(batch) again = %d, %v; want 0, nil", applied, err)
+		}
+		if rows, err := messageRepo.GetAggregatedDataByDeviceID(ctx, "dev-8", models.AggregationFilter{}); err != nil || len(rows) != 1 || rows[0].Sum != 99 {
+			t.Errorf("GetAggregatedDataByDeviceID(dev-8) = %v, %v; want the bucket of the other device", rows, err)
+		}
+
+		rows, err := messageRepo.GetAggregatedDataByDeviceID(ctx, "dev-9", models.AggregationFilter{})
+		if err != nil {
+			t.Fatalf("GetAggregatedDataByDeviceID() unexpected error: %v", err)
+		}
+		var got []string
+		for _, row := range rows {
+			got = append(got, fmt.Sprintf("%s/%s/%s/%s count=%d sum=%v min=%v max=%v avg=%v", row.Channel, row.Variable, row.Period, row.Timestamp.UTC().Format(time.RFC3339), row.Count, row.Sum, row.Min, row.Max, row.Avg))
+		}
+		want := []string{
+			"ch1/humidity/monthly/2024-03-01T00:00:00Z count=1 sum=50 min=50 max=50 avg=50",
+			"ch1/temperature/monthly/2024-03-01T00:00:00Z count=3 sum=75 min=20 max=30 avg=25",
+			"ch1/humidity/daily/2024-03-05T00:00:00Z count=1 sum=50 min=50 max=50 avg=50",
+			"ch1/temperature/daily/2024-03-05T00:00:00Z count=3 sum=75 min=20 max=30 avg=25",
+			"ch1/temperature/hourly/2024-03-05T10:00:00Z count=2 sum=50 min=20 max=30 avg=25",
+			"ch1/humidity/hourly/2024-03-05T11:00:00Z count=1 sum=50 min=50 max=50 avg=50",
+			"ch1/temperature/hourly/2024-03-05T11:00:00Z count=1 sum=25 min=25 max=25 avg=25",
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("GetAggregatedDataByDeviceID() after rollups = %v, want %v", got, want)
+		}
+
+		// The rollups keep the state behind the extended statistics
+		for _, row := range rows {
+			if row.Variable != "temperature" || row.Period != "daily" {
+				continue
+			}
+			row.WithExtendedStats()
+			stats := row.ExtendedStats
+			if stats.First == nil || *stats.First != 20 || !stats.FirstAt.Equal(base) || stats.Last == nil || *stats.Last != 25 || !stats.LastAt.Equal(base.Add(55*time.Minute)) {
+				t.Errorf("daily first/last = %+v, want 20 at %v and 25 at %v", stats, base, base.Add(55*time.Minute))
+			}
+			if stats.StdDev == nil || fmt.Sprintf("%.4f", *stats.StdDev) != "4.0825" {
+				t.Errorf("daily stddev = %v, want 4.0825", stats.StdDev)
+			}
+			if stats.P50 == nil || *stats.P50 < 24.5 || *stats.P50 > 25.5 {
+				t.Errorf("daily p50 = %v, want about 25", stats.P50)
+			}
+		}
+	})
+
+	t.Run("ReplaceBuckets", func(t *testing.T) {
+		aggRepo, messageRepo := newRepos(t, nil)
+
+		for id, timestamp := range map[string]time.Time{
+			fixtureID1: time.Date(2024, 3, 5, 10, 15, 0, 0, time.UTC),
+			fixtureID2: time.Date(2024, 4, 2, 8, 0, 0, 0, time.UTC),
+		} {
+			message := &models.Message{DeviceID: "dev-9", Timestamp: timestamp, Marshalled: map[string]interface{}{"temperature": 20.0}}
+			message.SetIDFromString(id)
+			if _, err := aggRepo.ApplyRollups(ctx, []models.MessageRollup{models.NewMessageRollup(message)}); err != nil {
+				t.Fatalf("ApplyRollups(%s) unexpected error: %v", id, err)
+			}
+		}
+
+		// March is recomputed from two messages, one of them arriving late
+		replacement := &models.ClientAggregations{ClientID: "dev-9"}
+		late := time.Date(2024, 3, 6, 0, 30, 0, 0, time.UTC)
+		for _, sample := range []struct {
+			timestamp time.Time
+			value     float64
+		}{{time.Date(2024, 3, 5, 10, 15, 0, 0, time.UTC), 20}, {late, 40}} {
+			for _, period := range models.RollupPeriods {
+				replacement.Apply(models.RollupSample{Channel: models.DefaultChannel, Variable: "temperature", Period: period, Key: period.Key(sample.timestamp), Value: sample.value})
+			}
+		}
+		from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+		to := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+		snapshot, err := aggRepo.SnapshotBuckets(ctx, "dev-9", from, to)
+		if err != nil {
+			t.Fatalf("SnapshotBuckets() unexpected error: %v", err)
+		}
+		if err := aggRepo.ReplaceBuckets(ctx, snapshot, replacement, []string{fixtureID1, fixtureID3}); err != nil {
+			t.Fatalf("ReplaceBuckets() unexpected error: %v", err)
+		}
+
+		rows, err := messageRepo.GetAggregatedDataByDeviceID(ctx, "dev-9", models.AggregationFilter{})
+		if err != nil {
+			t.Fatalf("GetAggregatedDataByDeviceID() unexpected error: %v", err)
+		}
+		var got []string
+		for _, row := range rows {
+			got = append(got, fmt.Sprintf("%s/%s/%s count=%d sum=%v avg=%v", row.Variable, row.Period, row.Timestamp.UTC().Format(time.RFC3339), row.Count, row.Sum, row.Avg))
+		}
+		want := []string{
+			"temperature/monthly/2024-03-01T00:00:00Z count=2 sum=60 avg=30",
+			"temperature/daily/2024-03-05T00:00:00Z count=1 sum=20 avg=20",
+			"temperature/hourly/2024-03-05T10:00:00Z count=1 sum=20 avg=20",
+			"temperature/daily/2024-03-06T00:00:00Z count=1 sum=40 avg=40",
+			"temperature/hourly/2024-03-06T00:00:00Z count=1 sum=40 avg=40",
+			"temperature/monthly/2024-04-01T00:00:00Z count=1 sum=20 avg=20",
+			"temperature/daily/2024-04-02T00:00:00Z count=1 sum=20 avg=20",
+			"temperature/hourly/2024-04-02T08:00:00Z count=1 sum=20 avg=20",
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("GetAggregatedDataByDeviceID() after ReplaceBuckets = %v, want %v", got, want)
+		}
+
+		// The late message is recorded as applied, so its queued rollup is ignored
+		lateMessage := &models.Message{DeviceID: "dev-9", Timestamp: late, Marshalled: map[string]interface{}{"temperature": 40.0}}
+		lateMessage.SetIDFromString(fixtureID3)
+		if applied, err := aggRepo.ApplyRollups(ctx, []models.MessageRollup{models.NewMessageRollup(lateMessage)}); err != nil || applied != 0 {
+			t.Errorf("ApplyRollups(late) = %d, %v; want 0, nil", applied, err)
+		}
+
+		// A replacement computed before further rollups is refused, and its messages are not
+		// recorded as applied
+		april, err := aggRepo.SnapshotBuckets(ctx, "dev-9", to, to.AddDate(0, 1, 0))
+		if err != nil {
+			t.Fatalf("SnapshotBuckets(april) unexpected error: %v", err)
+		}
+		aprilMessage := &models.Message{DeviceID: "dev-9", Timestamp: time.Date(2024, 4, 3, 9, 0, 0, 0, time.UTC), Marshalled: map[string]interface{}{"temperature": 30.0}}
+		aprilMessage.SetIDFromString(fixtureID4)
+		if applied, err := aggRepo.ApplyRollups(ctx, []models.MessageRollup{models.NewMessageRollup(aprilMessage)}); err != nil || applied != 1 {
+			t.Fatalf("ApplyRollups(april) = %d, %v; want 1, nil", applied, err)
+		}
+		empty := &models.ClientAggregations{ClientID: "dev-9"}
+		if err := aggRepo.ReplaceBuckets(ctx, april, empty, []string{fixtureID5}); !errors.Is(err, repositories.ErrAggregationsChanged) {
+			t.Fatalf("ReplaceBuckets(stale snapshot) error = %v, want %v", err, repositories.ErrAggregationsChanged)
+		}
+		released := models.MessageRollup{MessageID: fixtureID5, DeviceID: "dev-9"}
+		if applied, err := aggRepo.ApplyRollups(ctx, []models.MessageRollup{released}); err != nil || applied != 1 {
+			t.Errorf("ApplyRollups() of a refused replacement = %d, %v; want 1, nil", applied, err)
+		}
+
+		// A replacement after further rollups applies to the updated aggregations
+		if april, err = aggRepo.SnapshotBuckets(ctx, "dev-9", to, to.AddDate(0, 1, 0)); err != nil {
+			t.Fatalf("SnapshotBuckets(april) unexpected error: %v", err)
+		}
+		if err := aggRepo.ReplaceBuckets(ctx, april, empty, nil); err != nil {
+			t.Fatalf("ReplaceBuckets(april) unexpected error: %v", err)
+		}
+		rows, err = messageRepo.GetAggregatedDataByDeviceID(ctx, "dev-9", models.AggregationFilter{})
+		if err != nil || len(rows) != 5 {
+			t.Errorf("GetAggregatedDataByDeviceID() after clearing April = %d rows, %v; want the 5 March buckets", len(rows), err)
+		}
+	})
+
+	t.Run("ApplyRollups without samples", func(t *testing.T) {
+		aggRepo, _ := newRepos(t, nil)
+
+		rollup := models.MessageRollup{MessageID: fixtureID4, DeviceID: "dev-9"}
+		if applied, err := aggRepo.ApplyRollups(ctx, []models.MessageRollup{rollup}); err != nil || applied != 1 {
+			t.Fatalf("ApplyRollups() = %d, %v; want 1, nil", applied, err)
+		}
+		if applied, err := aggRepo.ApplyRollups(ctx, []models.MessageRollup{rollup}); err != nil || applied != 0 {
+			t.Fatalf("ApplyRollups() again = %d, %v; want 0, nil", applied, err)
+		}
+		for _, invalid := range []models.MessageRollup{
+			{DeviceID: "dev-9"},
+			{MessageID: fixtureID5, DeviceID: "dev-9", Samples: []models.RollupSample{{Channel: "ch1", Variable: "v", Period: models.PeriodDaily, Key: "2024-03"}}},
+		} {
+			if _, err := aggRepo.ApplyRollups(ctx, []models.MessageRollup{invalid}); err == nil {
+				t.Errorf("ApplyRollups(%+v) expected an error", invalid)
+			}
+		}
+	})
+
+	t.Run("legacy documents", func(t *testing.T) {
+		legacy := &models.ClientAggregations{ClientID: "dev-9"}
+		for _, timestamp := range []time.Time{time.Date(2024, 2, 10, 8, 0, 0, 0, time.UTC), time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)} {
+			for _, period := range models.RollupPeriods {
+				legacy.Apply(models.RollupSample{Channel: models.DefaultChannel, Variable: "temperature", Period: period, Key: period.Key(timestamp), Value: 10, Timestamp: timestamp})
+			}
+		}
+		aggRepo, messageRepo := newRepos(t, []*models.ClientAggregations{legacy})
+		read := func(filter models.AggregationFilter) []string {
+			t.Helper()
+			rows, err := messageRepo.GetAggregatedDataByDeviceID(ctx, "dev-9", filter)
+			if err != nil {
+				t.Fatalf("GetAggregatedDataByDeviceID() unexpected error: %v", err)
+			}
+			got := []string{}
+			for _, row := range rows {
+				got = append(got, fmt.Sprintf("%s/%s count=%d sum=%v", row.Period, row.Timestamp.UTC().Format(time.RFC3339), row.Count, row.Sum))
+			}
+			return got
+		}
+
+		// Rollups land next to the legacy document and both are read together
+		message := &models.Message{DeviceID: "dev-9", Timestamp: time.Date(2024, 3, 5, 10, 30, 0, 0, time.UTC), Marshalled: map[string]interface{}{"temperature": 20.0}}
+		message.SetIDFromString(fixtureID1)
+		if applied, err := aggRepo.ApplyRollups(ctx, []models.MessageRollup{models.NewMessageRollup(message)}); err != nil || applied != 1 {
+			t.Fatalf("ApplyRollups() = %d, %v; want 1, nil", applied, err)
+		}
+		want := []string{
+			"monthly/2024-02-01T00:00:00Z count=1 sum=10",
+			"daily/2024-02-10T00:00:00Z count=1 sum=10",
+			"hourly/2024-02-10T08:00:00Z count=1 sum=10",
+			"monthly/2024-03-01T00:00:00Z count=2 sum=30",
+			"daily/2024-03-05T00:00:00Z count=2 sum=30",
+			"hourly/2024-03-05T10:00:00Z count=2 sum=30",
+		}
+		if got := read(models.AggregationFilter{}); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("GetAggregatedDataByDeviceID() = %v, want %v", got, want)
+		}
+		from, to := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)
+		want = []string{"hourly/2024-03-05T10:00:00Z count=2 sum=30"}
+		if got := read(models.AggregationFilter{Periods: []string{"hourly"}, FromTime: &from, ToTime: &to}); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("GetAggregatedDataByDeviceID(hourly, March) = %v, want %v", got, want)
+		}
+		later := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+		if got := read(models.AggregationFilter{FromTime: &later}); len(got) != 0 {
+			t.Errorf("GetAggregatedDataByDeviceID(2030) = %v, want no buckets", got)
+		}
+		if _, err := messageRepo.GetAggregatedDataByDeviceID(ctx, "dev-unknown", models.AggregationFilter{}); !errors.Is(err, repositories.ErrAggregationsNotFound) {
+			t.Errorf("GetAggregatedDataByDeviceID(unknown) error = %v, want %v", err, repositories.ErrAggregationsNotFound)
+		}
+
+		// Replacing March also clears it from the legacy document
+		replacement := &models.ClientAggregations{ClientID: "dev-9"}
+		for _, sample := range models.NewMessageRollup(message).Samples {
+			replacement.Apply(sample)
+		}
+		snapshot, err := aggRepo.SnapshotBuckets(ctx, "dev-9", from, from.AddDate(0, 1, 0))
+		if err != nil {
+			t.Fatalf("SnapshotBuckets() unexpected error: %v", err)
+		}
+		if err := aggRepo.ReplaceBuckets(ctx, snapshot, replacement, []string{fixtureID1}); err != nil {
+			t.Fatalf("ReplaceBuckets() unexpected error: %v", err)
+		}
+		want = []string{
+			"monthly/2024-02-01T00:00:00Z count=1 sum=10",
+			"daily/2024-02-10T00:00:00Z count=1 sum=10",
+			"hourly/2024-02-10T08:00:00Z count=1 sum=10",
+			"monthly/2024-03-01T00:00:00Z count=1 sum=20",
+			"daily/2024-03-05T00:00:00Z count=1 sum=20",
+			"hourly/2024-03-05T10:00:00Z count=1 sum=20",
+		}
+		if got := read(models.AggregationFilter{}); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("GetAggregatedDataByDeviceID() after ReplaceBuckets = %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+// NewAlertRepositoryFunc builds an empty alert repository
+type NewAlertRepositoryFunc func(t *testing.T) repositories.AlertRepository
+
+// RunAlertRepositoryConformance checks the rule CRUD and the alert lookups and listing
+func RunAlertRepositoryConformance(t *testing.T, newRepo NewAlertRepositoryFunc) {
+	ctx := context.Background()
+
+	t.Run("Rules", func(t *testing.T) {
+		repo := newRepo(t)
+		rule := func(id, projectID string, minutes int) *models.AlertRule {
+			at := FixtureBase.Add(time.Duration(minutes) * time.Minute)
+			return &models.AlertRule{
+				ID:         id,
+				ProjectID:  projectID,
+				Name:       "hot " + id,
+				Kind:       models.AlertRuleThreshold,
+				Variable:   "temperature",
+				Operator:   ">",
+				Threshold:  80.5,
+				ForSeconds: 300,
+				Severity:   models.AlertSeverityWarning,
+				Enabled:    true,
+				CreatedAt:  at,
+				UpdatedAt:  at,
+			}
+		}
+		for _, r := range []*models.AlertRule{rule("r-2", "p1", 2), rule("r-1", "p1", 1), rule("r-3", "p2", 3)} {
+			if err := repo.CreateRule(ctx, r); err != nil {
+				t.Fatalf("CreateRule(%s) unexpected error: %v", r.ID, err)
+			}
+		}
+
+		found, err := repo.FindRule(ctx, "r-1")
+		if err != nil {
+			t.Fatalf("FindRule() unexpected error: %v", err)
+		}
+		if found.ID != "r-1" || found.ProjectID != "p1" || found.Threshold != 80.5 || found.ForSeconds != 300 || !found.Enabled ||
+			found.Kind != models.AlertRuleThreshold || !found.CreatedAt.Equal(FixtureBase.Add(time.Minute)) {
+			t.Errorf("FindRule() = %+v", found)
+		}
+		if _, err := repo.FindRule(ctx, "missing"); !errors.Is(err, repositories.ErrAlertRuleNotFound) {
+			t.Errorf("FindRule(missing) error = %v, want %v", err, repositories.ErrAlertRuleNotFound)
+		}
+
+		for projectID, want := range map[string]string{"p1": "[r-1 r-2]", "p2": "[r-3]", "": "[r-1 r-2 r-3]", "p3": "[]"} {
+			rules, err := repo.ListRules(ctx, projectID)
+			if err != nil {
+				t.Fatalf("ListRules(%q) unexpected error: %v", projectID, err)
+			}
+			ids := []string{}
+			for _, r := range rules {
+				ids = append(ids, r.ID)
+			}
+			if got := fmt.Sprint(ids); got != want {
+				t.Errorf("ListRules(%q) = %s, want %s", projectID, got, want)
+			}
+		}
+
+		found.Enabled = false
+		found.DeviceID = "dev-1"
+		if err := repo.UpdateRule(ctx, found); err != nil {
+			t.Fatalf("UpdateRule() unexpected error: %v", err)
+		}
+		if updated, err := repo.FindRule(ctx, "r-1"); err != nil || updated.Enabled || updated.DeviceID != "dev-1" {
+			t.Errorf("FindRule() after update = %+v, %v", updated, err)
+		}
+		if err := repo.UpdateRule(ctx, rule("missing", "p1", 0)); !errors.Is(err, repositories.ErrAlertRuleNotFound) {
+			t.Errorf("UpdateRule(missing) error = %v, want %v", err, repositories.ErrAlertRuleNotFound)
+		}
+
+		if err := repo.DeleteRule(ctx, "r-1"); err != nil {
+			t.Fatalf("DeleteRule() unexpected error: %v", err)
+		}
+		if err := repo.DeleteRule(ctx, "r-1"); !errors.Is(err, repositories.ErrAlertRuleNotFound) {
+			t.Errorf("DeleteRule() twice error = %v, want %v", err, repositories.ErrAlertRuleNotFound)
+		}
+	})
+
+	t.Run("Alerts", func(t *testing.T) {
+		repo := newRepo(t)
+		value := 85.5
+		fixtures := []struct {
+			id, projectID, deviceID, ruleID string
+			state                           models.AlertState
+		}{
+			{"a-0", "p1", "dev-1", "r-1", models.AlertStateResolved},
+			{"a-1", "p1", "dev-1", "r-1", models.AlertStateAcknowledged},
+			{"a-2", "p1", "dev-2", "r-1", models.AlertStateOpen},
+			{"a-3", "p1", "dev-1", "r-2", models.AlertStateOpen},
+			{"a-4", "p2", "dev-3", "r-3", models.AlertStateResolved},
+		}
+		for i, f := range fixtures {
+			at := FixtureBase.Add(time.Duration(i) * time.Minute)
+			alert := &models.Alert{
+				ID:              f.id,
+				RuleID:          f.ruleID,
+				ProjectID:       f.projectID,
+				DeviceID:        f.deviceID,
+				Kind:            models.AlertRuleThreshold,
+				Severity:        models.AlertSeverityCritical,
+				State:           f.state,
+				Value:           &value,
+				Occurrences:     1,
+				OpenedAt:        at,
+				LastTriggeredAt: at,
+				UpdatedAt:       at,
+			}
+			if err := repo.CreateAlert(ctx, alert); err != nil {
+				t.Fatalf("CreateAlert(%s) unexpected error: %v", f.id, err)
+			}
+		}
+
+		found, err := repo.FindAlert(ctx, "a-2")
+		if err != nil {
+			t.Fatalf("FindAlert() unexpected error: %v", err)
+		}
+		if found.ID != "a-2" || found.DeviceID != "dev-2" || found.State != models.AlertStateOpen || found.Value == nil || *found.Value != value ||
+			!found.OpenedAt.Equal(FixtureBase.Add(2*time.Minute)) {
+			t.Errorf("FindAlert() = %+v", found)
+		}
+		if _, err := repo.FindAlert(ctx, "missing"); !errors.Is(err, repositories.ErrAlertNotFound) {
+			t.Errorf("FindAlert(missing) error = %v, want %v", err, repositories.ErrAlertNotFound)
+		}
+
+		if active, err := repo.FindActiveAlert(ctx, "r-1", "dev-1"); err != nil || active.ID != "a-1" {
+			t.Errorf("FindActiveAlert(r-1, dev-1) = %+v, %v; want a-1", active, err)
+		}
+		if _, err := repo.FindActiveAlert(ctx, "r-3", "dev-3"); !errors.Is(err, repositories.ErrAlertNotFound) {
+			t.Errorf("FindActiveAlert() of a resolved alert error = %v, want %v", err, repositories.ErrAlertNotFound)
+		}
+
+		resolvedAt := FixtureBase.Add(time.Hour)
+		found.State = models.AlertStateResolved
+		found.ResolvedAt = &resolvedAt
+		found.ResolvedBy = models.AlertResolvedByRule
+		if err := repo.UpdateAlert(ctx, found); err != nil {
+			t.Fatalf("UpdateAlert() unexpected error: %v", err)
+		}
+		if updated, err := repo.FindAlert(ctx, "a-2"); err != nil || updated.State != models.AlertStateResolved || updated.ResolvedAt == nil || !updated.ResolvedAt.Equal(resolvedAt) {
+			t.Errorf("FindAlert() after update = %+v, %v", updated, err)
+		}
+		if err := repo.UpdateAlert(ctx, &models.Alert{ID: "missing"}); !errors.Is(err, repositories.ErrAlertNotFound) {
+			t.Errorf("UpdateAlert(missing) error = %v, want %v", err, repositories.ErrAlertNotFound)
+		}
+
+		active := []models.AlertState{models.AlertStateOpen, models.AlertStateAcknowledged}
+		tests := []struct {
+			name        string
+			filter      models.AlertFilter
+			skip, limit int
+			want        string
+			wantTotal   int
+		}{
+			{"project", models.AlertFilter{ProjectID: "p1"}, 0, 10, "[a-3 a-2 a-1 a-0]", 4},
+			{"page", models.AlertFilter{ProjectID: "p1"}, 1, 2, "[a-2 a-1]", 4},
+			{"active", models.AlertFilter{ProjectID: "p1", States: active}, 0, 10, "[a-3 a-1]", 2},
+			{"device", models.AlertFilter{ProjectID: "p1", DeviceID: "dev-1"}, 0, 10, "[a-3 a-1 a-0]", 3},
+			{"rule", models.AlertFilter{RuleID: "r-1"}, 0, 10, "[a-2 a-1 a-0]", 3},
+			{"beyond", models.AlertFilter{ProjectID: "p2"}, 5, 10, "[]", 1},
+		}
+		for _, tt := range tests {
+			alerts, total, err := repo.ListAlerts(ctx, tt.filter, tt.skip, tt.limit)
+			if err != nil {
+				t.Fatalf("ListAlerts(%s) unexpected error: %v", tt.name, err)
+			}
+			ids := []string{}
+			for _, a := range alerts {
+				ids = append(ids, a.ID)
+			}
+			if got := fmt.Sprint(ids); got != tt.want || total != tt.wantTotal {
+				t.Errorf("ListAlerts(%s) = %s, %d; want %s, %d", tt.name, got, total, tt.want, tt.wantTotal)
+			}
+		}
+	})
+}
@@ -0,0 +1,606 @@
+// Package repositorytest provides a conformance suite that every
+// repositories.MessageRepository implementation must pass, so the MongoDB,
+// Firestore and in-memory backends keep returning the same results.
+package repositorytest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+// NewRepositoryFunc builds a repository seeded with the given fixture data.
+// Implementations are expected to register any cleanup with t.Cleanup.
+type NewRepositoryFunc func(t *testing.T, messages []*models.Message, aggregations []*models.ClientAggregations) repositories.MessageRepository
+
+// Fixture IDs are valid ObjectID hex strings so every backend can store them
+const (
+	fixtureID1 = "65a000000000000000000001"
+	fixtureID2 = "65a000000000000000000002"
+	fixtureID3 = "65a000000000000000000003"
+	fixtureID4 = "65a000000000000000000004"
+	fixtureID5 = "65a000000000000000000005"
+
+	// MissingID is a well-formed ID that is never part of the fixtures
+	MissingID = "65a0000000000000000000ff"
+)
+
+// FixtureBase is the timestamp of the oldest fixture message
+var FixtureBase = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// Fixtures returns the messages and aggregations every conformance run is seeded with
+func Fixtures() ([]*models.Message, []*models.ClientAggregations) {
+	at := func(minutes int) time.Time {
+		return FixtureBase.Add(time.Duration(minutes) * time.Minute)
+	}
+	audit := FixtureBase.Add(-time.Hour)
+
+	messages := []*models.Message{
+		{ID: fixtureID1, Topic: "site/dev-1/telemetry", Payload: `{"temperature":20}`, Timestamp: at(0), ClientID: "dev-1", DeviceID: "dev-1", ProjectID: "p1", Type: models.MessageTypeTelemetry, Status: models.MessageStatusReceived, Metadata: map[string]string{"channel": "ch1"}, CreatedAt: audit, UpdatedAt: audit},
+		{ID: fixtureID2, Topic: "site/dev-1/status/online", Payload: `{"online":true}`, Timestamp: at(1), ClientID: "dev-1", DeviceID: "dev-1", ProjectID: "p1", Type: models.MessageTypeStatus, Status: models.MessageStatusProcessed, CreatedAt: audit, UpdatedAt: audit},
+		{ID: fixtureID3, Topic: "site/dev-2/telemetry", Payload: `{"temperature":25}`, Timestamp: at(2), ClientID: "dev-2", DeviceID: "dev-2", ProjectID: "p1", Type: models.MessageTypeTelemetry, Status: models.MessageStatusReceived, CreatedAt: audit, UpdatedAt: audit},
+		{ID: fixtureID4, Topic: "site/dev-1/telemetry", Payload: `{"temperature":21,"sensor":{"humidity":40}}`, Timestamp: at(3), ClientID: "dev-1", DeviceID: "dev-1", ProjectID: "p1", Type: models.MessageTypeTelemetry, Status: models.MessageStatusFailed, Metadata: map[string]string{"channel": "ch1"}, CreatedAt: audit, UpdatedAt: audit},
+		{ID: fixtureID5, Topic: "lab/dev-3/events/boot", Payload: `{"event":"boot"}`, Timestamp: at(4), ClientID: "dev-3", DeviceID: "dev-3", ProjectID: "p2", Type: models.MessageTypeEvent, Status: models.MessageStatusReceived, CreatedAt: audit, UpdatedAt: audit},
+	}
+
+	for _, message := range messages {
+		if err := json.Unmarshal([]byte(message.Payload), &message.Marshalled); err != nil {
+			panic(err)
+		}
+	}
+
+	aggregations := []*models.ClientAggregations{
+		{
+			ClientID: "dev-1",
+			Aggregations: map[string]map[string]map[string]map[string]*models.AggregatedData{
+				"ch1": {
+					"temperature": {
+						"hourly": {
+							"2024-01-01T12": {Sum: 41, Count: 2, Min: 20, Max: 21, Avg: 20.5},
+							"2024-01-01T13": {Sum: 22, Count: 1, Min: 22, Max: 22, Avg: 22},
+						},
+					},
+				},
+				"ch2": {
+					"humidity": {
+						"daily": {
+							"2024-01-01": {Sum: 80, Count: 2, Min: 38, Max: 42, Avg: 40},
+						},
+						"hourly": {
+							"2024-01-01T12": {Sum: 40, Count: 1, Min: 40, Max: 40, Avg: 40},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	return messages, aggregations
+}
+
+// RunMessageRepositoryConformance runs the conformance suite against the repository built by newRepo
+func RunMessageRepositoryConformance(t *testing.T, newRepo NewRepositoryFunc) {
+	messages, aggregations := Fixtures()
+	repo := newRepo(t, messages, aggregations)
+	ctx := context.Background()
+
+	t.Run("FindByID", func(t *testing.T) {
+		for _, want := range messages {
+			got, err := repo.FindByID(ctx, want.GetIDAsString())
+			if err != nil {
+				t.Fatalf("FindByID(%s) unexpected error: %v", want.GetIDAsString(), err)
+			}
+			assertMessage(t, got, want)
+		}
+
+		if _, err := repo.FindByID(ctx, MissingID); !errors.Is(err, repositories.ErrMessageNotFound) {
+			t.Errorf("FindByID(missing) error = %v, want %v", err, repositories.ErrMessageNotFound)
+		}
+		if _, err := repo.FindByID(ctx, ""); !errors.Is(err, repositories.ErrInvalidMessageID) {
+			t.Errorf("FindByID(\"\") error = %v, want %v", err, repositories.ErrInvalidMessageID)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		from, to := FixtureBase.Add(time.Minute), FixtureBase.Add(3*time.Minute)
+		tests := []struct {
+			name      string
+			filter    models.MessageFilter
+			sortOrder string
+			skip      int
+			limit     int
+			wantIDs   []string
+			wantTotal int
+			// mayBeUnsupported marks filters some backends reject with ErrUnsupportedFilter
+			mayBeUnsupported bool
+		}{
+			{name: "no filter", limit: 10, wantIDs: []string{fixtureID5, fixtureID4, fixtureID3, fixtureID2, fixtureID1}, wantTotal: 5},
+			{name: "ascending", sortOrder: "ASC", limit: 2, wantIDs: []string{fixtureID1, fixtureID2}, wantTotal: 5},
+			{name: "skip and limit", skip: 1, limit: 2, wantIDs: []string{fixtureID4, fixtureID3}, wantTotal: 5},
+			{name: "no limit", skip: 3, wantIDs: []string{fixtureID2, fixtureID1}, wantTotal: 5},
+			{name: "by ID", filter: models.MessageFilter{IDs: []string{fixtureID2, fixtureID3}}, limit: 10, wantIDs: []string{fixtureID3, fixtureID2}, wantTotal: 2},
+			{name: "by type", filter: models.MessageFilter{Type: models.MessageTypeTelemetry}, limit: 10, wantIDs: []string{fixtureID4, fixtureID3, fixtureID1}, wantTotal: 3},
+			{name: "by statuses", filter: models.MessageFilter{Statuses: []models.MessageStatus{models.MessageStatusProcessed, models.MessageStatusFailed}}, limit: 10, wantIDs: []string{fixtureID4, fixtureID2}, wantTotal: 2},
+			{name: "by client IDs", filter: models.MessageFilter{ClientIDs: []string{"dev-2", "dev-3"}}, limit: 10, wantIDs: []string{fixtureID5, fixtureID3}, wantTotal: 2},
+			{name: "by device and project", filter: models.MessageFilter{DeviceID: "dev-1", ProjectID: "p1"}, limit: 10, wantIDs: []string{fixtureID4, fixtureID2, fixtureID1}, wantTotal: 3},
+			{name: "scalar outside list", filter: models.MessageFilter{ClientID: "dev-3", ClientIDs: []string{"dev-1"}}, limit: 10, wantIDs: nil, wantTotal: 0},
+			{name: "time range", filter: models.MessageFilter{FromTime: &from, ToTime: &to}, limit: 10, wantIDs: []string{fixtureID4, fixtureID3, fixtureID2}, wantTotal: 3},
+			{name: "exact topic", filter: models.MessageFilter{TopicPattern: "site/dev-1/telemetry"}, limit: 10, wantIDs: []string{fixtureID4, fixtureID1}, wantTotal: 2},
+			{name: "topic multi-level wildcard", filter: models.MessageFilter{TopicPattern: "site/dev-1/#"}, limit: 10, wantIDs: []string{fixtureID4, fixtureID2, fixtureID1}, wantTotal: 3, mayBeUnsupported: true},
+			{name: "topic single-level wildcard", filter: models.MessageFilter{TopicPattern: "site/+/telemetry"}, limit: 10, wantIDs: []string{fixtureID4, fixtureID3, fixtureID1}, wantTotal: 3, mayBeUnsupported: true},
+			{name: "topic prefix", filter: models.MessageFilter{TopicPrefix: "lab/"}, limit: 10, wantIDs: []string{fixtureID5}, wantTotal: 1, mayBeUnsupported: true},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, total, err := repo.List(ctx, tt.filter, "timestamp", tt.sortOrder, tt.skip, tt.limit)
+				if err != nil {
+					if tt.mayBeUnsupported && errors.Is(err, repositories.ErrUnsupportedFilter) {
+						t.Skipf("filter not supported by this backend: %v", err)
+					}
+					t.Fatalf("List() unexpected error: %v", err)
+				}
+				if total != tt.wantTotal {
+					t.Errorf("List() total = %d, want %d", total, tt.wantTotal)
+				}
+				assertIDs(t, "List()", got, tt.wantIDs)
+			})
+		}
+
+		if _, _, err := repo.List(ctx, models.MessageFilter{TopicPattern: "site/#/x"}, "", "", 0, 10); err == nil {
+			t.Errorf("List() expected error for an invalid topic pattern")
+		}
+	})
+
+	t.Run("ListByCursor", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			filter    models.MessageFilter
+			sortOrder string
+			limit     int
+			wantPages [][]string
+		}{
+			{name: "descending", limit: 2, wantPages: [][]string{{fixtureID5, fixtureID4}, {fixtureID3, fixtureID2}, {fixtureID1}}},
+			{name: "ascending with filter", filter: models.MessageFilter{Type: models.MessageTypeTelemetry}, sortOrder: "ASC", limit: 2, wantPages: [][]string{{fixtureID1, fixtureID3}, {fixtureID4}}},
+			{name: "exact last page", filter: models.MessageFilter{DeviceID: "dev-2"}, limit: 1, wantPages: [][]string{{fixtureID3}}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var after *models.MessageCursor
+				for i, wantIDs := range tt.wantPages {
+					got, next, err := repo.ListByCursor(ctx, tt.filter, after, tt.sortOrder, tt.limit)
+					if err != nil {
+						t.Fatalf("ListByCursor() page %d unexpected error: %v", i, err)
+					}
+					assertIDs(t, fmt.Sprintf("ListByCursor() page %d", i), got, wantIDs)
+
+					if i == len(tt.wantPages)-1 {
+						if next != nil {
+							t.Errorf("ListByCursor() last page returned a next cursor")
+						}
+						return
+					}
+					if next == nil {
+						t.Fatalf("ListByCursor() page %d returned no next cursor", i)
+					}
+					// Round-trip through the opaque form clients see
+					if after, err = models.DecodeMessageCursor(next.Encode()); err != nil {
+						t.Fatalf("DecodeMessageCursor() unexpected error: %v", err)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("FindByTopic", func(t *testing.T) {
+		got, err := repo.FindByTopic(ctx, "site/dev-1/telemetry", 10)
+		if err != nil {
+			t.Fatalf("FindByTopic() unexpected error: %v", err)
+		}
+		assertIDs(t, "FindByTopic()", got, []string{fixtureID4, fixtureID1})
+
+		got, err = repo.FindByTopic(ctx, "site/dev-1/telemetry", 1)
+		if err != nil {
+			t.Fatalf("FindByTopic() unexpected error: %v", err)
+		}
+		assertIDs(t, "FindByTopic() with limit", got, []string{fixtureID4})
+	})
+
+	t.Run("FindByDeviceID", func(t *testing.T) {
+		got, err := repo.FindByDeviceID(ctx, "dev-1", 2)
+		if err != nil {
+			t.Fatalf("FindByDeviceID() unexpected error: %v", err)
+		}
+		assertIDs(t, "FindByDeviceID()", got, []string{fixtureID4, fixtureID2})
+
+		got, err = repo.FindByDeviceID(ctx, "unknown", 10)
+		if err != nil {
+			t.Fatalf("FindByDeviceID() unexpected error: %v", err)
+		}
+		assertIDs(t, "FindByDeviceID() unknown device", got, nil)
+	})
+
+	t.Run("FindByTimeRange", func(t *testing.T) {
+		got, err := repo.FindByTimeRange(ctx, FixtureBase.Add(2*time.Minute), FixtureBase.Add(4*time.Minute), 10)
+		if err != nil {
+			t.Fatalf("FindByTimeRange() unexpected error: %v", err)
+		}
+		assertIDs(t, "FindByTimeRange()", got, []string{fixtureID5, fixtureID4, fixtureID3})
+	})
+
+	t.Run("DistinctDeviceIDs", func(t *testing.T) {
+		from := FixtureBase.Add(time.Minute)
+		tests := []struct {
+			name   string
+			filter models.MessageFilter
+			want   []string
+		}{
+			{name: "all messages", filter: models.MessageFilter{}, want: []string{"dev-1", "dev-2", "dev-3"}},
+			{name: "by project", filter: models.MessageFilter{ProjectID: "p1"}, want: []string{"dev-1", "dev-2"}},
+			{name: "by project and time range", filter: models.MessageFilter{ProjectID: "p1", FromTime: &from}, want: []string{"dev-1", "dev-2"}},
+			{name: "by type", filter: models.MessageFilter{Type: models.MessageTypeEvent}, want: []string{"dev-3"}},
+			{name: "no match", filter: models.MessageFilter{ProjectID: "unknown"}, want: []string{}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, err := repo.DistinctDeviceIDs(ctx, tt.filter)
+				if err != nil {
+					t.Fatalf("DistinctDeviceIDs() unexpected error: %v", err)
+				}
+				if len(got) != len(tt.want) {
+					t.Fatalf("DistinctDeviceIDs() = %v, want %v", got, tt.want)
+				}
+				for i := range got {
+					if got[i] != tt.want[i] {
+						t.Fatalf("DistinctDeviceIDs() = %v, want %v", got, tt.want)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("GetAggregatedDataByDeviceID", func(t *testing.T) {
+		from, to := FixtureBase, FixtureBase.Add(30*time.Minute)
+		tests := []struct {
+			name   string
+			filter models.AggregationFilter
+			want   []string
+		}{
+			{
+				name: "no filter sorted by timestamp",
+				want: []string{
+					"ch2/humidity/daily/2024-01-01T00:00:00Z count=2 sum=80",
+					"ch1/temperature/hourly/2024-01-01T12:00:00Z count=2 sum=41",
+					"ch2/humidity/hourly/2024-01-01T12:00:00Z count=1 sum=40",
+					"ch1/temperature/hourly/2024-01-01T13:00:00Z count=1 sum=22",
+				},
+			},
+			{
+				name:   "by channel",
+				filter: models.AggregationFilter{Channels: []string{"ch1"}},
+				want: []string{
+					"ch1/temperature/hourly/2024-01-01T12:00:00Z count=2 sum=41",
+					"ch1/temperature/hourly/2024-01-01T13:00:00Z count=1 sum=22",
+				},
+			},
+			{
+				name:   "by variable and period",
+				filter: models.AggregationFilter{Variables: []string{"humidity"}, Periods: []string{"daily"}},
+				want:   []string{"ch2/humidity/daily/2024-01-01T00:00:00Z count=2 sum=80"},
+			},
+			{
+				name:   "by every level",
+				filter: models.AggregationFilter{Channels: []string{"ch1", "ch2"}, Variables: []string{"temperature", "humidity"}, Periods: []string{"hourly"}},
+				want: []string{
+					"ch1/temperature/hourly/2024-01-01T12:00:00Z count=2 sum=41",
+					"ch2/humidity/hourly/2024-01-01T12:00:00Z count=1 sum=40",
+					"ch1/temperature/hourly/2024-01-01T13:00:00Z count=1 sum=22",
+				},
+			},
+			{
+				name:   "by time range",
+				filter: models.AggregationFilter{FromTime: &from, ToTime: &to},
+				want: []string{
+					"ch1/temperature/hourly/2024-01-01T12:00:00Z count=2 sum=41",
+					"ch2/humidity/hourly/2024-01-01T12:00:00Z count=1 sum=40",
+				},
+			},
+			{
+				name:   "unknown channel",
+				filter: models.AggregationFilter{Channels: []string{"ch9"}},
+				want:   nil,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rows, err := repo.GetAggregatedDataByDeviceID(ctx, "dev-1", tt.filter)
+				if err != nil {
+					t.Fatalf("GetAggregatedDataByDeviceID() unexpected error: %v", err)
+				}
+
+				var got []string
+				for _, row := range rows {
+					if row.ClientID != "dev-1" {
+						t.Errorf("GetAggregatedDataByDeviceID() client = %s, want dev-1", row.ClientID)
+					}
+					got = append(got, fmt.Sprintf("%s/%s/%s/%s count=%d sum=%v", row.Channel, row.Variable, row.Period, row.Timestamp.UTC().Format(time.RFC3339), row.Count, row.Sum))
+				}
+				if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+					t.Errorf("GetAggregatedDataByDeviceID() = %v, want %v", got, tt.want)
+				}
+			})
+		}
+
+		if _, err := repo.GetAggregatedDataByDeviceID(ctx, "dev-2", models.AggregationFilter{}); !errors.Is(err, repositories.ErrAggregationsNotFound) {
+			t.Errorf("GetAggregatedDataByDeviceID(missing) error = %v, want %v", err, repositories.ErrAggregationsNotFound)
+		}
+		if _, err := repo.GetAggregatedDataByDeviceID(ctx, "dev-2", models.AggregationFilter{Channels: []string{"ch1"}}); !errors.Is(err, repositories.ErrAggregationsNotFound) {
+			t.Errorf("GetAggregatedDataByDeviceID(missing) with projection error = %v, want %v", err, repositories.ErrAggregationsNotFound)
+		}
+	})
+
+	t.Run("AggregateByDeviceID", func(t *testing.T) {
+		to := FixtureBase.Add(10 * time.Minute)
+		tests := []struct {
+			name  string
+			query models.AggregationQuery
+			want  []string
+		}{
+			{
+				name:  "5 minute buckets",
+				query: models.AggregationQuery{DeviceID: "dev-1", From: FixtureBase, To: to, Bucket: models.Bucket5Minutes},
+				want:  []string{"2024-01-01T12:00:00Z ch1/temperature count=2 sum=41 min=20 max=21 avg=20.5"},
+			},
+			{
+				name:  "1 minute buckets",
+				query: models.AggregationQuery{DeviceID: "dev-1", From: FixtureBase, To: to, Bucket: models.Bucket1Minute},
+				want: []string{
+					"2024-01-01T12:00:00Z ch1/temperature count=1 sum=20 min=20 max=20 avg=20",
+					"2024-01-01T12:03:00Z ch1/temperature count=1 sum=21 min=21 max=21 avg=21",
+				},
+			},
+			{
+				name:  "time range is inclusive",
+				query: models.AggregationQuery{DeviceID: "dev-1", From: FixtureBase.Add(time.Minute), To: FixtureBase.Add(3 * time.Minute), Bucket: models.Bucket1Hour},
+				want:  []string{"2024-01-01T12:00:00Z ch1/temperature count=1 sum=21 min=21 max=21 avg=21"},
+			},
+			{
+				name:  "nested field",
+				query: models.AggregationQuery{DeviceID: "dev-1", From: FixtureBase, To: to, Bucket: models.Bucket1Day, Fields: []string{"sensor.humidity", "missing"}},
+				want:  []string{"2024-01-01T00:00:00Z ch1/sensor.humidity count=1 sum=40 min=40 max=40 avg=40"},
+			},
+			{
+				name:  "message without channel",
+				query: models.AggregationQuery{DeviceID: "dev-2", From: FixtureBase, To: to, Bucket: models.Bucket1Hour},
+				want:  []string{"2024-01-01T12:00:00Z default/temperature count=1 sum=25 min=25 max=25 avg=25"},
+			},
+			{
+				name:  "no numeric fields",
+				query: models.AggregationQuery{DeviceID: "dev-3", From: FixtureBase, To: to, Bucket: models.Bucket1Hour},
+				want:  nil,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				results, err := repo.AggregateByDeviceID(ctx, tt.query)
+				if err != nil {
+					t.Fatalf("AggregateByDeviceID() unexpected error: %v", err)
+				}
+
+				var got []string
+				for _, r := range results {
+					if r.Period != string(tt.query.Bucket) || r.ClientID != tt.query.DeviceID {
+						t.Errorf("AggregateByDeviceID() period = %s, client = %s", r.Period, r.ClientID)
+					}
+					got = append(got, fmt.Sprintf("%s %s/%s count=%d sum=%v min=%v max=%v avg=%v",
+						r.Timestamp.UTC().Format(time.RFC3339), r.Channel, r.Variable, r.Count, r.Sum, r.Min, r.Max, r.Avg))
+				}
+				if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+					t.Errorf("AggregateByDeviceID() = %v, want %v", got, tt.want)
+				}
+			})
+		}
+
+		if _, err := repo.AggregateByDeviceID(ctx, models.AggregationQuery{DeviceID: "dev-1", From: FixtureBase, To: to, Bucket: "2m"}); err == nil {
+			t.Errorf("AggregateByDeviceID() expected error for an unsupported bucket")
+		}
+
+		t.Run("extended statistics", func(t *testing.T) {
+			query := models.AggregationQuery{DeviceID: "dev-1", From: FixtureBase, To: to, Bucket: models.Bucket5Minutes, Fields: []string{"temperature"}, Extended: true}
+			results, err := repo.AggregateByDeviceID(ctx, query)
+			if err != nil || len(results) != 1 {
+				t.Fatalf("AggregateByDeviceID() = %v, %v; want one bucket", results, err)
+			}
+			bucket := results[0]
+			bucket.WithExtendedStats()
+			stats := bucket.ExtendedStats
+			if stats.StdDev == nil || *stats.StdDev != 0.5 {
+				t.Errorf("stddev = %v, want 0.5", stats.StdDev)
+			}
+			if stats.P50 == nil || *stats.P50 < 20 || *stats.P50 > 21 || stats.P99 == nil || *stats.P99 < 20 || *stats.P99 > 21 {
+				t.Errorf("p50 = %v, p99 = %v; want values between 20 and 21", stats.P50, stats.P99)
+			}
+			if stats.First == nil || *stats.First != 20 || !stats.FirstAt.Equal(FixtureBase) {
+				t.Errorf("first = %v at %v, want 20 at %v", stats.First, stats.FirstAt, FixtureBase)
+			}
+			if stats.Last == nil || *stats.Last != 21 || !stats.LastAt.Equal(FixtureBase.Add(3*time.Minute)) {
+				t.Errorf("last = %v at %v, want 21 at %v", stats.Last, stats.LastAt, FixtureBase.Add(3*time.Minute))
+			}
+		})
+	})
+}
+
+// RunMessageRepositoryWriteConformance checks Create, CreateMany, Update and Delete on an empty repository
+func RunMessageRepositoryWriteConformance(t *testing.T, newRepo NewRepositoryFunc) {
+	repo := newRepo(t, nil, nil)
+	ctx := context.Background()
+	now := FixtureBase.Add(time.Hour)
+
+	created, err := repo.Create(ctx, &models.Message{Topic: "site/dev-9/telemetry", Payload: `{"t":1}`, Timestamp: FixtureBase, ClientID: "dev-9", DeviceID: "dev-9", Type: models.MessageTypeTelemetry, Status: models.MessageStatusReceived, CreatedAt: now, UpdatedAt: now})
+	if err != nil {
+		t.Fatalf("Create() unexpected error: %v", err)
+	}
+	id := created.GetIDAsString()
+	if id == "" {
+		t.Fatalf("Create() did not assign an ID")
+	}
+	found, err := repo.FindByID(ctx, id)
+	if err != nil {
+		t.Fatalf("FindByID() after Create() unexpected error: %v", err)
+	}
+	assertMessage(t, found, created)
+
+	batch, err := repo.CreateMany(ctx, []*models.Message{
+		{Topic: "site/dev-9/telemetry", Timestamp: FixtureBase.Add(time.Minute), ClientID: "dev-9", DeviceID: "dev-9", CreatedAt: now, UpdatedAt: now},
+		{Topic: "site/dev-9/telemetry", Timestamp: FixtureBase.Add(2 * time.Minute), ClientID: "dev-9", DeviceID: "dev-9", CreatedAt: now, UpdatedAt: now},
+	})
+	if err != nil {
+		t.Fatalf("CreateMany() unexpected error: %v", err)
+	}
+	if len(batch) != 2 || batch[0].GetIDAsString() == "" || batch[0].GetIDAsString() == batch[1].GetIDAsString() {
+		t.Fatalf("CreateMany() should assign distinct IDs, got %d messages", len(batch))
+	}
+	if _, total, err := repo.List(ctx, models.MessageFilter{DeviceID: "dev-9"}, "", "", 0, 10); err != nil || total != 3 {
+		t.Errorf("List() after CreateMany() total = %d, err = %v, want 3", total, err)
+	}
+
+	// A batch is stored atomically: the new message before the duplicate ID is not kept
+	_, err = repo.CreateMany(ctx, []*models.Message{
+		{Topic: "site/dev-9/telemetry", Timestamp: FixtureBase.Add(3 * time.Minute), ClientID: "dev-9", DeviceID: "dev-9", CreatedAt: now, UpdatedAt: now},
+		{ID: id, Topic: "site/dev-9/telemetry", Timestamp: FixtureBase.Add(4 * time.Minute), ClientID: "dev-9", DeviceID: "dev-9", CreatedAt: now, UpdatedAt: now},
+	})
+	if err == nil {
+		t.Errorf("CreateMany() with an existing ID should fail")
+	}
+	if _, total, err := repo.List(ctx, models.MessageFilter{DeviceID: "dev-9"}, "", "", 0, 10); err != nil || total != 3 {
+		t.Errorf("List() after a failed CreateMany() total = %d, err = %v, want 3", total, err)
+	}
+
+	processed := models.MessageStatusProcessed
+	processedAt := now.Add(time.Minute)
+	updated, err := repo.Update(ctx, id, models.MessageUpdate{Status: &processed, ProcessedAt: &processedAt, Metadata: map[string]string{"k": "v"}, UpdatedAt: processedAt})
+	if err != nil {
+		t.Fatalf("Update() unexpected error: %v", err)
+	}
+	if updated.Status != processed || updated.ProcessedAt == nil || !updated.ProcessedAt.Equal(processedAt) || updated.Metadata["k"] != "v" || !updated.UpdatedAt.Equal(processedAt) {
+		t.Errorf("Update() = %+v", updated)
+	}
+	if updated.Topic != created.Topic || !updated.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("Update() changed fields that were not part of the update: %+v", updated)
+	}
+	deviceID := "dev-10"
+	if updated, err := repo.Update(ctx, id, models.MessageUpdate{DeviceID: &deviceID, UpdatedAt: processedAt}); err != nil || updated.DeviceID != deviceID || updated.Status != processed {
+		t.Errorf("Update() of the device ID = %+v, err = %v", updated, err)
+	}
+	if _, err := repo.Update(ctx, MissingID, models.MessageUpdate{Status: &processed, UpdatedAt: now}); !errors.Is(err, repositories.ErrMessageNotFound) {
+		t.Errorf("Update(missing) error = %v, want %v", err, repositories.ErrMessageNotFound)
+	}
+
+	if err := repo.Delete(ctx, id); err != nil {
+		t.Fatalf("Delete() unexpected error: %v", err)
+	}
+	if _, err := repo.FindByID(ctx, id); !errors.Is(err, repositories.ErrMessageNotFound) {
+		t.Errorf("FindByID() after Delete() error = %v, want %v", err, repositories.ErrMessageNotFound)
+	}
+	if err := repo.Delete(ctx, id); !errors.Is(err, repositories.ErrMessageNotFound) {
+		t.Errorf("Delete() twice error = %v, want %v", err, repositories.ErrMessageNotFound)
+	}
+	if err := repo.Delete(ctx, ""); !errors.Is(err, repositories.ErrInvalidMessageID) {
+		t.Errorf("Delete(\"\") error = %v, want %v", err, repositories.ErrInvalidMessageID)
+	}
+}
+
+// RunDerivedMessageConformance checks that derived messages such as alerts, which carry the
+// device ID and numeric values, are left out of the on-demand aggregations and of the
+// queries excluding their type
+func RunDerivedMessageConformance(t *testing.T, newRepo NewRepositoryFunc) {
+	audit := FixtureBase.Add(-time.Hour)
+	alertMetadata := map[string]string{"alertId": "a1", "ruleId": "r1", "severity": "critical", "state": "open"}
+	messages := []*models.Message{
+		{ID: fixtureID1, Topic: "site/dev-9/telemetry", Payload: `{"temperature":20}`, Timestamp: FixtureBase, ClientID: "dev-9", DeviceID: "dev-9", ProjectID: "p1", Type: models.MessageTypeTelemetry, Status: models.MessageStatusReceived, CreatedAt: audit, UpdatedAt: audit},
+		{ID: fixtureID2, Topic: "alerts/dev-9/r1", Payload: `{"temperature":90,"value":90,"threshold":80}`, Timestamp: FixtureBase.Add(time.Minute), ClientID: "dev-9", DeviceID: "dev-9", ProjectID: "p1", Type: models.MessageTypeAlert, Status: models.MessageStatusReceived, Metadata: alertMetadata, CreatedAt: audit, UpdatedAt: audit},
+		{ID: fixtureID3, Topic: "alerts/dev-8/r1", Payload: `{"value":1}`, Timestamp: FixtureBase.Add(2 * time.Minute), ClientID: "dev-8", DeviceID: "dev-8", ProjectID: "p1", Type: models.MessageTypeAlert, Status: models.MessageStatusReceived, Metadata: alertMetadata, CreatedAt: audit, UpdatedAt: audit},
+	}
+	for _, message := range messages {
+		if err := json.Unmarshal([]byte(message.Payload), &message.Marshalled); err != nil {
+			panic(err)
+		}
+	}
+	repo := newRepo(t, messages, nil)
+	ctx := context.Background()
+	to := FixtureBase.Add(10 * time.Minute)
+
+	for _, extended := range []bool{false, true} {
+		query := models.AggregationQuery{DeviceID: "dev-9", From: FixtureBase, To: to, Bucket: models.Bucket1Hour, Extended: extended}
+		results, err := repo.AggregateByDeviceID(ctx, query)
+		if err != nil {
+			t.Fatalf("AggregateByDeviceID(extended=%v) unexpected error: %v", extended, err)
+		}
+		if len(results) != 1 || results[0].Variable != "temperature" || results[0].Count != 1 || results[0].Max != 20 {
+			t.Errorf("AggregateByDeviceID(extended=%v) = %d buckets, want the telemetry temperature only", extended, len(results))
+		}
+	}
+
+	excluded := models.MessageFilter{DeviceID: "dev-9", FromTime: &FixtureBase, ToTime: &to, ExcludeTypes: models.DerivedMessageTypes}
+	page, _, err := repo.ListByCursor(ctx, excluded, nil, "ASC", 10)
+	if err != nil {
+		t.Fatalf("ListByCursor() excluding alerts unexpected error: %v", err)
+	}
+	assertIDs(t, "ListByCursor() excluding alerts", page, []string{fixtureID1})
+
+	if _, total, err := repo.List(ctx, excluded, "timestamp", "ASC", 0, 10); err != nil && !errors.Is(err, repositories.ErrUnsupportedFilter) {
+		t.Errorf("List() excluding alerts unexpected error: %v", err)
+	} else if err == nil && total != 1 {
+		t.Errorf("List() excluding alerts total = %d, want 1", total)
+	}
+
+	withType := models.MessageFilter{DeviceID: "dev-9", Types: []models.MessageType{models.MessageTypeTelemetry, models.MessageTypeAlert}, ExcludeTypes: models.DerivedMessageTypes}
+	page, _, err = repo.ListByCursor(ctx, withType, nil, "ASC", 10)
+	if err != nil {
+		t.Fatalf("ListByCursor() with included and excluded types unexpected error: %v", err)
+	}
+	assertIDs(t, "ListByCursor() with included and excluded types", page, []string{fixtureID1})
+
+	deviceIDs, err := repo.DistinctDeviceIDs(ctx, models.MessageFilter{ProjectID: "p1", ExcludeTypes: models.DerivedMessageTypes})
+	if err != nil || fmt.Sprint(deviceIDs) != "[dev-9]" {
+		t.Errorf("DistinctDeviceIDs() excluding alerts = %v, %v; want [dev-9]", deviceIDs, err)
+	}
+}
+
+func assertIDs(t *testing.T, call string, got []*models.Message, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s returned %d messages, want %d", call, len(got), len(want))
+	}
+	for i, message := range got {
+		if message.GetIDAsString() != want[i] {
+			t.Errorf("%s[%d] = %s, want %s", call, i, message.GetIDAsString(), want[i])
+		}
+	}
+}
+
+func assertMessage(t *testing.T, got, want *models.Message) {
+	t.Helper()
+	if got.GetIDAsString() != want.GetIDAsString() ||
+		got.Topic != want.Topic ||
+		got.Payload != want.Payload ||
+		got.ClientID != want.ClientID ||
+		got.DeviceID != want.DeviceID ||
+		got.ProjectID != want.ProjectID ||
+		got.Type != want.Type ||
+		got.Status != want.Status ||
+		!got.Timestamp.Equal(want.Timestamp) ||
+		!got.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("message = %+v, want %+v", got, want)
+	}
+}
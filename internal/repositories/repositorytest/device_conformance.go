@@ -0,0 +1,193 @@
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewDeviceRepositoryFunc builds an empty device repository
+type NewDeviceRepositoryFunc func(t *testing.T) repositories.DeviceRepository
+
+// RunDeviceRepositoryConformance checks the device CRUD, the client ID lookups and
+// uniqueness, and the sorted listings
+func RunDeviceRepositoryConformance(t *testing.T, newRepo NewDeviceRepositoryFunc) {
+	ctx := context.Background()
+	deviceID := func(n int) primitive.ObjectID {
+		id, _ := primitive.ObjectIDFromHex(fmt.Sprintf("%024x", n))
+		return id
+	}
+	device := func(n int, projectID, name, deviceType string) *models.Device {
+		at := FixtureBase.Add(time.Duration(n) * time.Minute)
+		return &models.Device{
+			ID:        deviceID(n),
+			ProjectID: projectID,
+			Name:      name,
+			Type:      deviceType,
+			Status:    "active",
+			ClientID:  fmt.Sprintf("client-%d", n),
+			Metadata:  map[string]string{"floor": fmt.Sprint(n)},
+			CreatedAt: at,
+			UpdatedAt: at,
+			CreatedBy: "user@example.com",
+		}
+	}
+	names := func(devices []*models.Device) string {
+		found := []string{}
+		for _, d := range devices {
+			found = append(found, d.Name)
+		}
+		return fmt.Sprint(found)
+	}
+
+	t.Run("CRUD", func(t *testing.T) {
+		repo := newRepo(t)
+		created := device(1, "p1", "boiler", "sensor")
+		if err := repo.Create(ctx, created); err != nil {
+			t.Fatalf("Create() unexpected error: %v", err)
+		}
+
+		found, err := repo.FindByID(ctx, created.ID.Hex())
+		if err != nil {
+			t.Fatalf("FindByID() unexpected error: %v", err)
+		}
+		if found.ID != created.ID || found.ProjectID != "p1" || found.Name != "boiler" || found.ClientID != "client-1" ||
+			found.Metadata["floor"] != "1" || found.LastSeen != nil || !found.CreatedAt.Equal(created.CreatedAt) {
+			t.Errorf("FindByID() = %+v", found)
+		}
+		if found, err := repo.FindByClientID(ctx, "client-1"); err != nil || found.ID != created.ID {
+			t.Errorf("FindByClientID() = %+v, %v; want the created device", found, err)
+		}
+		for _, id := range []string{deviceID(99).Hex(), "not-an-id", ""} {
+			if _, err := repo.FindByID(ctx, id); !errors.Is(err, repositories.ErrDeviceNotFound) {
+				t.Errorf("FindByID(%q) error = %v, want %v", id, err, repositories.ErrDeviceNotFound)
+			}
+		}
+		if _, err := repo.FindByClientID(ctx, "unknown"); !errors.Is(err, repositories.ErrDeviceNotFound) {
+			t.Errorf("FindByClientID(unknown) error = %v, want %v", err, repositories.ErrDeviceNotFound)
+		}
+
+		name, clientID, updatedAt := "boiler room", "client-1b", FixtureBase.Add(time.Hour)
+		updated, err := repo.Update(ctx, created.ID.Hex(), models.DeviceUpdate{Name: &name, ClientID: &clientID, UpdatedAt: updatedAt})
+		if err != nil || updated.Name != "boiler room" || updated.ClientID != "client-1b" || updated.Type != "sensor" ||
+			updated.Status != "active" || updated.Metadata["floor"] != "1" || !updated.UpdatedAt.Equal(updatedAt) {
+			t.Errorf("Update() = %+v, %v", updated, err)
+		}
+		if found, err := repo.FindByID(ctx, created.ID.Hex()); err != nil || found.Name != "boiler room" || !found.CreatedAt.Equal(created.CreatedAt) {
+			t.Errorf("FindByID() after Update() = %+v, %v", found, err)
+		}
+		if _, err := repo.FindByClientID(ctx, "client-1"); !errors.Is(err, repositories.ErrDeviceNotFound) {
+			t.Errorf("FindByClientID(previous client ID) error = %v, want %v", err, repositories.ErrDeviceNotFound)
+		}
+		for _, id := range []string{deviceID(98).Hex(), "not-an-id"} {
+			if _, err := repo.Update(ctx, id, models.DeviceUpdate{Name: &name}); !errors.Is(err, repositories.ErrDeviceNotFound) {
+				t.Errorf("Update(%q) error = %v, want %v", id, err, repositories.ErrDeviceNotFound)
+			}
+		}
+
+		seen := updatedAt.Add(time.Minute)
+		if err := repo.SetPresence(ctx, "client-1b", "offline", seen); err != nil {
+			t.Fatalf("SetPresence() unexpected error: %v", err)
+		}
+		updated, err = repo.FindByID(ctx, created.ID.Hex())
+		if err != nil || updated.Status != "offline" || updated.LastSeen == nil || !updated.LastSeen.Equal(seen) || updated.Name != "boiler room" {
+			t.Errorf("FindByID() after SetPresence() = %+v, %v", updated, err)
+		}
+		if err := repo.SetPresence(ctx, "unknown", "online", seen); !errors.Is(err, repositories.ErrDeviceNotFound) {
+			t.Errorf("SetPresence(unknown) error = %v, want %v", err, repositories.ErrDeviceNotFound)
+		}
+
+		// An update leaves the presence alone
+		renamed := "boiler"
+		updated, err = repo.Update(ctx, created.ID.Hex(), models.DeviceUpdate{Name: &renamed, UpdatedAt: seen})
+		if err != nil || updated.Name != "boiler" || updated.Status != "offline" || updated.LastSeen == nil || !updated.LastSeen.Equal(seen) {
+			t.Errorf("Update() after SetPresence() = %+v, %v", updated, err)
+		}
+
+		if err := repo.Delete(ctx, created.ID.Hex()); err != nil {
+			t.Fatalf("Delete() unexpected error: %v", err)
+		}
+		if err := repo.Delete(ctx, created.ID.Hex()); !errors.Is(err, repositories.ErrDeviceNotFound) {
+			t.Errorf("Delete() twice error = %v, want %v", err, repositories.ErrDeviceNotFound)
+		}
+	})
+
+	t.Run("ClientIDUniqueness", func(t *testing.T) {
+		repo := newRepo(t)
+		first, second := device(1, "p1", "first", "sensor"), device(2, "p2", "second", "sensor")
+		for _, d := range []*models.Device{first, second} {
+			if err := repo.Create(ctx, d); err != nil {
+				t.Fatalf("Create(%s) unexpected error: %v", d.Name, err)
+			}
+		}
+
+		duplicate := device(3, "p2", "duplicate", "sensor")
+		duplicate.ClientID = first.ClientID
+		if err := repo.Create(ctx, duplicate); !errors.Is(err, repositories.ErrDeviceClientIDTaken) {
+			t.Errorf("Create(duplicate client ID) error = %v, want %v", err, repositories.ErrDeviceClientIDTaken)
+		}
+		if _, err := repo.Update(ctx, second.ID.Hex(), models.DeviceUpdate{ClientID: &first.ClientID}); !errors.Is(err, repositories.ErrDeviceClientIDTaken) {
+			t.Errorf("Update(duplicate client ID) error = %v, want %v", err, repositories.ErrDeviceClientIDTaken)
+		}
+		// A device keeps its own client ID
+		renamed := "renamed"
+		if _, err := repo.Update(ctx, first.ID.Hex(), models.DeviceUpdate{Name: &renamed, ClientID: &first.ClientID}); err != nil {
+			t.Errorf("Update(same client ID) unexpected error: %v", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		repo := newRepo(t)
+		withLastSeen := device(3, "p1", "gateway", "gateway")
+		lastSeen := FixtureBase.Add(time.Hour)
+		withLastSeen.LastSeen = &lastSeen
+		for _, d := range []*models.Device{
+			device(1, "p1", "boiler", "sensor"),
+			device(2, "p1", "attic", "sensor"),
+			withLastSeen,
+			device(4, "p2", "other", "sensor"),
+		} {
+			if err := repo.Create(ctx, d); err != nil {
+				t.Fatalf("Create(%s) unexpected error: %v", d.Name, err)
+			}
+		}
+
+		tests := []struct {
+			name                 string
+			filter               models.DeviceFilter
+			sortField, sortOrder string
+			skip, limit          int
+			want                 string
+			wantTotal            int
+		}{
+			{"by name", models.DeviceFilter{ProjectID: "p1"}, "name", "ASC", 0, 10, "[attic boiler gateway]", 3},
+			{"newest first", models.DeviceFilter{ProjectID: "p1"}, "createdAt", "DESC", 0, 10, "[gateway attic boiler]", 3},
+			{"by ID", models.DeviceFilter{ProjectID: "p1"}, "_id", "ASC", 0, 10, "[boiler attic gateway]", 3},
+			{"page", models.DeviceFilter{ProjectID: "p1"}, "name", "ASC", 1, 1, "[boiler]", 3},
+			{"type", models.DeviceFilter{ProjectID: "p1", Type: "sensor"}, "name", "DESC", 0, 10, "[boiler attic]", 2},
+			{"client ID", models.DeviceFilter{ClientID: "client-4"}, "name", "ASC", 0, 10, "[other]", 1},
+			{"never seen first", models.DeviceFilter{ProjectID: "p1"}, "lastSeen", "ASC", 0, 10, "[boiler attic gateway]", 3},
+			{"beyond", models.DeviceFilter{ProjectID: "p2"}, "name", "ASC", 5, 10, "[]", 1},
+		}
+		for _, tt := range tests {
+			devices, total, err := repo.List(ctx, tt.filter, tt.sortField, tt.sortOrder, tt.skip, tt.limit)
+			if err != nil {
+				t.Fatalf("List(%s) unexpected error: %v", tt.name, err)
+			}
+			if got := names(devices); got != tt.want || total != tt.wantTotal {
+				t.Errorf("List(%s) = %s, %d; want %s, %d", tt.name, got, total, tt.want, tt.wantTotal)
+			}
+		}
+
+		if _, _, err := repo.List(ctx, models.DeviceFilter{}, "metadata", "ASC", 0, 10); err == nil {
+			t.Error("List() sorted on an unsupported field should fail")
+		}
+	})
+}
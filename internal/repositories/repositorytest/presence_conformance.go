@@ -0,0 +1,153 @@
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+// NewPresenceRepositoryFunc builds an empty presence repository
+type NewPresenceRepositoryFunc func(t *testing.T) repositories.PresenceRepository
+
+// RunPresenceRepositoryConformance checks the presence upserts and listings and the
+// transition history
+func RunPresenceRepositoryConformance(t *testing.T, newRepo NewPresenceRepositoryFunc) {
+	ctx := context.Background()
+	at := func(minutes int) time.Time { return FixtureBase.Add(time.Duration(minutes) * time.Minute) }
+
+	t.Run("Presence", func(t *testing.T) {
+		repo := newRepo(t)
+		for i, p := range []struct {
+			deviceID, projectID string
+			state               models.PresenceState
+		}{
+			{"dev-1", "p1", models.PresenceOnline},
+			{"dev-2", "p1", models.PresenceOffline},
+			{"dev-3", "p1", models.PresenceOnline},
+			{"dev-4", "p2", models.PresenceOnline},
+		} {
+			presence := &models.DevicePresence{
+				DeviceID:  p.deviceID,
+				ProjectID: p.projectID,
+				ClientID:  "client-" + p.deviceID,
+				State:     models.PresenceOffline,
+				Reason:    models.PresenceReasonMessage,
+				Since:     at(i),
+				LastSeen:  at(i),
+				UpdatedAt: at(i),
+			}
+			if err := repo.SavePresence(ctx, presence); err != nil {
+				t.Fatalf("SavePresence(%s) unexpected error: %v", p.deviceID, err)
+			}
+			// Saving again replaces the presence
+			presence.State = p.state
+			if err := repo.SavePresence(ctx, presence); err != nil {
+				t.Fatalf("SavePresence(%s) again unexpected error: %v", p.deviceID, err)
+			}
+		}
+
+		found, err := repo.FindPresence(ctx, "dev-1")
+		if err != nil {
+			t.Fatalf("FindPresence() unexpected error: %v", err)
+		}
+		if found.DeviceID != "dev-1" || found.ProjectID != "p1" || found.ClientID != "client-dev-1" || found.State != models.PresenceOnline ||
+			found.Reason != models.PresenceReasonMessage || !found.LastSeen.Equal(at(0)) {
+			t.Errorf("FindPresence() = %+v", found)
+		}
+		if _, err := repo.FindPresence(ctx, "missing"); !errors.Is(err, repositories.ErrPresenceNotFound) {
+			t.Errorf("FindPresence(missing) error = %v, want %v", err, repositories.ErrPresenceNotFound)
+		}
+
+		seenBefore := at(2)
+		tests := []struct {
+			name        string
+			filter      models.PresenceFilter
+			skip, limit int
+			want        string
+			wantTotal   int
+		}{
+			{"project", models.PresenceFilter{ProjectID: "p1"}, 0, 10, "[dev-3 dev-2 dev-1]", 3},
+			{"page", models.PresenceFilter{ProjectID: "p1"}, 1, 1, "[dev-2]", 3},
+			{"online", models.PresenceFilter{ProjectID: "p1", State: models.PresenceOnline}, 0, 10, "[dev-3 dev-1]", 2},
+			{"stale online", models.PresenceFilter{State: models.PresenceOnline, SeenBefore: &seenBefore}, 0, 0, "[dev-1]", 1},
+			{"beyond", models.PresenceFilter{ProjectID: "p2"}, 5, 10, "[]", 1},
+		}
+		for _, tt := range tests {
+			presences, total, err := repo.ListPresence(ctx, tt.filter, tt.skip, tt.limit)
+			if err != nil {
+				t.Fatalf("ListPresence(%s) unexpected error: %v", tt.name, err)
+			}
+			ids := []string{}
+			for _, p := range presences {
+				ids = append(ids, p.DeviceID)
+			}
+			if got := fmt.Sprint(ids); got != tt.want || total != tt.wantTotal {
+				t.Errorf("ListPresence(%s) = %s, %d; want %s, %d", tt.name, got, total, tt.want, tt.wantTotal)
+			}
+		}
+	})
+
+	t.Run("Transitions", func(t *testing.T) {
+		repo := newRepo(t)
+		states := []models.PresenceState{models.PresenceOnline, models.PresenceOffline, models.PresenceOnline, models.PresenceOffline}
+		for i, state := range states {
+			transition := &models.PresenceTransition{
+				ID:        fmt.Sprintf("t-%d", i),
+				DeviceID:  "dev-1",
+				ProjectID: "p1",
+				To:        state,
+				Reason:    models.PresenceReasonTimeout,
+				MessageID: fixtureID1,
+				At:        at(i),
+			}
+			if i > 0 {
+				transition.From = states[i-1]
+			}
+			if err := repo.AddTransition(ctx, transition); err != nil {
+				t.Fatalf("AddTransition() unexpected error: %v", err)
+			}
+		}
+		if err := repo.AddTransition(ctx, &models.PresenceTransition{ID: "other", DeviceID: "dev-2", To: models.PresenceOnline, At: at(0)}); err != nil {
+			t.Fatalf("AddTransition() unexpected error: %v", err)
+		}
+
+		from, to := at(1), at(3)
+		tests := []struct {
+			name        string
+			from, to    *time.Time
+			skip, limit int
+			want        string
+			wantTotal   int
+		}{
+			{"all", nil, nil, 0, 10, "[t-3 t-2 t-1 t-0]", 4},
+			{"page", nil, nil, 1, 2, "[t-2 t-1]", 4},
+			{"range", &from, &to, 0, 10, "[t-2 t-1]", 2},
+			{"since", &to, nil, 0, 10, "[t-3]", 1},
+			{"beyond", nil, nil, 5, 10, "[]", 4},
+		}
+		for _, tt := range tests {
+			transitions, total, err := repo.ListTransitions(ctx, "dev-1", tt.from, tt.to, tt.skip, tt.limit)
+			if err != nil {
+				t.Fatalf("ListTransitions(%s) unexpected error: %v", tt.name, err)
+			}
+			ids := []string{}
+			for _, transition := range transitions {
+				ids = append(ids, transition.ID)
+			}
+			if got := fmt.Sprint(ids); got != tt.want || total != tt.wantTotal {
+				t.Errorf("ListTransitions(%s) = %s, %d; want %s, %d", tt.name, got, total, tt.want, tt.wantTotal)
+			}
+		}
+
+		transitions, _, _ := repo.ListTransitions(ctx, "dev-1", nil, nil, 0, 1)
+		if len(transitions) != 1 || transitions[0].From != models.PresenceOnline || transitions[0].To != models.PresenceOffline ||
+			transitions[0].Reason != models.PresenceReasonTimeout || transitions[0].MessageID != fixtureID1 || !transitions[0].At.Equal(at(3)) {
+			t.Errorf("ListTransitions() newest = %+v", transitions)
+		}
+	})
+}
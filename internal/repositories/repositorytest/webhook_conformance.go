@@ -0,0 +1,148 @@
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+// NewWebhookRepositoryFunc builds an empty webhook repository
+type NewWebhookRepositoryFunc func(t *testing.T) repositories.WebhookRepository
+
+// RunWebhookRepositoryConformance checks the webhook CRUD and the delivery listing
+func RunWebhookRepositoryConformance(t *testing.T, newRepo NewWebhookRepositoryFunc) {
+	ctx := context.Background()
+	webhook := func(id, projectID string, minutes int) *models.Webhook {
+		at := FixtureBase.Add(time.Duration(minutes) * time.Minute)
+		return &models.Webhook{
+			ID:        id,
+			ProjectID: projectID,
+			URL:       "https://partner.example.com/" + id,
+			Secret:    "secret-of-" + id,
+			Filter:    models.MessageFilter{Types: []models.MessageType{models.MessageTypeAlert}},
+			Active:    true,
+			CreatedAt: at,
+			UpdatedAt: at,
+		}
+	}
+
+	t.Run("Webhooks", func(t *testing.T) {
+		repo := newRepo(t)
+		for _, w := range []*models.Webhook{webhook("wh-2", "p1", 2), webhook("wh-1", "p1", 1), webhook("wh-3", "p2", 3)} {
+			if err := repo.CreateWebhook(ctx, w); err != nil {
+				t.Fatalf("CreateWebhook(%s) unexpected error: %v", w.ID, err)
+			}
+		}
+
+		found, err := repo.FindWebhook(ctx, "wh-1")
+		if err != nil {
+			t.Fatalf("FindWebhook() unexpected error: %v", err)
+		}
+		if found.ID != "wh-1" || found.ProjectID != "p1" || found.Secret != "secret-of-wh-1" || !found.Active ||
+			len(found.Filter.Types) != 1 || found.Filter.Types[0] != models.MessageTypeAlert || !found.CreatedAt.Equal(FixtureBase.Add(time.Minute)) {
+			t.Errorf("FindWebhook() = %+v", found)
+		}
+		if _, err := repo.FindWebhook(ctx, "missing"); !errors.Is(err, repositories.ErrWebhookNotFound) {
+			t.Errorf("FindWebhook(missing) error = %v, want %v", err, repositories.ErrWebhookNotFound)
+		}
+
+		for projectID, want := range map[string]string{"p1": "[wh-1 wh-2]", "p2": "[wh-3]", "": "[wh-1 wh-2 wh-3]", "p3": "[]"} {
+			webhooks, err := repo.ListWebhooks(ctx, projectID)
+			if err != nil {
+				t.Fatalf("ListWebhooks(%q) unexpected error: %v", projectID, err)
+			}
+			ids := []string{}
+			for _, w := range webhooks {
+				ids = append(ids, w.ID)
+			}
+			if got := fmt.Sprint(ids); got != want {
+				t.Errorf("ListWebhooks(%q) = %s, want %s", projectID, got, want)
+			}
+		}
+
+		found.Active = false
+		found.URL = "https://partner.example.com/changed"
+		if err := repo.UpdateWebhook(ctx, found); err != nil {
+			t.Fatalf("UpdateWebhook() unexpected error: %v", err)
+		}
+		if updated, err := repo.FindWebhook(ctx, "wh-1"); err != nil || updated.Active || updated.URL != found.URL {
+			t.Errorf("FindWebhook() after update = %+v, %v", updated, err)
+		}
+		if err := repo.UpdateWebhook(ctx, webhook("missing", "p1", 0)); !errors.Is(err, repositories.ErrWebhookNotFound) {
+			t.Errorf("UpdateWebhook(missing) error = %v, want %v", err, repositories.ErrWebhookNotFound)
+		}
+
+		if err := repo.DeleteWebhook(ctx, "wh-1"); err != nil {
+			t.Fatalf("DeleteWebhook() unexpected error: %v", err)
+		}
+		if err := repo.DeleteWebhook(ctx, "wh-1"); !errors.Is(err, repositories.ErrWebhookNotFound) {
+			t.Errorf("DeleteWebhook() twice error = %v, want %v", err, repositories.ErrWebhookNotFound)
+		}
+	})
+
+	t.Run("Deliveries", func(t *testing.T) {
+		repo := newRepo(t)
+		for i, deliveryStatus := range []models.WebhookDeliveryStatus{
+			models.WebhookDeliverySucceeded, models.WebhookDeliveryFailed, models.WebhookDeliverySucceeded, models.WebhookDeliveryPending,
+		} {
+			at := FixtureBase.Add(time.Duration(i) * time.Minute)
+			delivery := &models.WebhookDelivery{
+				ID:        fmt.Sprintf("d-%d", i),
+				WebhookID: "wh-1",
+				ProjectID: "p1",
+				MessageID: fixtureID1,
+				Event:     "message.created",
+				Status:    models.WebhookDeliveryPending,
+				CreatedAt: at,
+				UpdatedAt: at,
+			}
+			if err := repo.SaveDelivery(ctx, delivery); err != nil {
+				t.Fatalf("SaveDelivery() unexpected error: %v", err)
+			}
+			// Saving again replaces the record
+			delivery.Status = deliveryStatus
+			delivery.Attempts = []models.WebhookAttempt{{AttemptedAt: at, StatusCode: 500, Error: "boom", DurationMs: 12}, {AttemptedAt: at, StatusCode: 200}}
+			if err := repo.SaveDelivery(ctx, delivery); err != nil {
+				t.Fatalf("SaveDelivery() again unexpected error: %v", err)
+			}
+		}
+		other := &models.WebhookDelivery{ID: "other", WebhookID: "wh-2", Status: models.WebhookDeliverySucceeded, CreatedAt: FixtureBase}
+		if err := repo.SaveDelivery(ctx, other); err != nil {
+			t.Fatalf("SaveDelivery() unexpected error: %v", err)
+		}
+
+		tests := []struct {
+			status      models.WebhookDeliveryStatus
+			skip, limit int
+			want        string
+			wantTotal   int
+		}{
+			{"", 0, 10, "[d-3 d-2 d-1 d-0]", 4},
+			{"", 1, 2, "[d-2 d-1]", 4},
+			{models.WebhookDeliverySucceeded, 0, 10, "[d-2 d-0]", 2},
+			{models.WebhookDeliveryFailed, 0, 10, "[d-1]", 1},
+			{"", 5, 10, "[]", 4},
+		}
+		for _, tt := range tests {
+			deliveries, total, err := repo.ListDeliveries(ctx, "wh-1", tt.status, tt.skip, tt.limit)
+			if err != nil {
+				t.Fatalf("ListDeliveries(%q, %d, %d) unexpected error: %v", tt.status, tt.skip, tt.limit, err)
+			}
+			ids := []string{}
+			for _, d := range deliveries {
+				ids = append(ids, d.ID)
+			}
+			if got := fmt.Sprint(ids); got != tt.want || total != tt.wantTotal {
+				t.Errorf("ListDeliveries(%q, %d, %d) = %s, %d; want %s, %d", tt.status, tt.skip, tt.limit, got, total, tt.want, tt.wantTotal)
+			}
+			if len(deliveries) > 0 && (len(deliveries[0].Attempts) != 2 || deliveries[0].Attempts[0].Error != "boom" || deliveries[0].Attempts[1].StatusCode != 200) {
+				t.Errorf("ListDeliveries() attempts = %+v", deliveries[0].Attempts)
+			}
+		}
+	})
+}
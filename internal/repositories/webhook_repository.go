@@ -0,0 +1,35 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+// WebhookRepository stores the webhook subscriptions and the record of their deliveries
+type WebhookRepository interface {
+	// CreateWebhook stores a new webhook under its ID
+	CreateWebhook(ctx context.Context, webhook *models.Webhook) error
+	FindWebhook(ctx context.Context, id string) (*models.Webhook, error)
+	// ListWebhooks returns the webhooks of a project, or of every project when projectID is
+	// empty, ordered by creation time
+	ListWebhooks(ctx context.Context, projectID string) ([]*models.Webhook, error)
+	// UpdateWebhook replaces a stored webhook
+	UpdateWebhook(ctx context.Context, webhook *models.Webhook) error
+	DeleteWebhook(ctx context.Context, id string) error
+	// SaveDelivery creates or replaces a delivery record
+	SaveDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+	// ListDeliveries returns a page of the deliveries of a webhook, newest first, optionally
+	// restricted to a status, with the total number of matching deliveries
+	ListDeliveries(ctx context.Context, webhookID string, status models.WebhookDeliveryStatus, skip, limit int) ([]*models.WebhookDelivery, int, error)
+}
+
+// ErrWebhookNotFound is returned by every WebhookRepository implementation for unknown webhooks
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// Collections of the webhook repositories
+const (
+	webhookCollection         = "webhooks"
+	webhookDeliveryCollection = "webhook_deliveries"
+)
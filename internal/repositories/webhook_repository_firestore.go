@@ -0,0 +1,154 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"sit-iot-message-mng-api/internal/models"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type firestoreWebhookRepository struct {
+	client *firestore.Client
+}
+
+// NewFirestoreWebhookRepository creates a webhook repository on Firestore. Listing the
+// deliveries of a status needs a composite index on webhookId, status and createdAt.
+func NewFirestoreWebhookRepository(client *firestore.Client) WebhookRepository {
+	return &firestoreWebhookRepository{client: client}
+}
+
+func (r *firestoreWebhookRepository) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	if webhook.ID == "" {
+		return errors.New("webhook ID is required")
+	}
+	_, err := r.client.Collection(webhookCollection).Doc(webhook.ID).Create(ctx, webhook)
+	return err
+}
+
+func (r *firestoreWebhookRepository) FindWebhook(ctx context.Context, id string) (*models.Webhook, error) {
+	if id == "" {
+		return nil, ErrWebhookNotFound
+	}
+	doc, err := r.client.Collection(webhookCollection).Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	return decodeWebhook(doc)
+}
+
+func (r *firestoreWebhookRepository) ListWebhooks(ctx context.Context, projectID string) ([]*models.Webhook, error) {
+	query := r.client.Collection(webhookCollection).Query
+	if projectID != "" {
+		query = query.Where("projectId", "==", projectID)
+	}
+	iter := query.OrderBy("createdAt", firestore.Asc).OrderBy(firestore.DocumentID, firestore.Asc).Documents(ctx)
+	defer iter.Stop()
+
+	var webhooks []*models.Webhook
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			return webhooks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		webhook, err := decodeWebhook(doc)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+}
+
+func (r *firestoreWebhookRepository) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	ref := r.client.Collection(webhookCollection).Doc(webhook.ID)
+	return r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(ref); err != nil {
+			if status.Code(err) == codes.NotFound {
+				return ErrWebhookNotFound
+			}
+			return err
+		}
+		return tx.Set(ref, webhook)
+	})
+}
+
+func (r *firestoreWebhookRepository) DeleteWebhook(ctx context.Context, id string) error {
+	if id == "" {
+		return ErrWebhookNotFound
+	}
+	_, err := r.client.Collection(webhookCollection).Doc(id).Delete(ctx, firestore.Exists)
+	if status.Code(err) == codes.NotFound {
+		return ErrWebhookNotFound
+	}
+	return err
+}
+
+func (r *firestoreWebhookRepository) SaveDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	if delivery.ID == "" {
+		return errors.New("delivery ID is required")
+	}
+	_, err := r.client.Collection(webhookDeliveryCollection).Doc(delivery.ID).Set(ctx, delivery)
+	return err
+}
+
+func (r *firestoreWebhookRepository) ListDeliveries(ctx context.Context, webhookID string, deliveryStatus models.WebhookDeliveryStatus, skip, limit int) ([]*models.WebhookDelivery, int, error) {
+	query := r.client.Collection(webhookDeliveryCollection).Where("webhookId", "==", webhookID)
+	if deliveryStatus != "" {
+		query = query.Where("status", "==", string(deliveryStatus))
+	}
+
+	result, err := query.NewAggregationQuery().WithCount("total").Get(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, ok := result["total"].(*firestorepb.Value)
+	if !ok {
+		return nil, 0, errors.New("unexpected count aggregation result")
+	}
+
+	query = query.OrderBy("createdAt", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc).Offset(skip)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+
+	var deliveries []*models.WebhookDelivery
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		var delivery models.WebhookDelivery
+		if err := doc.DataTo(&delivery); err != nil {
+			return nil, 0, err
+		}
+		delivery.ID = doc.Ref.ID
+		deliveries = append(deliveries, &delivery)
+	}
+	return deliveries, int(total.GetIntegerValue()), nil
+}
+
+// decodeWebhook reads a webhook document, taking its ID from the document ID
+func decodeWebhook(doc *firestore.DocumentSnapshot) (*models.Webhook, error) {
+	var webhook models.Webhook
+	if err := doc.DataTo(&webhook); err != nil {
+		return nil, err
+	}
+	webhook.ID = doc.Ref.ID
+	return &webhook, nil
+}
@@ -0,0 +1,126 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+type memoryWebhookRepository struct {
+	mu         sync.RWMutex
+	webhooks   map[string]models.Webhook
+	deliveries map[string]models.WebhookDelivery
+}
+
+// NewMemoryWebhookRepository creates an empty in-memory webhook repository
+func NewMemoryWebhookRepository() WebhookRepository {
+	return &memoryWebhookRepository{
+		webhooks:   make(map[string]models.Webhook),
+		deliveries: make(map[string]models.WebhookDelivery),
+	}
+}
+
+func (r *memoryWebhookRepository) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	if webhook.ID == "" {
+		return errors.New("webhook ID is required")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.webhooks[webhook.ID]; ok {
+		return errors.New("webhook already exists: " + webhook.ID)
+	}
+	r.webhooks[webhook.ID] = *webhook
+	return nil
+}
+
+func (r *memoryWebhookRepository) FindWebhook(ctx context.Context, id string) (*models.Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	webhook, ok := r.webhooks[id]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+	return &webhook, nil
+}
+
+func (r *memoryWebhookRepository) ListWebhooks(ctx context.Context, projectID string) ([]*models.Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var webhooks []*models.Webhook
+	for _, webhook := range r.webhooks {
+		if projectID == "" || webhook.ProjectID == projectID {
+			webhook := webhook
+			webhooks = append(webhooks, &webhook)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		if !webhooks[i].CreatedAt.Equal(webhooks[j].CreatedAt) {
+			return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+		}
+		return webhooks[i].ID < webhooks[j].ID
+	})
+	return webhooks, nil
+}
+
+func (r *memoryWebhookRepository) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.webhooks[webhook.ID]; !ok {
+		return ErrWebhookNotFound
+	}
+	r.webhooks[webhook.ID] = *webhook
+	return nil
+}
+
+func (r *memoryWebhookRepository) DeleteWebhook(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.webhooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(r.webhooks, id)
+	return nil
+}
+
+func (r *memoryWebhookRepository) SaveDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	if delivery.ID == "" {
+		return errors.New("delivery ID is required")
+	}
+	stored := *delivery
+	stored.Attempts = append([]models.WebhookAttempt(nil), delivery.Attempts...)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deliveries[delivery.ID] = stored
+	return nil
+}
+
+func (r *memoryWebhookRepository) ListDeliveries(ctx context.Context, webhookID string, status models.WebhookDeliveryStatus, skip, limit int) ([]*models.WebhookDelivery, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var deliveries []*models.WebhookDelivery
+	for _, delivery := range r.deliveries {
+		if delivery.WebhookID == webhookID && (status == "" || delivery.Status == status) {
+			delivery := delivery
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		if !deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+		}
+		return deliveries[i].ID > deliveries[j].ID
+	})
+
+	total := len(deliveries)
+	if skip >= total {
+		return nil, total, nil
+	}
+	deliveries = deliveries[skip:]
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, total, nil
+}
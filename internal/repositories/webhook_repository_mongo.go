@@ -0,0 +1,118 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"sit-iot-message-mng-api/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type webhookRepository struct {
+	webhooks   *mongo.Collection
+	deliveries *mongo.Collection
+}
+
+func NewWebhookRepository(db *mongo.Database) WebhookRepository {
+	return &webhookRepository{
+		webhooks:   db.Collection(webhookCollection),
+		deliveries: db.Collection(webhookDeliveryCollection),
+	}
+}
+
+func (r *webhookRepository) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	if webhook.ID == "" {
+		return errors.New("webhook ID is required")
+	}
+	_, err := r.webhooks.InsertOne(ctx, webhook)
+	return err
+}
+
+func (r *webhookRepository) FindWebhook(ctx context.Context, id string) (*models.Webhook, error) {
+	var webhook models.Webhook
+	if err := r.webhooks.FindOne(ctx, bson.M{"_id": id}).Decode(&webhook); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (r *webhookRepository) ListWebhooks(ctx context.Context, projectID string) ([]*models.Webhook, error) {
+	filter := bson.M{}
+	if projectID != "" {
+		filter["projectId"] = projectID
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.webhooks.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var webhooks []*models.Webhook
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (r *webhookRepository) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	result, err := r.webhooks.ReplaceOne(ctx, bson.M{"_id": webhook.ID}, webhook)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (r *webhookRepository) DeleteWebhook(ctx context.Context, id string) error {
+	result, err := r.webhooks.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (r *webhookRepository) SaveDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	if delivery.ID == "" {
+		return errors.New("delivery ID is required")
+	}
+	_, err := r.deliveries.ReplaceOne(ctx, bson.M{"_id": delivery.ID}, delivery, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r *webhookRepository) ListDeliveries(ctx context.Context, webhookID string, status models.WebhookDeliveryStatus, skip, limit int) ([]*models.WebhookDelivery, int, error) {
+	filter := bson.M{"webhookId": webhookID}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	total, err := r.deliveries.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(skip))
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := r.deliveries.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	var deliveries []*models.WebhookDelivery
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, 0, err
+	}
+	return deliveries, int(total), nil
+}
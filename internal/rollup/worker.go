@@ -0,0 +1,125 @@
+// Package rollup maintains the precomputed aggregations (models.ClientAggregations) from the
+// messages stored by the service.
+package rollup
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+const (
+	// eventBuffer is the number of created messages queued for the worker
+	eventBuffer = 1024
+	// batchSize is the maximum number of queued events applied together
+	batchSize = 100
+	// applyTimeout bounds the time spent applying a batch of messages
+	applyTimeout = 10 * time.Second
+)
+
+// Worker rolls the numeric payload fields of every created message up into the hourly,
+// daily and monthly aggregations of its device
+type Worker interface {
+	// Start subscribes to the event bus and processes events in the background
+	Start(ctx context.Context) error
+	// Stop unsubscribes and waits for the queued events to be processed
+	Stop()
+	// Process applies messages, those of a device together; applying the same message
+	// again has no effect
+	Process(ctx context.Context, messages ...*models.Message) error
+}
+
+type worker struct {
+	bus    events.Bus
+	repo   repositories.AggregationRepository
+	cancel func()
+	done   chan struct{}
+}
+
+func NewWorker(bus events.Bus, repo repositories.AggregationRepository) Worker {
+	return &worker{
+		bus:  bus,
+		repo: repo,
+	}
+}
+
+func (w *worker) Start(ctx context.Context) error {
+	if w.done != nil {
+		return errors.New("rollup worker already started")
+	}
+
+	// A dropped event would leave its message out of the aggregations
+	queue, cancel := w.bus.SubscribeDurable("rollup", eventBuffer)
+	w.cancel = cancel
+	w.done = make(chan struct{})
+
+	go func() {
+		defer close(w.done)
+		for {
+			messages, open := nextBatch(queue)
+			if len(messages) > 0 {
+				applyCtx, cancelApply := context.WithTimeout(ctx, applyTimeout)
+				if err := w.Process(applyCtx, messages...); err != nil {
+					log.Printf("Rollup of %d messages failed: %v", len(messages), err)
+				}
+				cancelApply()
+			}
+			if !open {
+				return
+			}
+		}
+	}()
+	log.Printf("Rollup worker started")
+	return nil
+}
+
+func (w *worker) Stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+	w.cancel = nil
+}
+
+// nextBatch waits for an event and returns the created messages among it and the events
+// queued behind it, up to batchSize events, so the messages a device sends in a burst are
+// applied together. open is false once the queue is closed.
+func nextBatch(queue <-chan events.MessageEvent) (messages []*models.Message, open bool) {
+	event, open := <-queue
+	for n := 1; open; n++ {
+		if event.Type == events.MessageCreated {
+			messages = append(messages, event.Message)
+		}
+		if n == batchSize {
+			break
+		}
+		select {
+		case event, open = <-queue:
+		default:
+			return messages, true
+		}
+	}
+	return messages, open
+}
+
+func (w *worker) Process(ctx context.Context, messages ...*models.Message) error {
+	var rollups []models.MessageRollup
+	for _, message := range messages {
+		// Derived messages such as alerts repeat values measured by other messages of the device
+		if message.DeviceID == "" || message.GetIDAsString() == "" || message.IsDerived() {
+			continue
+		}
+		rollups = append(rollups, models.NewMessageRollup(message))
+	}
+	if len(rollups) == 0 {
+		return nil
+	}
+	_, err := w.repo.ApplyRollups(ctx, rollups)
+	return err
+}
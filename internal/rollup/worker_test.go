@@ -0,0 +1,95 @@
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+func TestWorker(t *testing.T) {
+	ctx := context.Background()
+	repo := repositories.NewMemoryMessageRepository(nil, nil)
+	aggRepo := repo.(repositories.AggregationRepository)
+	bus := events.NewBus()
+
+	w := NewWorker(bus, aggRepo)
+	if err := w.Start(ctx); err != nil {
+		t.Fatalf("Start() unexpected error: %v", err)
+	}
+
+	base := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+	var created []*models.Message
+	for i, value := range []float64{10, 20} {
+		message, err := repo.Create(ctx, &models.Message{
+			DeviceID:   "dev-1",
+			Timestamp:  base.Add(time.Duration(i) * time.Minute),
+			Marshalled: map[string]interface{}{"temperature": value},
+		})
+		if err != nil {
+			t.Fatalf("Create() unexpected error: %v", err)
+		}
+		created = append(created, message)
+		bus.Publish(events.MessageEvent{Type: events.MessageCreated, Message: message})
+	}
+	// Updates and redeliveries must not be counted again
+	bus.Publish(events.MessageEvent{Type: events.MessageUpdated, Message: created[0]})
+	bus.Publish(events.MessageEvent{Type: events.MessageCreated, Message: created[0]})
+	w.Stop()
+
+	if err := w.Process(ctx, created[1]); err != nil {
+		t.Fatalf("Process() unexpected error: %v", err)
+	}
+	if err := w.Process(ctx, &models.Message{Marshalled: map[string]interface{}{"temperature": 1.0}}); err != nil {
+		t.Fatalf("Process() without device unexpected error: %v", err)
+	}
+	alert := &models.Message{ID: "alert-1", DeviceID: "dev-1", Type: models.MessageTypeAlert, Timestamp: base, Marshalled: map[string]interface{}{"temperature": 99.0}}
+	if err := w.Process(ctx, alert); err != nil {
+		t.Fatalf("Process() of an alert unexpected error: %v", err)
+	}
+
+	rows, err := repo.GetAggregatedDataByDeviceID(ctx, "dev-1", models.AggregationFilter{Periods: []string{"hourly"}})
+	if err != nil {
+		t.Fatalf("GetAggregatedDataByDeviceID() unexpected error: %v", err)
+	}
+	var got []string
+	for _, row := range rows {
+		got = append(got, fmt.Sprintf("%s/%s/%s count=%d sum=%v avg=%v", row.Channel, row.Variable, row.Timestamp.Format(time.RFC3339), row.Count, row.Sum, row.Avg))
+	}
+	want := []string{"default/temperature/2024-03-05T10:00:00Z count=2 sum=30 avg=15"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("aggregations = %v, want %v", got, want)
+	}
+}
+
+func TestNextBatch(t *testing.T) {
+	queue := make(chan events.MessageEvent, batchSize+10)
+	for i := 0; i < batchSize+5; i++ {
+		eventType := events.MessageCreated
+		if i == 1 {
+			eventType = events.MessageUpdated
+		}
+		queue <- events.MessageEvent{Type: eventType, Message: &models.Message{ID: fmt.Sprint(i)}}
+	}
+
+	// A full batch, without the update
+	if messages, open := nextBatch(queue); len(messages) != batchSize-1 || !open || messages[1].ID != "2" {
+		t.Fatalf("nextBatch() = %d messages, %v; want %d, true", len(messages), open, batchSize-1)
+	}
+	// The rest of the queue, without waiting for more events
+	if messages, open := nextBatch(queue); len(messages) != 5 || !open {
+		t.Fatalf("nextBatch() = %d messages, %v; want 5, true", len(messages), open)
+	}
+	queue <- events.MessageEvent{Type: events.MessageCreated, Message: &models.Message{ID: "last"}}
+	close(queue)
+	if messages, open := nextBatch(queue); len(messages) != 1 || open {
+		t.Fatalf("nextBatch() before the end = %d messages, %v; want 1, false", len(messages), open)
+	}
+	if messages, open := nextBatch(queue); len(messages) != 0 || open {
+		t.Fatalf("nextBatch() at the end = %d messages, %v; want 0, false", len(messages), open)
+	}
+}
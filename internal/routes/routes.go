@@ -9,12 +9,12 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(router *gin.Engine, messageController *controllers.MessageController, cfg *config.Config) {
+func SetupRoutes(router *gin.Engine, messageController *controllers.MessageController, aggregationController *controllers.AggregationController, websocketController *controllers.WebSocketController, webhookController *controllers.WebhookController, alertController *controllers.AlertController, presenceController *controllers.PresenceController, deviceController *controllers.DeviceController, cfg *config.Config) {
 	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost", "http://localhost:5173", "http://127.0.0.1:5173", "https://console.sit-iot.com"},
+		AllowOrigins:     cfg.AllowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type", "Range"},
-		ExposeHeaders:    []string{"Content-Length", "Content-Range"},
+		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type", "Range", "Last-Event-ID"},
+		ExposeHeaders:    []string{"Content-Length", "Content-Range", "X-Next-Cursor"},
 		AllowCredentials: true,
 	}))
 
@@ -22,11 +22,72 @@ func SetupRoutes(router *gin.Engine, messageController *controllers.MessageContr
 	{
 		// Message routes
 		api.GET("/message/:id", messageController.GetMessage)
+		api.POST("/message", messageController.CreateMessage)
+		api.POST("/message/batch", messageController.CreateMessages)
+		api.PUT("/message/:id", messageController.UpdateMessage)
+		api.DELETE("/message/:id", messageController.DeleteMessage)
+
+		// Topic classification rules
+		api.GET("/message/topic-rules", messageController.ListTopicRules)
+		api.GET("/message/topic-rules/preview", messageController.PreviewTopicRules)
 
 		// Device-specific message routes
 		api.GET("/message/device/:deviceId", messageController.ListMessagesByDevice)
 
 		// Aggregated data for device (for graphing max, min, avg)
 		api.GET("/message/aggregations/device/:deviceId", messageController.GetAggregatedDataByDevice)
+
+		// Aggregations of several devices aligned on the same buckets (for comparison charts)
+		api.GET("/message/aggregations/compare", messageController.CompareDevices)
+
+		// Downsampled time series of a device variable (for charting raw values)
+		api.GET("/message/series/device/:deviceId", messageController.GetDeviceSeries)
+
+		// Gaps and coverage of the reports of a device against its expected interval
+		api.GET("/message/completeness/device/:deviceId", messageController.CheckDeviceCompleteness)
+
+		// Webhooks notified of the created messages of a project, and their deliveries
+		api.GET("/project/:projectId/webhooks", webhookController.ListWebhooks)
+		api.POST("/project/:projectId/webhooks", webhookController.CreateWebhook)
+		api.GET("/project/:projectId/webhooks/:webhookId", webhookController.GetWebhook)
+		api.PUT("/project/:projectId/webhooks/:webhookId", webhookController.UpdateWebhook)
+		api.DELETE("/project/:projectId/webhooks/:webhookId", webhookController.DeleteWebhook)
+		api.GET("/project/:projectId/webhooks/:webhookId/deliveries", webhookController.ListDeliveries)
+
+		// Alert rules of a project and the alerts they raise
+		api.GET("/project/:projectId/alert-rules", alertController.ListRules)
+		api.POST("/project/:projectId/alert-rules", alertController.CreateRule)
+		api.GET("/project/:projectId/alert-rules/:ruleId", alertController.GetRule)
+		api.PUT("/project/:projectId/alert-rules/:ruleId", alertController.UpdateRule)
+		api.DELETE("/project/:projectId/alert-rules/:ruleId", alertController.DeleteRule)
+		api.GET("/project/:projectId/alerts", alertController.ListAlerts)
+		api.GET("/project/:projectId/alerts/:alertId", alertController.GetAlert)
+		api.POST("/project/:projectId/alerts/:alertId/acknowledge", alertController.AcknowledgeAlert)
+		api.POST("/project/:projectId/alerts/:alertId/resolve", alertController.ResolveAlert)
+
+		// Presence of the devices of a project and its history
+		api.GET("/project/:projectId/presence", presenceController.ListPresence)
+		api.GET("/project/:projectId/presence/:deviceId", presenceController.GetPresence)
+		api.GET("/project/:projectId/presence/:deviceId/history", presenceController.ListTransitions)
+
+		// Device registry of a project
+		api.GET("/project/:projectId/devices", deviceController.ListDevices)
+		api.POST("/project/:projectId/devices", deviceController.CreateDevice)
+		api.GET("/project/:projectId/devices/:id", deviceController.GetDevice)
+		api.PUT("/project/:projectId/devices/:id", deviceController.UpdateDevice)
+		api.DELETE("/project/:projectId/devices/:id", deviceController.DeleteDevice)
+
+		// Admin routes, restricted to ADMIN_EMAILS
+		admin := api.Group("/admin", middleware.RequireAdmin(cfg))
+		admin.POST("/aggregations/recompute", aggregationController.StartRecompute)
+		admin.GET("/aggregations/recompute/:jobId", aggregationController.GetRecompute)
+	}
+
+	// Live messages (Server-Sent Events and WebSocket). Browsers cannot set headers on these
+	// connections, so the token may also be passed as the access_token query parameter.
+	live := router.Group("/api", middleware.AccessTokenFromQuery(), middleware.IdentityPlatformMiddleware(cfg))
+	{
+		live.GET("/message/device/:deviceId/stream", messageController.StreamMessagesByDevice)
+		live.GET("/message/ws", websocketController.Subscribe)
 	}
 }
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+// AggregationRecomputeOptions selects the precomputed aggregations to rebuild from the raw messages
+type AggregationRecomputeOptions struct {
+	DeviceID  string    `json:"deviceId,omitempty"`  // Device to recompute
+	ProjectID string    `json:"projectId,omitempty"` // Or every device with messages of the project
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	BatchSize int       `json:"-"` // Messages read per page
+	// Progress, when set, is called with a snapshot after every page and every device
+	Progress func(AggregationRecomputeProgress) `json:"-"`
+}
+
+// Validate checks that the options select a device or a project and a time range
+func (o AggregationRecomputeOptions) Validate() error {
+	if (o.DeviceID == "") == (o.ProjectID == "") {
+		return fmt.Errorf("%w: exactly one of deviceId and projectId is required", ErrInvalidQuery)
+	}
+	if o.From.IsZero() || o.To.IsZero() {
+		return fmt.Errorf("%w: from and to are required", ErrInvalidQuery)
+	}
+	if o.From.After(o.To) {
+		return fmt.Errorf("%w: from must not be after to", ErrInvalidQuery)
+	}
+	if o.BatchSize <= 0 {
+		return fmt.Errorf("%w: batch size must be positive", ErrInvalidQuery)
+	}
+	return nil
+}
+
+// AggregationRecomputeProgress reports how far a recompute run has got
+type AggregationRecomputeProgress struct {
+	From     time.Time `json:"from"`     // Start of the recomputed range, widened to whole months
+	To       time.Time `json:"to"`       // Exclusive end of the recomputed range
+	Total    int       `json:"total"`    // Messages in the range when the run started
+	Scanned  int       `json:"scanned"`  // Messages read so far
+	Devices  int       `json:"devices"`  // Devices whose buckets were replaced
+	Replaced int       `json:"replaced"` // Buckets written
+}
+
+// AggregationRecompute rebuilds the precomputed aggregations of a time range from the raw messages
+type AggregationRecompute interface {
+	Run(ctx context.Context, opts AggregationRecomputeOptions) (*AggregationRecomputeProgress, error)
+}
+
+type aggregationRecompute struct {
+	messageRepo     repositories.MessageRepository
+	aggregationRepo repositories.AggregationRepository
+}
+
+func NewAggregationRecompute(messageRepo repositories.MessageRepository, aggregationRepo repositories.AggregationRepository) AggregationRecompute {
+	return &aggregationRecompute{
+		messageRepo:     messageRepo,
+		aggregationRepo: aggregationRepo,
+	}
+}
+
+// recomputeAttempts bounds the recomputes of a device whose aggregations keep changing
+// while its messages are read
+const recomputeAttempts = 5
+
+// Run widens the range to whole UTC months, since a monthly bucket can only be rebuilt from
+// all of its messages, and replaces the buckets of the range device by device with those
+// rolled up again from the messages of the device
+func (r *aggregationRecompute) Run(ctx context.Context, opts AggregationRecomputeOptions) (*AggregationRecomputeProgress, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	from, to := recomputeRange(opts.From, opts.To)
+	last := to.Add(-time.Nanosecond)
+	// Derived messages such as alerts are not device data
+	filter := models.MessageFilter{DeviceID: opts.DeviceID, ProjectID: opts.ProjectID, FromTime: &from, ToTime: &last, ExcludeTypes: models.DerivedMessageTypes}
+
+	total, err := r.countDeviceData(ctx, filter)
+	if err != nil {
+		return nil, wrapQueryError(err)
+	}
+	progress := &AggregationRecomputeProgress{From: from, To: to, Total: total}
+	report := func() {
+		if opts.Progress != nil {
+			opts.Progress(*progress)
+		}
+	}
+	report()
+
+	// A device without messages left in the range gets its buckets cleared
+	deviceIDs := []string{opts.DeviceID}
+	if opts.DeviceID == "" {
+		if deviceIDs, err = r.messageRepo.DistinctDeviceIDs(ctx, filter); err != nil {
+			return progress, wrapQueryError(err)
+		}
+	}
+	for _, deviceID := range deviceIDs {
+		filter.DeviceID = deviceID
+		scanned := progress.Scanned
+		for attempt := 1; ; attempt++ {
+			replaced, err := r.recomputeDevice(ctx, filter, opts.BatchSize, progress, report)
+			if errors.Is(err, repositories.ErrAggregationsChanged) && attempt < recomputeAttempts {
+				// A rollup landed meanwhile: the messages of the device are read again
+				progress.Scanned = scanned
+				continue
+			}
+			if err != nil {
+				return progress, fmt.Errorf("device %s: %w", deviceID, err)
+			}
+			progress.Devices++
+			progress.Replaced += replaced
+			report()
+			break
+		}
+	}
+	return progress, nil
+}
+
+// recomputeDevice rolls up the messages of the device of the filter and replaces the
+// buckets of the range with them, returning the number of buckets written. The
+// aggregations are snapshotted before the messages are read, so the replacement fails
+// with repositories.ErrAggregationsChanged rather than overwrite a rollup applied while
+// they were read.
+func (r *aggregationRecompute) recomputeDevice(ctx context.Context, filter models.MessageFilter, batchSize int, progress *AggregationRecomputeProgress, report func()) (int, error) {
+	snapshot, err := r.aggregationRepo.SnapshotBuckets(ctx, filter.DeviceID, progress.From, progress.To)
+	if err != nil {
+		return 0, err
+	}
+
+	aggregations := &models.ClientAggregations{ClientID: filter.DeviceID}
+	var messageIDs []string
+	var cursor *models.MessageCursor
+	for {
+		messages, next, err := r.messageRepo.ListByCursor(ctx, filter, cursor, "ASC", batchSize)
+		if err != nil {
+			return 0, wrapQueryError(err)
+		}
+		for _, message := range messages {
+			progress.Scanned++
+			// Only the hourly buckets are built from the messages; the coarser ones are
+			// merged from them below
+			rollup := models.NewMessageRollup(message)
+			for _, sample := range rollup.Samples {
+				if sample.Period == models.PeriodHourly {
+					aggregations.Apply(sample)
+				}
+			}
+			messageIDs = append(messageIDs, rollup.MessageID)
+		}
+		report()
+
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+
+	aggregations.DerivePeriod(models.PeriodHourly, models.PeriodDaily)
+	aggregations.DerivePeriod(models.PeriodHourly, models.PeriodMonthly)
+	if err := r.aggregationRepo.ReplaceBuckets(ctx, snapshot, aggregations, messageIDs); err != nil {
+		return 0, err
+	}
+	return len(aggregations.BucketPaths(progress.From, progress.To)), nil
+}
+
+// countDeviceData counts the messages of the filter as all of them minus the derived ones,
+// since not every database provider can count with excluded types
+func (r *aggregationRecompute) countDeviceData(ctx context.Context, filter models.MessageFilter) (int, error) {
+	filter.ExcludeTypes = nil
+	_, total, err := r.messageRepo.List(ctx, filter, "timestamp", "ASC", 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	filter.Types = models.DerivedMessageTypes
+	_, derived, err := r.messageRepo.List(ctx, filter, "timestamp", "ASC", 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	return total - derived, nil
+}
+
+// recomputeRange widens [from, to] to the UTC months holding it; the end is exclusive
+func recomputeRange(from, to time.Time) (time.Time, time.Time) {
+	from, to = from.UTC(), to.UTC()
+	start := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+	return start, end
+}
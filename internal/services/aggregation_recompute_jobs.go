@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrJobNotFound is returned for unknown recompute job IDs
+var ErrJobNotFound = errors.New("job not found")
+
+// Recompute job statuses
+const (
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+// recomputeBatchSize is the page size of recompute jobs started through the API
+const recomputeBatchSize = 500
+
+// RecomputeJob is the state of an aggregation recompute run in the background
+type RecomputeJob struct {
+	ID          string                       `json:"id"`
+	Status      string                       `json:"status"`
+	DeviceID    string                       `json:"deviceId,omitempty"`
+	ProjectID   string                       `json:"projectId,omitempty"`
+	RequestedBy string                       `json:"requestedBy"`
+	Progress    AggregationRecomputeProgress `json:"progress"`
+	Error       string                       `json:"error,omitempty"`
+	StartedAt   time.Time                    `json:"startedAt"`
+	FinishedAt  *time.Time                   `json:"finishedAt,omitempty"`
+}
+
+// RecomputeJobs runs aggregation recomputes in the background and keeps their state in
+// memory, so jobs are lost on restart
+type RecomputeJobs interface {
+	// Start validates the options and starts a job
+	Start(ctx context.Context, opts AggregationRecomputeOptions) (*RecomputeJob, error)
+	// Get returns a snapshot of a job
+	Get(id string) (*RecomputeJob, error)
+}
+
+type recomputeJobs struct {
+	recompute AggregationRecompute
+	mu        sync.RWMutex
+	jobs      map[string]*RecomputeJob
+}
+
+func NewRecomputeJobs(recompute AggregationRecompute) RecomputeJobs {
+	return &recomputeJobs{
+		recompute: recompute,
+		jobs:      make(map[string]*RecomputeJob),
+	}
+}
+
+func (j *recomputeJobs) Start(ctx context.Context, opts AggregationRecomputeOptions) (*RecomputeJob, error) {
+	requestedBy, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = recomputeBatchSize
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	from, to := recomputeRange(opts.From, opts.To)
+	job := &RecomputeJob{
+		ID:          id,
+		Status:      JobStatusRunning,
+		DeviceID:    opts.DeviceID,
+		ProjectID:   opts.ProjectID,
+		RequestedBy: requestedBy,
+		Progress:    AggregationRecomputeProgress{From: from, To: to},
+		StartedAt:   time.Now().UTC(),
+	}
+	j.mu.Lock()
+	j.jobs[id] = job
+	snapshot := *job
+	j.mu.Unlock()
+
+	opts.Progress = func(progress AggregationRecomputeProgress) {
+		j.mu.Lock()
+		job.Progress = progress
+		j.mu.Unlock()
+	}
+	// The job outlives the request that started it
+	go func() {
+		_, err := j.recompute.Run(context.Background(), opts)
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		finished := time.Now().UTC()
+		job.FinishedAt = &finished
+		job.Status = JobStatusSucceeded
+		if err != nil {
+			job.Status = JobStatusFailed
+			job.Error = err.Error()
+			log.Printf("Aggregation recompute job %s failed: %v", job.ID, err)
+		}
+	}()
+	return &snapshot, nil
+}
+
+func (j *recomputeJobs) Get(id string) (*RecomputeJob, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	job, ok := j.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// newJobID returns a random hex job ID
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
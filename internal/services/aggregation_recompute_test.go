@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+// newRecomputeRepo seeds a device whose March aggregations missed a late message, and an
+// alert of the device that must not be aggregated
+func newRecomputeRepo() repositories.MessageRepository {
+	march := time.Date(2024, 3, 5, 10, 15, 0, 0, time.UTC)
+	var messages []*models.Message
+	for i, m := range []struct {
+		device      string
+		messageType models.MessageType
+		timestamp   time.Time
+		value       float64
+	}{
+		{"dev-1", models.MessageTypeTelemetry, march, 10},
+		{"dev-1", models.MessageTypeTelemetry, march.Add(30 * time.Minute), 30},
+		{"dev-1", models.MessageTypeTelemetry, march.AddDate(0, 1, 0), 5},
+		{"dev-2", models.MessageTypeTelemetry, march, 7},
+		{"dev-1", models.MessageTypeAlert, march.Add(30 * time.Minute), 30},
+	} {
+		message := &models.Message{
+			ProjectID:  "p1",
+			DeviceID:   m.device,
+			Type:       m.messageType,
+			Timestamp:  m.timestamp,
+			Marshalled: map[string]interface{}{"temperature": m.value},
+		}
+		message.SetIDFromString(fmt.Sprintf("65a00000000000000000000%d", i+1))
+		messages = append(messages, message)
+	}
+
+	stale := &models.ClientAggregations{ClientID: "dev-1"}
+	for _, message := range messages[:1] {
+		for _, sample := range models.NewMessageRollup(message).Samples {
+			stale.Apply(sample)
+		}
+	}
+	stale.Apply(models.RollupSample{Channel: "ch9", Variable: "old", Period: models.PeriodDaily, Key: "2024-03-01", Value: 1})
+	stale.Apply(models.RollupSample{Channel: "ch9", Variable: "old", Period: models.PeriodDaily, Key: "2024-02-28", Value: 1})
+	return repositories.NewMemoryMessageRepository(messages, []*models.ClientAggregations{stale})
+}
+
+func TestAggregationRecompute(t *testing.T) {
+	ctx := context.Background()
+	repo := newRecomputeRepo()
+	recompute := NewAggregationRecompute(repo, repo.(repositories.AggregationRepository))
+
+	var reports int
+	progress, err := recompute.Run(ctx, AggregationRecomputeOptions{
+		DeviceID:  "dev-1",
+		From:      time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC),
+		To:        time.Date(2024, 3, 5, 23, 0, 0, 0, time.UTC),
+		BatchSize: 1,
+		Progress:  func(AggregationRecomputeProgress) { reports++ },
+	})
+	if err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+	wantProgress := AggregationRecomputeProgress{
+		From:     time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		To:       time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		Total:    2,
+		Scanned:  2,
+		Devices:  1,
+		Replaced: 3,
+	}
+	if *progress != wantProgress {
+		t.Errorf("Run() progress = %+v, want %+v", *progress, wantProgress)
+	}
+	// Initial report, one per page and one per device
+	if reports != 4 {
+		t.Errorf("Run() reported progress %d times, want 4", reports)
+	}
+
+	rows, err := repo.GetAggregatedDataByDeviceID(ctx, "dev-1", models.AggregationFilter{})
+	if err != nil {
+		t.Fatalf("GetAggregatedDataByDeviceID() unexpected error: %v", err)
+	}
+	var got []string
+	for _, row := range rows {
+		got = append(got, fmt.Sprintf("%s/%s/%s/%s count=%d sum=%v", row.Channel, row.Variable, row.Period, row.Timestamp.Format(time.RFC3339), row.Count, row.Sum))
+	}
+	want := []string{
+		"ch9/old/daily/2024-02-28T00:00:00Z count=1 sum=1",
+		"default/temperature/monthly/2024-03-01T00:00:00Z count=2 sum=40",
+		"default/temperature/daily/2024-03-05T00:00:00Z count=2 sum=40",
+		"default/temperature/hourly/2024-03-05T10:00:00Z count=2 sum=40",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("aggregations after recompute = %v, want %v", got, want)
+	}
+
+	// The rollups queued for the recomputed messages are ignored
+	message, _ := repo.FindByID(ctx, "65a000000000000000000002")
+	if applied, err := repo.(repositories.AggregationRepository).ApplyRollups(ctx, []models.MessageRollup{models.NewMessageRollup(message)}); err != nil || applied != 0 {
+		t.Errorf("ApplyRollups() after recompute = %d, %v; want 0, nil", applied, err)
+	}
+}
+
+func TestAggregationRecomputeProject(t *testing.T) {
+	ctx := context.Background()
+	repo := newRecomputeRepo()
+	recompute := NewAggregationRecompute(repo, repo.(repositories.AggregationRepository))
+
+	progress, err := recompute.Run(ctx, AggregationRecomputeOptions{
+		ProjectID: "p1",
+		From:      time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		To:        time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC),
+		BatchSize: 10,
+	})
+	if err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+	if progress.Scanned != 4 || progress.Devices != 2 {
+		t.Errorf("Run() progress = %+v, want 4 messages of 2 devices", *progress)
+	}
+
+	rows, err := repo.GetAggregatedDataByDeviceID(ctx, "dev-2", models.AggregationFilter{Periods: []string{"monthly"}})
+	if err != nil {
+		t.Fatalf("GetAggregatedDataByDeviceID() unexpected error: %v", err)
+	}
+	if len(rows) != 1 || rows[0].Count != 1 || rows[0].Sum != 7 {
+		t.Errorf("dev-2 monthly aggregations = %+v, want one bucket with 7", rows)
+	}
+}
+
+// racingAggregationRepository stores a message of the device and applies its rollup right
+// after each of the first races snapshots, as the rollup worker would while a recompute
+// reads the messages
+type racingAggregationRepository struct {
+	repositories.AggregationRepository
+	messageRepo repositories.MessageRepository
+	races       int
+	snapshots   int
+}
+
+func (r *racingAggregationRepository) SnapshotBuckets(ctx context.Context, deviceID string, from, to time.Time) (*repositories.AggregationSnapshot, error) {
+	snapshot, err := r.AggregationRepository.SnapshotBuckets(ctx, deviceID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	r.snapshots++
+	if r.snapshots <= r.races {
+		message, err := r.messageRepo.Create(ctx, &models.Message{
+			ProjectID:  "p1",
+			DeviceID:   deviceID,
+			Type:       models.MessageTypeTelemetry,
+			Timestamp:  time.Date(2024, 3, 5, 10, 45, 0, 0, time.UTC),
+			Marshalled: map[string]interface{}{"temperature": 20.0},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := r.AggregationRepository.ApplyRollups(ctx, []models.MessageRollup{models.NewMessageRollup(message)}); err != nil {
+			return nil, err
+		}
+	}
+	return snapshot, nil
+}
+
+func TestAggregationRecomputeConcurrentRollups(t *testing.T) {
+	ctx := context.Background()
+	opts := AggregationRecomputeOptions{
+		DeviceID:  "dev-1",
+		From:      time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC),
+		To:        time.Date(2024, 3, 5, 23, 0, 0, 0, time.UTC),
+		BatchSize: 10,
+	}
+
+	tests := []struct {
+		name          string
+		races         int
+		wantErr       error
+		wantSnapshots int
+		wantScanned   int
+		wantHourly    string
+	}{
+		{"retried", 1, nil, 2, 3, "count=3 sum=60"},
+		{"changing on every attempt", recomputeAttempts, repositories.ErrAggregationsChanged, recomputeAttempts, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRecomputeRepo()
+			aggRepo := &racingAggregationRepository{AggregationRepository: repo.(repositories.AggregationRepository), messageRepo: repo, races: tt.races}
+			progress, err := NewAggregationRecompute(repo, aggRepo).Run(ctx, opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+			if aggRepo.snapshots != tt.wantSnapshots {
+				t.Errorf("Run() took %d snapshots, want %d", aggRepo.snapshots, tt.wantSnapshots)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if progress.Scanned != tt.wantScanned || progress.Devices != 1 {
+				t.Errorf("Run() progress = %+v, want %d messages of 1 device", *progress, tt.wantScanned)
+			}
+
+			// The message rolled up during the first attempt is counted once
+			hour := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+			rows, err := repo.GetAggregatedDataByDeviceID(ctx, "dev-1", models.AggregationFilter{Periods: []string{"hourly"}, FromTime: &hour, ToTime: &hour})
+			if err != nil || len(rows) != 1 {
+				t.Fatalf("GetAggregatedDataByDeviceID() = %v, %v; want one bucket", rows, err)
+			}
+			if got := fmt.Sprintf("count=%d sum=%v", rows[0].Count, rows[0].Sum); got != tt.wantHourly {
+				t.Errorf("hourly bucket = %s, want %s", got, tt.wantHourly)
+			}
+		})
+	}
+}
+
+func TestAggregationRecomputeValidation(t *testing.T) {
+	repo := newRecomputeRepo()
+	recompute := NewAggregationRecompute(repo, repo.(repositories.AggregationRepository))
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		opts AggregationRecomputeOptions
+	}{
+		{"no device or project", AggregationRecomputeOptions{From: from, To: from, BatchSize: 1}},
+		{"device and project", AggregationRecomputeOptions{DeviceID: "dev-1", ProjectID: "p1", From: from, To: from, BatchSize: 1}},
+		{"missing range", AggregationRecomputeOptions{DeviceID: "dev-1", BatchSize: 1}},
+		{"inverted range", AggregationRecomputeOptions{DeviceID: "dev-1", From: from, To: from.Add(-time.Hour), BatchSize: 1}},
+		{"batch size", AggregationRecomputeOptions{DeviceID: "dev-1", From: from, To: from}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := recompute.Run(context.Background(), tt.opts); !errors.Is(err, ErrInvalidQuery) {
+				t.Errorf("Run() error = %v, want %v", err, ErrInvalidQuery)
+			}
+		})
+	}
+}
+
+func TestRecomputeJobs(t *testing.T) {
+	repo := newRecomputeRepo()
+	jobs := NewRecomputeJobs(NewAggregationRecompute(repo, repo.(repositories.AggregationRepository)))
+
+	opts := AggregationRecomputeOptions{DeviceID: "dev-1", From: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC)}
+	if _, err := jobs.Start(context.Background(), opts); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Start() without user error = %v, want %v", err, ErrUnauthenticated)
+	}
+	if _, err := jobs.Start(testContext(), AggregationRecomputeOptions{DeviceID: "dev-1"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("Start() without range error = %v, want %v", err, ErrInvalidQuery)
+	}
+
+	job, err := jobs.Start(testContext(), opts)
+	if err != nil {
+		t.Fatalf("Start() unexpected error: %v", err)
+	}
+	if job.Status != JobStatusRunning || job.RequestedBy != "user@example.com" {
+		t.Errorf("Start() = %+v, want a running job of user@example.com", job)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status == JobStatusRunning && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		if job, err = jobs.Get(job.ID); err != nil {
+			t.Fatalf("Get() unexpected error: %v", err)
+		}
+	}
+	if job.Status != JobStatusSucceeded || job.Progress.Scanned != 2 || job.FinishedAt == nil {
+		t.Errorf("Get() = %+v, want a succeeded job that scanned 2 messages", job)
+	}
+
+	if _, err := jobs.Get("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Get(missing) error = %v, want %v", err, ErrJobNotFound)
+	}
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrInvalidAlertRule is returned when a rule submitted for creation or update is not valid
+	ErrInvalidAlertRule = errors.New("invalid alert rule")
+	// ErrAlertStateConflict is returned when an alert cannot move to the requested state,
+	// e.g. when acknowledging a resolved alert
+	ErrAlertStateConflict = errors.New("alert state conflict")
+)
+
+// The not found errors of the alert repository are re-exported so controllers can map them
+// to an HTTP status
+var (
+	ErrAlertRuleNotFound = repositories.ErrAlertRuleNotFound
+	ErrAlertNotFound     = repositories.ErrAlertNotFound
+)
+
+// MaxAlertRulesPerProject caps the number of alert rules of a project
+const MaxAlertRulesPerProject = 100
+
+// AlertService manages the alert rules of the projects of the user and the lifecycle of the
+// alerts they raise. The rules are evaluated by the alerting engine.
+type AlertService interface {
+	// CreateRule stores a new enabled rule of the project
+	CreateRule(ctx context.Context, projectID string, rule *models.AlertRule) (*models.AlertRule, error)
+	GetRule(ctx context.Context, projectID, id string) (*models.AlertRule, error)
+	ListRules(ctx context.Context, projectID string) ([]*models.AlertRule, error)
+	UpdateRule(ctx context.Context, projectID, id string, update models.AlertRuleUpdate) (*models.AlertRule, error)
+	// DeleteRule deletes a rule and resolves its active alerts
+	DeleteRule(ctx context.Context, projectID, id string) error
+
+	// ListAlerts returns a page of the alerts of the project selected by the filter, most
+	// recently opened first, with the total number of selected alerts
+	ListAlerts(ctx context.Context, projectID string, filter models.AlertFilter, skip, limit int) ([]*models.Alert, int, error)
+	GetAlert(ctx context.Context, projectID, id string) (*models.Alert, error)
+	// AcknowledgeAlert records that the user handles an open alert
+	AcknowledgeAlert(ctx context.Context, projectID, id string) (*models.Alert, error)
+	// ResolveAlert closes an open or acknowledged alert
+	ResolveAlert(ctx context.Context, projectID, id string) (*models.Alert, error)
+}
+
+type alertService struct {
+	alertRepo   repositories.AlertRepository
+	messageRepo repositories.MessageRepository
+	bus         events.Bus
+	projects    ProjectAuthorizer
+}
+
+func NewAlertService(alertRepo repositories.AlertRepository, messageRepo repositories.MessageRepository, bus events.Bus, projects ProjectAuthorizer) AlertService {
+	return &alertService{
+		alertRepo:   alertRepo,
+		messageRepo: messageRepo,
+		bus:         bus,
+		projects:    projects,
+	}
+}
+
+func (s *alertService) CreateRule(ctx context.Context, projectID string, rule *models.AlertRule) (*models.AlertRule, error) {
+	createdBy, err := s.authorize(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if rule == nil {
+		return nil, fmt.Errorf("%w: no rule provided", ErrInvalidAlertRule)
+	}
+
+	existing, err := s.alertRepo.ListRules(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= MaxAlertRulesPerProject {
+		return nil, fmt.Errorf("%w: a project has at most %d alert rules", ErrInvalidAlertRule, MaxAlertRulesPerProject)
+	}
+
+	now := time.Now().UTC()
+	stored := *rule
+	stored.ID = primitive.NewObjectID().Hex()
+	stored.ProjectID = projectID
+	stored.Enabled = true
+	stored.CreatedBy = createdBy
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	if stored.Severity == "" {
+		stored.Severity = models.AlertSeverityWarning
+	}
+	if err := stored.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAlertRule, err)
+	}
+
+	if err := s.alertRepo.CreateRule(ctx, &stored); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+func (s *alertService) GetRule(ctx context.Context, projectID, id string) (*models.AlertRule, error) {
+	if _, err := s.authorize(ctx, projectID); err != nil {
+		return nil, err
+	}
+	return s.findRule(ctx, projectID, id)
+}
+
+func (s *alertService) ListRules(ctx context.Context, projectID string) ([]*models.AlertRule, error) {
+	if _, err := s.authorize(ctx, projectID); err != nil {
+		return nil, err
+	}
+	return s.alertRepo.ListRules(ctx, projectID)
+}
+
+func (s *alertService) UpdateRule(ctx context.Context, projectID, id string, update models.AlertRuleUpdate) (*models.AlertRule, error) {
+	if _, err := s.authorize(ctx, projectID); err != nil {
+		return nil, err
+	}
+	if update.IsEmpty() {
+		return nil, fmt.Errorf("%w: no fields to update", ErrInvalidAlertRule)
+	}
+
+	rule, err := s.findRule(ctx, projectID, id)
+	if err != nil {
+		return nil, err
+	}
+	update.Apply(rule)
+	if err := rule.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAlertRule, err)
+	}
+	rule.UpdatedAt = time.Now().UTC()
+
+	if err := s.alertRepo.UpdateRule(ctx, rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (s *alertService) DeleteRule(ctx context.Context, projectID, id string) error {
+	user, err := s.authorize(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.findRule(ctx, projectID, id); err != nil {
+		return err
+	}
+	if err := s.alertRepo.DeleteRule(ctx, id); err != nil {
+		return err
+	}
+
+	active, _, err := s.alertRepo.ListAlerts(ctx, models.AlertFilter{RuleID: id, States: []models.AlertState{models.AlertStateOpen, models.AlertStateAcknowledged}}, 0, 0)
+	if err != nil {
+		return err
+	}
+	for _, alert := range active {
+		if err := alert.Resolve(user, time.Now().UTC()); err != nil {
+			return err
+		}
+		if err := s.saveAlert(ctx, alert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *alertService) ListAlerts(ctx context.Context, projectID string, filter models.AlertFilter, skip, limit int) ([]*models.Alert, int, error) {
+	if _, err := s.authorize(ctx, projectID); err != nil {
+		return nil, 0, err
+	}
+	for _, state := range filter.States {
+		switch state {
+		case models.AlertStateOpen, models.AlertStateAcknowledged, models.AlertStateResolved:
+		default:
+			return nil, 0, fmt.Errorf("%w: unsupported alert state %q", ErrInvalidQuery, state)
+		}
+	}
+	filter.ProjectID = projectID
+	return s.alertRepo.ListAlerts(ctx, filter, skip, limit)
+}
+
+func (s *alertService) GetAlert(ctx context.Context, projectID, id string) (*models.Alert, error) {
+	if _, err := s.authorize(ctx, projectID); err != nil {
+		return nil, err
+	}
+	return s.findAlert(ctx, projectID, id)
+}
+
+func (s *alertService) AcknowledgeAlert(ctx context.Context, projectID, id string) (*models.Alert, error) {
+	return s.transition(ctx, projectID, id, (*models.Alert).Acknowledge)
+}
+
+func (s *alertService) ResolveAlert(ctx context.Context, projectID, id string) (*models.Alert, error) {
+	return s.transition(ctx, projectID, id, (*models.Alert).Resolve)
+}
+
+// transition moves an alert of the project to another state on behalf of the user
+func (s *alertService) transition(ctx context.Context, projectID, id string, apply func(alert *models.Alert, user string, at time.Time) error) (*models.Alert, error) {
+	user, err := s.authorize(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	alert, err := s.findAlert(ctx, projectID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := apply(alert, user, time.Now().UTC()); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAlertStateConflict, err)
+	}
+	if err := s.saveAlert(ctx, alert); err != nil {
+		return nil, err
+	}
+	return alert, nil
+}
+
+// saveAlert stores a changed alert and brings its alert message up to date
+func (s *alertService) saveAlert(ctx context.Context, alert *models.Alert) error {
+	if err := s.alertRepo.UpdateAlert(ctx, alert); err != nil {
+		return err
+	}
+	if alert.MessageID == "" {
+		return nil
+	}
+	message, err := s.messageRepo.Update(ctx, alert.MessageID, alert.MessageUpdate())
+	if errors.Is(err, repositories.ErrMessageNotFound) {
+		// The alert message was deleted; the alert is the record that matters
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if s.bus != nil {
+		s.bus.Publish(events.MessageEvent{Type: events.MessageUpdated, Message: message})
+	}
+	return nil
+}
+
+// authorize checks that the user may manage the alerts of the project and returns the user
+func (s *alertService) authorize(ctx context.Context, projectID string) (string, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	if projectID == "" {
+		return "", fmt.Errorf("%w: project ID is required", ErrInvalidQuery)
+	}
+	if err := s.projects.AuthorizeProject(ctx, projectID); err != nil {
+		return "", err
+	}
+	return user, nil
+}
+
+// findRule returns a rule of the project; rules of other projects are not found
+func (s *alertService) findRule(ctx context.Context, projectID, id string) (*models.AlertRule, error) {
+	rule, err := s.alertRepo.FindRule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if rule.ProjectID != projectID {
+		return nil, ErrAlertRuleNotFound
+	}
+	return rule, nil
+}
+
+// findAlert returns an alert of the project; alerts of other projects are not found
+func (s *alertService) findAlert(ctx context.Context, projectID, id string) (*models.Alert, error) {
+	alert, err := s.alertRepo.FindAlert(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if alert.ProjectID != projectID {
+		return nil, ErrAlertNotFound
+	}
+	return alert, nil
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+func TestAlertServiceRules(t *testing.T) {
+	repo := repositories.NewMemoryAlertRepository()
+	service := NewAlertService(repo, repositories.NewMemoryMessageRepository(nil, nil), events.NewBus(), projectMembership{"p1", "p2"})
+	ctx := testContext()
+
+	created, err := service.CreateRule(ctx, "p1", &models.AlertRule{
+		ID:         "ignored",
+		Name:       "hot",
+		Kind:       models.AlertRuleThreshold,
+		Variable:   "temperature",
+		Operator:   ">",
+		Threshold:  80,
+		ForSeconds: 300,
+	})
+	if err != nil {
+		t.Fatalf("CreateRule() unexpected error: %v", err)
+	}
+	if created.ID == "ignored" || created.ProjectID != "p1" || !created.Enabled || created.Severity != models.AlertSeverityWarning || created.CreatedBy != "user@example.com" {
+		t.Errorf("CreateRule() = %+v, want an enabled p1 warning rule", created)
+	}
+
+	if _, err := service.GetRule(ctx, "p2", created.ID); !errors.Is(err, ErrAlertRuleNotFound) {
+		t.Errorf("GetRule() from another project error = %v, want %v", err, ErrAlertRuleNotFound)
+	}
+
+	threshold := 90.0
+	updated, err := service.UpdateRule(ctx, "p1", created.ID, models.AlertRuleUpdate{Threshold: &threshold})
+	if err != nil {
+		t.Fatalf("UpdateRule() unexpected error: %v", err)
+	}
+	if updated.Threshold != 90 || updated.Variable != "temperature" {
+		t.Errorf("UpdateRule() = %+v, want threshold 90", updated)
+	}
+
+	rules, err := service.ListRules(ctx, "p1")
+	if err != nil || len(rules) != 1 || rules[0].Threshold != 90 {
+		t.Errorf("ListRules() = %+v, %v; want the updated rule", rules, err)
+	}
+}
+
+func TestAlertServiceLifecycle(t *testing.T) {
+	ctx := testContext()
+	alertRepo := repositories.NewMemoryAlertRepository()
+	messageRepo := repositories.NewMemoryMessageRepository(nil, nil)
+	bus := events.NewBus()
+	updates, cancel := bus.Subscribe("test", 16)
+	defer cancel()
+	service := NewAlertService(alertRepo, messageRepo, bus, projectMembership{"p1"})
+
+	rule, err := service.CreateRule(ctx, "p1", &models.AlertRule{Name: "silent", Kind: models.AlertRuleMissing, ForSeconds: 600})
+	if err != nil {
+		t.Fatalf("CreateRule() unexpected error: %v", err)
+	}
+	opened := time.Now().UTC()
+	for _, alert := range []*models.Alert{
+		{ID: "a-1", RuleID: rule.ID, ProjectID: "p1", DeviceID: "dev-1", State: models.AlertStateOpen, OpenedAt: opened},
+		{ID: "a-2", RuleID: rule.ID, ProjectID: "p1", DeviceID: "dev-2", State: models.AlertStateOpen, OpenedAt: opened.Add(time.Second)},
+		{ID: "a-3", RuleID: "other", ProjectID: "p1", DeviceID: "dev-1", State: models.AlertStateResolved, OpenedAt: opened.Add(2 * time.Second)},
+	} {
+		if alert.ID == "a-1" {
+			message, err := messageRepo.Create(ctx, &models.Message{Type: models.MessageTypeAlert, DeviceID: "dev-1", ProjectID: "p1"})
+			if err != nil {
+				t.Fatalf("Create() unexpected error: %v", err)
+			}
+			alert.MessageID = message.GetIDAsString()
+		}
+		if err := alertRepo.CreateAlert(ctx, alert); err != nil {
+			t.Fatalf("CreateAlert() unexpected error: %v", err)
+		}
+	}
+
+	acknowledged, err := service.AcknowledgeAlert(ctx, "p1", "a-1")
+	if err != nil {
+		t.Fatalf("AcknowledgeAlert() unexpected error: %v", err)
+	}
+	if acknowledged.State != models.AlertStateAcknowledged || acknowledged.AcknowledgedBy != "user@example.com" {
+		t.Errorf("AcknowledgeAlert() = %+v", acknowledged)
+	}
+	if message, _ := messageRepo.FindByID(ctx, acknowledged.MessageID); message.Metadata["state"] != "acknowledged" {
+		t.Errorf("alert message metadata = %v, want the acknowledged state", message.Metadata)
+	}
+	select {
+	case event := <-updates:
+		if event.Type != events.MessageUpdated || event.Message.GetIDAsString() != acknowledged.MessageID {
+			t.Errorf("event = %+v, want the update of the alert message", event)
+		}
+	default:
+		t.Error("no update of the alert message published")
+	}
+	if _, err := service.AcknowledgeAlert(ctx, "p1", "a-1"); !errors.Is(err, ErrAlertStateConflict) {
+		t.Errorf("AcknowledgeAlert() twice error = %v, want %v", err, ErrAlertStateConflict)
+	}
+
+	alerts, total, err := service.ListAlerts(ctx, "p1", models.AlertFilter{States: []models.AlertState{models.AlertStateOpen}}, 0, 10)
+	if err != nil || total != 1 || len(alerts) != 1 || alerts[0].ID != "a-2" {
+		t.Errorf("ListAlerts(open) = %+v, %d, %v; want a-2", alerts, total, err)
+	}
+
+	// Deleting the rule resolves its active alerts
+	if err := service.DeleteRule(ctx, "p1", rule.ID); err != nil {
+		t.Fatalf("DeleteRule() unexpected error: %v", err)
+	}
+	for _, id := range []string{"a-1", "a-2"} {
+		alert, err := service.GetAlert(ctx, "p1", id)
+		if err != nil || alert.State != models.AlertStateResolved || alert.ResolvedBy != "user@example.com" {
+			t.Errorf("GetAlert(%s) after the rule deletion = %+v, %v; want resolved", id, alert, err)
+		}
+	}
+	if _, err := service.ResolveAlert(ctx, "p1", "a-3"); !errors.Is(err, ErrAlertStateConflict) {
+		t.Errorf("ResolveAlert() of a resolved alert error = %v, want %v", err, ErrAlertStateConflict)
+	}
+}
+
+func TestAlertServiceErrors(t *testing.T) {
+	repo := repositories.NewMemoryAlertRepository()
+	service := NewAlertService(repo, repositories.NewMemoryMessageRepository(nil, nil), nil, projectMembership{"p1"})
+	ctx := testContext()
+	valid := func() *models.AlertRule {
+		return &models.AlertRule{Name: "hot", Kind: models.AlertRuleThreshold, Variable: "temperature", Operator: ">", Threshold: 80}
+	}
+
+	created, err := service.CreateRule(ctx, "p1", valid())
+	if err != nil {
+		t.Fatalf("CreateRule() unexpected error: %v", err)
+	}
+	repo.CreateAlert(ctx, &models.Alert{ID: "other-project", ProjectID: "p2", State: models.AlertStateOpen})
+	badOperator := "=>"
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{"no user", func() error { _, err := service.CreateRule(context.Background(), "p1", valid()); return err }, ErrUnauthenticated},
+		{"other project", func() error { _, err := service.ListRules(ctx, "p9"); return err }, ErrForbidden},
+		{"no project", func() error { _, _, err := service.ListAlerts(ctx, "", models.AlertFilter{}, 0, 10); return err }, ErrInvalidQuery},
+		{"invalid rule", func() error {
+			_, err := service.CreateRule(ctx, "p1", &models.AlertRule{Name: "hot", Kind: models.AlertRuleThreshold})
+			return err
+		}, ErrInvalidAlertRule},
+		{"empty update", func() error {
+			_, err := service.UpdateRule(ctx, "p1", created.ID, models.AlertRuleUpdate{})
+			return err
+		}, ErrInvalidAlertRule},
+		{"invalid update", func() error {
+			_, err := service.UpdateRule(ctx, "p1", created.ID, models.AlertRuleUpdate{Operator: &badOperator})
+			return err
+		}, ErrInvalidAlertRule},
+		{"unknown rule", func() error { return service.DeleteRule(ctx, "p1", "missing") }, ErrAlertRuleNotFound},
+		{"unknown alert", func() error { _, err := service.ResolveAlert(ctx, "p1", "missing"); return err }, ErrAlertNotFound},
+		{"alert of another project", func() error { _, err := service.GetAlert(ctx, "p1", "other-project"); return err }, ErrAlertNotFound},
+		{"unsupported state", func() error {
+			_, _, err := service.ListAlerts(ctx, "p1", models.AlertFilter{States: []models.AlertState{"closed"}}, 0, 10)
+			return err
+		}, ErrInvalidQuery},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	for i := 1; i < MaxAlertRulesPerProject; i++ {
+		if _, err := service.CreateRule(ctx, "p1", valid()); err != nil {
+			t.Fatalf("CreateRule() %d unexpected error: %v", i, err)
+		}
+	}
+	if _, err := service.CreateRule(ctx, "p1", valid()); !errors.Is(err, ErrInvalidAlertRule) {
+		t.Errorf("CreateRule() beyond the limit error = %v, want %v", err, ErrInvalidAlertRule)
+	}
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"sit-iot-message-mng-api/internal/completeness"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+// completenessPageSize is the number of messages read per page when checking completeness
+const completenessPageSize = 1000
+
+// CompletenessChecker finds the gaps in the reports of a device and measures its coverage
+type CompletenessChecker interface {
+	Check(ctx context.Context, query models.CompletenessQuery) (*models.CompletenessReport, error)
+}
+
+type completenessChecker struct {
+	messageRepo repositories.MessageRepository
+}
+
+func NewCompletenessChecker(messageRepo repositories.MessageRepository) CompletenessChecker {
+	return &completenessChecker{messageRepo: messageRepo}
+}
+
+// Check streams the message timestamps of the device oldest first with keyset pagination,
+// which every database provider supports, so only one page is held in memory
+func (c *completenessChecker) Check(ctx context.Context, query models.CompletenessQuery) (*models.CompletenessReport, error) {
+	if err := query.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+
+	analyzer := completeness.NewAnalyzer(query)
+	filter := models.MessageFilter{DeviceID: query.DeviceID, FromTime: &query.From, ToTime: &query.To, ExcludeTypes: models.DerivedMessageTypes}
+	var cursor *models.MessageCursor
+	for {
+		messages, next, err := c.messageRepo.ListByCursor(ctx, filter, cursor, "ASC", completenessPageSize)
+		if err != nil {
+			return nil, wrapQueryError(err)
+		}
+		for _, message := range messages {
+			if query.Channel != "" && message.Channel() != query.Channel {
+				continue
+			}
+			analyzer.Add(message.Timestamp)
+		}
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+	return analyzer.Report(), nil
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+func TestCompletenessChecker(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// One report per minute over 20 hours, more than a page; ch1 is silent for the 11th hour
+	// while ch2 reports in its place
+	var messages []*models.Message
+	for minute := 0; minute < 1200; minute++ {
+		channel := "ch1"
+		if minute >= 600 && minute < 660 {
+			channel = "ch2"
+		}
+		messages = append(messages, &models.Message{
+			DeviceID:  "dev-1",
+			Timestamp: base.Add(time.Duration(minute) * time.Minute),
+			Metadata:  map[string]string{models.ChannelMetadataKey: channel},
+		})
+	}
+	// An alert raised during the outage of ch1 does not fill it
+	messages = append(messages, &models.Message{
+		DeviceID:  "dev-1",
+		Type:      models.MessageTypeAlert,
+		Timestamp: base.Add(630 * time.Minute),
+		Metadata:  map[string]string{models.ChannelMetadataKey: "ch1"},
+	})
+	checker := NewCompletenessChecker(repositories.NewMemoryMessageRepository(messages, nil))
+
+	query := models.CompletenessQuery{DeviceID: "dev-1", Interval: time.Minute, From: base, To: base.Add(20 * time.Hour)}
+	report, err := checker.Check(ctx, query)
+	if err != nil {
+		t.Fatalf("Check() unexpected error: %v", err)
+	}
+	if report.Messages != 1200 || report.Coverage != 100 || report.GapCount != 0 || report.LongestOutage != nil {
+		t.Errorf("Check() = %d messages, %v%% coverage, %d gaps; want 1200, 100%%, 0", report.Messages, report.Coverage, report.GapCount)
+	}
+
+	query.Channel = "ch1"
+	if report, err = checker.Check(ctx, query); err != nil {
+		t.Fatalf("Check(ch1) unexpected error: %v", err)
+	}
+	if report.Messages != 1140 || report.Coverage != 95 || report.GapCount != 1 {
+		t.Fatalf("Check(ch1) = %d messages, %v%% coverage, %d gaps; want 1140, 95%%, 1", report.Messages, report.Coverage, report.GapCount)
+	}
+	if outage := report.LongestOutage; outage.Missing != 60 || !outage.Start.Equal(base.Add(599*time.Minute)) || !outage.End.Equal(base.Add(660*time.Minute)) {
+		t.Errorf("Check(ch1) longest outage = %+v", outage)
+	}
+
+	query.Interval = 0
+	if _, err := checker.Check(ctx, query); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("Check() error = %v, want %v", err, ErrInvalidQuery)
+	}
+}
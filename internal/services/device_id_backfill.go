@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sit-iot-message-mng-api/internal/deviceid"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+	"sit-iot-message-mng-api/internal/topics"
+)
+
+// DeviceIDBackfillOptions controls a device ID backfill run
+type DeviceIDBackfillOptions struct {
+	Filter    models.MessageFilter // Restricts the messages to recompute; empty means all messages
+	BatchSize int                  // Messages read per page
+	DryRun    bool                 // Count the changes without writing them
+}
+
+// DeviceIDBackfillResult reports the outcome of a backfill run
+type DeviceIDBackfillResult struct {
+	Scanned int `json:"scanned"`
+	Updated int `json:"updated"` // Messages whose device ID changed (or would change on a dry run)
+}
+
+// DeviceIDBackfill recomputes the device ID of stored messages with the current extraction strategies
+type DeviceIDBackfill interface {
+	Run(ctx context.Context, opts DeviceIDBackfillOptions) (*DeviceIDBackfillResult, error)
+}
+
+type deviceIDBackfill struct {
+	messageRepo repositories.MessageRepository
+	topicRouter topics.Router
+	deviceIDs   deviceid.Resolver
+}
+
+func NewDeviceIDBackfill(messageRepo repositories.MessageRepository, topicRouter topics.Router, deviceIDs deviceid.Resolver) DeviceIDBackfill {
+	return &deviceIDBackfill{
+		messageRepo: messageRepo,
+		topicRouter: topicRouter,
+		deviceIDs:   deviceIDs,
+	}
+}
+
+// Run walks the messages oldest first with keyset pagination, so messages stored while
+// it runs are either visited or already have a current device ID
+func (b *deviceIDBackfill) Run(ctx context.Context, opts DeviceIDBackfillOptions) (*DeviceIDBackfillResult, error) {
+	if opts.BatchSize <= 0 {
+		return nil, fmt.Errorf("%w: batch size must be positive", ErrInvalidQuery)
+	}
+	if err := opts.Filter.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+
+	result := &DeviceIDBackfillResult{}
+	var cursor *models.MessageCursor
+	for {
+		messages, next, err := b.messageRepo.ListByCursor(ctx, opts.Filter, cursor, "ASC", opts.BatchSize)
+		if err != nil {
+			return result, wrapQueryError(err)
+		}
+
+		for _, message := range messages {
+			result.Scanned++
+			deviceID, err := resolveDeviceID(ctx, b.deviceIDs, message, b.topicRouter.Classify(message.Topic))
+			if err != nil {
+				return result, fmt.Errorf("message %s: %w", message.GetIDAsString(), err)
+			}
+			if deviceID == message.DeviceID {
+				continue
+			}
+
+			result.Updated++
+			if opts.DryRun {
+				continue
+			}
+			update := models.MessageUpdate{DeviceID: &deviceID, UpdatedAt: time.Now().UTC()}
+			if _, err := b.messageRepo.Update(ctx, message.GetIDAsString(), update); err != nil {
+				return result, fmt.Errorf("message %s: %w", message.GetIDAsString(), err)
+			}
+		}
+
+		if next == nil {
+			return result, nil
+		}
+		cursor = next
+	}
+}
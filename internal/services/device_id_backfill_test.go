@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/deviceid"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+	"sit-iot-message-mng-api/internal/topics"
+)
+
+func TestDeviceIDBackfill(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var messages []*models.Message
+	for i, clientID := range []string{"proj-42:gw-07:sensor-3", "proj-42:gw-07:sensor-4", "sensor-5", "proj-1:gw-01:sensor-6"} {
+		messages = append(messages, &models.Message{
+			Topic:     "telemetry",
+			ClientID:  clientID,
+			DeviceID:  clientID,
+			Timestamp: base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	messages[3].DeviceID = "sensor-6"
+	repo := repositories.NewMemoryMessageRepository(messages, nil)
+
+	topicRouter, err := topics.NewRouter(topics.DefaultRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, err := deviceid.NewTemplateStrategy(deviceid.SourceClientID, "{project}:{gateway}:{device}", "{device}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backfill := NewDeviceIDBackfill(repo, topicRouter, deviceid.NewResolver(template))
+	ctx := context.Background()
+
+	result, err := backfill.Run(ctx, DeviceIDBackfillOptions{BatchSize: 2, DryRun: true})
+	if err != nil {
+		t.Fatalf("Run() dry run unexpected error: %v", err)
+	}
+	if result.Scanned != 4 || result.Updated != 2 {
+		t.Errorf("Run() dry run = %+v, want 4 scanned and 2 updated", result)
+	}
+	if _, total, _ := repo.List(ctx, models.MessageFilter{DeviceID: "sensor-3"}, "", "", 0, 10); total != 0 {
+		t.Errorf("Run() dry run should not update messages")
+	}
+
+	result, err = backfill.Run(ctx, DeviceIDBackfillOptions{BatchSize: 3})
+	if err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+	if result.Scanned != 4 || result.Updated != 2 {
+		t.Errorf("Run() = %+v, want 4 scanned and 2 updated", result)
+	}
+	for _, deviceID := range []string{"sensor-3", "sensor-4", "sensor-5", "sensor-6"} {
+		if _, total, _ := repo.List(ctx, models.MessageFilter{DeviceID: deviceID}, "", "", 0, 10); total != 1 {
+			t.Errorf("device %s has %d messages after the backfill, want 1", deviceID, total)
+		}
+	}
+
+	if _, err := backfill.Run(ctx, DeviceIDBackfillOptions{}); err == nil {
+		t.Errorf("Run() expected error without a batch size")
+	}
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+const (
+	// deviceProjectTTL is how long the project of a client ID is cached, so changes to the
+	// device registry take up to this long to apply to new messages
+	deviceProjectTTL = 10 * time.Second
+	// maxDeviceProjectEntries bounds the number of cached client IDs
+	maxDeviceProjectEntries = 10000
+)
+
+// deviceProjects resolves the project of the device registered with a client ID, caching
+// the lookups, those of unregistered client IDs included
+type deviceProjects struct {
+	repo repositories.DeviceRepository
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]deviceProjectEntry
+}
+
+type deviceProjectEntry struct {
+	projectID string // Empty when the client ID is not registered
+	expires   time.Time
+}
+
+// newDeviceProjects returns the resolver of a device registry; a nil registry resolves no
+// client ID
+func newDeviceProjects(repo repositories.DeviceRepository) *deviceProjects {
+	return &deviceProjects{
+		repo:    repo,
+		now:     time.Now,
+		entries: make(map[string]deviceProjectEntry),
+	}
+}
+
+// projectOf returns the project of the device registered with the client ID, empty when
+// none is
+func (p *deviceProjects) projectOf(ctx context.Context, clientID string) (string, error) {
+	if p.repo == nil || clientID == "" {
+		return "", nil
+	}
+	now := p.now()
+	p.mu.Lock()
+	entry, ok := p.entries[clientID]
+	p.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.projectID, nil
+	}
+
+	device, err := p.repo.FindByClientID(ctx, clientID)
+	if err != nil && !errors.Is(err, repositories.ErrDeviceNotFound) {
+		return "", err
+	}
+	entry = deviceProjectEntry{expires: now.Add(deviceProjectTTL)}
+	if device != nil {
+		entry.projectID = device.ProjectID
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.entries) >= maxDeviceProjectEntries {
+		for cached, e := range p.entries {
+			if !now.Before(e.expires) {
+				delete(p.entries, cached)
+			}
+		}
+		if len(p.entries) >= maxDeviceProjectEntries {
+			p.entries = make(map[string]deviceProjectEntry)
+		}
+	}
+	p.entries[clientID] = entry
+	return entry.projectID, nil
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidDevice is returned when a device submitted for registration or update is not valid
+var ErrInvalidDevice = errors.New("invalid device")
+
+// The errors of the device repository are re-exported so controllers can map them to an
+// HTTP status
+var (
+	ErrDeviceNotFound      = repositories.ErrDeviceNotFound
+	ErrDeviceClientIDTaken = repositories.ErrDeviceClientIDTaken
+)
+
+// ClientIDAuthorizer checks that the user of the context owns an MQTT client ID
+type ClientIDAuthorizer interface {
+	AuthorizeClientID(ctx context.Context, clientID string) error
+}
+
+// DeviceService manages the device registry of the projects of the user. The project of a
+// registered device is given to the messages of its client ID sent without a project.
+type DeviceService interface {
+	// ListDevices returns a page of the devices of the project selected by the filter, sorted
+	// on an API field (name by default), with the total number of selected devices
+	ListDevices(ctx context.Context, projectID string, filter models.DeviceFilter, sortField, sortOrder string, skip, limit int) ([]*models.Device, int, error)
+	GetDevice(ctx context.Context, projectID, id string) (*models.Device, error)
+	// CreateDevice registers a device in the project; its client ID must be one of the user's
+	// and not be registered yet
+	CreateDevice(ctx context.Context, projectID string, device *models.Device) (*models.Device, error)
+	// UpdateDevice partially updates a device; a new client ID must be one of the user's
+	UpdateDevice(ctx context.Context, projectID, id string, update models.DeviceUpdate) (*models.Device, error)
+	// DeleteDevice removes a device from the registry and returns the deleted record
+	DeleteDevice(ctx context.Context, projectID, id string) (*models.Device, error)
+}
+
+type deviceService struct {
+	deviceRepo repositories.DeviceRepository
+	projects   ProjectAuthorizer
+	clients    ClientIDAuthorizer
+}
+
+func NewDeviceService(deviceRepo repositories.DeviceRepository, projects ProjectAuthorizer, clients ClientIDAuthorizer) DeviceService {
+	return &deviceService{
+		deviceRepo: deviceRepo,
+		projects:   projects,
+		clients:    clients,
+	}
+}
+
+// deviceSortFields maps the sortable API field names to their stored field names
+var deviceSortFields = map[string]string{
+	"id":        "_id",
+	"name":      "name",
+	"type":      "type",
+	"status":    "status",
+	"clientId":  "clientId",
+	"lastSeen":  "lastSeen",
+	"createdAt": "createdAt",
+	"updatedAt": "updatedAt",
+}
+
+func (s *deviceService) ListDevices(ctx context.Context, projectID string, filter models.DeviceFilter, sortField, sortOrder string, skip, limit int) ([]*models.Device, int, error) {
+	if _, err := s.authorize(ctx, projectID); err != nil {
+		return nil, 0, err
+	}
+	if sortField == "" {
+		sortField = "name"
+	}
+	storedField, ok := deviceSortFields[sortField]
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: unsupported sort field %q", ErrInvalidQuery, sortField)
+	}
+	switch sortOrder = strings.ToUpper(sortOrder); sortOrder {
+	case "":
+		sortOrder = "ASC"
+	case "ASC", "DESC":
+	default:
+		return nil, 0, fmt.Errorf("%w: unsupported sort order %q", ErrInvalidQuery, sortOrder)
+	}
+
+	filter.ProjectID = projectID
+	return s.deviceRepo.List(ctx, filter, storedField, sortOrder, skip, limit)
+}
+
+func (s *deviceService) GetDevice(ctx context.Context, projectID, id string) (*models.Device, error) {
+	if _, err := s.authorize(ctx, projectID); err != nil {
+		return nil, err
+	}
+	return s.findDevice(ctx, projectID, id)
+}
+
+func (s *deviceService) CreateDevice(ctx context.Context, projectID string, device *models.Device) (*models.Device, error) {
+	createdBy, err := s.authorize(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		return nil, fmt.Errorf("%w: no device provided", ErrInvalidDevice)
+	}
+
+	now := time.Now().UTC()
+	stored := *device
+	stored.ID = primitive.NewObjectID()
+	stored.ProjectID = projectID
+	stored.LastSeen = nil
+	stored.CreatedBy = createdBy
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	if err := stored.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDevice, err)
+	}
+	if err := s.clients.AuthorizeClientID(ctx, stored.ClientID); err != nil {
+		return nil, err
+	}
+
+	if err := s.deviceRepo.Create(ctx, &stored); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+func (s *deviceService) UpdateDevice(ctx context.Context, projectID, id string, update models.DeviceUpdate) (*models.Device, error) {
+	if _, err := s.authorize(ctx, projectID); err != nil {
+		return nil, err
+	}
+	if update.IsEmpty() {
+		return nil, fmt.Errorf("%w: no fields to update", ErrInvalidDevice)
+	}
+
+	device, err := s.findDevice(ctx, projectID, id)
+	if err != nil {
+		return nil, err
+	}
+	clientID := device.ClientID
+	update.Apply(device)
+	if err := device.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDevice, err)
+	}
+	if device.ClientID != clientID {
+		if err := s.clients.AuthorizeClientID(ctx, device.ClientID); err != nil {
+			return nil, err
+		}
+	}
+	update.UpdatedAt = time.Now().UTC()
+
+	// Only the updated fields are written, so a presence update made meanwhile is kept
+	return s.deviceRepo.Update(ctx, id, update)
+}
+
+func (s *deviceService) DeleteDevice(ctx context.Context, projectID, id string) (*models.Device, error) {
+	if _, err := s.authorize(ctx, projectID); err != nil {
+		return nil, err
+	}
+	device, err := s.findDevice(ctx, projectID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.deviceRepo.Delete(ctx, id); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// authorize checks that the user may manage the devices of the project and returns the user
+func (s *deviceService) authorize(ctx context.Context, projectID string) (string, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	if projectID == "" {
+		return "", fmt.Errorf("%w: project ID is required", ErrInvalidQuery)
+	}
+	if err := s.projects.AuthorizeProject(ctx, projectID); err != nil {
+		return "", err
+	}
+	return user, nil
+}
+
+// findDevice returns a device of the project; devices of other projects are not found
+func (s *deviceService) findDevice(ctx context.Context, projectID, id string) (*models.Device, error) {
+	device, err := s.deviceRepo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if device.ProjectID != projectID {
+		return nil, ErrDeviceNotFound
+	}
+	return device, nil
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/deviceid"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+	"sit-iot-message-mng-api/internal/topics"
+)
+
+// clientOwnership lists the client IDs of the user
+type clientOwnership []string
+
+func (o clientOwnership) AuthorizeClientID(ctx context.Context, clientID string) error {
+	for _, id := range o {
+		if id == clientID {
+			return nil
+		}
+	}
+	return ErrForbidden
+}
+
+func TestDeviceService(t *testing.T) {
+	repo := repositories.NewMemoryDeviceRepository()
+	service := NewDeviceService(repo, projectMembership{"p1", "p2"}, clientOwnership{"boiler-01", "attic-01"})
+	ctx := testContext()
+
+	created, err := service.CreateDevice(ctx, "p1", &models.Device{ProjectID: "p2", Name: "boiler", Type: "sensor", ClientID: "boiler-01"})
+	if err != nil {
+		t.Fatalf("CreateDevice() unexpected error: %v", err)
+	}
+	if created.ID.IsZero() || created.ProjectID != "p1" || created.CreatedBy != "user@example.com" || created.CreatedAt.IsZero() {
+		t.Errorf("CreateDevice() = %+v, want a p1 device", created)
+	}
+	if _, err := service.CreateDevice(ctx, "p1", &models.Device{Name: "attic", Type: "sensor", ClientID: "attic-01"}); err != nil {
+		t.Fatalf("CreateDevice() unexpected error: %v", err)
+	}
+
+	devices, total, err := service.ListDevices(ctx, "p1", models.DeviceFilter{Type: "sensor"}, "", "", 0, 10)
+	if err != nil || total != 2 || devices[0].Name != "attic" || devices[1].Name != "boiler" {
+		t.Errorf("ListDevices() = %d devices of %d, %v; want attic and boiler", len(devices), total, err)
+	}
+
+	name := "boiler room"
+	updated, err := service.UpdateDevice(ctx, "p1", created.ID.Hex(), models.DeviceUpdate{Name: &name})
+	if err != nil || updated.Name != name || updated.ClientID != "boiler-01" || updated.UpdatedAt.Before(created.UpdatedAt) {
+		t.Errorf("UpdateDevice() = %+v, %v; want renamed", updated, err)
+	}
+
+	deleted, err := service.DeleteDevice(ctx, "p1", created.ID.Hex())
+	if err != nil || deleted.ID != created.ID {
+		t.Errorf("DeleteDevice() = %+v, %v; want the deleted device", deleted, err)
+	}
+	if _, err := service.GetDevice(ctx, "p1", created.ID.Hex()); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("GetDevice() after DeleteDevice() error = %v, want %v", err, ErrDeviceNotFound)
+	}
+}
+
+func TestDeviceServiceErrors(t *testing.T) {
+	repo := repositories.NewMemoryDeviceRepository()
+	service := NewDeviceService(repo, projectMembership{"p1", "p2"}, clientOwnership{"boiler-01", "attic-01"})
+	ctx := testContext()
+	device, err := service.CreateDevice(ctx, "p1", &models.Device{Name: "boiler", ClientID: "boiler-01"})
+	if err != nil {
+		t.Fatalf("CreateDevice() unexpected error: %v", err)
+	}
+	id := device.ID.Hex()
+	clientID := "boiler-01"
+	strangerClientID := "stranger-01"
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{name: "invalid device", call: func() error {
+			_, err := service.CreateDevice(ctx, "p1", &models.Device{ClientID: "attic-01"})
+			return err
+		}, want: ErrInvalidDevice},
+		{name: "client ID taken", call: func() error {
+			_, err := service.CreateDevice(ctx, "p2", &models.Device{Name: "copy", ClientID: clientID})
+			return err
+		}, want: ErrDeviceClientIDTaken},
+		{name: "client ID of another user", call: func() error {
+			_, err := service.CreateDevice(ctx, "p1", &models.Device{Name: "stranger", ClientID: strangerClientID})
+			return err
+		}, want: ErrForbidden},
+		{name: "update to a client ID of another user", call: func() error {
+			_, err := service.UpdateDevice(ctx, "p1", id, models.DeviceUpdate{ClientID: &strangerClientID})
+			return err
+		}, want: ErrForbidden},
+		{name: "empty update", call: func() error {
+			_, err := service.UpdateDevice(ctx, "p1", id, models.DeviceUpdate{})
+			return err
+		}, want: ErrInvalidDevice},
+		{name: "device of another project", call: func() error {
+			_, err := service.UpdateDevice(ctx, "p2", id, models.DeviceUpdate{ClientID: &clientID})
+			return err
+		}, want: ErrDeviceNotFound},
+		{name: "malformed ID", call: func() error {
+			_, err := service.DeleteDevice(ctx, "p1", "not-an-id")
+			return err
+		}, want: ErrDeviceNotFound},
+		{name: "unsupported sort", call: func() error {
+			_, _, err := service.ListDevices(ctx, "p1", models.DeviceFilter{}, "metadata", "ASC", 0, 10)
+			return err
+		}, want: ErrInvalidQuery},
+		{name: "unsupported order", call: func() error {
+			_, _, err := service.ListDevices(ctx, "p1", models.DeviceFilter{}, "name", "UP", 0, 10)
+			return err
+		}, want: ErrInvalidQuery},
+		{name: "other project", call: func() error {
+			_, err := service.GetDevice(ctx, "p3", id)
+			return err
+		}, want: ErrForbidden},
+		{name: "unauthenticated", call: func() error {
+			_, err := service.GetDevice(context.Background(), "p1", id)
+			return err
+		}, want: ErrUnauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessagesGetTheProjectOfTheirRegisteredDevice(t *testing.T) {
+	deviceRepo := repositories.NewMemoryDeviceRepository()
+	devices := NewDeviceService(deviceRepo, projectMembership{"p1"}, clientOwnership{"boiler-01"})
+	if _, err := devices.CreateDevice(testContext(), "p1", &models.Device{Name: "boiler", ClientID: "boiler-01"}); err != nil {
+		t.Fatalf("CreateDevice() unexpected error: %v", err)
+	}
+	topicRouter, err := topics.NewRouter(topics.DefaultRules())
+	if err != nil {
+		t.Fatalf("NewRouter() unexpected error: %v", err)
+	}
+	service := NewMessageService(repositories.NewMemoryMessageRepository(nil, nil), nil, nil, topicRouter, deviceid.NewResolver(deviceid.DefaultStrategies()...), deviceRepo, nil)
+
+	tests := []struct {
+		name    string
+		message models.Message
+		want    string
+	}{
+		{name: "registered", message: models.Message{Topic: "boiler-01/telemetry", ClientID: "boiler-01"}, want: "p1"},
+		{name: "registered with another project", message: models.Message{Topic: "boiler-01/telemetry", ClientID: "boiler-01", ProjectID: "p9"}, want: "p1"},
+		{name: "unregistered", message: models.Message{Topic: "attic-01/telemetry", ClientID: "attic-01", ProjectID: "p9"}, want: "p9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ingested, err := service.IngestMessage(context.Background(), &tt.message)
+			if err != nil {
+				t.Fatalf("IngestMessage() unexpected error: %v", err)
+			}
+			if ingested.ProjectID != tt.want {
+				t.Errorf("IngestMessage() projectId = %q, want %q", ingested.ProjectID, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviceProjectsCache(t *testing.T) {
+	ctx := testContext()
+	repo := repositories.NewMemoryDeviceRepository()
+	devices := NewDeviceService(repo, projectMembership{"p1", "p2"}, clientOwnership{"boiler-01", "attic-01"})
+	projects := newDeviceProjects(repo)
+	now := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+	projects.now = func() time.Time { return now }
+
+	// Unregistered client IDs are cached too
+	if projectID, err := projects.projectOf(ctx, "boiler-01"); err != nil || projectID != "" {
+		t.Fatalf("projectOf() = %q, %v; want no project", projectID, err)
+	}
+	device, err := devices.CreateDevice(ctx, "p1", &models.Device{Name: "boiler", ClientID: "boiler-01"})
+	if err != nil {
+		t.Fatalf("CreateDevice() unexpected error: %v", err)
+	}
+	if projectID, _ := projects.projectOf(ctx, "boiler-01"); projectID != "" {
+		t.Errorf("projectOf() within the TTL = %q, want the cached empty project", projectID)
+	}
+	now = now.Add(deviceProjectTTL)
+	if projectID, _ := projects.projectOf(ctx, "boiler-01"); projectID != "p1" {
+		t.Errorf("projectOf() after the TTL = %q, want p1", projectID)
+	}
+
+	if _, err := devices.DeleteDevice(ctx, "p1", device.ID.Hex()); err != nil {
+		t.Fatalf("DeleteDevice() unexpected error: %v", err)
+	}
+	now = now.Add(deviceProjectTTL)
+	if projectID, _ := projects.projectOf(ctx, "boiler-01"); projectID != "" {
+		t.Errorf("projectOf() of a deleted device = %q, want no project", projectID)
+	}
+}
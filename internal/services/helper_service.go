@@ -18,6 +18,9 @@ import (
 )
 
 func (s *messageService) VerifyToken(ctx context.Context, token string) (*auth.Token, error) {
+	if s.firebaseAuth == nil {
+		return nil, errors.New("Firebase authentication is not configured")
+	}
 	return s.firebaseAuth.VerifyIDToken(ctx, token)
 }
 
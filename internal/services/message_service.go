@@ -2,14 +2,51 @@ package services
 
 import (
 	"context"
+	"errors"
 	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+	"sit-iot-message-mng-api/internal/topics"
 
 	"firebase.google.com/go/v4/auth"
 )
 
+// ErrInvalidQuery is returned when listing parameters (sort, filter) cannot be honored
+var ErrInvalidQuery = errors.New("invalid query")
+
+// ErrInvalidMessage is returned when a message submitted for creation or update is not valid
+var ErrInvalidMessage = errors.New("invalid message")
+
+// ErrUnauthenticated is returned when no authenticated user is found in the context
+var ErrUnauthenticated = errors.New("user not found in context")
+
+// ErrForbidden is returned when the user is not a member of the project of a request
+var ErrForbidden = errors.New("access to the project denied")
+
+// Repository errors re-exported so controllers can map them to HTTP statuses
+var (
+	ErrMessageNotFound  = repositories.ErrMessageNotFound
+	ErrInvalidMessageID = repositories.ErrInvalidMessageID
+)
+
 type MessageService interface {
 	GetMessageByID(ctx context.Context, id string) (*models.Message, error)
-	ListMessagesByDeviceID(ctx context.Context, deviceID string, filter map[string]interface{}, sortField, sortOrder string, skip, limit int) ([]*models.Message, int, error)
+	ListMessagesByDeviceID(ctx context.Context, deviceID string, filter models.MessageFilter, sortField, sortOrder string, skip, limit int) ([]*models.Message, int, error)
+	ListMessagesByDeviceIDCursor(ctx context.Context, deviceID string, filter models.MessageFilter, cursor *models.MessageCursor, sortOrder string, limit int) ([]*models.Message, *models.MessageCursor, error)
+	StreamMessagesByDeviceID(ctx context.Context, deviceID string, filter models.MessageFilter, after *models.MessageCursor) (<-chan MessageStreamEvent, error)
+	AuthorizeSubscription(ctx context.Context, filter models.MessageFilter) error
+	AuthorizeProject(ctx context.Context, projectID string) error
+	AuthorizeClientID(ctx context.Context, clientID string) error
 	VerifyToken(ctx context.Context, token string) (*auth.Token, error)
-	GetAggregatedDataByDeviceID(ctx context.Context, deviceID string) ([]map[string]interface{}, error)
+	GetAggregatedDataByDeviceID(ctx context.Context, deviceID string, filter models.AggregationFilter) ([]*models.AggregatedData, error)
+	AggregateMessagesByDeviceID(ctx context.Context, query models.AggregationQuery) ([]*models.AggregatedData, error)
+	GetDeviceSeries(ctx context.Context, query models.SeriesQuery) (*models.DeviceSeries, error)
+	CompareDevices(ctx context.Context, query models.ComparisonQuery) (*models.DeviceComparison, error)
+	CheckCompleteness(ctx context.Context, query models.CompletenessQuery) (*models.CompletenessReport, error)
+	CreateMessage(ctx context.Context, message *models.Message) (*models.Message, error)
+	CreateMessages(ctx context.Context, messages []*models.Message) ([]*models.Message, error)
+	UpdateMessage(ctx context.Context, id string, update models.MessageUpdate) (*models.Message, error)
+	DeleteMessage(ctx context.Context, id string) (*models.Message, error)
+	IngestMessage(ctx context.Context, message *models.Message) (*models.Message, error)
+	ClassifyTopic(topic string) *topics.Match
+	TopicRules() []topics.Rule
 }
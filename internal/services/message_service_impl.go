@@ -2,12 +2,20 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"sit-iot-message-mng-api/config"
+	"sit-iot-message-mng-api/internal/deviceid"
+	"sit-iot-message-mng-api/internal/downsample"
+	"sit-iot-message-mng-api/internal/events"
 	"sit-iot-message-mng-api/internal/middleware"
 	"sit-iot-message-mng-api/internal/models"
 	"sit-iot-message-mng-api/internal/repositories"
+	"sit-iot-message-mng-api/internal/topics"
 
 	"firebase.google.com/go/v4/auth"
 )
@@ -16,14 +24,33 @@ type messageService struct {
 	messageRepo  repositories.MessageRepository
 	firebaseAuth *auth.Client
 	Config       *config.Config
+	topicRouter  topics.Router
+	deviceIDs    deviceid.Resolver
+	devices      *deviceProjects
+	bus          events.Bus
+	access       accessChecker
 }
 
-func NewMessageService(messageRepo repositories.MessageRepository, firebaseAuth *auth.Client, cfg *config.Config) MessageService {
-	return &messageService{
+// accessChecker checks the access of the user of the context to projects and client IDs
+type accessChecker interface {
+	ProjectAuthorizer
+	ClientIDAuthorizer
+}
+
+// NewMessageService creates the message service. Messages of the client IDs registered in
+// deviceRepo get the project of their device; deviceRepo and bus may be nil.
+func NewMessageService(messageRepo repositories.MessageRepository, firebaseAuth *auth.Client, cfg *config.Config, topicRouter topics.Router, deviceIDs deviceid.Resolver, deviceRepo repositories.DeviceRepository, bus events.Bus) MessageService {
+	s := &messageService{
 		messageRepo:  messageRepo,
 		firebaseAuth: firebaseAuth,
 		Config:       cfg,
+		topicRouter:  topicRouter,
+		deviceIDs:    deviceIDs,
+		devices:      newDeviceProjects(deviceRepo),
+		bus:          bus,
 	}
+	s.access = remoteAccess{service: s}
+	return s
 }
 
 func (s *messageService) GetMessageByID(ctx context.Context, id string) (*models.Message, error) {
@@ -48,7 +75,7 @@ func (s *messageService) GetMessageByID(ctx context.Context, id string) (*models
 	return message, nil
 }
 
-func (s *messageService) ListMessages(ctx context.Context, filter map[string]interface{}, sortField, sortOrder string, skip, limit int) ([]*models.Message, int, error) {
+func (s *messageService) ListMessages(ctx context.Context, filter models.MessageFilter, sortField, sortOrder string, skip, limit int) ([]*models.Message, int, error) {
 	// userEmail, ok := ctx.Value(middleware.UserEmailKey).(string)
 	// if !ok || userEmail == "" {
 	// 	return nil, 0, errors.New("user email not found in context4")
@@ -72,19 +99,17 @@ func (s *messageService) ListMessages(ctx context.Context, filter map[string]int
 		return nil, 0, errors.New("no client IDs found for the user - access denied")
 	}
 
-	if filter == nil {
-		filter = make(map[string]interface{})
-	}
-
-	// Filter messages by client IDs instead of user email
-	filter["client_id"] = map[string]interface{}{
-		"$in": allClientIDs,
+	// Filter messages by client IDs instead of user email, keeping any client IDs
+	// already requested only if the user is allowed to see them
+	filter.ClientIDs = intersectClientIDs(filter.ClientIDs, allClientIDs)
+	if len(filter.ClientIDs) == 0 {
+		return []*models.Message{}, 0, nil
 	}
 
 	return s.messageRepo.List(ctx, filter, sortField, sortOrder, skip, limit)
 }
 
-func (s *messageService) ListMessagesByDeviceID(ctx context.Context, deviceID string, filter map[string]interface{}, sortField, sortOrder string, skip, limit int) ([]*models.Message, int, error) {
+func (s *messageService) ListMessagesByDeviceID(ctx context.Context, deviceID string, filter models.MessageFilter, sortField, sortOrder string, skip, limit int) ([]*models.Message, int, error) {
 	userEmail, ok := ctx.Value(middleware.UserEmailKey).(string)
 	if !ok || userEmail == "" {
 		return nil, 0, errors.New("user email not found in context2")
@@ -116,14 +141,629 @@ func (s *messageService) ListMessagesByDeviceID(ctx context.Context, deviceID st
 	// 	return nil, 0, errors.New("access denied: device not found in user's allowed client IDs")
 	// }
 
-	messages, err := s.messageRepo.FindByDeviceID(ctx, deviceID, limit)
+	storedSortField, normalizedOrder, err := resolveSort(sortField, sortOrder)
 	if err != nil {
 		return nil, 0, err
 	}
-	return messages, len(messages), nil
+
+	filter.DeviceID = deviceID
+	messages, total, err := s.messageRepo.List(ctx, filter, storedSortField, normalizedOrder, skip, limit)
+	if err != nil {
+		return nil, 0, wrapQueryError(err)
+	}
+	return messages, total, nil
+}
+
+// ListMessagesByDeviceIDCursor returns a page of device messages using keyset pagination on timestamp and ID
+func (s *messageService) ListMessagesByDeviceIDCursor(ctx context.Context, deviceID string, filter models.MessageFilter, cursor *models.MessageCursor, sortOrder string, limit int) ([]*models.Message, *models.MessageCursor, error) {
+	userEmail, ok := ctx.Value(middleware.UserEmailKey).(string)
+	if !ok || userEmail == "" {
+		return nil, nil, errors.New("user email not found in context")
+	}
+
+	if limit <= 0 {
+		return nil, nil, fmt.Errorf("%w: limit must be positive", ErrInvalidQuery)
+	}
+
+	_, normalizedOrder, err := resolveSort("timestamp", sortOrder)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter.DeviceID = deviceID
+	messages, next, err := s.messageRepo.ListByCursor(ctx, filter, cursor, normalizedOrder, limit)
+	if err != nil {
+		return nil, nil, wrapQueryError(err)
+	}
+	return messages, next, nil
+}
+
+// GetAggregatedDataByDeviceID returns the precomputed aggregated data of a device selected by the filter
+func (s *messageService) GetAggregatedDataByDeviceID(ctx context.Context, deviceID string, filter models.AggregationFilter) ([]*models.AggregatedData, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+	aggregations, err := s.messageRepo.GetAggregatedDataByDeviceID(ctx, deviceID, filter)
+	if err != nil {
+		return nil, err
+	}
+	return withExtendedStats(aggregations, filter.Extended), nil
+}
+
+// AggregateMessagesByDeviceID computes bucketed aggregations from the raw messages of a device,
+// for devices the external aggregator never processed or bucket sizes it does not produce
+func (s *messageService) AggregateMessagesByDeviceID(ctx context.Context, query models.AggregationQuery) ([]*models.AggregatedData, error) {
+	if err := query.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+	aggregations, err := s.messageRepo.AggregateByDeviceID(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return withExtendedStats(aggregations, query.Extended), nil
+}
+
+// withExtendedStats fills the extended statistics of the buckets when they were requested
+func withExtendedStats(aggregations []*models.AggregatedData, extended bool) []*models.AggregatedData {
+	if extended {
+		for _, aggregation := range aggregations {
+			aggregation.WithExtendedStats()
+		}
+	}
+	return aggregations
 }
 
-// GetAggregatedDataByDeviceID returns aggregated data for a device (placeholder implementation)
-func (s *messageService) GetAggregatedDataByDeviceID(ctx context.Context, deviceID string) ([]map[string]interface{}, error) {
-	return s.messageRepo.GetAggregatedDataByDeviceID(ctx, deviceID)
+// seriesPageSize is the number of messages read per page when building a time series
+const seriesPageSize = 1000
+
+// GetDeviceSeries reads the raw values of a device variable in the time range and
+// downsamples them to the requested number of points
+func (s *messageService) GetDeviceSeries(ctx context.Context, query models.SeriesQuery) (*models.DeviceSeries, error) {
+	if err := query.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+
+	filter := models.MessageFilter{DeviceID: query.DeviceID, FromTime: &query.From, ToTime: &query.To, ExcludeTypes: models.DerivedMessageTypes}
+	var raw []models.SeriesPoint
+	var cursor *models.MessageCursor
+	for {
+		messages, next, err := s.messageRepo.ListByCursor(ctx, filter, cursor, "ASC", seriesPageSize)
+		if err != nil {
+			return nil, wrapQueryError(err)
+		}
+		for _, message := range messages {
+			if query.Channel != "" && message.Channel() != query.Channel {
+				continue
+			}
+			if value, ok := models.NumericFields(message.Marshalled, []string{query.Variable})[query.Variable]; ok {
+				raw = append(raw, models.SeriesPoint{Timestamp: message.Timestamp, Value: value})
+			}
+		}
+		if len(raw) > models.MaxSeriesRawPoints {
+			return nil, fmt.Errorf("%w: more than %d values in the time range", ErrInvalidQuery, models.MaxSeriesRawPoints)
+		}
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+
+	var points []models.SeriesPoint
+	switch query.Method {
+	case models.DownsampleMinMax:
+		points = downsample.MinMax(raw, query.Points, query.From, query.To)
+	default:
+		points = downsample.LTTB(raw, query.Points)
+	}
+	if points == nil {
+		points = []models.SeriesPoint{}
+	}
+	return &models.DeviceSeries{
+		DeviceID:  query.DeviceID,
+		Variable:  query.Variable,
+		Channel:   query.Channel,
+		Method:    query.Method,
+		From:      query.From,
+		To:        query.To,
+		RawPoints: len(raw),
+		Points:    points,
+	}, nil
+}
+
+// CompareDevices aggregates one variable of several devices and aligns their buckets. The
+// devices of a project, which must be one of the user's, are those that sent messages in the
+// time range.
+func (s *messageService) CompareDevices(ctx context.Context, query models.ComparisonQuery) (*models.DeviceComparison, error) {
+	if err := query.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+
+	deviceIDs := query.DeviceIDs
+	if query.ProjectID != "" {
+		if err := s.AuthorizeProject(ctx, query.ProjectID); err != nil {
+			return nil, err
+		}
+		filter := models.MessageFilter{ProjectID: query.ProjectID, FromTime: &query.From, ToTime: &query.To, ExcludeTypes: models.DerivedMessageTypes}
+		found, err := s.messageRepo.DistinctDeviceIDs(ctx, filter)
+		if err != nil {
+			return nil, wrapQueryError(err)
+		}
+		if len(found) > models.MaxCompareDevices {
+			return nil, fmt.Errorf("%w: project %s has more than %d devices in the time range", ErrInvalidQuery, query.ProjectID, models.MaxCompareDevices)
+		}
+		deviceIDs = found
+	}
+
+	buckets := make(map[string][]*models.AggregatedData, len(deviceIDs))
+	for _, deviceID := range deviceIDs {
+		aggregations, err := s.compareDeviceBuckets(ctx, deviceID, query)
+		if err != nil {
+			return nil, err
+		}
+		buckets[deviceID] = aggregations
+	}
+	return models.NewDeviceComparison(query, deviceIDs, buckets), nil
+}
+
+// compareDeviceBuckets reads the buckets of the compared variable of one device, from the
+// stored rollups or computed on demand; a device without stored aggregations has no buckets
+func (s *messageService) compareDeviceBuckets(ctx context.Context, deviceID string, query models.ComparisonQuery) ([]*models.AggregatedData, error) {
+	if query.Bucket != "" {
+		aggregations, err := s.messageRepo.AggregateByDeviceID(ctx, models.AggregationQuery{
+			DeviceID: deviceID,
+			From:     query.From,
+			To:       query.To,
+			Bucket:   query.Bucket,
+			Fields:   []string{query.Variable},
+			Extended: query.Extended,
+		})
+		if err != nil || query.Channel == "" {
+			return aggregations, err
+		}
+		filtered := aggregations[:0]
+		for _, aggregation := range aggregations {
+			if aggregation.Channel == query.Channel {
+				filtered = append(filtered, aggregation)
+			}
+		}
+		return filtered, nil
+	}
+
+	filter := models.AggregationFilter{
+		Variables: []string{query.Variable},
+		Periods:   []string{string(query.Period)},
+		FromTime:  &query.From,
+		ToTime:    &query.To,
+	}
+	if query.Channel != "" {
+		filter.Channels = []string{query.Channel}
+	}
+	aggregations, err := s.messageRepo.GetAggregatedDataByDeviceID(ctx, deviceID, filter)
+	if errors.Is(err, repositories.ErrAggregationsNotFound) {
+		return nil, nil
+	}
+	return aggregations, err
+}
+
+// CheckCompleteness reports the gaps and daily coverage of the reports of a device
+func (s *messageService) CheckCompleteness(ctx context.Context, query models.CompletenessQuery) (*models.CompletenessReport, error) {
+	return NewCompletenessChecker(s.messageRepo).Check(ctx, query)
+}
+
+// intersectClientIDs restricts the requested client IDs to the allowed ones.
+// When nothing was requested, all allowed client IDs are returned.
+func intersectClientIDs(requested, allowed []string) []string {
+	if len(requested) == 0 {
+		return allowed
+	}
+
+	allowedSet := make(map[string]bool, len(allowed))
+	for _, clientID := range allowed {
+		allowedSet[clientID] = true
+	}
+
+	result := []string{}
+	for _, clientID := range requested {
+		if allowedSet[clientID] {
+			result = append(result, clientID)
+		}
+	}
+	return result
+}
+
+// maxBatchSize caps the number of messages accepted by a single batch insert
+const maxBatchSize = 500
+
+// CreateMessage stores a new message, computing its derived and audit fields
+func (s *messageService) CreateMessage(ctx context.Context, message *models.Message) (*models.Message, error) {
+	created, err := s.CreateMessages(ctx, []*models.Message{message})
+	if err != nil {
+		return nil, err
+	}
+	return created[0], nil
+}
+
+// CreateMessages stores a batch of new messages, computing their derived and audit fields.
+// The client IDs of the messages must be the user's.
+func (s *messageService) CreateMessages(ctx context.Context, messages []*models.Message) ([]*models.Message, error) {
+	createdBy, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("%w: no messages provided", ErrInvalidMessage)
+	}
+	if len(messages) > maxBatchSize {
+		return nil, fmt.Errorf("%w: at most %d messages can be created at once", ErrInvalidMessage, maxBatchSize)
+	}
+
+	now := time.Now().UTC()
+	access := newBatchAccess(s.access)
+	prepared := make([]*models.Message, 0, len(messages))
+	for i, message := range messages {
+		if message == nil || message.Topic == "" || message.ClientID == "" {
+			return nil, fmt.Errorf("%w: message %d requires topic and clientId", ErrInvalidMessage, i)
+		}
+
+		if err := access.AuthorizeClientID(ctx, message.ClientID); err != nil {
+			return nil, err
+		}
+
+		stored := *message
+		stored.ID = nil
+		if err := s.prepareMessage(ctx, &stored, now); err != nil {
+			return nil, err
+		}
+		if err := s.authorizeProjectOf(ctx, access, &stored); err != nil {
+			return nil, err
+		}
+		stored.CreatedAt = now
+		stored.UpdatedAt = now
+		stored.CreatedBy = createdBy
+		prepared = append(prepared, &stored)
+	}
+
+	created, err := s.messageRepo.CreateMany(ctx, prepared)
+	if err != nil {
+		return nil, err
+	}
+	for _, message := range created {
+		s.publish(events.MessageCreated, message)
+	}
+	return created, nil
+}
+
+// UpdateMessage applies a partial update to a message of the user
+func (s *messageService) UpdateMessage(ctx context.Context, id string, update models.MessageUpdate) (*models.Message, error) {
+	if _, err := userFromContext(ctx); err != nil {
+		return nil, err
+	}
+
+	if update.Status == nil && update.ProcessedAt == nil && update.Metadata == nil {
+		return nil, fmt.Errorf("%w: no fields to update", ErrInvalidMessage)
+	}
+	message, err := s.messageRepo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizeMessage(ctx, message); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	update.UpdatedAt = now
+	// Record when the message was processed unless the caller provides it
+	if update.Status != nil && *update.Status == models.MessageStatusProcessed && update.ProcessedAt == nil {
+		update.ProcessedAt = &now
+	}
+
+	updated, err := s.messageRepo.Update(ctx, id, update)
+	if err != nil {
+		return nil, err
+	}
+	s.publish(events.MessageUpdated, updated)
+	return updated, nil
+}
+
+// DeleteMessage removes a message of the user and returns the deleted record
+func (s *messageService) DeleteMessage(ctx context.Context, id string) (*models.Message, error) {
+	if _, err := userFromContext(ctx); err != nil {
+		return nil, err
+	}
+
+	message, err := s.messageRepo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizeMessage(ctx, message); err != nil {
+		return nil, err
+	}
+	if err := s.messageRepo.Delete(ctx, id); err != nil {
+		return nil, err
+	}
+	s.publish(events.MessageDeleted, message)
+	return message, nil
+}
+
+// ingestionCreatedBy is recorded as the author of messages stored by the MQTT ingestion subsystem
+const ingestionCreatedBy = "mqtt-ingestion"
+
+// IngestMessage stores a message received from the MQTT broker. Unlike CreateMessage it
+// needs no authenticated user and always stores the message as received.
+func (s *messageService) IngestMessage(ctx context.Context, message *models.Message) (*models.Message, error) {
+	if message == nil || message.Topic == "" || message.ClientID == "" {
+		return nil, fmt.Errorf("%w: message requires topic and clientId", ErrInvalidMessage)
+	}
+
+	now := time.Now().UTC()
+	stored := *message
+	stored.ID = nil
+	stored.Status = models.MessageStatusReceived
+	if err := s.prepareMessage(ctx, &stored, now); err != nil {
+		return nil, err
+	}
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	stored.CreatedBy = ingestionCreatedBy
+
+	created, err := s.messageRepo.Create(ctx, &stored)
+	if err != nil {
+		return nil, err
+	}
+	s.publish(events.MessageCreated, created)
+	return created, nil
+}
+
+// publish announces a stored change on the event bus, if the service has one
+func (s *messageService) publish(eventType events.EventType, message *models.Message) {
+	if s.bus == nil {
+		return
+	}
+	s.bus.Publish(events.MessageEvent{Type: eventType, Message: message})
+}
+
+// prepareMessage fills the fields derived from the raw MQTT data of a message
+func (s *messageService) prepareMessage(ctx context.Context, message *models.Message, now time.Time) error {
+	if message.Timestamp.IsZero() {
+		message.Timestamp = now
+	}
+	if message.Status == "" {
+		message.Status = models.MessageStatusReceived
+	}
+
+	message.Type = models.MessageTypeUnknown
+	match := s.topicRouter.Classify(message.Topic)
+	if match != nil {
+		message.Type = match.Type
+		applyTopicFields(message, match.Fields)
+	}
+
+	deviceID, err := resolveDeviceID(ctx, s.deviceIDs, message, match)
+	if err != nil {
+		return err
+	}
+	message.DeviceID = deviceID
+
+	// The registry is authoritative for the project of a registered device
+	projectID, err := s.devices.projectOf(ctx, message.ClientID)
+	if err != nil {
+		return err
+	}
+	if projectID != "" {
+		message.ProjectID = projectID
+	}
+
+	// Parse JSON object payloads so their fields can be queried and aggregated
+	if message.Marshalled == nil && message.Payload != "" {
+		var marshalled map[string]interface{}
+		if err := json.Unmarshal([]byte(message.Payload), &marshalled); err == nil {
+			message.Marshalled = marshalled
+		}
+	}
+	return nil
+}
+
+// authorizeMessage checks that the user owns the client ID of a stored message or is a
+// member of its project
+func (s *messageService) authorizeMessage(ctx context.Context, message *models.Message) error {
+	err := s.access.AuthorizeClientID(ctx, message.ClientID)
+	if !errors.Is(err, ErrForbidden) || message.ProjectID == "" {
+		return err
+	}
+	return s.access.AuthorizeProject(ctx, message.ProjectID)
+}
+
+// authorizeProjectOf checks that the user is a member of the project of a message created
+// from an unregistered client ID; the project of a registered device is the registry's
+func (s *messageService) authorizeProjectOf(ctx context.Context, access *batchAccess, message *models.Message) error {
+	if message.ProjectID == "" {
+		return nil
+	}
+	registered, err := s.devices.projectOf(ctx, message.ClientID)
+	if err != nil {
+		return err
+	}
+	if registered != "" {
+		return nil
+	}
+	return access.AuthorizeProject(ctx, message.ProjectID)
+}
+
+// resolveDeviceID derives the device ID of a message from its client ID, topic and the
+// captures of the topic rule matching it
+func resolveDeviceID(ctx context.Context, resolver deviceid.Resolver, message *models.Message, match *topics.Match) (string, error) {
+	src := deviceid.Source{ClientID: message.ClientID, Topic: message.Topic}
+	if match != nil {
+		src.Captures = match.Fields
+	}
+	return resolver.Resolve(ctx, src)
+}
+
+// applyTopicFields copies the fields captured from the topic onto the message. deviceId
+// is left to the device ID resolver, projectId only fills a missing project and any
+// other capture is kept in the metadata unless already set there.
+func applyTopicFields(message *models.Message, fields map[string]string) {
+	for name, value := range fields {
+		switch name {
+		case "deviceId":
+			continue
+		case "projectId":
+			if message.ProjectID == "" {
+				message.ProjectID = value
+			}
+		default:
+			if _, ok := message.Metadata[name]; ok {
+				continue
+			}
+			metadata := make(map[string]string, len(message.Metadata)+1)
+			for key, existing := range message.Metadata {
+				metadata[key] = existing
+			}
+			metadata[name] = value
+			message.Metadata = metadata
+		}
+	}
+}
+
+// ClassifyTopic tests a topic against the topic rules without storing anything
+func (s *messageService) ClassifyTopic(topic string) *topics.Match {
+	return s.topicRouter.Classify(topic)
+}
+
+// TopicRules returns the configured topic rules in evaluation order
+func (s *messageService) TopicRules() []topics.Rule {
+	return s.topicRouter.Rules()
+}
+
+// AuthorizeProject checks that the project is one of the user's
+func (s *messageService) AuthorizeProject(ctx context.Context, projectID string) error {
+	return s.access.AuthorizeProject(ctx, projectID)
+}
+
+// AuthorizeClientID checks that the client ID is one of the user's
+func (s *messageService) AuthorizeClientID(ctx context.Context, clientID string) error {
+	return s.access.AuthorizeClientID(ctx, clientID)
+}
+
+// remoteAccess checks the access of the user with the project and MQTT services
+type remoteAccess struct {
+	service *messageService
+}
+
+// AuthorizeProject checks with the project service that the project is one of the user's
+func (a remoteAccess) AuthorizeProject(ctx context.Context, projectID string) error {
+	if _, err := userFromContext(ctx); err != nil {
+		return err
+	}
+	projectIDs, err := a.service.fetchProjectIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range projectIDs {
+		if id.Hex() == projectID {
+			return nil
+		}
+	}
+	return ErrForbidden
+}
+
+// AuthorizeClientID checks with the MQTT service that the client ID is one of the user's
+func (a remoteAccess) AuthorizeClientID(ctx context.Context, clientID string) error {
+	if _, err := userFromContext(ctx); err != nil {
+		return err
+	}
+	usersResponse, err := a.service.fetchUsers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, user := range usersResponse.Users {
+		for _, allowed := range user.ClientIDs {
+			if allowed == clientID {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: client ID %q is not one of the user's", ErrForbidden, clientID)
+}
+
+// batchAccess authorizes the projects and client IDs of a batch of messages, checking each
+// of them once
+type batchAccess struct {
+	access  accessChecker
+	checked map[string]error
+}
+
+func newBatchAccess(access accessChecker) *batchAccess {
+	return &batchAccess{access: access, checked: make(map[string]error)}
+}
+
+func (b *batchAccess) AuthorizeProject(ctx context.Context, projectID string) error {
+	return b.check("project:"+projectID, func() error { return b.access.AuthorizeProject(ctx, projectID) })
+}
+
+func (b *batchAccess) AuthorizeClientID(ctx context.Context, clientID string) error {
+	return b.check("client:"+clientID, func() error { return b.access.AuthorizeClientID(ctx, clientID) })
+}
+
+func (b *batchAccess) check(key string, authorize func() error) error {
+	if err, ok := b.checked[key]; ok {
+		return err
+	}
+	err := authorize()
+	b.checked[key] = err
+	return err
+}
+
+// userFromContext returns the authenticated user recorded as the author of a change,
+// preferring the email over the user ID
+func userFromContext(ctx context.Context) (string, error) {
+	if userEmail, ok := ctx.Value(middleware.UserEmailKey).(string); ok && userEmail != "" {
+		return userEmail, nil
+	}
+	if userID, ok := ctx.Value(middleware.UserIDKey).(string); ok && userID != "" {
+		return userID, nil
+	}
+	return "", ErrUnauthenticated
+}
+
+// messageSortFields maps the sortable API field names to their stored field names
+var messageSortFields = map[string]string{
+	"id":        "_id",
+	"timestamp": "timestamp",
+	"topic":     "topic",
+	"type":      "type",
+	"status":    "status",
+	"clientId":  "client_id",
+	"deviceId":  "deviceId",
+	"createdAt": "createdAt",
+	"updatedAt": "updatedAt",
+}
+
+// resolveSort validates a React Admin sort pair and returns the stored field name and normalized order
+func resolveSort(sortField, sortOrder string) (string, string, error) {
+	if sortField == "" {
+		sortField = "timestamp"
+	}
+	storedField, ok := messageSortFields[sortField]
+	if !ok {
+		return "", "", fmt.Errorf("%w: unsupported sort field %q", ErrInvalidQuery, sortField)
+	}
+
+	switch strings.ToUpper(sortOrder) {
+	case "", "DESC":
+		return storedField, "DESC", nil
+	case "ASC":
+		return storedField, "ASC", nil
+	default:
+		return "", "", fmt.Errorf("%w: unsupported sort order %q", ErrInvalidQuery, sortOrder)
+	}
+}
+
+// wrapQueryError reports filters the database provider cannot express as invalid queries
+func wrapQueryError(err error) error {
+	if errors.Is(err, repositories.ErrUnsupportedFilter) {
+		return fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+	return err
 }
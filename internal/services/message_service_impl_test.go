@@ -0,0 +1,426 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/deviceid"
+	"sit-iot-message-mng-api/internal/middleware"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+	"sit-iot-message-mng-api/internal/topics"
+)
+
+func newTestService() MessageService {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var messages []*models.Message
+	for i := 0; i < 5; i++ {
+		messageType := models.MessageTypeTelemetry
+		if i%2 == 1 {
+			messageType = models.MessageTypeStatus
+		}
+		messages = append(messages, &models.Message{
+			Topic:     "site/dev-1/" + string(messageType),
+			ClientID:  "dev-1",
+			DeviceID:  "dev-1",
+			Type:      messageType,
+			Timestamp: base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	messages = append(messages, &models.Message{Topic: "site/dev-2/telemetry", ClientID: "dev-2", DeviceID: "dev-2", Type: models.MessageTypeTelemetry, Timestamp: base})
+
+	topicRouter, err := topics.NewRouter(topics.DefaultRules())
+	if err != nil {
+		panic(err)
+	}
+	return withTestAccess(NewMessageService(repositories.NewMemoryMessageRepository(messages, nil), nil, nil, topicRouter, deviceid.NewResolver(deviceid.DefaultStrategies()...), nil, nil))
+}
+
+// testAccess grants the user the projects and client IDs it lists
+type testAccess struct {
+	projectMembership
+	clientOwnership
+}
+
+// withTestAccess grants the user of a test service the projects p1 and p-compare, and the
+// client IDs dev-1 to dev-9
+func withTestAccess(service MessageService) MessageService {
+	clients := clientOwnership{}
+	for i := 1; i <= 9; i++ {
+		clients = append(clients, fmt.Sprintf("dev-%d", i))
+	}
+	service.(*messageService).access = testAccess{projectMembership{"p1", "p-compare"}, clients}
+	return service
+}
+
+func testContext() context.Context {
+	ctx := context.WithValue(context.Background(), middleware.UserIDKey, "user-1")
+	return context.WithValue(ctx, middleware.UserEmailKey, "user@example.com")
+}
+
+func TestListMessagesByDeviceID(t *testing.T) {
+	service := newTestService()
+	ctx := testContext()
+
+	messages, total, err := service.ListMessagesByDeviceID(ctx, "dev-1", models.MessageFilter{}, "timestamp", "ASC", 1, 2)
+	if err != nil {
+		t.Fatalf("ListMessagesByDeviceID() unexpected error: %v", err)
+	}
+	if total != 5 {
+		t.Errorf("ListMessagesByDeviceID() total = %d, want 5", total)
+	}
+	if len(messages) != 2 || !messages[0].Timestamp.Before(messages[1].Timestamp) {
+		t.Errorf("ListMessagesByDeviceID() should return the second page in ascending order, got %d messages", len(messages))
+	}
+
+	messages, total, err = service.ListMessagesByDeviceID(ctx, "dev-1", models.MessageFilter{Type: models.MessageTypeStatus}, "", "", 0, 10)
+	if err != nil {
+		t.Fatalf("ListMessagesByDeviceID() unexpected error: %v", err)
+	}
+	if total != 2 || len(messages) != 2 {
+		t.Errorf("ListMessagesByDeviceID() with type filter total = %d, len = %d, want 2", total, len(messages))
+	}
+
+	if _, _, err := service.ListMessagesByDeviceID(ctx, "dev-1", models.MessageFilter{}, "payload", "ASC", 0, 10); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("ListMessagesByDeviceID() error = %v, want %v for a non-whitelisted sort field", err, ErrInvalidQuery)
+	}
+	if _, _, err := service.ListMessagesByDeviceID(ctx, "dev-1", models.MessageFilter{}, "timestamp", "sideways", 0, 10); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("ListMessagesByDeviceID() error = %v, want %v for an invalid sort order", err, ErrInvalidQuery)
+	}
+}
+
+func TestCreateAndUpdateMessage(t *testing.T) {
+	service := newTestService()
+	ctx := testContext()
+
+	created, err := service.CreateMessage(ctx, &models.Message{
+		Topic:     "site/dev-7/telemetry",
+		Payload:   `{"temperature":21.5}`,
+		ClientID:  "dev-7",
+		Type:      models.MessageTypeAlert,
+		CreatedBy: "spoofed",
+	})
+	if err != nil {
+		t.Fatalf("CreateMessage() unexpected error: %v", err)
+	}
+	if created.Type != models.MessageTypeTelemetry || created.DeviceID != "dev-7" {
+		t.Errorf("CreateMessage() derived type = %s, deviceId = %s", created.Type, created.DeviceID)
+	}
+	if created.CreatedBy != "user@example.com" || created.CreatedAt.IsZero() || created.Status != models.MessageStatusReceived {
+		t.Errorf("CreateMessage() audit fields = %+v", created)
+	}
+	if created.Marshalled["temperature"] != 21.5 {
+		t.Errorf("CreateMessage() marshalled = %v", created.Marshalled)
+	}
+
+	if _, err := service.CreateMessages(ctx, []*models.Message{{Topic: "site/dev-7/telemetry"}}); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("CreateMessages() error = %v, want %v", err, ErrInvalidMessage)
+	}
+	if _, err := service.CreateMessage(context.Background(), &models.Message{Topic: "t", ClientID: "c"}); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("CreateMessage() without user error = %v, want %v", err, ErrUnauthenticated)
+	}
+
+	processed := models.MessageStatusProcessed
+	updated, err := service.UpdateMessage(ctx, created.GetIDAsString(), models.MessageUpdate{Status: &processed})
+	if err != nil {
+		t.Fatalf("UpdateMessage() unexpected error: %v", err)
+	}
+	if updated.Status != processed || updated.ProcessedAt == nil {
+		t.Errorf("UpdateMessage() should set status and processedAt, got %+v", updated)
+	}
+
+	if _, err := service.DeleteMessage(ctx, created.GetIDAsString()); err != nil {
+		t.Fatalf("DeleteMessage() unexpected error: %v", err)
+	}
+	if _, err := service.DeleteMessage(ctx, created.GetIDAsString()); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("DeleteMessage() twice error = %v, want %v", err, ErrMessageNotFound)
+	}
+}
+
+func TestMessageWritesNeedAccess(t *testing.T) {
+	ctx := testContext()
+	topicRouter, err := topics.NewRouter(topics.DefaultRules())
+	if err != nil {
+		t.Fatalf("NewRouter() unexpected error: %v", err)
+	}
+	other := &models.Message{Topic: "site/dev-20/telemetry", ClientID: "dev-20", DeviceID: "dev-20", ProjectID: "p9"}
+	other.SetIDFromString("65a000000000000000000001")
+	member := &models.Message{Topic: "site/dev-21/telemetry", ClientID: "dev-21", DeviceID: "dev-21", ProjectID: "p1"}
+	member.SetIDFromString("65a000000000000000000002")
+	repo := repositories.NewMemoryMessageRepository([]*models.Message{other, member}, nil)
+	service := withTestAccess(NewMessageService(repo, nil, nil, topicRouter, deviceid.NewResolver(deviceid.DefaultStrategies()...), nil, nil))
+
+	batch := []*models.Message{{Topic: "site/dev-1/telemetry", ClientID: "dev-1"}, {Topic: "site/dev-20/telemetry", ClientID: "dev-20"}}
+	if _, err := service.CreateMessages(ctx, batch); !errors.Is(err, ErrForbidden) {
+		t.Errorf("CreateMessages() with a client ID of another user error = %v, want %v", err, ErrForbidden)
+	}
+	if _, total, _ := repo.List(ctx, models.MessageFilter{}, "timestamp", "ASC", 0, 10); total != 2 {
+		t.Errorf("CreateMessages() refused stored messages: %d messages, want 2", total)
+	}
+
+	processed := models.MessageStatusProcessed
+	if _, err := service.UpdateMessage(ctx, other.GetIDAsString(), models.MessageUpdate{Status: &processed}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("UpdateMessage() of another user error = %v, want %v", err, ErrForbidden)
+	}
+	if _, err := service.DeleteMessage(ctx, other.GetIDAsString()); !errors.Is(err, ErrForbidden) {
+		t.Errorf("DeleteMessage() of another user error = %v, want %v", err, ErrForbidden)
+	}
+	if _, err := repo.FindByID(ctx, other.GetIDAsString()); err != nil {
+		t.Errorf("FindByID() after a refused DeleteMessage() error = %v", err)
+	}
+
+	// Members of the project of a message may change it
+	if _, err := service.UpdateMessage(ctx, member.GetIDAsString(), models.MessageUpdate{Status: &processed}); err != nil {
+		t.Errorf("UpdateMessage() in a project of the user unexpected error: %v", err)
+	}
+	if _, err := service.DeleteMessage(ctx, member.GetIDAsString()); err != nil {
+		t.Errorf("DeleteMessage() in a project of the user unexpected error: %v", err)
+	}
+}
+
+func TestCreateMessagesAuthorizesTheirProject(t *testing.T) {
+	ctx := testContext()
+	deviceRepo := repositories.NewMemoryDeviceRepository()
+	devices := NewDeviceService(deviceRepo, projectMembership{"p2"}, clientOwnership{"dev-3"})
+	if _, err := devices.CreateDevice(ctx, "p2", &models.Device{Name: "boiler", ClientID: "dev-3"}); err != nil {
+		t.Fatalf("CreateDevice() unexpected error: %v", err)
+	}
+	topicRouter, err := topics.NewRouter(topics.DefaultRules())
+	if err != nil {
+		t.Fatalf("NewRouter() unexpected error: %v", err)
+	}
+	service := withTestAccess(NewMessageService(repositories.NewMemoryMessageRepository(nil, nil), nil, nil, topicRouter, deviceid.NewResolver(deviceid.DefaultStrategies()...), deviceRepo, nil))
+
+	tests := []struct {
+		name    string
+		message models.Message
+		want    string
+		wantErr error
+	}{
+		{name: "registered", message: models.Message{Topic: "dev-3/telemetry", ClientID: "dev-3", ProjectID: "p9"}, want: "p2"},
+		{name: "unregistered in a project of the user", message: models.Message{Topic: "dev-4/telemetry", ClientID: "dev-4", ProjectID: "p1"}, want: "p1"},
+		{name: "unregistered without project", message: models.Message{Topic: "dev-4/telemetry", ClientID: "dev-4"}, want: ""},
+		{name: "unregistered in another project", message: models.Message{Topic: "dev-4/telemetry", ClientID: "dev-4", ProjectID: "p9"}, wantErr: ErrForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			created, err := service.CreateMessage(ctx, &tt.message)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("CreateMessage() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateMessage() unexpected error: %v", err)
+			}
+			if created.ProjectID != tt.want {
+				t.Errorf("CreateMessage() projectId = %q, want %q", created.ProjectID, tt.want)
+			}
+		})
+	}
+}
+
+func TestIngestMessage(t *testing.T) {
+	service := newTestService()
+
+	failed := models.MessageStatusFailed
+	ingested, err := service.IngestMessage(context.Background(), &models.Message{
+		Topic:    "site/dev-8/telemetry",
+		Payload:  `{"temperature":19}`,
+		ClientID: "dev-8",
+		Status:   failed,
+	})
+	if err != nil {
+		t.Fatalf("IngestMessage() unexpected error: %v", err)
+	}
+	if ingested.Status != models.MessageStatusReceived || ingested.Type != models.MessageTypeTelemetry || ingested.DeviceID != "dev-8" {
+		t.Errorf("IngestMessage() derived fields = %+v", ingested)
+	}
+	if ingested.CreatedBy != ingestionCreatedBy || ingested.Marshalled["temperature"] != float64(19) {
+		t.Errorf("IngestMessage() = %+v", ingested)
+	}
+
+	if _, err := service.IngestMessage(context.Background(), &models.Message{Topic: "site/dev-8/telemetry"}); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("IngestMessage() error = %v, want %v", err, ErrInvalidMessage)
+	}
+}
+
+func TestCreateMessageAppliesTopicRules(t *testing.T) {
+	service := newTestService()
+
+	created, err := service.CreateMessage(testContext(), &models.Message{
+		Topic:    "shelly-1234/status/switch:0",
+		ClientID: "dev-3",
+		Payload:  `{"output":true}`,
+	})
+	if err != nil {
+		t.Fatalf("CreateMessage() unexpected error: %v", err)
+	}
+	if created.Type != models.MessageTypeStatus || created.DeviceID != "shelly-1234" || created.Metadata["channel"] != "switch:0" {
+		t.Errorf("CreateMessage() type = %s, deviceId = %s, metadata = %v", created.Type, created.DeviceID, created.Metadata)
+	}
+
+	created, err = service.CreateMessage(testContext(), &models.Message{Topic: "site/rpc-status/x", ClientID: "dev-3"})
+	if err != nil {
+		t.Fatalf("CreateMessage() unexpected error: %v", err)
+	}
+	if created.Type != models.MessageTypeUnknown || created.DeviceID != "dev-3" {
+		t.Errorf("CreateMessage() type = %s, deviceId = %s for an unmatched topic", created.Type, created.DeviceID)
+	}
+}
+
+func TestAggregateMessagesByDeviceID(t *testing.T) {
+	service := newTestService()
+	ctx := testContext()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, temperature := range []string{"20", "22", "24"} {
+		_, err := service.CreateMessage(ctx, &models.Message{
+			Topic:     "site/dev-5/telemetry",
+			ClientID:  "dev-5",
+			Payload:   `{"temperature":` + temperature + `}`,
+			Timestamp: base.Add(time.Duration(i) * 20 * time.Minute),
+		})
+		if err != nil {
+			t.Fatalf("CreateMessage() unexpected error: %v", err)
+		}
+	}
+
+	results, err := service.AggregateMessagesByDeviceID(ctx, models.AggregationQuery{DeviceID: "dev-5", From: base, To: base.Add(time.Hour), Bucket: models.Bucket1Hour})
+	if err != nil {
+		t.Fatalf("AggregateMessagesByDeviceID() unexpected error: %v", err)
+	}
+	if len(results) != 1 || results[0].Count != 3 || results[0].Avg != 22 || results[0].Min != 20 || results[0].Max != 24 {
+		t.Errorf("AggregateMessagesByDeviceID() = %+v", results)
+	}
+
+	if _, err := service.AggregateMessagesByDeviceID(ctx, models.AggregationQuery{DeviceID: "dev-5", From: base, To: base.Add(time.Hour), Bucket: "1w"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("AggregateMessagesByDeviceID() error = %v, want %v", err, ErrInvalidQuery)
+	}
+	if _, err := service.AggregateMessagesByDeviceID(ctx, models.AggregationQuery{DeviceID: "dev-5", From: base, To: base.AddDate(1, 0, 0), Bucket: models.Bucket1Minute}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("AggregateMessagesByDeviceID() error = %v, want %v for too many buckets", err, ErrInvalidQuery)
+	}
+}
+
+func TestGetDeviceSeries(t *testing.T) {
+	service := newTestService()
+	ctx := testContext()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var messages []*models.Message
+	for i := 0; i < 20; i++ {
+		temperature := 20
+		if i == 7 {
+			temperature = 90
+		}
+		topic := "dev-6/telemetry/ch1"
+		if i%5 == 4 {
+			topic = "dev-6/telemetry/ch2"
+		}
+		messages = append(messages, &models.Message{
+			Topic:     topic,
+			ClientID:  "dev-6",
+			Payload:   fmt.Sprintf(`{"sensor":{"temperature":%d}}`, temperature),
+			Timestamp: base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	// Alerts repeat the reading that raised them and are left out of the series
+	messages = append(messages, &models.Message{Topic: "dev-6/alert/r1", ClientID: "dev-6", Payload: `{"sensor":{"temperature":90}}`, Timestamp: base.Add(7 * time.Minute)})
+	if _, err := service.CreateMessages(ctx, messages); err != nil {
+		t.Fatalf("CreateMessages() unexpected error: %v", err)
+	}
+
+	query := models.SeriesQuery{DeviceID: "dev-6", Variable: "sensor.temperature", Channel: "ch1", From: base, To: base.Add(time.Hour), Points: 4, Method: models.DownsampleLTTB}
+	series, err := service.GetDeviceSeries(ctx, query)
+	if err != nil {
+		t.Fatalf("GetDeviceSeries() unexpected error: %v", err)
+	}
+	if series.RawPoints != 16 || len(series.Points) != 4 {
+		t.Fatalf("GetDeviceSeries() = %d raw, %d points; want 16 raw, 4 points", series.RawPoints, len(series.Points))
+	}
+	if spike := series.Points[1]; spike.Value != 90 || !spike.Timestamp.Equal(base.Add(7*time.Minute)) {
+		t.Errorf("GetDeviceSeries() lost the spike: %+v", series.Points)
+	}
+
+	query.Method = models.DownsampleMinMax
+	query.Channel = ""
+	if series, err = service.GetDeviceSeries(ctx, query); err != nil {
+		t.Fatalf("GetDeviceSeries(minmax) unexpected error: %v", err)
+	}
+	if series.RawPoints != 20 || len(series.Points) > 4 {
+		t.Errorf("GetDeviceSeries(minmax) = %d raw, %d points; want 20 raw, at most 4 points", series.RawPoints, len(series.Points))
+	}
+
+	query.Points = 1
+	if _, err := service.GetDeviceSeries(ctx, query); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("GetDeviceSeries() error = %v, want %v", err, ErrInvalidQuery)
+	}
+}
+
+func TestCompareDevices(t *testing.T) {
+	service := newTestService()
+	ctx := testContext()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var messages []*models.Message
+	for i, temperature := range []int{20, 22, 30, 26} {
+		deviceID := "dev-7"
+		if i%2 == 1 {
+			deviceID = "dev-8"
+		}
+		messages = append(messages, &models.Message{
+			Topic:     "site/" + deviceID + "/telemetry",
+			ClientID:  deviceID,
+			DeviceID:  deviceID,
+			ProjectID: "p-compare",
+			Payload:   fmt.Sprintf(`{"temperature":%d}`, temperature),
+			Timestamp: base.Add(time.Duration(i/2) * time.Hour),
+		})
+	}
+	// dev-8 has no data in the third hour, its alerts and those of dev-9 are not device data
+	messages = append(messages,
+		&models.Message{Topic: "site/dev-7/telemetry", ClientID: "dev-7", DeviceID: "dev-7", ProjectID: "p-compare", Payload: `{"temperature":40}`, Timestamp: base.Add(2 * time.Hour)},
+		&models.Message{Topic: "site/dev-8/alert", ClientID: "dev-8", DeviceID: "dev-8", ProjectID: "p-compare", Payload: `{"temperature":26}`, Timestamp: base.Add(2 * time.Hour)},
+		&models.Message{Topic: "site/dev-9/alert", ClientID: "dev-9", DeviceID: "dev-9", ProjectID: "p-compare", Payload: `{"temperature":50}`, Timestamp: base.Add(2 * time.Hour)},
+	)
+	if _, err := service.CreateMessages(ctx, messages); err != nil {
+		t.Fatalf("CreateMessages() unexpected error: %v", err)
+	}
+
+	query := models.ComparisonQuery{ProjectID: "p-compare", Variable: "temperature", Bucket: models.Bucket1Hour, From: base, To: base.Add(3 * time.Hour), Summary: true}
+	comparison, err := service.CompareDevices(ctx, query)
+	if err != nil {
+		t.Fatalf("CompareDevices() unexpected error: %v", err)
+	}
+	if len(comparison.Devices) != 2 || comparison.Devices[0] != "dev-7" || comparison.Devices[1] != "dev-8" {
+		t.Fatalf("CompareDevices() devices = %v, want [dev-7 dev-8]", comparison.Devices)
+	}
+	if len(comparison.Timestamps) != 3 || comparison.Series["dev-8"][2] != nil || comparison.Series["dev-7"][2].Avg != 40 {
+		t.Errorf("CompareDevices() series = %+v", comparison.Series)
+	}
+	if summary := comparison.Summary[1]; summary.Devices != 2 || summary.Mean != 28 || summary.Spread != 4 {
+		t.Errorf("CompareDevices() summary[1] = %+v", summary)
+	}
+
+	if _, err := service.CompareDevices(ctx, models.ComparisonQuery{ProjectID: "p9", Variable: "temperature", Bucket: models.Bucket1Hour, From: base, To: base.Add(3 * time.Hour)}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("CompareDevices() of another project error = %v, want %v", err, ErrForbidden)
+	}
+
+	// Devices without stored rollups are listed with empty series
+	query = models.ComparisonQuery{DeviceIDs: []string{"dev-7", "unknown"}, Variable: "temperature", Period: models.PeriodHourly, From: base, To: base.Add(3 * time.Hour)}
+	if comparison, err = service.CompareDevices(ctx, query); err != nil {
+		t.Fatalf("CompareDevices(period) unexpected error: %v", err)
+	}
+	if len(comparison.Devices) != 2 || len(comparison.Timestamps) != 0 || comparison.Summary != nil {
+		t.Errorf("CompareDevices(period) = %+v", comparison)
+	}
+
+	query.Bucket = models.Bucket1Hour
+	if _, err := service.CompareDevices(ctx, query); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("CompareDevices() error = %v, want %v", err, ErrInvalidQuery)
+	}
+}
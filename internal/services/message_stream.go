@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/middleware"
+	"sit-iot-message-mng-api/internal/models"
+)
+
+// ErrStreamUnavailable is returned when live messages cannot be streamed because no event bus is configured
+var ErrStreamUnavailable = errors.New("live message stream unavailable")
+
+const (
+	// streamBuffer is the number of live messages queued for a slow stream client before
+	// further messages are dropped
+	streamBuffer = 256
+	// streamReplayPageSize is the number of stored messages read per page when replaying
+	streamReplayPageSize = 100
+	// StreamMaxReplay caps the number of stored messages replayed when a stream resumes
+	StreamMaxReplay = 1000
+)
+
+// MessageStreamEvent is a message delivered on a stream, or a notice that the replay was cut
+// short and the client should reload the listing to catch up
+type MessageStreamEvent struct {
+	Message   *models.Message
+	Truncated bool // More than StreamMaxReplay messages were stored since the resumption cursor
+}
+
+// AuthorizeSubscription checks that the filter is valid and scoped to devices, client IDs or
+// projects, and that the user may receive the live messages it selects, as the REST routes
+// do for stored messages: its projects must be the user's, and so must its client IDs
+func (s *messageService) AuthorizeSubscription(ctx context.Context, filter models.MessageFilter) error {
+	userEmail, ok := ctx.Value(middleware.UserEmailKey).(string)
+	if !ok || userEmail == "" {
+		return ErrUnauthenticated
+	}
+	if err := filter.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+	if filter.DeviceID == "" && len(filter.DeviceIDs) == 0 && filter.ClientID == "" && len(filter.ClientIDs) == 0 &&
+		filter.ProjectID == "" && len(filter.ProjectIDs) == 0 {
+		return fmt.Errorf("%w: a device, client ID or project is required", ErrInvalidQuery)
+	}
+
+	access := newBatchAccess(s.access)
+	for _, projectID := range append([]string{filter.ProjectID}, filter.ProjectIDs...) {
+		if projectID == "" {
+			continue
+		}
+		if err := access.AuthorizeProject(ctx, projectID); err != nil {
+			return err
+		}
+	}
+	for _, clientID := range append([]string{filter.ClientID}, filter.ClientIDs...) {
+		if clientID == "" {
+			continue
+		}
+		if err := access.AuthorizeClientID(ctx, clientID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StreamMessagesByDeviceID replays the stored messages of the device matching the filter that
+// come after the cursor (none when it is nil), ordered by timestamp and ID, then delivers the
+// messages stored from now on until ctx is done. The first replay page is read before returning
+// so unsupported filters are reported as errors rather than ending the stream.
+func (s *messageService) StreamMessagesByDeviceID(ctx context.Context, deviceID string, filter models.MessageFilter, after *models.MessageCursor) (<-chan MessageStreamEvent, error) {
+	filter.DeviceID = deviceID
+	if err := s.AuthorizeSubscription(ctx, filter); err != nil {
+		return nil, err
+	}
+	if s.bus == nil {
+		return nil, ErrStreamUnavailable
+	}
+
+	// Subscribe before replaying so no message stored in between is missed
+	live, cancel := s.bus.Subscribe("stream:"+deviceID, streamBuffer)
+
+	var page []*models.Message
+	var next *models.MessageCursor
+	if after != nil {
+		var err error
+		if page, next, err = s.messageRepo.ListByCursor(ctx, filter, after, "ASC", streamReplayPageSize); err != nil {
+			cancel()
+			return nil, wrapQueryError(err)
+		}
+	}
+
+	out := make(chan MessageStreamEvent)
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		send := func(event MessageStreamEvent) bool {
+			select {
+			case out <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		// Live messages may also be part of the replay
+		replayed := make(map[string]bool)
+		for {
+			for _, message := range page {
+				if len(replayed) == StreamMaxReplay {
+					if !send(MessageStreamEvent{Truncated: true}) {
+						return
+					}
+					next = nil
+					break
+				}
+				replayed[message.GetIDAsString()] = true
+				if !send(MessageStreamEvent{Message: message}) {
+					return
+				}
+			}
+			if next == nil {
+				break
+			}
+			var err error
+			if page, next, err = s.messageRepo.ListByCursor(ctx, filter, next, "ASC", streamReplayPageSize); err != nil {
+				log.Printf("Error replaying messages of device %s: %v", deviceID, err)
+				return
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-live:
+				if !ok {
+					return
+				}
+				if event.Type != events.MessageCreated || !filter.Matches(event.Message) || replayed[event.Message.GetIDAsString()] {
+					continue
+				}
+				if !send(MessageStreamEvent{Message: event.Message}) {
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
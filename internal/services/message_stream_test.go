@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/deviceid"
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+	"sit-iot-message-mng-api/internal/topics"
+)
+
+// newStreamService returns a service publishing on a bus, seeded with count telemetry
+// messages of dev-1 one minute apart
+func newStreamService(t *testing.T, count int) (MessageService, []*models.Message) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var messages []*models.Message
+	for i := 0; i < count; i++ {
+		message := &models.Message{Topic: "site/dev-1/telemetry", ClientID: "dev-1", DeviceID: "dev-1", Type: models.MessageTypeTelemetry, Timestamp: base.Add(time.Duration(i) * time.Minute)}
+		message.SetIDFromString(fmt.Sprintf("65a0000000000000000%05d", i))
+		messages = append(messages, message)
+	}
+
+	topicRouter, err := topics.NewRouter(topics.DefaultRules())
+	if err != nil {
+		t.Fatalf("NewRouter() unexpected error: %v", err)
+	}
+	repo := repositories.NewMemoryMessageRepository(messages, nil)
+	return withTestAccess(NewMessageService(repo, nil, nil, topicRouter, deviceid.NewResolver(deviceid.DefaultStrategies()...), nil, events.NewBus())), messages
+}
+
+// receive returns the next event of the stream or fails after a second
+func receive(t *testing.T, stream <-chan MessageStreamEvent) MessageStreamEvent {
+	t.Helper()
+	select {
+	case event, ok := <-stream:
+		if !ok {
+			t.Fatal("stream closed unexpectedly")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	return MessageStreamEvent{}
+}
+
+func TestStreamMessagesByDeviceID(t *testing.T) {
+	service, messages := newStreamService(t, 3)
+	ctx, cancel := context.WithCancel(testContext())
+	defer cancel()
+
+	// Resuming after the first message replays the two others
+	stream, err := service.StreamMessagesByDeviceID(ctx, "dev-1", models.MessageFilter{Types: []models.MessageType{models.MessageTypeTelemetry}}, models.CursorFromMessage(messages[0]))
+	if err != nil {
+		t.Fatalf("StreamMessagesByDeviceID() unexpected error: %v", err)
+	}
+	for _, want := range messages[1:] {
+		if event := receive(t, stream); event.Message == nil || event.Message.GetIDAsString() != want.GetIDAsString() {
+			t.Fatalf("replayed event = %+v, want message %s", event, want.GetIDAsString())
+		}
+	}
+
+	// Live messages of other devices or types are filtered out
+	for _, message := range []*models.Message{
+		{Topic: "site/dev-2/telemetry", ClientID: "dev-2"},
+		{Topic: "site/dev-1/status/online", ClientID: "dev-1"},
+		{Topic: "site/dev-1/telemetry", ClientID: "dev-1", Payload: `{"temperature":21}`},
+	} {
+		if _, err := service.CreateMessage(ctx, message); err != nil {
+			t.Fatalf("CreateMessage() unexpected error: %v", err)
+		}
+	}
+	if event := receive(t, stream); event.Message == nil || event.Message.Payload != `{"temperature":21}` {
+		t.Errorf("live event = %+v, want the dev-1 telemetry message", event)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-stream:
+		if ok {
+			t.Error("stream delivered an event after its context was canceled")
+		}
+	case <-time.After(time.Second):
+		t.Error("stream not closed after its context was canceled")
+	}
+}
+
+func TestStreamMessagesByDeviceIDTruncatesReplay(t *testing.T) {
+	service, messages := newStreamService(t, StreamMaxReplay+2)
+	ctx, cancel := context.WithCancel(testContext())
+	defer cancel()
+
+	stream, err := service.StreamMessagesByDeviceID(ctx, "dev-1", models.MessageFilter{}, models.CursorFromMessage(messages[0]))
+	if err != nil {
+		t.Fatalf("StreamMessagesByDeviceID() unexpected error: %v", err)
+	}
+	for i := 0; i < StreamMaxReplay; i++ {
+		if event := receive(t, stream); event.Message == nil {
+			t.Fatalf("event %d = %+v, want a message", i, event)
+		}
+	}
+	if event := receive(t, stream); !event.Truncated {
+		t.Errorf("event after the replay limit = %+v, want truncated", event)
+	}
+}
+
+func TestAuthorizeSubscription(t *testing.T) {
+	service := newTestService()
+	tests := []struct {
+		name   string
+		filter models.MessageFilter
+		want   error
+	}{
+		{name: "device", filter: models.MessageFilter{DeviceIDs: []string{"dev-1", "dev-2"}}},
+		{name: "projects of the user", filter: models.MessageFilter{ProjectIDs: []string{"p1", "p-compare"}}},
+		{name: "client IDs of the user", filter: models.MessageFilter{ClientID: "dev-1", Types: []models.MessageType{models.MessageTypeAlert}}},
+		{name: "unscoped", filter: models.MessageFilter{Types: []models.MessageType{models.MessageTypeAlert}}, want: ErrInvalidQuery},
+		{name: "empty", filter: models.MessageFilter{}, want: ErrInvalidQuery},
+		{name: "another project", filter: models.MessageFilter{ProjectIDs: []string{"p1", "p9"}}, want: ErrForbidden},
+		{name: "another client ID", filter: models.MessageFilter{DeviceID: "dev-1", ClientIDs: []string{"dev-20"}}, want: ErrForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := service.AuthorizeSubscription(testContext(), tt.filter); !errors.Is(err, tt.want) {
+				t.Errorf("AuthorizeSubscription() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamMessagesByDeviceIDErrors(t *testing.T) {
+	service, _ := newStreamService(t, 0)
+	if _, err := service.StreamMessagesByDeviceID(context.Background(), "dev-1", models.MessageFilter{}, nil); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("StreamMessagesByDeviceID() without user error = %v, want %v", err, ErrUnauthenticated)
+	}
+	if _, err := service.StreamMessagesByDeviceID(testContext(), "dev-1", models.MessageFilter{TopicPattern: "a/#/b"}, nil); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("StreamMessagesByDeviceID() error = %v, want %v", err, ErrInvalidQuery)
+	}
+	if _, err := service.StreamMessagesByDeviceID(testContext(), "dev-1", models.MessageFilter{ProjectID: "p9"}, nil); !errors.Is(err, ErrForbidden) {
+		t.Errorf("StreamMessagesByDeviceID() of another project error = %v, want %v", err, ErrForbidden)
+	}
+	if _, err := newTestService().StreamMessagesByDeviceID(testContext(), "dev-1", models.MessageFilter{}, nil); !errors.Is(err, ErrStreamUnavailable) {
+		t.Errorf("StreamMessagesByDeviceID() without bus error = %v, want %v", err, ErrStreamUnavailable)
+	}
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+// ErrPresenceNotFound is re-exported so controllers can map it to an HTTP status
+var ErrPresenceNotFound = repositories.ErrPresenceNotFound
+
+// PresenceService reads the presence of the devices of the projects of the user, which is
+// kept up to date by the presence tracker
+type PresenceService interface {
+	// ListPresence returns a page of the presences of the project, optionally in a single
+	// state, most recently seen first, with the total number of selected presences
+	ListPresence(ctx context.Context, projectID string, state models.PresenceState, skip, limit int) ([]*models.DevicePresence, int, error)
+	GetPresence(ctx context.Context, projectID, deviceID string) (*models.DevicePresence, error)
+	// ListTransitions returns a page of the transitions of a device of the project, newest
+	// first, optionally restricted to [from, to), with the total number of transitions
+	ListTransitions(ctx context.Context, projectID, deviceID string, from, to *time.Time, skip, limit int) ([]*models.PresenceTransition, int, error)
+}
+
+type presenceService struct {
+	presenceRepo repositories.PresenceRepository
+	projects     ProjectAuthorizer
+}
+
+func NewPresenceService(presenceRepo repositories.PresenceRepository, projects ProjectAuthorizer) PresenceService {
+	return &presenceService{
+		presenceRepo: presenceRepo,
+		projects:     projects,
+	}
+}
+
+func (s *presenceService) ListPresence(ctx context.Context, projectID string, state models.PresenceState, skip, limit int) ([]*models.DevicePresence, int, error) {
+	if err := s.authorize(ctx, projectID); err != nil {
+		return nil, 0, err
+	}
+	if state != "" && state != models.PresenceOnline && state != models.PresenceOffline {
+		return nil, 0, fmt.Errorf("%w: unknown presence state %q", ErrInvalidQuery, state)
+	}
+	return s.presenceRepo.ListPresence(ctx, models.PresenceFilter{ProjectID: projectID, State: state}, skip, limit)
+}
+
+func (s *presenceService) GetPresence(ctx context.Context, projectID, deviceID string) (*models.DevicePresence, error) {
+	if err := s.authorize(ctx, projectID); err != nil {
+		return nil, err
+	}
+	return s.findPresence(ctx, projectID, deviceID)
+}
+
+func (s *presenceService) ListTransitions(ctx context.Context, projectID, deviceID string, from, to *time.Time, skip, limit int) ([]*models.PresenceTransition, int, error) {
+	if err := s.authorize(ctx, projectID); err != nil {
+		return nil, 0, err
+	}
+	if from != nil && to != nil && from.After(*to) {
+		return nil, 0, fmt.Errorf("%w: from must not be after to", ErrInvalidQuery)
+	}
+	if _, err := s.findPresence(ctx, projectID, deviceID); err != nil {
+		return nil, 0, err
+	}
+	return s.presenceRepo.ListTransitions(ctx, deviceID, from, to, skip, limit)
+}
+
+// authorize checks that the user is authenticated and may access the project
+func (s *presenceService) authorize(ctx context.Context, projectID string) error {
+	if _, err := userFromContext(ctx); err != nil {
+		return err
+	}
+	if projectID == "" {
+		return fmt.Errorf("%w: project ID is required", ErrInvalidQuery)
+	}
+	return s.projects.AuthorizeProject(ctx, projectID)
+}
+
+// findPresence returns the presence of a device of the project; devices of other projects
+// are not found
+func (s *presenceService) findPresence(ctx context.Context, projectID, deviceID string) (*models.DevicePresence, error) {
+	presence, err := s.presenceRepo.FindPresence(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if presence.ProjectID != projectID {
+		return nil, ErrPresenceNotFound
+	}
+	return presence, nil
+}
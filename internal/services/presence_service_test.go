@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+func TestPresenceService(t *testing.T) {
+	ctx := testContext()
+	repo := repositories.NewMemoryPresenceRepository()
+	service := NewPresenceService(repo, projectMembership{"p1", "p2"})
+
+	at := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+	for _, presence := range []*models.DevicePresence{
+		{DeviceID: "dev-1", ProjectID: "p1", State: models.PresenceOnline, LastSeen: at},
+		{DeviceID: "dev-2", ProjectID: "p1", State: models.PresenceOffline, LastSeen: at.Add(-time.Hour)},
+		{DeviceID: "dev-3", ProjectID: "p2", State: models.PresenceOnline, LastSeen: at},
+	} {
+		if err := repo.SavePresence(context.Background(), presence); err != nil {
+			t.Fatalf("SavePresence() unexpected error: %v", err)
+		}
+	}
+	for i, to := range []models.PresenceState{models.PresenceOnline, models.PresenceOffline, models.PresenceOnline} {
+		transition := &models.PresenceTransition{ID: string(rune('a' + i)), DeviceID: "dev-1", ProjectID: "p1", To: to, At: at.Add(time.Duration(i-2) * time.Minute)}
+		if err := repo.AddTransition(context.Background(), transition); err != nil {
+			t.Fatalf("AddTransition() unexpected error: %v", err)
+		}
+	}
+
+	presences, total, err := service.ListPresence(ctx, "p1", "", 0, 10)
+	if err != nil || total != 2 || len(presences) != 2 || presences[0].DeviceID != "dev-1" {
+		t.Errorf("ListPresence() = %d presences of %d, %v; want dev-1 and dev-2", len(presences), total, err)
+	}
+	presences, total, err = service.ListPresence(ctx, "p1", models.PresenceOffline, 0, 10)
+	if err != nil || total != 1 || presences[0].DeviceID != "dev-2" {
+		t.Errorf("ListPresence(offline) = %d presences of %d, %v; want dev-2", len(presences), total, err)
+	}
+
+	if _, err := service.GetPresence(ctx, "p2", "dev-1"); !errors.Is(err, ErrPresenceNotFound) {
+		t.Errorf("GetPresence() from another project error = %v, want %v", err, ErrPresenceNotFound)
+	}
+
+	from := at.Add(-time.Minute)
+	transitions, total, err := service.ListTransitions(ctx, "p1", "dev-1", &from, nil, 0, 10)
+	if err != nil || total != 2 || transitions[0].ID != "c" {
+		t.Errorf("ListTransitions() = %d transitions of %d, %v; want c and b", len(transitions), total, err)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{name: "unknown state", call: func() error {
+			_, _, err := service.ListPresence(ctx, "p1", "away", 0, 10)
+			return err
+		}, want: ErrInvalidQuery},
+		{name: "other project", call: func() error {
+			_, _, err := service.ListPresence(ctx, "p3", "", 0, 10)
+			return err
+		}, want: ErrForbidden},
+		{name: "unauthenticated", call: func() error {
+			_, err := service.GetPresence(context.Background(), "p1", "dev-1")
+			return err
+		}, want: ErrUnauthenticated},
+		{name: "reversed range", call: func() error {
+			to := from.Add(-time.Hour)
+			_, _, err := service.ListTransitions(ctx, "p1", "dev-1", &from, &to, 0, 10)
+			return err
+		}, want: ErrInvalidQuery},
+		{name: "history of another project", call: func() error {
+			_, _, err := service.ListTransitions(ctx, "p2", "dev-1", nil, nil, 0, 10)
+			return err
+		}, want: ErrPresenceNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
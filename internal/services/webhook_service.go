@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidWebhook is returned when a webhook submitted for creation or update is not valid
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+// ErrWebhookNotFound is re-exported so controllers can map it to an HTTP status
+var ErrWebhookNotFound = repositories.ErrWebhookNotFound
+
+// MaxWebhooksPerProject caps the number of webhooks of a project
+const MaxWebhooksPerProject = 50
+
+// ProjectAuthorizer checks that the user of the context is a member of a project
+type ProjectAuthorizer interface {
+	AuthorizeProject(ctx context.Context, projectID string) error
+}
+
+// WebhookService manages the webhooks of the projects of the user. Secrets are only
+// returned by CreateWebhook.
+type WebhookService interface {
+	// CreateWebhook stores a new active webhook, generating its secret when none is given
+	CreateWebhook(ctx context.Context, projectID string, webhook *models.Webhook) (*models.Webhook, error)
+	GetWebhook(ctx context.Context, projectID, id string) (*models.Webhook, error)
+	ListWebhooks(ctx context.Context, projectID string) ([]*models.Webhook, error)
+	UpdateWebhook(ctx context.Context, projectID, id string, update models.WebhookUpdate) (*models.Webhook, error)
+	DeleteWebhook(ctx context.Context, projectID, id string) error
+	// ListDeliveries returns a page of the deliveries of a webhook, newest first, with the
+	// total number of deliveries of the status (all statuses when empty)
+	ListDeliveries(ctx context.Context, projectID, id string, status models.WebhookDeliveryStatus, skip, limit int) ([]*models.WebhookDelivery, int, error)
+}
+
+type webhookService struct {
+	webhookRepo repositories.WebhookRepository
+	projects    ProjectAuthorizer
+}
+
+func NewWebhookService(webhookRepo repositories.WebhookRepository, projects ProjectAuthorizer) WebhookService {
+	return &webhookService{
+		webhookRepo: webhookRepo,
+		projects:    projects,
+	}
+}
+
+func (s *webhookService) CreateWebhook(ctx context.Context, projectID string, webhook *models.Webhook) (*models.Webhook, error) {
+	createdBy, err := s.authorize(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if webhook == nil {
+		return nil, fmt.Errorf("%w: no webhook provided", ErrInvalidWebhook)
+	}
+
+	existing, err := s.webhookRepo.ListWebhooks(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= MaxWebhooksPerProject {
+		return nil, fmt.Errorf("%w: a project has at most %d webhooks", ErrInvalidWebhook, MaxWebhooksPerProject)
+	}
+
+	now := time.Now().UTC()
+	stored := *webhook
+	stored.ID = primitive.NewObjectID().Hex()
+	stored.ProjectID = projectID
+	stored.Active = true
+	stored.CreatedBy = createdBy
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	if stored.Secret == "" {
+		if stored.Secret, err = generateSecret(); err != nil {
+			return nil, err
+		}
+	}
+	if err := stored.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+
+	if err := s.webhookRepo.CreateWebhook(ctx, &stored); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+func (s *webhookService) GetWebhook(ctx context.Context, projectID, id string) (*models.Webhook, error) {
+	if _, err := s.authorize(ctx, projectID); err != nil {
+		return nil, err
+	}
+	webhook, err := s.findWebhook(ctx, projectID, id)
+	if err != nil {
+		return nil, err
+	}
+	return withoutSecret(webhook), nil
+}
+
+func (s *webhookService) ListWebhooks(ctx context.Context, projectID string) ([]*models.Webhook, error) {
+	if _, err := s.authorize(ctx, projectID); err != nil {
+		return nil, err
+	}
+	webhooks, err := s.webhookRepo.ListWebhooks(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	for i, webhook := range webhooks {
+		webhooks[i] = withoutSecret(webhook)
+	}
+	return webhooks, nil
+}
+
+func (s *webhookService) UpdateWebhook(ctx context.Context, projectID, id string, update models.WebhookUpdate) (*models.Webhook, error) {
+	if _, err := s.authorize(ctx, projectID); err != nil {
+		return nil, err
+	}
+	if update.URL == nil && update.Secret == nil && update.Description == nil && update.Filter == nil && update.Active == nil {
+		return nil, fmt.Errorf("%w: no fields to update", ErrInvalidWebhook)
+	}
+
+	webhook, err := s.findWebhook(ctx, projectID, id)
+	if err != nil {
+		return nil, err
+	}
+	update.Apply(webhook)
+	if err := webhook.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+	webhook.UpdatedAt = time.Now().UTC()
+
+	if err := s.webhookRepo.UpdateWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+	return withoutSecret(webhook), nil
+}
+
+func (s *webhookService) DeleteWebhook(ctx context.Context, projectID, id string) error {
+	if _, err := s.authorize(ctx, projectID); err != nil {
+		return err
+	}
+	if _, err := s.findWebhook(ctx, projectID, id); err != nil {
+		return err
+	}
+	return s.webhookRepo.DeleteWebhook(ctx, id)
+}
+
+func (s *webhookService) ListDeliveries(ctx context.Context, projectID, id string, status models.WebhookDeliveryStatus, skip, limit int) ([]*models.WebhookDelivery, int, error) {
+	if _, err := s.authorize(ctx, projectID); err != nil {
+		return nil, 0, err
+	}
+	switch status {
+	case "", models.WebhookDeliveryPending, models.WebhookDeliverySucceeded, models.WebhookDeliveryFailed:
+	default:
+		return nil, 0, fmt.Errorf("%w: unsupported delivery status %q", ErrInvalidQuery, status)
+	}
+	if _, err := s.findWebhook(ctx, projectID, id); err != nil {
+		return nil, 0, err
+	}
+	return s.webhookRepo.ListDeliveries(ctx, id, status, skip, limit)
+}
+
+// authorize checks that the user may manage the webhooks of the project and returns the user
+func (s *webhookService) authorize(ctx context.Context, projectID string) (string, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	if projectID == "" {
+		return "", fmt.Errorf("%w: project ID is required", ErrInvalidQuery)
+	}
+	if err := s.projects.AuthorizeProject(ctx, projectID); err != nil {
+		return "", err
+	}
+	return user, nil
+}
+
+// findWebhook returns a webhook of the project; webhooks of other projects are not found
+func (s *webhookService) findWebhook(ctx context.Context, projectID, id string) (*models.Webhook, error) {
+	webhook, err := s.webhookRepo.FindWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if webhook.ProjectID != projectID {
+		return nil, ErrWebhookNotFound
+	}
+	return webhook, nil
+}
+
+// withoutSecret returns a copy of the webhook without its secret
+func withoutSecret(webhook *models.Webhook) *models.Webhook {
+	copied := *webhook
+	copied.Secret = ""
+	return &copied
+}
+
+// generateSecret returns a random signing secret of 32 bytes, hex encoded
+func generateSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+// projectMembership authorizes the listed projects only
+type projectMembership []string
+
+func (m projectMembership) AuthorizeProject(ctx context.Context, projectID string) error {
+	for _, id := range m {
+		if id == projectID {
+			return nil
+		}
+	}
+	return ErrForbidden
+}
+
+func TestWebhookService(t *testing.T) {
+	repo := repositories.NewMemoryWebhookRepository()
+	service := NewWebhookService(repo, projectMembership{"p1", "p2"})
+	ctx := testContext()
+
+	created, err := service.CreateWebhook(ctx, "p1", &models.Webhook{
+		ID:     "ignored",
+		URL:    "https://partner.example.com/hooks",
+		Filter: models.MessageFilter{Types: []models.MessageType{models.MessageTypeAlert}},
+	})
+	if err != nil {
+		t.Fatalf("CreateWebhook() unexpected error: %v", err)
+	}
+	if created.ID == "ignored" || created.ProjectID != "p1" || !created.Active || len(created.Secret) != 64 || created.CreatedBy != "user@example.com" {
+		t.Errorf("CreateWebhook() = %+v, want an active p1 webhook with a generated secret", created)
+	}
+
+	got, err := service.GetWebhook(ctx, "p1", created.ID)
+	if err != nil {
+		t.Fatalf("GetWebhook() unexpected error: %v", err)
+	}
+	if got.Secret != "" || got.URL != created.URL {
+		t.Errorf("GetWebhook() = %+v, want the webhook without its secret", got)
+	}
+	if stored, _ := repo.FindWebhook(ctx, created.ID); stored.Secret != created.Secret {
+		t.Error("stored secret differs from the returned one")
+	}
+	if _, err := service.GetWebhook(ctx, "p2", created.ID); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("GetWebhook() from another project error = %v, want %v", err, ErrWebhookNotFound)
+	}
+
+	active := false
+	updated, err := service.UpdateWebhook(ctx, "p1", created.ID, models.WebhookUpdate{Active: &active})
+	if err != nil {
+		t.Fatalf("UpdateWebhook() unexpected error: %v", err)
+	}
+	if updated.Active || updated.Secret != "" {
+		t.Errorf("UpdateWebhook() = %+v, want an inactive webhook without its secret", updated)
+	}
+	if stored, _ := repo.FindWebhook(ctx, created.ID); stored.Secret != created.Secret || stored.Active {
+		t.Errorf("stored webhook after update = %+v, want the secret kept", stored)
+	}
+
+	for _, delivery := range []*models.WebhookDelivery{
+		{ID: "d-1", WebhookID: created.ID, Status: models.WebhookDeliveryFailed, CreatedAt: time.Now()},
+		{ID: "d-2", WebhookID: created.ID, Status: models.WebhookDeliverySucceeded, CreatedAt: time.Now()},
+	} {
+		repo.SaveDelivery(ctx, delivery)
+	}
+	deliveries, total, err := service.ListDeliveries(ctx, "p1", created.ID, models.WebhookDeliveryFailed, 0, 10)
+	if err != nil || total != 1 || len(deliveries) != 1 || deliveries[0].ID != "d-1" {
+		t.Errorf("ListDeliveries(failed) = %+v, %d, %v; want d-1", deliveries, total, err)
+	}
+
+	webhooks, err := service.ListWebhooks(ctx, "p1")
+	if err != nil || len(webhooks) != 1 || webhooks[0].Secret != "" {
+		t.Errorf("ListWebhooks() = %+v, %v; want one webhook without its secret", webhooks, err)
+	}
+	if err := service.DeleteWebhook(ctx, "p1", created.ID); err != nil {
+		t.Fatalf("DeleteWebhook() unexpected error: %v", err)
+	}
+	if _, err := service.GetWebhook(ctx, "p1", created.ID); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("GetWebhook() after delete error = %v, want %v", err, ErrWebhookNotFound)
+	}
+}
+
+func TestWebhookServiceErrors(t *testing.T) {
+	repo := repositories.NewMemoryWebhookRepository()
+	service := NewWebhookService(repo, projectMembership{"p1"})
+	ctx := testContext()
+	valid := func() *models.Webhook { return &models.Webhook{URL: "https://partner.example.com"} }
+
+	created, err := service.CreateWebhook(ctx, "p1", valid())
+	if err != nil {
+		t.Fatalf("CreateWebhook() unexpected error: %v", err)
+	}
+	badURL := "not a url"
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{"no user", func() error { _, err := service.CreateWebhook(context.Background(), "p1", valid()); return err }, ErrUnauthenticated},
+		{"other project", func() error { _, err := service.CreateWebhook(ctx, "p9", valid()); return err }, ErrForbidden},
+		{"invalid URL", func() error {
+			_, err := service.CreateWebhook(ctx, "p1", &models.Webhook{URL: "ftp://partner.example.com"})
+			return err
+		}, ErrInvalidWebhook},
+		{"short secret", func() error {
+			_, err := service.CreateWebhook(ctx, "p1", &models.Webhook{URL: "https://partner.example.com", Secret: "short"})
+			return err
+		}, ErrInvalidWebhook},
+		{"empty update", func() error {
+			_, err := service.UpdateWebhook(ctx, "p1", created.ID, models.WebhookUpdate{})
+			return err
+		}, ErrInvalidWebhook},
+		{"invalid update", func() error {
+			_, err := service.UpdateWebhook(ctx, "p1", created.ID, models.WebhookUpdate{URL: &badURL})
+			return err
+		}, ErrInvalidWebhook},
+		{"unknown webhook", func() error { return service.DeleteWebhook(ctx, "p1", "missing") }, ErrWebhookNotFound},
+		{"unsupported status", func() error { _, _, err := service.ListDeliveries(ctx, "p1", created.ID, "lost", 0, 10); return err }, ErrInvalidQuery},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	for i := 1; i < MaxWebhooksPerProject; i++ {
+		if _, err := service.CreateWebhook(ctx, "p1", valid()); err != nil {
+			t.Fatalf("CreateWebhook() %d unexpected error: %v", i, err)
+		}
+	}
+	if _, err := service.CreateWebhook(ctx, "p1", valid()); !errors.Is(err, ErrInvalidWebhook) {
+		t.Errorf("CreateWebhook() beyond the limit error = %v, want %v", err, ErrInvalidWebhook)
+	}
+}
@@ -0,0 +1,168 @@
+package topics
+
+import (
+	"fmt"
+	"strings"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+// Rule classifies the topics matching an MQTT pattern. Wildcard levels may be named to
+// capture the matched value: "+deviceId" captures one level and "#channel" captures the
+// remaining levels joined with "/". Unnamed "+" and "#" behave as plain MQTT wildcards.
+type Rule struct {
+	Name    string             `json:"name"`
+	Pattern string             `json:"pattern"`
+	Type    models.MessageType `json:"type"`
+}
+
+// Match is the result of classifying a topic
+type Match struct {
+	Rule   string             `json:"rule"`
+	Type   models.MessageType `json:"type"`
+	Fields map[string]string  `json:"fields,omitempty"` // Named captures, e.g. deviceId, projectId, channel
+}
+
+// Router classifies topics against an ordered rule table; the first matching rule wins
+type Router interface {
+	Classify(topic string) *Match // Returns nil when no rule matches
+	Rules() []Rule
+}
+
+type levelKind int
+
+const (
+	levelLiteral levelKind = iota
+	levelSingle
+	levelMulti
+)
+
+type patternLevel struct {
+	kind  levelKind
+	value string // Literal value or capture name (empty for anonymous wildcards)
+}
+
+type compiledRule struct {
+	rule   Rule
+	levels []patternLevel
+}
+
+type router struct {
+	rules []compiledRule
+}
+
+func NewRouter(rules []Rule) (Router, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	names := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("topic rule %d: name is required", i)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("topic rule %q: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.Type == "" {
+			return nil, fmt.Errorf("topic rule %q: type is required", rule.Name)
+		}
+
+		levels, err := compilePattern(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("topic rule %q: %w", rule.Name, err)
+		}
+		compiled = append(compiled, compiledRule{rule: rule, levels: levels})
+	}
+	return &router{rules: compiled}, nil
+}
+
+// compilePattern parses a rule pattern, checking wildcard placement and capture names
+func compilePattern(pattern string) ([]patternLevel, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("pattern is required")
+	}
+
+	parts := strings.Split(pattern, "/")
+	levels := make([]patternLevel, 0, len(parts))
+	captures := make(map[string]bool)
+	for i, part := range parts {
+		level := patternLevel{kind: levelLiteral, value: part}
+		switch {
+		case strings.HasPrefix(part, "+"):
+			level = patternLevel{kind: levelSingle, value: part[1:]}
+		case strings.HasPrefix(part, "#"):
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("invalid pattern %q: '#' must be the last level", pattern)
+			}
+			level = patternLevel{kind: levelMulti, value: part[1:]}
+		}
+
+		if strings.ContainsAny(level.value, "+#") {
+			return nil, fmt.Errorf("invalid pattern %q: wildcards must start a level", pattern)
+		}
+		if level.kind != levelLiteral && level.value != "" {
+			if captures[level.value] {
+				return nil, fmt.Errorf("invalid pattern %q: duplicate capture %q", pattern, level.value)
+			}
+			captures[level.value] = true
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
+
+func (r *router) Classify(topic string) *Match {
+	topicLevels := strings.Split(topic, "/")
+	for _, rule := range r.rules {
+		if fields, ok := rule.match(topicLevels); ok {
+			return &Match{Rule: rule.rule.Name, Type: rule.rule.Type, Fields: fields}
+		}
+	}
+	return nil
+}
+
+func (r *router) Rules() []Rule {
+	rules := make([]Rule, len(r.rules))
+	for i, rule := range r.rules {
+		rules[i] = rule.rule
+	}
+	return rules
+}
+
+// match applies MQTT matching semantics to the topic levels, collecting named captures
+func (c compiledRule) match(topicLevels []string) (map[string]string, bool) {
+	var fields map[string]string
+	capture := func(name, value string) {
+		if name == "" || value == "" {
+			return
+		}
+		if fields == nil {
+			fields = make(map[string]string)
+		}
+		fields[name] = value
+	}
+
+	for i, level := range c.levels {
+		if level.kind == levelMulti {
+			// "#" also matches the parent level, capturing nothing
+			if i < len(topicLevels) {
+				capture(level.value, strings.Join(topicLevels[i:], "/"))
+			}
+			return fields, true
+		}
+		if i >= len(topicLevels) {
+			return nil, false
+		}
+		switch level.kind {
+		case levelSingle:
+			capture(level.value, topicLevels[i])
+		case levelLiteral:
+			if level.value != topicLevels[i] {
+				return nil, false
+			}
+		}
+	}
+	if len(c.levels) != len(topicLevels) {
+		return nil, false
+	}
+	return fields, true
+}
@@ -0,0 +1,114 @@
+package topics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+func TestDefaultRulesClassify(t *testing.T) {
+	router, err := NewRouter(DefaultRules())
+	if err != nil {
+		t.Fatalf("NewRouter() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		topic      string
+		wantType   models.MessageType
+		wantFields map[string]string
+	}{
+		{"shelly-1234/status/switch:0", models.MessageTypeStatus, map[string]string{"deviceId": "shelly-1234", "channel": "switch:0"}},
+		{"shelly-1234/events/rpc", models.MessageTypeEvent, map[string]string{"deviceId": "shelly-1234"}},
+		{"shelly-1234/online", models.MessageTypeOnline, map[string]string{"deviceId": "shelly-1234"}},
+		{"shelly-1234/rpc", models.MessageTypeRPC, map[string]string{"deviceId": "shelly-1234"}},
+		{"site/dev-1/telemetry", models.MessageTypeTelemetry, map[string]string{"deviceId": "dev-1"}},
+		{"site/dev-1/telemetry/ch1/temperature", models.MessageTypeTelemetry, map[string]string{"deviceId": "dev-1", "channel": "ch1/temperature"}},
+		{"site/rpc-status/x", "", nil},
+		{"telemetry", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			match := router.Classify(tt.topic)
+			if tt.wantType == "" {
+				if match != nil {
+					t.Fatalf("Classify() = %+v, want no match", match)
+				}
+				return
+			}
+			if match == nil {
+				t.Fatalf("Classify() = nil, want %s", tt.wantType)
+			}
+			if match.Type != tt.wantType || !reflect.DeepEqual(match.Fields, tt.wantFields) {
+				t.Errorf("Classify() = %+v, want type %s fields %v", match, tt.wantType, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestRouterFirstMatchWins(t *testing.T) {
+	router, err := NewRouter([]Rule{
+		{Name: "alarms", Pattern: "+projectId/+deviceId/alarm/#", Type: "alarm"},
+		{Name: "any", Pattern: "#", Type: models.MessageTypeUnknown},
+	})
+	if err != nil {
+		t.Fatalf("NewRouter() unexpected error: %v", err)
+	}
+
+	match := router.Classify("p1/dev-1/alarm")
+	if match == nil || match.Rule != "alarms" || match.Fields["projectId"] != "p1" || match.Fields["deviceId"] != "dev-1" {
+		t.Errorf("Classify() = %+v, want alarms rule with captures", match)
+	}
+	if match := router.Classify("p1/dev-1/other"); match == nil || match.Rule != "any" {
+		t.Errorf("Classify() = %+v, want catch-all rule", match)
+	}
+}
+
+func TestNewRouterRejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"missing name", Rule{Pattern: "a/+", Type: "t"}},
+		{"missing type", Rule{Name: "r", Pattern: "a/+"}},
+		{"missing pattern", Rule{Name: "r", Type: "t"}},
+		{"hash not last", Rule{Name: "r", Pattern: "a/#/b", Type: "t"}},
+		{"wildcard inside level", Rule{Name: "r", Pattern: "a/b+/c", Type: "t"}},
+		{"duplicate capture", Rule{Name: "r", Pattern: "+id/+id", Type: "t"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRouter([]Rule{tt.rule}); err == nil {
+				t.Errorf("NewRouter() expected error")
+			}
+		})
+	}
+}
+
+func TestLoadRouter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	rules := `{"rules": [{"name": "meters", "pattern": "meters/+deviceId/+channel", "type": "telemetry"}]}`
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := LoadRouter(path)
+	if err != nil {
+		t.Fatalf("LoadRouter() unexpected error: %v", err)
+	}
+	match := router.Classify("meters/m-1/energy")
+	if match == nil || match.Fields["channel"] != "energy" {
+		t.Errorf("Classify() = %+v", match)
+	}
+	if match := router.Classify("shelly-1234/status/switch:0"); match != nil {
+		t.Errorf("Classify() = %+v, configured rules should replace the defaults", match)
+	}
+
+	if _, err := LoadRouter(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("LoadRouter() expected error for missing file")
+	}
+}
@@ -0,0 +1,81 @@
+package topics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"sit-iot-message-mng-api/internal/models"
+)
+
+// ruleFile is the layout of a topic rules configuration file
+type ruleFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// defaultTypeLevels lists, by priority, the topic levels that identify the built-in message types
+var defaultTypeLevels = []struct {
+	level       string
+	messageType models.MessageType
+	capture     string
+}{
+	{"status", models.MessageTypeStatus, "#channel"},
+	{"events", models.MessageTypeEvent, "#"},
+	{"online", models.MessageTypeOnline, "#"},
+	{"rpc", models.MessageTypeRPC, "#"},
+	{"command", models.MessageTypeCommand, "#"},
+	{"telemetry", models.MessageTypeTelemetry, "#channel"},
+	{"alert", models.MessageTypeAlert, "#"},
+}
+
+// DefaultRules returns the rules used when no rules file is configured. They recognise
+// Shelly-style topics ("<deviceId>/status/switch:0") and topics with one level in front
+// of the device ID ("site/<deviceId>/telemetry").
+func DefaultRules() []Rule {
+	rules := make([]Rule, 0, 2*len(defaultTypeLevels))
+	for _, typeLevel := range defaultTypeLevels {
+		rules = append(rules,
+			Rule{
+				Name:    typeLevel.level,
+				Pattern: "+deviceId/" + typeLevel.level + "/" + typeLevel.capture,
+				Type:    typeLevel.messageType,
+			},
+			Rule{
+				Name:    typeLevel.level + "-nested",
+				Pattern: "+/+deviceId/" + typeLevel.level + "/" + typeLevel.capture,
+				Type:    typeLevel.messageType,
+			},
+		)
+	}
+	return rules
+}
+
+// LoadRules reads a JSON rules file of the form {"rules": [{"name", "pattern", "type"}]}
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read topic rules: %w", err)
+	}
+
+	var file ruleFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse topic rules %s: %w", path, err)
+	}
+	if len(file.Rules) == 0 {
+		return nil, fmt.Errorf("topic rules %s: no rules defined", path)
+	}
+	return file.Rules, nil
+}
+
+// LoadRouter builds a router from the rules file at path, or from DefaultRules when path is empty
+func LoadRouter(path string) (Router, error) {
+	if path == "" {
+		return NewRouter(DefaultRules())
+	}
+
+	rules, err := LoadRules(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewRouter(rules)
+}
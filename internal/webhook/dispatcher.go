@@ -0,0 +1,434 @@
+// Package webhook POSTs the created messages matching the webhook subscriptions of the
+// projects to their target URLs, signed with the secret of the subscription.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// MaxAttempts is the number of POSTs made for a delivery before it is marked failed
+	MaxAttempts = 6
+	// eventBuffer is the number of created messages queued for the dispatcher
+	eventBuffer = 1024
+	// attemptTimeout bounds a single POST, response included
+	attemptTimeout = 10 * time.Second
+	// maxConcurrentDeliveries caps the deliveries in progress, retries included
+	maxConcurrentDeliveries = 32
+	// webhookCacheTTL is how long the list of webhooks is reused before being read again
+	webhookCacheTTL = 10 * time.Second
+	// saveTimeout bounds the recording of a delivery
+	saveTimeout = 5 * time.Second
+	// maxDrainedBody is the number of bytes of a response read to reuse its connection
+	maxDrainedBody = 64 << 10
+)
+
+// Headers sent with every POST
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	WebhookHeader   = "X-Webhook-ID"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	EventHeader     = "X-Webhook-Event"
+)
+
+// Payload is the JSON body POSTed to a webhook. Retries of a delivery send the same body.
+type Payload struct {
+	DeliveryID string          `json:"deliveryId"`
+	WebhookID  string          `json:"webhookId"`
+	ProjectID  string          `json:"projectId"`
+	Event      string          `json:"event"`
+	OccurredAt time.Time       `json:"occurredAt"`
+	Message    *models.Message `json:"message"`
+}
+
+// Sign returns the value of the signature header of a POST made at the given time:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>" keyed with the secret>".
+// Receivers recompute it to authenticate the body and reject old timestamps to prevent replays.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher delivers every created message to the active webhooks of its project whose
+// filter it matches. Failed POSTs are retried with exponential backoff and every attempt
+// is recorded in the delivery.
+type Dispatcher interface {
+	// Start subscribes to the event bus and delivers in the background. The deliveries left
+	// pending by a previous run are resumed at their next attempt time.
+	Start(ctx context.Context) error
+	// Stop unsubscribes and waits for the attempts in progress. Retries not made yet are
+	// abandoned and their deliveries stay pending until the next Start.
+	Stop()
+}
+
+type dispatcher struct {
+	bus         events.Bus
+	repo        repositories.WebhookRepository
+	messageRepo repositories.MessageRepository
+	client      *http.Client
+
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	// The webhook cache is only used by the event loop
+	cached   []*models.Webhook
+	cachedAt time.Time
+
+	// slots is held while a POST is made and recorded, not while waiting for a retry
+	slots       chan struct{}
+	deliveries  sync.WaitGroup
+	unsubscribe func()
+	cancel      func()
+	done        chan struct{}
+}
+
+// pendingDelivery is a delivery with what its next attempts need
+type pendingDelivery struct {
+	webhook  *models.Webhook
+	delivery *models.WebhookDelivery
+	body     []byte
+}
+
+func NewDispatcher(bus events.Bus, repo repositories.WebhookRepository, messageRepo repositories.MessageRepository) Dispatcher {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would make the connections, hiding the addresses they reach
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{Timeout: attemptTimeout, Control: refuseNonPublic}).DialContext
+
+	return &dispatcher{
+		bus:         bus,
+		repo:        repo,
+		messageRepo: messageRepo,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   attemptTimeout,
+			// A redirected POST would be replayed as a GET; redirects count as failures
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		initialBackoff: 10 * time.Second,
+		maxBackoff:     5 * time.Minute,
+		slots:          make(chan struct{}, maxConcurrentDeliveries),
+	}
+}
+
+func (d *dispatcher) Start(ctx context.Context) error {
+	if d.done != nil {
+		return errors.New("webhook dispatcher already started")
+	}
+
+	queue, unsubscribe := d.bus.SubscribeDurable("webhook", eventBuffer)
+	ctx, cancel := context.WithCancel(ctx)
+	d.unsubscribe = unsubscribe
+	d.cancel = cancel
+	d.done = make(chan struct{})
+
+	// Stored times may be truncated to milliseconds
+	started := time.Now().UTC().Truncate(time.Millisecond)
+	d.deliveries.Add(1)
+	go func() {
+		defer d.deliveries.Done()
+		d.resume(ctx, started)
+	}()
+
+	go func() {
+		defer close(d.done)
+		for event := range queue {
+			if event.Type != events.MessageCreated {
+				continue
+			}
+			for _, webhook := range d.matching(ctx, event.Message) {
+				pending, err := newPendingDelivery(webhook, event)
+				if err != nil {
+					log.Printf("Failed to encode webhook payload of message %s: %v", event.Message.GetIDAsString(), err)
+					continue
+				}
+				// Recorded before waiting for a slot, so a stop or a crash leaves it to resume
+				d.save(pending.delivery)
+				select {
+				case d.slots <- struct{}{}:
+				case <-ctx.Done():
+					continue
+				}
+				d.deliveries.Add(1)
+				go func() {
+					defer d.deliveries.Done()
+					d.attemptInSlot(ctx, pending)
+				}()
+			}
+		}
+	}()
+	log.Printf("Webhook dispatcher started")
+	return nil
+}
+
+func (d *dispatcher) Stop() {
+	if d.cancel == nil {
+		return
+	}
+	d.unsubscribe()
+	d.cancel()
+	<-d.done
+	d.deliveries.Wait()
+	d.cancel = nil
+}
+
+// refuseNonPublic checks the address of every connection right before it is made, after
+// the host was resolved, so a webhook host that starts resolving to an internal address
+// after being validated is still refused
+func refuseNonPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !models.IsPublicWebhookAddress(net.ParseIP(host)) {
+		return fmt.Errorf("%w: %s", models.ErrWebhookAddressNotAllowed, host)
+	}
+	return nil
+}
+
+// matching returns the webhooks the message must be delivered to
+func (d *dispatcher) matching(ctx context.Context, message *models.Message) []*models.Webhook {
+	if d.cached == nil || time.Since(d.cachedAt) >= webhookCacheTTL {
+		webhooks, err := d.repo.ListWebhooks(ctx, "")
+		if err != nil {
+			// Keep using the previous list until the repository is back
+			log.Printf("Failed to list webhooks: %v", err)
+		} else {
+			d.cached = webhooks
+			d.cachedAt = time.Now()
+		}
+	}
+
+	var matching []*models.Webhook
+	for _, webhook := range d.cached {
+		if webhook.Matches(message) {
+			matching = append(matching, webhook)
+		}
+	}
+	return matching
+}
+
+// newPendingDelivery returns the delivery of a message event to a webhook
+func newPendingDelivery(webhook *models.Webhook, event events.MessageEvent) (*pendingDelivery, error) {
+	now := time.Now().UTC()
+	delivery := &models.WebhookDelivery{
+		ID:            primitive.NewObjectID().Hex(),
+		WebhookID:     webhook.ID,
+		ProjectID:     webhook.ProjectID,
+		MessageID:     event.Message.GetIDAsString(),
+		Event:         string(event.Type),
+		Status:        models.WebhookDeliveryPending,
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	body, err := json.Marshal(Payload{
+		DeliveryID: delivery.ID,
+		WebhookID:  webhook.ID,
+		ProjectID:  webhook.ProjectID,
+		Event:      delivery.Event,
+		OccurredAt: event.OccurredAt,
+		Message:    event.Message,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pendingDelivery{webhook: webhook, delivery: delivery, body: body}, nil
+}
+
+// resume schedules the deliveries left pending by a previous run, with the current version
+// of their webhook. Those whose webhook was deactivated or whose message was deleted fail.
+// Deliveries created since this run started are already being attempted.
+func (d *dispatcher) resume(ctx context.Context, started time.Time) {
+	webhooks, err := d.repo.ListWebhooks(ctx, "")
+	if err != nil {
+		log.Printf("Failed to list webhooks to resume their deliveries: %v", err)
+		return
+	}
+	resumed := 0
+	for _, webhook := range webhooks {
+		deliveries, _, err := d.repo.ListDeliveries(ctx, webhook.ID, models.WebhookDeliveryPending, 0, 0)
+		if err != nil {
+			log.Printf("Failed to list the pending deliveries of webhook %s: %v", webhook.ID, err)
+			continue
+		}
+		for _, delivery := range deliveries {
+			if delivery.NextAttemptAt == nil || !delivery.CreatedAt.Before(started) {
+				continue
+			}
+			pending, err := d.reload(ctx, webhook, delivery)
+			if err != nil {
+				log.Printf("Failing webhook delivery %s: %v", delivery.ID, err)
+				delivery.Status = models.WebhookDeliveryFailed
+				delivery.NextAttemptAt = nil
+				delivery.UpdatedAt = time.Now().UTC()
+				d.save(delivery)
+				continue
+			}
+			d.schedule(ctx, pending, *delivery.NextAttemptAt)
+			resumed++
+		}
+	}
+	if resumed > 0 {
+		log.Printf("Resumed %d pending webhook deliveries", resumed)
+	}
+}
+
+// reload rebuilds the payload of a pending delivery from its stored message
+func (d *dispatcher) reload(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) (*pendingDelivery, error) {
+	if !webhook.Active {
+		return nil, errors.New("webhook is not active")
+	}
+	message, err := d.messageRepo.FindByID(ctx, delivery.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("message %s: %w", delivery.MessageID, err)
+	}
+	body, err := json.Marshal(Payload{
+		DeliveryID: delivery.ID,
+		WebhookID:  webhook.ID,
+		ProjectID:  webhook.ProjectID,
+		Event:      delivery.Event,
+		OccurredAt: delivery.CreatedAt,
+		Message:    message,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pendingDelivery{webhook: webhook, delivery: delivery, body: body}, nil
+}
+
+// attemptInSlot makes the next attempt of the delivery, releases the slot taken by the
+// caller and schedules the following attempt when one is due
+func (d *dispatcher) attemptInSlot(ctx context.Context, pending *pendingDelivery) {
+	next := d.attempt(ctx, pending)
+	<-d.slots
+	if next != nil {
+		d.schedule(ctx, pending, *next)
+	}
+}
+
+// schedule makes the next attempt of the delivery at the given time, without holding a
+// slot while waiting. The attempt is abandoned when the dispatcher stops.
+func (d *dispatcher) schedule(ctx context.Context, pending *pendingDelivery, at time.Time) {
+	d.deliveries.Add(1)
+	go func() {
+		defer d.deliveries.Done()
+		timer := time.NewTimer(time.Until(at))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case d.slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		d.attemptInSlot(ctx, pending)
+	}()
+}
+
+// attempt POSTs the message once and records the delivery. It returns the time of the next
+// attempt, nil once the webhook accepted or rejected the message or MaxAttempts is reached.
+func (d *dispatcher) attempt(ctx context.Context, pending *pendingDelivery) *time.Time {
+	delivery := pending.delivery
+	result, retry := d.post(ctx, pending.webhook, delivery, pending.body)
+	delivery.Attempts = append(delivery.Attempts, result)
+	delivery.UpdatedAt = time.Now().UTC()
+	delivery.NextAttemptAt = nil
+	switch {
+	case result.Error == "":
+		delivery.Status = models.WebhookDeliverySucceeded
+	case !retry || len(delivery.Attempts) >= MaxAttempts:
+		delivery.Status = models.WebhookDeliveryFailed
+	default:
+		next := delivery.UpdatedAt.Add(d.backoff(len(delivery.Attempts)))
+		delivery.NextAttemptAt = &next
+	}
+	d.save(delivery)
+	return delivery.NextAttemptAt
+}
+
+// backoff returns the delay before the attempt following the given number of attempts,
+// doubling from initialBackoff up to maxBackoff
+func (d *dispatcher) backoff(attempts int) time.Duration {
+	delay := d.initialBackoff
+	for i := 1; i < attempts && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, d.maxBackoff)
+}
+
+// post makes one attempt of the delivery and reports whether a failure is worth retrying
+func (d *dispatcher) post(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery, body []byte) (models.WebhookAttempt, bool) {
+	started := time.Now()
+	attempt := models.WebhookAttempt{AttemptedAt: started.UTC()}
+	finish := func(err string, retry bool) (models.WebhookAttempt, bool) {
+		attempt.Error = err
+		attempt.DurationMs = time.Since(started).Milliseconds()
+		return attempt, retry
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return finish(err.Error(), false)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sit-iot-message-mng-webhook")
+	req.Header.Set(WebhookHeader, webhook.ID)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, started, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		// The address of a target does not change between retries
+		return finish(err.Error(), !errors.Is(err, models.ErrWebhookAddressNotAllowed))
+	}
+	defer resp.Body.Close()
+	attempt.StatusCode = resp.StatusCode
+	// The body is not recorded: it could expose the responses of internal services
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainedBody))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return finish("", false)
+	}
+	// Server errors, throttling and timeouts are transient; other statuses reject the message
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+	return finish(fmt.Sprintf("HTTP %d", resp.StatusCode), retry)
+}
+
+// save records the delivery, even when the dispatcher is stopping
+func (d *dispatcher) save(delivery *models.WebhookDelivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), saveTimeout)
+	defer cancel()
+	if err := d.repo.SaveDelivery(ctx, delivery); err != nil {
+		log.Printf("Failed to record webhook delivery %s: %v", delivery.ID, err)
+	}
+}
@@ -0,0 +1,370 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sit-iot-message-mng-api/internal/events"
+	"sit-iot-message-mng-api/internal/models"
+	"sit-iot-message-mng-api/internal/repositories"
+)
+
+const testSecret = "0123456789abcdef"
+
+func TestSign(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	signature := Sign(testSecret, at, []byte(`{"a":1}`))
+	if !strings.HasPrefix(signature, "t=1700000000,v1=") || len(signature) != len("t=1700000000,v1=")+64 {
+		t.Errorf("Sign() = %q, want t=1700000000,v1=<64 hex digits>", signature)
+	}
+	if Sign(testSecret, at, []byte(`{"a":2}`)) == signature || Sign("another secret!!", at, []byte(`{"a":1}`)) == signature {
+		t.Error("Sign() does not depend on the body and the secret")
+	}
+	if Sign(testSecret, at, []byte(`{"a":1}`)) != signature {
+		t.Error("Sign() is not deterministic")
+	}
+}
+
+// waitForDeliveries polls the repository until the webhook has the given number of
+// finished deliveries, or fails after a second
+func waitForDeliveries(t *testing.T, repo repositories.WebhookRepository, webhookID string, count int) []*models.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		deliveries, _, err := repo.ListDeliveries(context.Background(), webhookID, "", 0, 0)
+		if err != nil {
+			t.Fatalf("ListDeliveries() unexpected error: %v", err)
+		}
+		finished := 0
+		for _, delivery := range deliveries {
+			if delivery.Status != models.WebhookDeliveryPending {
+				finished++
+			}
+		}
+		if finished >= count {
+			return deliveries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("webhook %s has %d finished deliveries, want %d", webhookID, finished, count)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/flaky":
+			// Fails once, then checks the request and accepts it
+			if calls.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			var payload Payload
+			if err := json.Unmarshal(body, &payload); err != nil || payload.Message == nil || payload.Message.DeviceID != "dev-1" ||
+				payload.DeliveryID != r.Header.Get(DeliveryHeader) || r.Header.Get(EventHeader) != string(events.MessageCreated) {
+				w.WriteHeader(http.StatusTeapot)
+				return
+			}
+			signature := r.Header.Get(SignatureHeader)
+			unix := strings.TrimPrefix(strings.Split(signature, ",")[0], "t=")
+			seconds, _ := strconv.ParseInt(unix, 10, 64)
+			if signature != Sign(testSecret, time.Unix(seconds, 0), body) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		case "/rejecting":
+			http.Error(w, "unknown device", http.StatusBadRequest)
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	repo := repositories.NewMemoryWebhookRepository()
+	alerts := models.MessageFilter{Types: []models.MessageType{models.MessageTypeAlert}}
+	for _, webhook := range []*models.Webhook{
+		{ID: "flaky", ProjectID: "p1", URL: server.URL + "/flaky", Secret: testSecret, Filter: alerts, Active: true},
+		{ID: "rejecting", ProjectID: "p1", URL: server.URL + "/rejecting", Secret: testSecret, Active: true},
+		{ID: "down", ProjectID: "p1", URL: server.URL + "/down", Secret: testSecret, Filter: alerts, Active: true},
+		{ID: "other-project", ProjectID: "p2", URL: server.URL + "/flaky", Secret: testSecret, Active: true},
+		{ID: "inactive", ProjectID: "p1", URL: server.URL + "/flaky", Secret: testSecret},
+	} {
+		if err := repo.CreateWebhook(context.Background(), webhook); err != nil {
+			t.Fatalf("CreateWebhook() unexpected error: %v", err)
+		}
+	}
+
+	bus := events.NewBus()
+	d := NewDispatcher(bus, repo, repositories.NewMemoryMessageRepository(nil, nil)).(*dispatcher)
+	// The test server listens on loopback, which the dispatcher refuses
+	d.client.Transport = http.DefaultTransport
+	d.initialBackoff = time.Millisecond
+	d.maxBackoff = 2 * time.Millisecond
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatalf("Start() unexpected error: %v", err)
+	}
+	defer d.Stop()
+
+	message := &models.Message{ProjectID: "p1", DeviceID: "dev-1", Type: models.MessageTypeAlert}
+	message.SetIDFromString("65a000000000000000000001")
+	bus.Publish(events.MessageEvent{Type: events.MessageUpdated, Message: message})
+	bus.Publish(events.MessageEvent{Type: events.MessageCreated, Message: message})
+
+	tests := []struct {
+		webhookID    string
+		wantStatus   models.WebhookDeliveryStatus
+		wantAttempts int
+		wantCode     int
+	}{
+		{"flaky", models.WebhookDeliverySucceeded, 2, http.StatusOK},
+		{"rejecting", models.WebhookDeliveryFailed, 1, http.StatusBadRequest},
+		{"down", models.WebhookDeliveryFailed, MaxAttempts, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		deliveries := waitForDeliveries(t, repo, tt.webhookID, 1)
+		if len(deliveries) != 1 {
+			t.Fatalf("webhook %s has %d deliveries, want 1", tt.webhookID, len(deliveries))
+		}
+		delivery := deliveries[0]
+		if delivery.Status != tt.wantStatus || len(delivery.Attempts) != tt.wantAttempts || delivery.MessageID != "65a000000000000000000001" || delivery.NextAttemptAt != nil {
+			t.Errorf("webhook %s delivery = %+v, want %s after %d attempts", tt.webhookID, delivery, tt.wantStatus, tt.wantAttempts)
+			continue
+		}
+		if last := delivery.Attempts[len(delivery.Attempts)-1]; last.StatusCode != tt.wantCode {
+			t.Errorf("webhook %s last attempt = %+v, want status %d", tt.webhookID, last, tt.wantCode)
+		}
+	}
+	if deliveries := waitForDeliveries(t, repo, "rejecting", 1); deliveries[0].Attempts[0].Error != "HTTP 400" {
+		t.Errorf("rejected attempt error = %q", deliveries[0].Attempts[0].Error)
+	}
+
+	for _, webhookID := range []string{"other-project", "inactive"} {
+		if deliveries, _, _ := repo.ListDeliveries(context.Background(), webhookID, "", 0, 0); len(deliveries) != 0 {
+			t.Errorf("webhook %s has %d deliveries, want none", webhookID, len(deliveries))
+		}
+	}
+}
+
+func TestDispatcherRefusesInternalAddresses(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer server.Close()
+
+	repo := repositories.NewMemoryWebhookRepository()
+	// Stored directly, as a host resolving to loopback after its validation would be
+	webhook := &models.Webhook{ID: "internal", ProjectID: "p1", URL: server.URL, Secret: testSecret, Active: true}
+	if err := repo.CreateWebhook(context.Background(), webhook); err != nil {
+		t.Fatalf("CreateWebhook() unexpected error: %v", err)
+	}
+
+	bus := events.NewBus()
+	d := NewDispatcher(bus, repo, repositories.NewMemoryMessageRepository(nil, nil)).(*dispatcher)
+	d.initialBackoff = time.Millisecond
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatalf("Start() unexpected error: %v", err)
+	}
+	defer d.Stop()
+
+	message := &models.Message{ProjectID: "p1", DeviceID: "dev-1"}
+	message.SetIDFromString("65a000000000000000000001")
+	bus.Publish(events.MessageEvent{Type: events.MessageCreated, Message: message})
+
+	delivery := waitForDeliveries(t, repo, "internal", 1)[0]
+	if delivery.Status != models.WebhookDeliveryFailed || len(delivery.Attempts) != 1 || !strings.Contains(delivery.Attempts[0].Error, "not allowed") {
+		t.Errorf("delivery = %+v, want a single refused attempt", delivery)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("the internal server received %d requests, want none", calls.Load())
+	}
+}
+
+func TestDispatcherReleasesSlotsDuringBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	repo := repositories.NewMemoryWebhookRepository()
+	alerts := models.MessageFilter{Types: []models.MessageType{models.MessageTypeAlert}}
+	for _, webhook := range []*models.Webhook{
+		{ID: "down", ProjectID: "p1", URL: server.URL + "/down", Secret: testSecret, Filter: alerts, Active: true},
+		{ID: "up", ProjectID: "p1", URL: server.URL + "/up", Secret: testSecret, Active: true},
+	} {
+		if err := repo.CreateWebhook(context.Background(), webhook); err != nil {
+			t.Fatalf("CreateWebhook() unexpected error: %v", err)
+		}
+	}
+
+	bus := events.NewBus()
+	d := NewDispatcher(bus, repo, repositories.NewMemoryMessageRepository(nil, nil)).(*dispatcher)
+	d.client.Transport = http.DefaultTransport
+	// A single slot, kept by the failing delivery if it held it while waiting for its retry
+	d.slots = make(chan struct{}, 1)
+	d.initialBackoff = time.Hour
+	d.maxBackoff = time.Hour
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatalf("Start() unexpected error: %v", err)
+	}
+
+	alert := &models.Message{ProjectID: "p1", Type: models.MessageTypeAlert}
+	alert.SetIDFromString("65a000000000000000000001")
+	telemetry := &models.Message{ProjectID: "p1", Type: models.MessageTypeTelemetry}
+	telemetry.SetIDFromString("65a000000000000000000002")
+	bus.Publish(events.MessageEvent{Type: events.MessageCreated, Message: alert})
+	bus.Publish(events.MessageEvent{Type: events.MessageCreated, Message: telemetry})
+
+	if deliveries := waitForDeliveries(t, repo, "up", 2); deliveries[0].Status != models.WebhookDeliverySucceeded {
+		t.Errorf("delivery to the available webhook = %+v, want succeeded", deliveries[0])
+	}
+
+	// Stop does not wait for the scheduled retry
+	stopped := make(chan struct{})
+	go func() {
+		d.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop() waited for a scheduled retry")
+	}
+	deliveries, _, _ := repo.ListDeliveries(context.Background(), "down", models.WebhookDeliveryPending, 0, 0)
+	if len(deliveries) != 1 || deliveries[0].NextAttemptAt == nil {
+		t.Errorf("failing deliveries = %+v, want one pending with its next attempt", deliveries)
+	}
+}
+
+func TestDispatcherRecordsDeliveriesBeforeAttempting(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	repo := repositories.NewMemoryWebhookRepository()
+	if err := repo.CreateWebhook(context.Background(), &models.Webhook{ID: "slow", ProjectID: "p1", URL: server.URL, Secret: testSecret, Active: true}); err != nil {
+		t.Fatalf("CreateWebhook() unexpected error: %v", err)
+	}
+	bus := events.NewBus()
+	d := NewDispatcher(bus, repo, repositories.NewMemoryMessageRepository(nil, nil)).(*dispatcher)
+	d.client.Transport = http.DefaultTransport
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatalf("Start() unexpected error: %v", err)
+	}
+	defer d.Stop()
+
+	message := &models.Message{ProjectID: "p1"}
+	message.SetIDFromString("65a000000000000000000001")
+	bus.Publish(events.MessageEvent{Type: events.MessageCreated, Message: message})
+
+	// The delivery is pending, due now, while its first POST is in progress
+	deadline := time.Now().Add(time.Second)
+	for {
+		deliveries, _, err := repo.ListDeliveries(context.Background(), "slow", models.WebhookDeliveryPending, 0, 0)
+		if err != nil {
+			t.Fatalf("ListDeliveries() unexpected error: %v", err)
+		}
+		if len(deliveries) == 1 {
+			if deliveries[0].NextAttemptAt == nil || len(deliveries[0].Attempts) != 0 {
+				t.Errorf("delivery before its first attempt = %+v, want pending and due", deliveries[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the delivery was not recorded before its first attempt")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(release)
+	if deliveries := waitForDeliveries(t, repo, "slow", 1); deliveries[0].Status != models.WebhookDeliverySucceeded {
+		t.Errorf("delivery = %+v, want succeeded", deliveries[0])
+	}
+}
+
+func TestDispatcherResumesPendingDeliveries(t *testing.T) {
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload Payload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.DeliveryID != "resumed" || payload.Message == nil || payload.Message.DeviceID != "dev-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received.Add(1)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	repo := repositories.NewMemoryWebhookRepository()
+	messageRepo := repositories.NewMemoryMessageRepository(nil, nil)
+	for _, webhook := range []*models.Webhook{
+		{ID: "active", ProjectID: "p1", URL: server.URL, Secret: testSecret, Active: true},
+		{ID: "inactive", ProjectID: "p1", URL: server.URL, Secret: testSecret},
+	} {
+		if err := repo.CreateWebhook(ctx, webhook); err != nil {
+			t.Fatalf("CreateWebhook() unexpected error: %v", err)
+		}
+	}
+	message, err := messageRepo.Create(ctx, &models.Message{ProjectID: "p1", DeviceID: "dev-1"})
+	if err != nil {
+		t.Fatalf("Create() unexpected error: %v", err)
+	}
+
+	earlier := time.Now().UTC().Add(-time.Minute)
+	for _, delivery := range []*models.WebhookDelivery{
+		{ID: "resumed", WebhookID: "active", MessageID: message.GetIDAsString()},
+		{ID: "deleted message", WebhookID: "active", MessageID: "65a0000000000000000000ff"},
+		{ID: "inactive webhook", WebhookID: "inactive", MessageID: message.GetIDAsString()},
+	} {
+		delivery.ProjectID = "p1"
+		delivery.Event = string(events.MessageCreated)
+		delivery.Status = models.WebhookDeliveryPending
+		delivery.Attempts = []models.WebhookAttempt{{AttemptedAt: earlier, StatusCode: http.StatusBadGateway, Error: "HTTP 502"}}
+		delivery.NextAttemptAt = &earlier
+		delivery.CreatedAt, delivery.UpdatedAt = earlier, earlier
+		if err := repo.SaveDelivery(ctx, delivery); err != nil {
+			t.Fatalf("SaveDelivery() unexpected error: %v", err)
+		}
+	}
+
+	d := NewDispatcher(events.NewBus(), repo, messageRepo).(*dispatcher)
+	d.client.Transport = http.DefaultTransport
+	if err := d.Start(ctx); err != nil {
+		t.Fatalf("Start() unexpected error: %v", err)
+	}
+	defer d.Stop()
+
+	want := map[string]struct {
+		status   models.WebhookDeliveryStatus
+		attempts int
+	}{
+		"resumed":          {models.WebhookDeliverySucceeded, 2},
+		"deleted message":  {models.WebhookDeliveryFailed, 1},
+		"inactive webhook": {models.WebhookDeliveryFailed, 1},
+	}
+	var deliveries []*models.WebhookDelivery
+	deliveries = append(deliveries, waitForDeliveries(t, repo, "active", 2)...)
+	deliveries = append(deliveries, waitForDeliveries(t, repo, "inactive", 1)...)
+	for _, delivery := range deliveries {
+		if w := want[delivery.ID]; delivery.Status != w.status || len(delivery.Attempts) != w.attempts || delivery.NextAttemptAt != nil {
+			t.Errorf("delivery %s = %+v, want %s after %d attempts", delivery.ID, delivery, w.status, w.attempts)
+		}
+	}
+	if received.Load() != 1 {
+		t.Errorf("the webhook received %d deliveries, want the resumed one", received.Load())
+	}
+}